@@ -0,0 +1,174 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+// This file contains the implementation of appending to remote files without
+// downloading them first, for RemoteAccessors that are Appenders.
+
+import (
+	"io"
+	"os"
+)
+
+// canAppend returns true if our accessor is an Appender that can currently
+// append, and we're not streaming (see streaming()) or verifying checksums,
+// both of which need us to transfer whole files.
+func (r *remote) canAppend() bool {
+	ap, ok := r.accessor.(Appender)
+	if !ok || r.streaming() {
+		return false
+	}
+	if _, verifying := r.checksummer(); verifying {
+		return false
+	}
+	return ap.CanAppend()
+}
+
+// startAppend records that the given cache file, which may not be fully
+// cached, has been opened for appending to its remote file, which is base bytes
+// long. If we already recorded an earlier append that hasn't been uploaded yet,
+// this does nothing.
+func (r *remote) startAppend(localPath string, base int64) {
+	r.appendsMutex.Lock()
+	defer r.appendsMutex.Unlock()
+	if _, appending := r.appends[localPath]; !appending {
+		r.appends[localPath] = base
+	}
+}
+
+// appendBase returns the size the remote file was when we started appending to
+// the given cache file, if we did.
+func (r *remote) appendBase(localPath string) (base int64, appending bool) {
+	r.appendsMutex.Lock()
+	defer r.appendsMutex.Unlock()
+	base, appending = r.appends[localPath]
+	return base, appending
+}
+
+// forgetAppend forgets any append to the given cache file.
+func (r *remote) forgetAppend(localPath string) {
+	r.appendsMutex.Lock()
+	defer r.appendsMutex.Unlock()
+	delete(r.appends, localPath)
+}
+
+// renameAppend moves any record of an append from oldPath to newPath.
+func (r *remote) renameAppend(oldPath, newPath string) {
+	r.appendsMutex.Lock()
+	defer r.appendsMutex.Unlock()
+	if base, appending := r.appends[oldPath]; appending {
+		r.appends[newPath] = base
+		delete(r.appends, oldPath)
+	}
+}
+
+// copyAppend copies any record of an append from oldPath to newPath.
+func (r *remote) copyAppend(oldPath, newPath string) {
+	r.appendsMutex.Lock()
+	defer r.appendsMutex.Unlock()
+	if base, appending := r.appends[oldPath]; appending {
+		r.appends[newPath] = base
+	}
+}
+
+// uploadAppend uploads the given cache file, which was opened for appending
+// when its remote file was base bytes long. If only bytes after base were
+// modified, our Appender just appends them to the remote file. Otherwise, or
+// if the Appender can't append to this file, the parts of the file we haven't
+// cached are downloaded and the whole file is uploaded using upload.
+func (r *remote) uploadAppend(localPath, remotePath string, base int64, upload retryFunc) error {
+	if r.onlyAppended(localPath, base) {
+		err := r.accessor.(Appender).AppendFile(localPath, remotePath, base)
+		if err != ErrAppendUnsupported {
+			return err
+		}
+		r.Info("Append not possible, uploading whole file", "path", remotePath)
+	}
+	err := r.fillUncached(localPath, remotePath)
+	if err != nil {
+		return err
+	}
+	return upload()
+}
+
+// onlyAppended returns true if the given cache file is at least base bytes
+// long and none of its first base bytes have been modified.
+func (r *remote) onlyAppended(localPath string, base int64) bool {
+	info, err := os.Stat(localPath)
+	if err != nil || info.Size() < base {
+		return false
+	}
+	for _, iv := range r.modified.intervals(localPath) {
+		if iv.Start < base {
+			return false
+		}
+	}
+	return true
+}
+
+// fillUncached downloads the parts of the remote file that we haven't cached
+// in to the given cache file. Parts beyond the end of the remote file (or all
+// of it, if it doesn't exist) are left as they are.
+func (r *remote) fillUncached(localPath, remotePath string) error {
+	info, err := os.Stat(localPath)
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+	uncached := r.Uncached(localPath, NewInterval(0, info.Size()))
+	if len(uncached) == 0 {
+		return nil
+	}
+
+	f, err := os.OpenFile(localPath, os.O_WRONLY, os.FileMode(fileMode))
+	if err != nil {
+		return err
+	}
+	defer logClose(r.Logger, f, "fill uncached", "path", localPath)
+	for _, iv := range uncached {
+		err = r.fillInterval(f, remotePath, iv)
+		if err != nil {
+			if r.accessor.ErrorIsNotExists(err) {
+				return nil
+			}
+			return err
+		}
+		r.Cached(localPath, iv)
+	}
+	return nil
+}
+
+// fillInterval copies the given interval of the remote file to the same
+// position in the given file.
+func (r *remote) fillInterval(f *os.File, remotePath string, iv Interval) error {
+	reader, err := r.accessor.OpenFile(remotePath, iv.Start)
+	if err != nil {
+		return err
+	}
+	defer logClose(r.Logger, reader, "fill uncached reader", "path", remotePath)
+	_, err = f.Seek(iv.Start, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(f, reader, iv.Length())
+	if err == io.EOF {
+		// the remote file ends before the interval does
+		return nil
+	}
+	return err
+}
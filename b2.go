@@ -0,0 +1,305 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+// This file contains an implementation of RemoteAccessor for Backblaze B2,
+// using the native B2 API.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kurin/blazer/b2"
+)
+
+const (
+	b2Scheme = "b2"
+
+	// b2LargeFileSize is the size above which uploads use B2's large file API,
+	// and the size of each part of a large file upload.
+	b2LargeFileSize = 100000000
+
+	// b2ConcurrentUploads is the number of parts of a large file we upload at
+	// once.
+	b2ConcurrentUploads = 4
+)
+
+// B2Config struct lets you provide details of the Backblaze B2 bucket you wish
+// to mount.
+type B2Config struct {
+	// Target is the bucket and possible sub-path you wish to mount, in the form
+	// b2://bucket/subpath. For performance reasons, you should specify the
+	// deepest subpath that holds all your files.
+	Target string
+
+	// KeyID and ApplicationKey are your B2 access credentials.
+	KeyID          string
+	ApplicationKey string
+}
+
+// B2Accessor implements the RemoteAccessor interface by embedding blazer.
+type B2Accessor struct {
+	client   *b2.Client
+	bucket   *b2.Bucket
+	target   string
+	basePath string
+}
+
+// NewB2Accessor creates a B2Accessor for interacting with Backblaze B2.
+func NewB2Accessor(config *B2Config) (*B2Accessor, error) {
+	bucketName, basePath, err := parseTarget(b2Scheme, config.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	// create a client for interacting with B2 (we do this here instead of
+	// as-needed inside remote because authorizing is a remote call)
+	ctx := context.Background()
+	client, err := b2.NewClient(ctx, config.KeyID, config.ApplicationKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not access B2: %s", err)
+	}
+
+	bucket, err := client.Bucket(ctx, bucketName)
+	if err != nil {
+		return nil, fmt.Errorf("could not access B2 bucket %s: %s", bucketName, err)
+	}
+
+	return &B2Accessor{
+		client:   client,
+		bucket:   bucket,
+		target:   config.Target,
+		basePath: basePath,
+	}, nil
+}
+
+// DownloadFile implements RemoteAccessor by deferring to blazer.
+func (a *B2Accessor) DownloadFile(source, dest string) (err error) {
+	err = os.MkdirAll(filepath.Dir(dest), os.FileMode(dirMode))
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		errc := f.Close()
+		if err == nil {
+			err = errc
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reader := a.bucket.Object(source).NewReader(ctx)
+	defer func() {
+		errc := reader.Close()
+		if err == nil {
+			err = errc
+		}
+	}()
+
+	_, err = io.Copy(f, reader)
+	return err
+}
+
+// UploadFile implements RemoteAccessor by deferring to blazer. Files over
+// 100MB are uploaded using the large file API.
+func (a *B2Accessor) UploadFile(source, dest, contentType string) error {
+	f, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return a.upload(context.Background(), f, dest, contentType)
+}
+
+// UploadData implements RemoteAccessor by deferring to blazer.
+func (a *B2Accessor) UploadData(data io.Reader, dest string) error {
+	return a.upload(context.Background(), data, dest, "")
+}
+
+// UploadReader implements ReaderUploader by deferring to blazer.
+func (a *B2Accessor) UploadReader(ctx context.Context, data io.Reader, size int64, dest string, opts UploadOptions) error {
+	return a.upload(ctx, io.LimitReader(data, size), dest, opts.ContentType)
+}
+
+// upload writes data to the object at dest, using the large file API if data
+// turns out to be bigger than b2LargeFileSize. It gives up if ctx is done.
+func (a *B2Accessor) upload(ctx context.Context, data io.Reader, dest, contentType string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w := a.bucket.Object(dest).NewWriter(ctx)
+	w.ChunkSize = b2LargeFileSize
+	w.ConcurrentUploads = b2ConcurrentUploads
+	w.UseFileBuffer = true
+	if contentType != "" {
+		w = w.WithAttrs(&b2.Attrs{ContentType: contentType})
+	}
+
+	if _, err := io.Copy(w, data); err != nil {
+		errc := w.Close()
+		if errc != nil {
+			return fmt.Errorf("%s; %s", err, errc)
+		}
+		return err
+	}
+	return w.Close()
+}
+
+// ListEntries implements RemoteAccessor by deferring to blazer, which uses the
+// b2_list_file_names cursor to get through all entries.
+func (a *B2Accessor) ListEntries(dir string) ([]RemoteAttr, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	iter := a.bucket.List(ctx, b2.ListPrefix(dir), b2.ListDelimiter("/"))
+	var ras []RemoteAttr
+	for iter.Next() {
+		obj := iter.Object()
+		name := obj.Name()
+		if strings.HasSuffix(name, "/") {
+			ras = append(ras, RemoteAttr{Name: name})
+			continue
+		}
+
+		attrs, err := obj.Attrs(ctx)
+		if err != nil {
+			return nil, err
+		}
+		mtime := attrs.LastModified
+		if mtime.IsZero() {
+			mtime = attrs.UploadTimestamp
+		}
+		ras = append(ras, RemoteAttr{
+			Name:  name,
+			Size:  attrs.Size,
+			MTime: mtime,
+		})
+	}
+
+	return ras, iter.Err()
+}
+
+// OpenFile implements RemoteAccessor by deferring to blazer, using a ranged
+// read starting at offset.
+func (a *B2Accessor) OpenFile(path string, offset int64) (io.ReadCloser, error) {
+	return a.bucket.Object(path).NewRangeReader(context.Background(), offset, -1), nil
+}
+
+// Seek implements RemoteAccessor by closing the given reader and opening a new
+// ranged read starting at offset.
+func (a *B2Accessor) Seek(path string, rc io.ReadCloser, offset int64) (io.ReadCloser, error) {
+	err := rc.Close()
+	if err != nil {
+		return nil, err
+	}
+	return a.OpenFile(path, offset)
+}
+
+// CopyFile implements RemoteAccessor by streaming source in to dest, since the
+// B2 API we use has no server-side copy.
+func (a *B2Accessor) CopyFile(source, dest string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reader := a.bucket.Object(source).NewReader(ctx)
+	defer reader.Close()
+
+	return a.upload(ctx, reader, dest, "")
+}
+
+// DeleteFile implements RemoteAccessor by deleting all versions of the file, so
+// that an older version doesn't take its place. Like S3, deleting a file that
+// doesn't exist is not an error.
+func (a *B2Accessor) DeleteFile(path string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	iter := a.bucket.List(ctx, b2.ListPrefix(path), b2.ListHidden())
+	for iter.Next() {
+		obj := iter.Object()
+		if obj.Name() != path {
+			continue
+		}
+		if err := obj.Delete(ctx); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+// DeleteIncompleteUpload implements RemoteAccessor by cancelling any unfinished
+// large file uploads to the given path.
+func (a *B2Accessor) DeleteIncompleteUpload(path string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	iter := a.bucket.List(ctx, b2.ListUnfinished())
+	for iter.Next() {
+		obj := iter.Object()
+		if obj.Name() != path {
+			continue
+		}
+		if err := obj.Delete(ctx); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+// ErrorIsNotExists implements RemoteAccessor by deferring to blazer.
+func (a *B2Accessor) ErrorIsNotExists(err error) bool {
+	return b2.IsNotExist(err) || os.IsNotExist(err)
+}
+
+// ErrorIsNoQuota implements RemoteAccessor by looking for B2's cap exceeded
+// error.
+func (a *B2Accessor) ErrorIsNoQuota(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "cap_exceeded") || strings.Contains(msg, "cap exceeded")
+}
+
+// Target implements RemoteAccessor by returning the initial target we were
+// configured with.
+func (a *B2Accessor) Target() string {
+	return a.target
+}
+
+// RemotePath implements RemoteAccessor by using the initially configured base
+// path.
+func (a *B2Accessor) RemotePath(relPath string) string {
+	return filepath.Join(a.basePath, relPath)
+}
+
+// LocalPath implements RemoteAccessor by including the initially configured
+// bucket in the return value.
+func (a *B2Accessor) LocalPath(baseDir, remotePath string) string {
+	return filepath.Join(baseDir, b2Scheme, a.bucket.Name(), remotePath)
+}
@@ -0,0 +1,113 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestB2(t *testing.T) {
+	Convey("B2 targets are parsed in to bucket and base path", t, func() {
+		bucket, basePath, err := parseTarget(b2Scheme, "b2://mybucket/sub/dir/")
+		So(err, ShouldBeNil)
+		So(bucket, ShouldEqual, "mybucket")
+		So(basePath, ShouldEqual, "sub/dir")
+
+		bucket, basePath, err = parseTarget(b2Scheme, "b2://mybucket")
+		So(err, ShouldBeNil)
+		So(bucket, ShouldEqual, "mybucket")
+		So(basePath, ShouldEqual, "")
+
+		_, _, err = parseTarget(b2Scheme, "")
+		So(err, ShouldNotBeNil)
+		_, _, err = parseTarget(b2Scheme, "s3://mybucket/sub")
+		So(err, ShouldNotBeNil)
+		_, _, err = parseTarget(b2Scheme, "b2:///sub")
+		So(err, ShouldNotBeNil)
+	})
+
+	// For the remaining tests to work, MUXFYS_B2_TARGET must be a
+	// b2://bucket/subdir that you have read and write permissions for, and
+	// B2_KEY_ID and B2_APPLICATION_KEY must be set.
+	target := os.Getenv("MUXFYS_B2_TARGET")
+	keyID := os.Getenv("B2_KEY_ID")
+	appKey := os.Getenv("B2_APPLICATION_KEY")
+	if target == "" || keyID == "" || appKey == "" {
+		SkipConvey("Without MUXFYS_B2_TARGET, B2_KEY_ID and B2_APPLICATION_KEY environment variables, we'll skip remote B2 tests", t, func() {})
+		return
+	}
+
+	Convey("You can make a B2Accessor and use it to upload, list, read and delete", t, func() {
+		accessor, err := NewB2Accessor(&B2Config{
+			Target:         target,
+			KeyID:          keyID,
+			ApplicationKey: appKey,
+		})
+		So(err, ShouldBeNil)
+
+		tmpdir, err := ioutil.TempDir("", "muxfys_testing")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tmpdir)
+
+		source := filepath.Join(tmpdir, "source")
+		err = ioutil.WriteFile(source, []byte("0123456789"), 0600)
+		So(err, ShouldBeNil)
+
+		remotePath := accessor.RemotePath("muxfys_b2_test.file")
+		err = accessor.UploadFile(source, remotePath, "text/plain")
+		So(err, ShouldBeNil)
+		defer accessor.DeleteFile(remotePath)
+
+		ras, err := accessor.ListEntries(accessor.RemotePath("") + "/")
+		So(err, ShouldBeNil)
+		var found bool
+		for _, ra := range ras {
+			if ra.Name == remotePath {
+				found = true
+				So(ra.Size, ShouldEqual, 10)
+			}
+		}
+		So(found, ShouldBeTrue)
+
+		rc, err := accessor.OpenFile(remotePath, 5)
+		So(err, ShouldBeNil)
+		b, err := ioutil.ReadAll(rc)
+		So(err, ShouldBeNil)
+		So(string(b), ShouldEqual, "56789")
+
+		rc, err = accessor.Seek(remotePath, rc, 2)
+		So(err, ShouldBeNil)
+		b, err = ioutil.ReadAll(rc)
+		So(err, ShouldBeNil)
+		So(string(b), ShouldEqual, "23456789")
+		rc.Close()
+
+		err = accessor.DeleteFile(remotePath)
+		So(err, ShouldBeNil)
+		rc, err = accessor.OpenFile(remotePath, 0)
+		So(err, ShouldBeNil)
+		_, err = ioutil.ReadAll(rc)
+		So(accessor.ErrorIsNotExists(err), ShouldBeTrue)
+	})
+}
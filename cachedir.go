@@ -0,0 +1,128 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+// This file contains the implementation of the clean up of temporary cache
+// directories left behind by processes that were killed while mounted.
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	// tmpCachePrefix is the prefix of the name of the cache directories we
+	// create in CacheBase when a RemoteConfig has CacheData but no CacheDir.
+	tmpCachePrefix = ".muxfys_cache"
+
+	// ownerFile is the name of the file we create in such a cache directory,
+	// recording the host and pid of the process using it.
+	ownerFile = ".muxfys_owner"
+)
+
+// makeTmpCacheDir creates a new uniquely named cache directory in cacheBase,
+// recording that it belongs to the current process.
+func makeTmpCacheDir(cacheBase string) (string, error) {
+	cacheDir, err := ioutil.TempDir(cacheBase, tmpCachePrefix)
+	if err != nil {
+		return "", err
+	}
+	host, err := os.Hostname()
+	if err == nil {
+		owner := fmt.Sprintf("%s %d\n", host, os.Getpid())
+		err = ioutil.WriteFile(filepath.Join(cacheDir, ownerFile), []byte(owner), os.FileMode(fileMode))
+	}
+	if err != nil {
+		os.RemoveAll(cacheDir)
+		return "", err
+	}
+	return cacheDir, nil
+}
+
+// cleanupStaleCaches removes the temporary cache directories in cacheBase that
+// belong to processes on this host that are no longer running. Directories
+// with no record of their owner, or that belong to processes on other hosts
+// (when cacheBase is on a shared file system) are left alone. It returns the
+// directories removed.
+func cleanupStaleCaches(cacheBase string) ([]string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	dirs, err := filepath.Glob(filepath.Join(cacheBase, tmpCachePrefix+"*"))
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	var errs []string
+	for _, dir := range dirs {
+		owner, err := ioutil.ReadFile(filepath.Join(dir, ownerFile))
+		if err != nil {
+			continue
+		}
+		fields := strings.Fields(string(owner))
+		if len(fields) != 2 || fields[0] != host {
+			continue
+		}
+		pid, err := strconv.Atoi(fields[1])
+		if err != nil || pid <= 0 || processAlive(pid) {
+			continue
+		}
+
+		err = os.RemoveAll(dir)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		removed = append(removed, dir)
+	}
+
+	if len(errs) > 0 {
+		return removed, fmt.Errorf("failed to remove stale caches: %s", strings.Join(errs, "; "))
+	}
+	return removed, nil
+}
+
+// processAlive tells you if a process with the given pid is running on this
+// host.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
+}
+
+// CacheDir returns the cache directory being used by the currently mounted
+// remote with the given Target() (as also reported by Targets()). It returns
+// an empty string if no such remote is mounted, or if it isn't caching data.
+func (fs *MuxFys) CacheDir(target string) string {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	for _, r := range fs.remotes {
+		if r.accessor.Target() == target {
+			return r.cacheDir
+		}
+	}
+	return ""
+}
@@ -0,0 +1,119 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/inconshreveable/log15"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCacheDir(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "muxfys_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	source := filepath.Join(tmpdir, "source")
+	os.MkdirAll(source, os.FileMode(0777))
+	cacheBase := filepath.Join(tmpdir, "cacheBase")
+	os.MkdirAll(cacheBase, os.FileMode(0777))
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+
+	host, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// get the pid of a process that is no longer running
+	cmd := exec.Command("true")
+	err = cmd.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadPid := cmd.Process.Pid
+
+	makeCache := func(name, owner string) string {
+		dir := filepath.Join(cacheBase, name)
+		err := os.MkdirAll(filepath.Join(dir, "bucket"), os.FileMode(0700))
+		So(err, ShouldBeNil)
+		if owner != "" {
+			err = ioutil.WriteFile(filepath.Join(dir, ownerFile), []byte(owner), os.FileMode(0600))
+			So(err, ShouldBeNil)
+		}
+		return dir
+	}
+
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+
+	Convey("Temporary cache dirs record their owner", t, func() {
+		r, err := newRemote(&RemoteConfig{Accessor: &localAccessor{target: source}, CacheData: true}, cacheBase, 1, logger)
+		So(err, ShouldBeNil)
+		defer r.deleteCache()
+		So(r.cacheIsTmp, ShouldBeTrue)
+		So(filepath.Base(r.cacheDir), ShouldStartWith, tmpCachePrefix)
+
+		owner, err := ioutil.ReadFile(filepath.Join(r.cacheDir, ownerFile))
+		So(err, ShouldBeNil)
+		So(string(owner), ShouldEqual, fmt.Sprintf("%s %d\n", host, os.Getpid()))
+
+		Convey("CacheDir() finds the cache dir of a remote", func() {
+			fs, err := New(&Config{Mount: filepath.Join(tmpdir, "cacheDirMount"), CacheBase: cacheBase})
+			So(err, ShouldBeNil)
+			So(fs.CacheDir(r.accessor.Target()), ShouldBeEmpty)
+			fs.remotes = []*remote{r}
+			So(fs.CacheDir(r.accessor.Target()), ShouldEqual, r.cacheDir)
+			So(fs.CacheDir("/not/a/target"), ShouldBeEmpty)
+		})
+	})
+
+	Convey("CleanupStaleCaches only deletes the caches of dead processes on this host", t, func() {
+		dead := makeCache(tmpCachePrefix+"dead", fmt.Sprintf("%s %d\n", host, deadPid))
+		alive := makeCache(tmpCachePrefix+"alive", fmt.Sprintf("%s %d\n", host, os.Getpid()))
+		other := makeCache(tmpCachePrefix+"other", fmt.Sprintf("not.%s %d\n", host, deadPid))
+		unowned := makeCache(tmpCachePrefix+"unowned", "")
+		corrupt := makeCache(tmpCachePrefix+"corrupt", "garbage")
+		unrelated := makeCache("unrelated", fmt.Sprintf("%s %d\n", host, deadPid))
+
+		_, err := New(&Config{Mount: filepath.Join(tmpdir, "staleMount"), CacheBase: cacheBase})
+		So(err, ShouldBeNil)
+		So(exists(dead), ShouldBeTrue)
+
+		_, err = New(&Config{Mount: filepath.Join(tmpdir, "staleMount"), CacheBase: cacheBase, CleanupStaleCaches: true})
+		So(err, ShouldBeNil)
+		So(exists(dead), ShouldBeFalse)
+		So(exists(alive), ShouldBeTrue)
+		So(exists(other), ShouldBeTrue)
+		So(exists(unowned), ShouldBeTrue)
+		So(exists(corrupt), ShouldBeTrue)
+		So(exists(unrelated), ShouldBeTrue)
+	})
+}
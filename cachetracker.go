@@ -59,8 +59,9 @@ func (c *CacheTracker) Uncached(path string, iv Interval) Intervals {
 }
 
 // CacheTruncate should be used to update the tracker if you truncate a cache
-// file. The internal knowledge of what you have cached for that file will then
-// be updated to exclude anything beyond the truncation point.
+// file to the given offset (its new size). The internal knowledge of what you
+// have cached for that file will then be updated to exclude anything at or
+// beyond that offset.
 func (c *CacheTracker) CacheTruncate(path string, offset int64) {
 	c.Lock()
 	defer c.Unlock()
@@ -84,6 +85,14 @@ func (c *CacheTracker) CacheRename(oldPath, newPath string) {
 	delete(c.cached, oldPath)
 }
 
+// CacheLink should be used if you hard link a cache file on disk, so that
+// newPath is known to have the same cached content as oldPath.
+func (c *CacheTracker) CacheLink(oldPath, newPath string) {
+	c.Lock()
+	defer c.Unlock()
+	c.cached[newPath] = append(Intervals(nil), c.cached[oldPath]...)
+}
+
 // CacheDelete should be used if you delete a cache file.
 func (c *CacheTracker) CacheDelete(path string) {
 	c.Lock()
@@ -97,3 +106,10 @@ func (c *CacheTracker) CacheWipe() {
 	defer c.Unlock()
 	c.cached = make(map[string]Intervals)
 }
+
+// intervals returns a copy of the Intervals recorded for the given path.
+func (c *CacheTracker) intervals(path string) Intervals {
+	c.Lock()
+	defer c.Unlock()
+	return append(Intervals(nil), c.cached[path]...)
+}
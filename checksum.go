@@ -0,0 +1,108 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+// This file contains the implementation of RemoteConfig.VerifyChecksum.
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// checksummer returns our accessor as a ChecksumAccessor, if we're configured
+// to VerifyChecksum and it is one.
+func (r *remote) checksummer() (ChecksumAccessor, bool) {
+	if !r.verifyChecksum {
+		return nil, false
+	}
+	ca, ok := r.accessor.(ChecksumAccessor)
+	return ca, ok
+}
+
+// uploadSHA256 returns the hex encoded SHA256 of the given local file, for
+// storing alongside it when we upload it, if we're configured to
+// VerifyChecksum and our accessor is a ChecksumAccessor. Otherwise it returns
+// an empty string.
+func (r *remote) uploadSHA256(localPath string) (string, error) {
+	if _, ok := r.checksummer(); !ok {
+		return "", nil
+	}
+	return fileHash(localPath, sha256.New())
+}
+
+// verifyDownload checks, if we're configured to VerifyChecksum, that the given
+// local file has the same checksum as the remote file it was just downloaded
+// from. If not, the local file is deleted and an error wrapping
+// ErrChecksumMismatch is returned.
+func (r *remote) verifyDownload(remotePath, localPath string) error {
+	ca, ok := r.checksummer()
+	if !ok {
+		return nil
+	}
+	remoteMD5, remoteSHA256, err := ca.Checksums(remotePath)
+	if err != nil {
+		return err
+	}
+	remoteMD5 = strings.Trim(remoteMD5, "\"")
+
+	var h hash.Hash
+	var kind, expected string
+	switch {
+	case remoteMD5 != "" && !strings.Contains(remoteMD5, "-"):
+		h, kind, expected = md5.New(), "MD5", remoteMD5
+	case remoteSHA256 != "":
+		h, kind, expected = sha256.New(), "SHA256", remoteSHA256
+	default:
+		r.Warn("Remote checksum unknown, download not verified", "path", remotePath)
+		return nil
+	}
+
+	sum, err := fileHash(localPath, h)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(sum, expected) {
+		return nil
+	}
+	errr := os.Remove(localPath)
+	if errr != nil && !os.IsNotExist(errr) {
+		r.Warn("Could not remove corrupt download", "path", localPath, "err", errr)
+	}
+	return fmt.Errorf("%w: %s of download of %s was %s, expected %s", ErrChecksumMismatch, kind, remotePath, sum, expected)
+}
+
+// fileHash returns the hex encoded hash of the contents of the given file,
+// calculated using the given hash.
+func fileHash(path string, h hash.Hash) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,128 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCommit(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "muxfys_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	cacheBase := filepath.Join(tmpdir, "base")
+	err = os.MkdirAll(cacheBase, os.FileMode(0700))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("With a slow writeable remote that has a created file", t, func() {
+		mem := NewMemoryAccessor("commit")
+		fi := NewFaultInjector(mem)
+		fs, r := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "mnt"), CacheBase: cacheBase}, &RemoteConfig{Accessor: fi, CacheData: true, Write: true})
+
+		file, status := fs.Create("new.file", uint32(os.O_WRONLY), 0644, nil)
+		So(status, ShouldEqual, fuse.OK)
+		_, status = file.Write([]byte("new"), 0)
+		So(status, ShouldEqual, fuse.OK)
+		file.Release()
+
+		latency := 500 * time.Millisecond
+		fi.AddRule(FaultRule{Method: "UploadFile", Latency: latency})
+		committed := make(chan error, 1)
+		go func() {
+			committed <- fs.Commit()
+		}()
+		waitForUploadStart := func() {
+			limit := time.After(latency)
+			for fi.Calls("UploadFile") == 0 {
+				select {
+				case <-limit:
+					return
+				case <-time.After(5 * time.Millisecond):
+				}
+			}
+		}
+		waitForUploadStart()
+		So(fi.Calls("UploadFile"), ShouldEqual, 1)
+
+		Convey("GetAttr() is not blocked by a Commit() in progress", func() {
+			start := time.Now()
+			attr, status := fs.GetAttr("new.file", nil)
+			So(status, ShouldEqual, fuse.OK)
+			So(attr.Size, ShouldEqual, 3)
+			So(time.Since(start), ShouldBeLessThan, latency/2)
+
+			So(<-committed, ShouldBeNil)
+			fs.mapMutex.RLock()
+			So(fs.createdFiles["new.file"], ShouldBeFalse)
+			fs.mapMutex.RUnlock()
+		})
+
+		Convey("A file altered during the Commit() is uploaded again later", func() {
+			<-time.After(10 * time.Millisecond)
+			file, status := fs.Open("new.file", uint32(os.O_WRONLY), nil)
+			So(status, ShouldEqual, fuse.OK)
+			_, status = file.Write([]byte("newer"), 0)
+			So(status, ShouldEqual, fuse.OK)
+			file.Release()
+
+			So(<-committed, ShouldBeNil)
+			fs.mapMutex.RLock()
+			So(fs.createdFiles["new.file"], ShouldBeTrue)
+			fs.mapMutex.RUnlock()
+
+			fi.ClearRules()
+			So(fs.Commit(), ShouldBeNil)
+			fs.mapMutex.RLock()
+			So(fs.createdFiles["new.file"], ShouldBeFalse)
+			fs.mapMutex.RUnlock()
+		})
+
+		Convey("Unlink() during the Commit() waits for the upload, so the file isn't re-created remotely", func() {
+			So(fs.Unlink("new.file", nil), ShouldEqual, fuse.OK)
+			So(<-committed, ShouldBeNil)
+
+			_, exists := mem.Get(r.getRemotePath("new.file"))
+			So(exists, ShouldBeFalse)
+			_, status := fs.GetAttr("new.file", nil)
+			So(status, ShouldEqual, fuse.ENOENT)
+		})
+
+		Convey("Rename() during the Commit() waits for the upload, so the old name isn't re-created remotely", func() {
+			So(fs.Rename("new.file", "renamed.file", nil), ShouldEqual, fuse.OK)
+			So(<-committed, ShouldBeNil)
+
+			_, exists := mem.Get(r.getRemotePath("new.file"))
+			So(exists, ShouldBeFalse)
+			data, exists := mem.Get(r.getRemotePath("renamed.file"))
+			So(exists, ShouldBeTrue)
+			So(string(data), ShouldEqual, "new")
+		})
+	})
+}
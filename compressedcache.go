@@ -0,0 +1,608 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+// This file implements the compressed cache used when RemoteConfig
+// CacheCompress is true. Cache files are stored as a series of independently
+// gzipped blocks, each holding compressedBlockSize bytes of the uncompressed
+// file (the last block may be shorter). Blocks are appended to the cache file
+// in the order they are first read, and an index file next to it records the
+// location of each block, so that random reads only need to decompress the
+// blocks they touch.
+//
+// Files of writeable remotes are decompressed to plain cache files while they
+// are open for writing (or being truncated, renamed or uploaded), so that they
+// can be worked on like those of remotes without CacheCompress. Once the last
+// handle is closed, they are compressed again, if all their data is cached.
+// Plain cache files are those without an index file.
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/hanwen/go-fuse/v2/fuse/nodefs"
+	"github.com/inconshreveable/log15"
+)
+
+const (
+	// compressedBlockSize is the number of uncompressed bytes in each
+	// compressed block of a cache file.
+	compressedBlockSize = 1048576
+
+	// blockIndexPrefix is prefixed to the basename of compressed cache files to
+	// get the name of their index file.
+	blockIndexPrefix = ".muxfys_blocks."
+
+	// blockIndexMagic starts every index file, to identify the format.
+	blockIndexMagic = "MUXFYSB1"
+
+	// compressingPrefix is prefixed to the basename of cache files to get the
+	// name of the temporary file they are compressed or decompressed in to.
+	compressingPrefix = ".muxfys_compressing."
+)
+
+// blockLocation describes where a compressed block is stored in a cache file.
+type blockLocation struct {
+	Offset int64
+	Length int64
+}
+
+// blockIndexRecord is how a blockLocation is stored in an index file.
+type blockIndexRecord struct {
+	Block  int64
+	Offset int64
+	Length int64
+}
+
+// blockCache manages the compressed cache file for one remote file. All the
+// open compressedFiles for the same remote file share a blockCache.
+type blockCache struct {
+	dataPath  string
+	indexPath string
+	size      int64
+	blocks    map[int64]blockLocation
+	end       int64
+	retired   bool
+	mutex     sync.Mutex
+}
+
+// errRetiredBlockCache is returned by blockCache methods once the blockCache's
+// files have been decompressed or recompressed, and so must no longer be used.
+var errRetiredBlockCache = fmt.Errorf("compressed cache has been retired")
+
+// openBlockCache loads the index of an existing compressed cache file at
+// localPath, or creates a new empty one if there isn't a valid one for a file
+// of the given uncompressed size.
+func openBlockCache(localPath string, size int64) (*blockCache, error) {
+	bc := &blockCache{
+		dataPath:  localPath,
+		indexPath: blockIndexPath(localPath),
+		size:      size,
+		blocks:    make(map[int64]blockLocation),
+	}
+
+	err := os.MkdirAll(filepath.Dir(localPath), os.FileMode(dirMode))
+	if err != nil {
+		return nil, err
+	}
+
+	if bc.loadIndex() == nil {
+		return bc, nil
+	}
+	return bc, bc.reset()
+}
+
+// blockIndexPath returns the path of the index file of the given compressed
+// cache file.
+func blockIndexPath(localPath string) string {
+	return filepath.Join(filepath.Dir(localPath), blockIndexPrefix+filepath.Base(localPath))
+}
+
+// loadIndex reads our index file, returning an error if it doesn't exist or
+// doesn't match our data file or size. If our size is negative, it is set to
+// the size recorded in the index.
+func (bc *blockCache) loadIndex() error {
+	index, err := ioutil.ReadFile(bc.indexPath)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(bc.dataPath)
+	if err != nil {
+		return err
+	}
+
+	reader := bytes.NewReader(index)
+	magic := make([]byte, len(blockIndexMagic))
+	if _, err = io.ReadFull(reader, magic); err != nil || string(magic) != blockIndexMagic {
+		return fmt.Errorf("not a compressed cache index")
+	}
+	var size int64
+	if err = binary.Read(reader, binary.LittleEndian, &size); err != nil {
+		return err
+	}
+	if bc.size < 0 {
+		bc.size = size
+	} else if size != bc.size {
+		return fmt.Errorf("compressed cache is for a file of a different size")
+	}
+
+	for {
+		var record blockIndexRecord
+		err = binary.Read(reader, binary.LittleEndian, &record)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// a partially written final record is ignored
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if record.Offset+record.Length > info.Size() {
+			break
+		}
+		bc.blocks[record.Block] = blockLocation{Offset: record.Offset, Length: record.Length}
+	}
+	bc.end = info.Size()
+	return nil
+}
+
+// reset deletes any existing cached data and starts a new index.
+func (bc *blockCache) reset() error {
+	bc.blocks = make(map[int64]blockLocation)
+	bc.end = 0
+
+	err := ioutil.WriteFile(bc.dataPath, nil, os.FileMode(fileMode))
+	if err != nil {
+		return err
+	}
+
+	header := new(bytes.Buffer)
+	header.WriteString(blockIndexMagic)
+	err = binary.Write(header, binary.LittleEndian, bc.size)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(bc.indexPath, header.Bytes(), os.FileMode(fileMode))
+}
+
+// blockInterval returns the uncompressed interval covered by the given block.
+func (bc *blockCache) blockInterval(block int64) Interval {
+	start := block * compressedBlockSize
+	length := bc.size - start
+	if length > compressedBlockSize {
+		length = compressedBlockSize
+	}
+	return NewInterval(start, length)
+}
+
+// cachedIntervals returns the uncompressed intervals of all the blocks we have
+// stored.
+func (bc *blockCache) cachedIntervals() Intervals {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+	ivs := make(Intervals, 0, len(bc.blocks))
+	for block := range bc.blocks {
+		ivs = append(ivs, bc.blockInterval(block))
+	}
+	return ivs
+}
+
+// has tells you if the given block has been stored.
+func (bc *blockCache) has(block int64) bool {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+	_, exists := bc.blocks[block]
+	return exists
+}
+
+// store compresses the given uncompressed data and appends it to our data
+// file as the given block, recording its location in our index.
+func (bc *blockCache) store(block int64, data []byte) error {
+	compressed := new(bytes.Buffer)
+	gz := gzip.NewWriter(compressed)
+	if _, err := gz.Write(data); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+	if bc.retired {
+		return errRetiredBlockCache
+	}
+	if _, exists := bc.blocks[block]; exists {
+		return nil
+	}
+
+	// *** like uncompressed caches, this isn't safe if multiple processes
+	// share the same permanent cache folder
+	err := appendToFile(bc.dataPath, compressed.Bytes())
+	if err != nil {
+		return err
+	}
+	location := blockLocation{Offset: bc.end, Length: int64(compressed.Len())}
+	bc.end += location.Length
+
+	record := new(bytes.Buffer)
+	err = binary.Write(record, binary.LittleEndian, blockIndexRecord{Block: block, Offset: location.Offset, Length: location.Length})
+	if err != nil {
+		return err
+	}
+	err = appendToFile(bc.indexPath, record.Bytes())
+	if err != nil {
+		return err
+	}
+
+	bc.blocks[block] = location
+	return nil
+}
+
+// load reads and decompresses the given block.
+func (bc *blockCache) load(block int64) ([]byte, error) {
+	bc.mutex.Lock()
+	location, exists := bc.blocks[block]
+	retired := bc.retired
+	bc.mutex.Unlock()
+	if retired {
+		return nil, errRetiredBlockCache
+	}
+	if !exists {
+		return nil, fmt.Errorf("block %d of %s has not been cached", block, bc.dataPath)
+	}
+
+	f, err := os.Open(bc.dataPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(io.NewSectionReader(f, location.Offset, location.Length))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	iv := bc.blockInterval(block)
+	data := make([]byte, iv.Length())
+	_, err = io.ReadFull(gz, data)
+	return data, err
+}
+
+// retire stops us being used, before our files are replaced.
+func (bc *blockCache) retire() {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+	bc.retired = true
+}
+
+// appendToFile appends data to the file at path.
+func appendToFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, os.FileMode(fileMode))
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	errc := f.Close()
+	if err == nil {
+		err = errc
+	}
+	return err
+}
+
+// compressedFile is muxfys' implementation of pathfs.File for reading data from
+// a remote file via a compressed cache file. It is read-only.
+type compressedFile struct {
+	byteCounter
+	nodefs.File
+	r          *remote
+	localPath  string
+	bc         *blockCache
+	remoteFile *remoteFile
+	lastBlock  int64
+	lastData   []byte
+	mutex      sync.Mutex
+	log15.Logger
+}
+
+// newCompressedFile makes a compressedFile that reads each block of remotePath
+// only once, storing it compressed in the cache, and returning subsequent reads
+// from the cache.
+func newCompressedFile(r *remote, remotePath string, attr *fuse.Attr, logger log15.Logger) (nodefs.File, fuse.Status) {
+	localPath := r.getLocalPath(remotePath)
+	bc, err := r.getBlockCache(localPath, int64(attr.Size))
+	if err != nil {
+		logger.Error("Could not open compressed cache", "path", localPath, "err", err)
+		return nil, fuse.ToStatus(err)
+	}
+
+	return &compressedFile{
+		File:       nodefs.NewDefaultFile(),
+		r:          r,
+		localPath:  localPath,
+		bc:         bc,
+		remoteFile: newRemoteFile(r, remotePath, attr, false, logger).(*remoteFile),
+		lastBlock:  -1,
+		Logger:     logger.New("rpath", remotePath, "lpath", localPath),
+	}, fuse.OK
+}
+
+// Read decompresses the blocks of our cache file that hold the requested bytes,
+// first getting and storing any blocks we haven't read before from the remote
+// file.
+func (f *compressedFile) Read(buf []byte, offset int64) (fuse.ReadResult, fuse.Status) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if offset >= f.bc.size {
+		// nothing to read
+		return nil, fuse.OK
+	}
+	end := offset + int64(len(buf))
+	if end > f.bc.size {
+		end = f.bc.size
+	}
+
+	var n int
+	for block := offset / compressedBlockSize; block*compressedBlockSize < end; block++ {
+		data, status := f.block(block)
+		if status != fuse.OK {
+			return nil, status
+		}
+
+		blockStart := block * compressedBlockSize
+		from := offset + int64(n) - blockStart
+		n += copy(buf[n:end-offset], data[from:])
+	}
+
+	f.countRead(int64(n))
+	return fuse.ReadResultData(buf[:n]), fuse.OK
+}
+
+// block returns the uncompressed data of the given block, reading it from the
+// remote file if we haven't done so before.
+func (f *compressedFile) block(block int64) ([]byte, fuse.Status) {
+	if block == f.lastBlock {
+		return f.lastData, fuse.OK
+	}
+
+	// (if our cache file has since been decompressed for writing, we carry on
+	// reading from the remote file, without caching)
+	iv := f.bc.blockInterval(block)
+	data, err := f.bc.load(block)
+	if err == nil && len(f.r.Uncached(f.localPath, iv)) > 0 {
+		err = fmt.Errorf("block %d of %s is no longer cached", block, f.localPath)
+	}
+	if err != nil {
+		data = make([]byte, iv.Length())
+		_, status := f.remoteFile.Read(data, iv.Start)
+		if status != fuse.OK {
+			// we warn instead of error because this is a "normal" situation
+			// when trying to read from non-existent files
+			f.Warn("Read failed", "status", status)
+			return nil, status
+		}
+
+		err = f.bc.store(block, data)
+		switch err {
+		case nil:
+			f.r.Cached(f.localPath, iv)
+		case errRetiredBlockCache:
+		default:
+			f.Error("Failed to store compressed cache block", "block", block, "err", err)
+			return nil, fuse.EIO
+		}
+	}
+
+	f.lastBlock = block
+	f.lastData = data
+	return data, fuse.OK
+}
+
+// Release is called before the file handle is forgotten, so we free our memory
+// and the remote file.
+func (f *compressedFile) Release() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.lastBlock = -1
+	f.lastData = nil
+	f.remoteFile.Release()
+}
+
+// holdPlain makes the given cache file a plain, uncompressed one, for the use
+// of a cachedFile or other code that works on plain cache files, until a
+// matching releasePlain() call. If always is false, it only does this (and
+// returns true) if the file is already plain, eg. because it was written to
+// but not all of it is cached.
+func (r *remote) holdPlain(localPath string, always bool) (bool, error) {
+	r.bcMutex.Lock()
+	defer r.bcMutex.Unlock()
+	if !always && r.plainHolds[localPath] == 0 {
+		if _, err := os.Stat(localPath); err != nil {
+			return false, nil
+		}
+		if _, err := os.Stat(blockIndexPath(localPath)); err == nil {
+			return false, nil
+		}
+	}
+	if err := r.decompressCache(localPath); err != nil {
+		return false, err
+	}
+	r.plainHolds[localPath]++
+	return true, nil
+}
+
+// releasePlain undoes a holdPlain(). Once there are no more holds on the given
+// cache file, it is compressed again if all of it is cached.
+func (r *remote) releasePlain(localPath string) {
+	r.bcMutex.Lock()
+	defer r.bcMutex.Unlock()
+	r.plainHolds[localPath]--
+	if r.plainHolds[localPath] > 0 {
+		return
+	}
+	delete(r.plainHolds, localPath)
+	if err := r.compressCache(localPath); err != nil {
+		r.Warn("Could not compress cache file", "path", localPath, "err", err)
+	}
+}
+
+// decompressCache turns the given compressed cache file in to a plain one,
+// holding the same cached data. It does nothing if the file isn't compressed.
+// You must hold the bcMutex.
+func (r *remote) decompressCache(localPath string) error {
+	indexPath := blockIndexPath(localPath)
+	if _, err := os.Stat(indexPath); err != nil {
+		return nil
+	}
+	r.retireBlockCache(localPath)
+
+	bc := &blockCache{dataPath: localPath, indexPath: indexPath, size: -1, blocks: make(map[int64]blockLocation)}
+	if err := bc.loadIndex(); err != nil {
+		// there's nothing usable cached
+		r.Warn("Discarding bad compressed cache file", "path", localPath, "err", err)
+		r.CacheDelete(localPath)
+		return removeFiles(localPath, indexPath)
+	}
+
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+	tmpPath := filepath.Join(filepath.Dir(localPath), compressingPrefix+filepath.Base(localPath))
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(fileMode))
+	if err != nil {
+		return err
+	}
+	err = f.Truncate(bc.size)
+	for block := range bc.blocks {
+		if err != nil {
+			break
+		}
+		var data []byte
+		data, err = bc.load(block)
+		if err == nil {
+			_, err = f.WriteAt(data, block*compressedBlockSize)
+		}
+	}
+	if errc := f.Close(); err == nil {
+		err = errc
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, localPath)
+	}
+	if err != nil {
+		removeFiles(tmpPath) // (best effort; we report the original error)
+		return err
+	}
+	err = os.Chtimes(localPath, info.ModTime(), info.ModTime())
+	if err != nil {
+		return err
+	}
+
+	// (a previous mount may have cached blocks we don't know about)
+	for _, iv := range bc.cachedIntervals() {
+		r.Cached(localPath, iv)
+	}
+	return removeFiles(indexPath)
+}
+
+// compressCache turns the given plain cache file in to a compressed one, if
+// all of it is cached; otherwise it is left plain. If the file no longer
+// exists, any index it had is removed. You must hold the bcMutex.
+func (r *remote) compressCache(localPath string) error {
+	r.retireBlockCache(localPath)
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return removeFiles(blockIndexPath(localPath))
+	}
+	size := info.Size()
+	if size > 0 && len(r.Uncached(localPath, NewInterval(0, size))) > 0 {
+		return nil
+	}
+
+	src, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmpPath := filepath.Join(filepath.Dir(localPath), compressingPrefix+filepath.Base(localPath))
+	bc := &blockCache{dataPath: tmpPath, indexPath: blockIndexPath(tmpPath), size: size, blocks: make(map[int64]blockLocation)}
+	err = bc.reset()
+	for block := int64(0); err == nil && block*compressedBlockSize < size; block++ {
+		iv := bc.blockInterval(block)
+		data := make([]byte, iv.Length())
+		if _, err = src.ReadAt(data, iv.Start); err == nil {
+			err = bc.store(block, data)
+		}
+	}
+
+	// we move the index first, so that if we're interrupted the data can't be
+	// mistaken for a plain cache file
+	if err == nil {
+		err = os.Rename(bc.indexPath, blockIndexPath(localPath))
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, localPath)
+	}
+	if err != nil {
+		removeFiles(tmpPath, bc.indexPath, blockIndexPath(localPath))
+		return err
+	}
+	return os.Chtimes(localPath, info.ModTime(), info.ModTime())
+}
+
+// retireBlockCache stops any blockCache in use for the given cache file being
+// used, so that its files can be replaced. You must hold the bcMutex.
+func (r *remote) retireBlockCache(localPath string) {
+	if bc, exists := r.blockCaches[localPath]; exists {
+		bc.retire()
+		delete(r.blockCaches, localPath)
+	}
+}
+
+// forgetCompressed removes the index of the given cache file, for when the
+// file has been deleted.
+func (r *remote) forgetCompressed(localPath string) {
+	r.bcMutex.Lock()
+	defer r.bcMutex.Unlock()
+	r.retireBlockCache(localPath)
+	if err := removeFiles(blockIndexPath(localPath)); err != nil {
+		r.Warn("Could not remove compressed cache index", "path", localPath, "err", err)
+	}
+}
+
+// removeFiles removes the given files, ignoring any that don't exist.
+func removeFiles(paths ...string) error {
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
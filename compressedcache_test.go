@@ -0,0 +1,214 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/inconshreveable/log15"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCompressedCache(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "muxfys_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	source := filepath.Join(tmpdir, "source")
+	os.MkdirAll(source, os.FileMode(0777))
+	cacheDir := filepath.Join(tmpdir, "cache")
+
+	// a compressible file spanning a few blocks, with a short final block
+	var content bytes.Buffer
+	for i := 0; content.Len() < 2*compressedBlockSize+1000; i++ {
+		fmt.Fprintf(&content, "chr1\t%d\tACGTACGTACGT\n", i)
+	}
+	data := content.Bytes()
+	sourceFile := filepath.Join(source, "big.txt")
+	err = ioutil.WriteFile(sourceFile, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attr := &fuse.Attr{Size: uint64(len(data))}
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	accessor := &localAccessor{target: source}
+
+	read := func(r *remote, offset, length int) ([]byte, fuse.Status) {
+		file, status := newCompressedFile(r, sourceFile, attr, logger)
+		if status != fuse.OK {
+			return nil, status
+		}
+		defer file.Release()
+		rr, status := file.Read(make([]byte, length), int64(offset))
+		if status != fuse.OK || rr == nil {
+			return nil, status
+		}
+		b, _ := rr.Bytes(nil)
+		return b, status
+	}
+
+	Convey("With CacheCompress and Write, files are compressed once closed", t, func() {
+		defer os.RemoveAll(cacheDir)
+		a := NewMemoryAccessor("compressed")
+		a.Put("dir/big.txt", data)
+
+		fs, r := newTestFS(&Config{Mount: filepath.Join(tmpdir, "mount"), CacheBase: tmpdir}, &RemoteConfig{Accessor: a, CacheDir: cacheDir, CacheCompress: true, Write: true})
+		_, status := fs.OpenDir("", nil)
+		So(status, ShouldEqual, fuse.OK)
+		_, status = fs.OpenDir("dir", nil)
+		So(status, ShouldEqual, fuse.OK)
+
+		compressed := func(name string) bool {
+			_, err := os.Stat(blockIndexPath(r.getLocalPath(r.getRemotePath(name))))
+			return err == nil
+		}
+		readAll := func(name string) []byte {
+			attr, status := fs.GetAttr(name, nil)
+			So(status, ShouldEqual, fuse.OK)
+			file, status := fs.Open(name, uint32(os.O_RDONLY), nil)
+			So(status, ShouldEqual, fuse.OK)
+			defer file.Release()
+			rr, status := file.Read(make([]byte, attr.Size), 0)
+			So(status, ShouldEqual, fuse.OK)
+			b, _ := rr.Bytes(nil)
+			return b
+		}
+
+		So(readAll("dir/big.txt")[:100], ShouldResemble, data[:100])
+		So(compressed("dir/big.txt"), ShouldBeTrue)
+
+		Convey("Created files are compressed when released, and uploaded intact", func() {
+			file, status := fs.Create("dir/new.txt", uint32(os.O_WRONLY), uint32(0644), nil)
+			So(status, ShouldEqual, fuse.OK)
+			_, status = file.Write(data, 0)
+			So(status, ShouldEqual, fuse.OK)
+			So(compressed("dir/new.txt"), ShouldBeFalse)
+			file.Release()
+			So(compressed("dir/new.txt"), ShouldBeTrue)
+			So(readAll("dir/new.txt"), ShouldResemble, data)
+
+			So(fs.uploadCreated(), ShouldBeNil)
+			got, exists := a.Get("dir/new.txt")
+			So(exists, ShouldBeTrue)
+			So(got, ShouldResemble, data)
+			So(compressed("dir/new.txt"), ShouldBeTrue)
+		})
+
+		Convey("Modified files are decompressed while open, and keep their cached data", func() {
+			file, status := fs.Open("dir/big.txt", uint32(os.O_RDWR), nil)
+			So(status, ShouldEqual, fuse.OK)
+			So(compressed("dir/big.txt"), ShouldBeFalse)
+			_, status = file.Write([]byte("XYZ"), 10)
+			So(status, ShouldEqual, fuse.OK)
+			file.Release()
+
+			expected := append([]byte{}, data...)
+			copy(expected[10:], "XYZ")
+			So(readAll("dir/big.txt"), ShouldResemble, expected)
+			So(compressed("dir/big.txt"), ShouldBeTrue)
+
+			So(fs.uploadCreated(), ShouldBeNil)
+			got, exists := a.Get("dir/big.txt")
+			So(exists, ShouldBeTrue)
+			So(got, ShouldResemble, expected)
+			So(compressed("dir/big.txt"), ShouldBeTrue)
+
+			Convey("Renamed files stay compressed", func() {
+				So(fs.Rename("dir/big.txt", "dir/moved.txt", nil), ShouldEqual, fuse.OK)
+				So(compressed("dir/big.txt"), ShouldBeFalse)
+				So(compressed("dir/moved.txt"), ShouldBeTrue)
+				So(readAll("dir/moved.txt"), ShouldResemble, expected)
+			})
+		})
+	})
+
+	Convey("With CacheCompress, reads are cached compressed", t, func() {
+		defer os.RemoveAll(cacheDir)
+		r, err := newRemote(&RemoteConfig{Accessor: accessor, CacheDir: cacheDir, CacheCompress: true}, tmpdir, 1, logger)
+		So(err, ShouldBeNil)
+		So(r.cacheData, ShouldBeTrue)
+		localPath := r.getLocalPath(sourceFile)
+
+		// a read spanning the first 2 blocks
+		offset := compressedBlockSize - 100
+		b, status := read(r, offset, 200)
+		So(status, ShouldEqual, fuse.OK)
+		So(b, ShouldResemble, data[offset:offset+200])
+		So(r.Uncached(localPath, NewInterval(0, 2*compressedBlockSize)), ShouldBeEmpty)
+		So(r.Uncached(localPath, NewInterval(2*compressedBlockSize, 1000)), ShouldHaveLength, 1)
+
+		info, err := os.Stat(localPath)
+		So(err, ShouldBeNil)
+		So(info.Size(), ShouldBeGreaterThan, 0)
+		So(info.Size(), ShouldBeLessThan, 2*compressedBlockSize/4)
+
+		// reads past the end are truncated
+		b, status = read(r, len(data)-10, 100)
+		So(status, ShouldEqual, fuse.OK)
+		So(b, ShouldResemble, data[len(data)-10:])
+		b, status = read(r, len(data), 100)
+		So(status, ShouldEqual, fuse.OK)
+		So(b, ShouldBeEmpty)
+
+		Convey("Cached blocks are read without the remote", func() {
+			resetMutex.Lock()
+			resetFail = true
+			resetMutex.Unlock()
+			defer func() {
+				resetMutex.Lock()
+				resetFail = false
+				resetMutex.Unlock()
+			}()
+
+			b, status := read(r, 5, 1000)
+			So(status, ShouldEqual, fuse.OK)
+			So(b, ShouldResemble, data[5:1005])
+
+			Convey("Even after a remount with the same CacheDir", func() {
+				r2, err := newRemote(&RemoteConfig{Accessor: accessor, CacheDir: cacheDir, CacheCompress: true}, tmpdir, 1, logger)
+				So(err, ShouldBeNil)
+				b, status := read(r2, compressedBlockSize+7, 5000)
+				So(status, ShouldEqual, fuse.OK)
+				So(b, ShouldResemble, data[compressedBlockSize+7:compressedBlockSize+5007])
+				So(r2.Uncached(localPath, NewInterval(0, int64(len(data)))), ShouldBeEmpty)
+			})
+		})
+
+		Convey("A change in size invalidates the cache", func() {
+			r2, err := newRemote(&RemoteConfig{Accessor: accessor, CacheDir: cacheDir, CacheCompress: true}, tmpdir, 1, logger)
+			So(err, ShouldBeNil)
+			_, err = r2.getBlockCache(localPath, int64(len(data)-1))
+			So(err, ShouldBeNil)
+			So(r2.Uncached(localPath, NewInterval(0, 10)), ShouldHaveLength, 1)
+			info, err := os.Stat(localPath)
+			So(err, ShouldBeNil)
+			So(info.Size(), ShouldEqual, 0)
+		})
+	})
+}
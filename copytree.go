@@ -0,0 +1,127 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+// This file contains the implementation of RemoteConfig.CopyTreeTo().
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// ServerSideCopier is an optional interface that RemoteAccessors can also
+// implement, so that RemoteConfig.CopyTreeTo() can copy files to them from
+// other RemoteAccessors without downloading the files.
+type ServerSideCopier interface {
+	// CanCopyFrom should return true if CopyFileFrom() can copy files from
+	// the given RemoteAccessor, eg. because it accesses the same host.
+	CanCopyFrom(src RemoteAccessor) bool
+
+	// CopyFileFrom should do a remote copy of source (a path of src) to our
+	// dest without involving the local file system.
+	CopyFileFrom(src RemoteAccessor, source, dest string) error
+}
+
+// CopyTreeError is returned by RemoteConfig.CopyTreeTo() when some files
+// couldn't be copied. It maps the names of those files to the reason why.
+type CopyTreeError map[string]error
+
+// Error implements error, listing the files that failed.
+func (e CopyTreeError) Error() string {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msgs := make([]string, len(names))
+	for i, name := range names {
+		msgs[i] = fmt.Sprintf("%s: %s", name, e[name])
+	}
+	return fmt.Sprintf("failed to copy %d files: %s", len(names), strings.Join(msgs, "; "))
+}
+
+// CopyTreeTo copies all the files that would be in a mount of this
+// RemoteConfig (see List()) to the same relative paths of dest's Accessor,
+// without needing to mount anything. Only dest's Accessor is used, none of
+// its other options.
+//
+// If dest's Accessor is a ServerSideCopier that can copy from our Accessor,
+// files are copied remotely. Otherwise each file is streamed from our Accessor
+// to dest's using OpenFile() and UploadData(). Failures to copy individual
+// files don't stop the others being copied, and are returned together as a
+// CopyTreeError.
+func (c *RemoteConfig) CopyTreeTo(dest *RemoteConfig) error {
+	if dest == nil || dest.Accessor == nil {
+		return fmt.Errorf("no destination Accessor supplied")
+	}
+	r, err := c.uncachedRemote()
+	if err != nil {
+		return err
+	}
+	ras, err := r.list(true)
+	if err != nil {
+		return err
+	}
+
+	copier, serverSide := dest.Accessor.(ServerSideCopier)
+	serverSide = serverSide && copier.CanCopyFrom(r.accessor)
+
+	failed := make(CopyTreeError)
+	for _, ra := range ras {
+		if strings.HasSuffix(ra.Name, "/") {
+			continue
+		}
+		source := r.getRemotePath(path.Join(r.mountSubpath, ra.Name))
+		target := dest.Accessor.RemotePath(ra.Name)
+		if serverSide {
+			err = copier.CopyFileFrom(r.accessor, source, target)
+		} else {
+			err = copyStreamed(r.accessor, source, dest.Accessor, target)
+		}
+		if err != nil {
+			failed[ra.Name] = err
+		}
+	}
+
+	if len(failed) > 0 {
+		return failed
+	}
+	return nil
+}
+
+// copyStreamed copies source from src to dest of dst by reading it from src
+// while uploading it to dst.
+func copyStreamed(src RemoteAccessor, source string, dst RemoteAccessor, dest string) error {
+	rc, err := src.OpenFile(source, 0)
+	if err != nil {
+		return err
+	}
+	err = dst.UploadData(rc, dest)
+	if errc := rc.Close(); err == nil {
+		err = errc
+	}
+	if err != nil {
+		if errd := dst.DeleteIncompleteUpload(dest); errd != nil {
+			pkgLogger.Warn("Deletion of incomplete upload failed", "path", dest, "err", errd)
+		}
+	}
+	return err
+}
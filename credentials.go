@@ -0,0 +1,133 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+// This file contains the implementation of S3Config.CredentialsProvider.
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	minio "github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const (
+	// defaultCredentialsRefreshInterval is the default for
+	// S3Config.CredentialsRefreshInterval.
+	defaultCredentialsRefreshInterval = 15 * time.Minute
+
+	// minCredentialsRefreshInterval is the least time we leave between calls
+	// to a CredentialsProvider due to requests being rejected, so that we
+	// don't call it for every request when access is genuinely forbidden.
+	minCredentialsRefreshInterval = 5 * time.Second
+)
+
+// s3CredentialsProvider is a credentials.Provider that gets credentials from
+// an S3Config.CredentialsProvider.
+type s3CredentialsProvider struct {
+	get       func() (accessKey, secretKey, sessionToken string, err error)
+	interval  time.Duration
+	refreshed time.Time
+	rejected  bool
+	mutex     sync.Mutex
+}
+
+// newS3CredentialsProvider creates a s3CredentialsProvider that calls get
+// every interval (or defaultCredentialsRefreshInterval if interval is 0).
+func newS3CredentialsProvider(get func() (string, string, string, error), interval time.Duration) *s3CredentialsProvider {
+	if interval <= 0 {
+		interval = defaultCredentialsRefreshInterval
+	}
+	return &s3CredentialsProvider{get: get, interval: interval}
+}
+
+// Retrieve implements credentials.Provider by calling our get function.
+func (p *s3CredentialsProvider) Retrieve() (credentials.Value, error) {
+	accessKey, secretKey, sessionToken, err := p.get()
+	if err != nil {
+		return credentials.Value{}, err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.refreshed = time.Now()
+	p.rejected = false
+
+	signer := credentials.SignatureV4
+	if accessKey == "" && secretKey == "" {
+		signer = credentials.SignatureAnonymous
+	}
+	return credentials.Value{
+		AccessKeyID:     accessKey,
+		SecretAccessKey: secretKey,
+		SessionToken:    sessionToken,
+		SignerType:      signer,
+	}, nil
+}
+
+// IsExpired implements credentials.Provider, returning true if our interval
+// has passed since we last retrieved credentials, or if a request was rejected
+// since then.
+func (p *s3CredentialsProvider) IsExpired() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.rejected || time.Since(p.refreshed) >= p.interval
+}
+
+// reject notes that a request was rejected, possibly due to our credentials
+// having become invalid, so they should be retrieved again, unless that was
+// only just done.
+func (p *s3CredentialsProvider) reject() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if time.Since(p.refreshed) >= minCredentialsRefreshInterval {
+		p.rejected = true
+	}
+}
+
+// watch returns an http.RoundTripper that wraps the given one (or minio's
+// default if nil), calling reject() when a response is 401 or 403.
+func (p *s3CredentialsProvider) watch(transport http.RoundTripper, secure bool) (http.RoundTripper, error) {
+	if transport == nil {
+		tr, err := minio.DefaultTransport(secure)
+		if err != nil {
+			return nil, err
+		}
+		transport = tr
+	}
+	return &rejectionWatcher{RoundTripper: transport, provider: p}, nil
+}
+
+// rejectionWatcher is an http.RoundTripper that tells a s3CredentialsProvider
+// about rejected requests.
+type rejectionWatcher struct {
+	http.RoundTripper
+	provider *s3CredentialsProvider
+}
+
+// RoundTrip implements http.RoundTripper.
+func (w *rejectionWatcher) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := w.RoundTripper.RoundTrip(req)
+	if err == nil && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+		w.provider.reject()
+	}
+	return resp, err
+}
@@ -0,0 +1,79 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+// This file contains the implementation of RemoteConfig.EagerCacheBelow.
+
+import (
+	"os"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// eagerCacheWorkers is the most files that will be downloaded at once due to
+// RemoteConfig.EagerCacheBelow.
+const eagerCacheWorkers = 4
+
+// eagerCache downloads the given files of the given remote in to its cache in
+// the background, skipping any already fully cached. You must hold the
+// mapMutex.
+func (fs *MuxFys) eagerCache(r *remote, names []string) {
+	for _, name := range names {
+		size := int64(fs.files[name].Size)
+		if len(r.Uncached(r.getLocalPath(r.getRemotePath(name)), NewInterval(0, size))) == 0 {
+			continue
+		}
+		fs.eagerWG.Add(1)
+		go func(name string, size int64) {
+			defer fs.eagerWG.Done()
+			fs.eagerSem <- struct{}{}
+			defer func() {
+				<-fs.eagerSem
+			}()
+			fs.eagerlyCache(name, size)
+		}(name, size)
+	}
+}
+
+// eagerlyCache reads the whole of the given file the same way a user's read
+// via the mount would, so that it ends up in the cache.
+func (fs *MuxFys) eagerlyCache(name string, size int64) {
+	file, status := fs.Open(name, uint32(os.O_RDONLY), nil)
+	if status != fuse.OK {
+		fs.Warn("Eager caching could not open file", "path", name, "status", status)
+		return
+	}
+	defer file.Release()
+
+	bufSize := size
+	if bufSize > fs.readBlockSize {
+		bufSize = fs.readBlockSize
+	}
+	buf := make([]byte, bufSize)
+	for offset := int64(0); offset < size; offset += bufSize {
+		rr, status := file.Read(buf, offset)
+		if rr != nil {
+			rr.Done()
+		}
+		if status != fuse.OK {
+			fs.Warn("Eager caching failed", "path", name, "status", status)
+			return
+		}
+	}
+}
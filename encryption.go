@@ -0,0 +1,375 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+// This file implements the client-side encryption used when RemoteConfig
+// EncryptionKey is set. Objects are stored as a header (encryptionMagic
+// followed by a random nonce) and then a series of chunks, each holding
+// encryptionChunkSize bytes of plaintext (the last may be shorter) sealed with
+// AES-256-GCM. Each chunk's nonce is derived from the object's nonce and the
+// chunk's index, and its index and whether it is the final chunk are
+// authenticated, so chunks can't be reordered and objects can't be truncated
+// without detection. Because every chunk has the same size, the ciphertext
+// offset of any plaintext offset can be calculated, so ranged reads only need
+// to download and decrypt the chunks they touch.
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// encryptionChunkSize is the number of plaintext bytes in each encrypted
+	// chunk of an object.
+	encryptionChunkSize = 65536
+
+	// encryptionMagic starts every encrypted object, to identify the format.
+	encryptionMagic = "MUXFYSE1"
+
+	// encryptionKeySize is the required length of an EncryptionKey, for
+	// AES-256.
+	encryptionKeySize = 32
+
+	encryptionNonceSize  = 12
+	encryptionTagSize    = 16
+	encryptionHeaderSize = int64(len(encryptionMagic) + encryptionNonceSize)
+	encryptedChunkSize   = int64(encryptionChunkSize + encryptionTagSize)
+)
+
+// encryptingAccessor is a RemoteAccessor that wraps another one, encrypting
+// data before it is uploaded and decrypting it after it is downloaded.
+type encryptingAccessor struct {
+	RemoteAccessor
+	aead cipher.AEAD
+}
+
+// newEncryptingAccessor wraps the given accessor so that file contents are
+// encrypted with the given AES-256 key.
+func newEncryptingAccessor(accessor RemoteAccessor, key []byte) (*encryptingAccessor, error) {
+	if len(key) != encryptionKeySize {
+		return nil, fmt.Errorf("EncryptionKey must be %d bytes long, not %d", encryptionKeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptingAccessor{RemoteAccessor: accessor, aead: aead}, nil
+}
+
+// chunkNonce returns the nonce for the given chunk of an object with the given
+// base nonce.
+func chunkNonce(base []byte, chunk int64) []byte {
+	nonce := make([]byte, encryptionNonceSize)
+	copy(nonce, base)
+	counter := binary.BigEndian.Uint64(nonce[encryptionNonceSize-8:])
+	binary.BigEndian.PutUint64(nonce[encryptionNonceSize-8:], counter^uint64(chunk))
+	return nonce
+}
+
+// chunkAD returns the additional data authenticated along with the given
+// chunk.
+func chunkAD(chunk int64, final bool) []byte {
+	ad := make([]byte, 9)
+	binary.BigEndian.PutUint64(ad, uint64(chunk))
+	if final {
+		ad[8] = 1
+	}
+	return ad
+}
+
+// decryptedSize returns the plaintext size of an encrypted object with the
+// given size.
+func decryptedSize(size int64) int64 {
+	size -= encryptionHeaderSize
+	if size <= 0 {
+		return 0
+	}
+	plain := (size / encryptedChunkSize) * encryptionChunkSize
+	if rem := size % encryptedChunkSize; rem > encryptionTagSize {
+		plain += rem - encryptionTagSize
+	}
+	return plain
+}
+
+// DownloadFile implements RemoteAccessor by decrypting the remote source file
+// as it is downloaded to dest.
+func (a *encryptingAccessor) DownloadFile(source, dest string) error {
+	reader, err := a.OpenFile(source, 0)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	err = os.MkdirAll(filepath.Dir(dest), os.FileMode(dirMode))
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, reader)
+	errc := f.Close()
+	if err == nil {
+		err = errc
+	}
+	if err != nil {
+		errr := os.Remove(dest)
+		if errr != nil && !os.IsNotExist(errr) {
+			err = fmt.Errorf("%s (and removing the partial download failed: %s)", err, errr)
+		}
+	}
+	return err
+}
+
+// UploadFile implements RemoteAccessor by encrypting the local source file as
+// it is uploaded. contentType is not recorded, since the remote file will not
+// be of that type.
+func (a *encryptingAccessor) UploadFile(source, dest, contentType string) error {
+	f, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return a.UploadData(f, dest)
+}
+
+// UploadData implements RemoteAccessor by encrypting the data as it is
+// uploaded.
+func (a *encryptingAccessor) UploadData(data io.Reader, dest string) error {
+	nonce := make([]byte, encryptionNonceSize)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return err
+	}
+	return a.RemoteAccessor.UploadData(&encryptReader{
+		aead:   a.aead,
+		nonce:  nonce,
+		source: bufio.NewReaderSize(data, encryptionChunkSize),
+		buf:    append([]byte(encryptionMagic), nonce...),
+		plain:  make([]byte, encryptionChunkSize),
+	}, dest)
+}
+
+// ListEntries implements RemoteAccessor by reporting the decrypted sizes of
+// files.
+func (a *encryptingAccessor) ListEntries(dir string) ([]RemoteAttr, error) {
+	ras, err := a.RemoteAccessor.ListEntries(dir)
+	if err != nil {
+		return nil, err
+	}
+	for i := range ras {
+		if strings.HasSuffix(ras[i].Name, "/") {
+			continue
+		}
+		ras[i].Size = decryptedSize(ras[i].Size)
+		ras[i].MD5 = ""
+	}
+	return ras, nil
+}
+
+// OpenFile implements RemoteAccessor by returning a reader that decrypts the
+// remote file from the given plaintext offset.
+func (a *encryptingAccessor) OpenFile(path string, offset int64) (io.ReadCloser, error) {
+	rc, err := a.RemoteAccessor.OpenFile(path, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, encryptionHeaderSize)
+	_, err = io.ReadFull(rc, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		// (some accessors only report non-existent files on first read)
+		rc.Close()
+		return nil, err
+	}
+	if err != nil || string(header[:len(encryptionMagic)]) != encryptionMagic {
+		rc.Close()
+		return nil, fmt.Errorf("%s is not an encrypted muxfys object", path)
+	}
+
+	dr := &decryptReader{
+		a:      a,
+		path:   path,
+		rc:     rc,
+		br:     bufio.NewReaderSize(rc, int(encryptedChunkSize)),
+		nonce:  header[len(encryptionMagic):],
+		cipher: make([]byte, encryptedChunkSize),
+		skip:   offset,
+		fresh:  true,
+	}
+	if offset >= encryptionChunkSize {
+		// (we're already at the start of the first chunk otherwise)
+		err = dr.seek(offset)
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+	}
+	return dr, nil
+}
+
+// Seek implements RemoteAccessor by seeking the underlying remote file to the
+// start of the chunk holding the given plaintext offset.
+func (a *encryptingAccessor) Seek(path string, rc io.ReadCloser, offset int64) (io.ReadCloser, error) {
+	dr, ok := rc.(*decryptReader)
+	if !ok {
+		return nil, fmt.Errorf("Seek was not given a reader from OpenFile")
+	}
+	return dr, dr.seek(offset)
+}
+
+// encryptReader is an io.Reader that encrypts the data it reads from source.
+type encryptReader struct {
+	aead   cipher.AEAD
+	nonce  []byte
+	source *bufio.Reader
+	buf    []byte
+	plain  []byte
+	chunk  int64
+	done   bool
+}
+
+// Read implements io.Reader by returning the header followed by each encrypted
+// chunk of source.
+func (e *encryptReader) Read(p []byte) (int, error) {
+	for len(e.buf) == 0 {
+		if e.done {
+			return 0, io.EOF
+		}
+
+		n, err := io.ReadFull(e.source, e.plain)
+		switch err {
+		case nil:
+			if _, errp := e.source.Peek(1); errp == io.EOF {
+				e.done = true
+			} else if errp != nil {
+				return 0, errp
+			}
+		case io.EOF, io.ErrUnexpectedEOF:
+			e.done = true
+		default:
+			return 0, err
+		}
+
+		e.buf = e.aead.Seal(e.buf[:0], chunkNonce(e.nonce, e.chunk), e.plain[:n], chunkAD(e.chunk, e.done))
+		e.chunk++
+	}
+
+	n := copy(p, e.buf)
+	e.buf = e.buf[n:]
+	return n, nil
+}
+
+// decryptReader is an io.ReadCloser that decrypts an object opened by an
+// encryptingAccessor.
+type decryptReader struct {
+	a      *encryptingAccessor
+	path   string
+	rc     io.ReadCloser
+	br     *bufio.Reader
+	nonce  []byte
+	cipher []byte
+	plain  []byte
+	chunk  int64
+	skip   int64
+	final  bool
+	fresh  bool
+}
+
+// seek positions us to read from the given plaintext offset.
+func (d *decryptReader) seek(offset int64) error {
+	d.chunk = offset / encryptionChunkSize
+	d.skip = offset % encryptionChunkSize
+	d.plain = nil
+	d.final = false
+	d.fresh = true
+
+	rc, err := d.a.RemoteAccessor.Seek(d.path, d.rc, encryptionHeaderSize+d.chunk*encryptedChunkSize)
+	if err != nil {
+		return err
+	}
+	d.rc = rc
+	d.br = bufio.NewReaderSize(rc, int(encryptedChunkSize))
+	return nil
+}
+
+// Read implements io.Reader by decrypting chunks as necessary.
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.final {
+			return 0, io.EOF
+		}
+
+		n, err := io.ReadFull(d.br, d.cipher)
+		switch err {
+		case nil:
+			if _, errp := d.br.Peek(1); errp == io.EOF {
+				d.final = true
+			} else if errp != nil {
+				return 0, errp
+			}
+		case io.EOF:
+			if d.fresh {
+				// we were asked to read from the end of the file (or beyond)
+				d.final = true
+				return 0, io.EOF
+			}
+			return 0, fmt.Errorf("%s is truncated", d.path)
+		case io.ErrUnexpectedEOF:
+			d.final = true
+		default:
+			return 0, err
+		}
+
+		plain, err := d.a.aead.Open(d.cipher[:0], chunkNonce(d.nonce, d.chunk), d.cipher[:n], chunkAD(d.chunk, d.final))
+		if err != nil {
+			return 0, fmt.Errorf("could not decrypt chunk %d of %s: %s", d.chunk, d.path, err)
+		}
+		d.chunk++
+		d.fresh = false
+
+		if d.skip > int64(len(plain)) {
+			d.skip = int64(len(plain))
+		}
+		d.plain = plain[d.skip:]
+		d.skip = 0
+	}
+
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// Close implements io.Closer by closing the underlying remote file.
+func (d *decryptReader) Close() error {
+	return d.rc.Close()
+}
@@ -0,0 +1,207 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/inconshreveable/log15"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEncryption(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "muxfys_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	source := filepath.Join(tmpdir, "source")
+	os.MkdirAll(source, os.FileMode(0777))
+
+	// content spanning a few chunks, with a short final chunk
+	var content bytes.Buffer
+	for i := 0; content.Len() < 2*encryptionChunkSize+1000; i++ {
+		fmt.Fprintf(&content, "line %d\n", i)
+	}
+	data := content.Bytes()
+	plainFile := filepath.Join(tmpdir, "plain")
+	err = ioutil.WriteFile(plainFile, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := bytes.Repeat([]byte{7}, encryptionKeySize)
+	accessor := &localAccessor{target: source}
+
+	Convey("You can't make an encryptingAccessor with a bad key", t, func() {
+		_, err := newEncryptingAccessor(accessor, []byte("short"))
+		So(err, ShouldNotBeNil)
+		_, err = newRemote(&RemoteConfig{Accessor: accessor, EncryptionKey: []byte("short")}, tmpdir, 1, log15.New())
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Encrypted sizes can be converted to decrypted sizes", t, func() {
+		for _, size := range []int64{0, 1, encryptionChunkSize - 1, encryptionChunkSize, encryptionChunkSize + 1, 3 * encryptionChunkSize} {
+			chunks := size/encryptionChunkSize + 1
+			if size > 0 && size%encryptionChunkSize == 0 {
+				chunks--
+			}
+			So(decryptedSize(encryptionHeaderSize+size+chunks*encryptionTagSize), ShouldEqual, size)
+		}
+		So(decryptedSize(0), ShouldEqual, 0)
+	})
+
+	Convey("With an encryptingAccessor", t, func() {
+		a, err := newEncryptingAccessor(accessor, key)
+		So(err, ShouldBeNil)
+		remotePath := a.RemotePath("enc.file")
+
+		err = a.UploadFile(plainFile, remotePath, "text/plain")
+		So(err, ShouldBeNil)
+
+		Convey("Uploaded files are encrypted", func() {
+			stored, err := ioutil.ReadFile(remotePath)
+			So(err, ShouldBeNil)
+			So(bytes.Contains(stored, []byte("line 1\n")), ShouldBeFalse)
+			So(int64(len(stored)), ShouldEqual, encryptionHeaderSize+int64(len(data))+3*encryptionTagSize)
+
+			Convey("And the same data gets a different nonce each time", func() {
+				err = a.UploadData(bytes.NewReader(data), remotePath)
+				So(err, ShouldBeNil)
+				stored2, err := ioutil.ReadFile(remotePath)
+				So(err, ShouldBeNil)
+				So(len(stored2), ShouldEqual, len(stored))
+				So(bytes.Equal(stored, stored2), ShouldBeFalse)
+			})
+		})
+
+		Convey("ListEntries gives decrypted sizes", func() {
+			ras, err := a.ListEntries(source)
+			So(err, ShouldBeNil)
+			So(ras, ShouldHaveLength, 1)
+			So(ras[0].Size, ShouldEqual, len(data))
+		})
+
+		Convey("DownloadFile decrypts", func() {
+			dest := filepath.Join(tmpdir, "download", "enc.file")
+			err := a.DownloadFile(remotePath, dest)
+			So(err, ShouldBeNil)
+			downloaded, err := ioutil.ReadFile(dest)
+			So(err, ShouldBeNil)
+			So(downloaded, ShouldResemble, data)
+		})
+
+		Convey("OpenFile and Seek work from any offset", func() {
+			for _, offset := range []int{0, 5, encryptionChunkSize - 1, encryptionChunkSize, 2*encryptionChunkSize + 10, len(data) - 1} {
+				rc, err := a.OpenFile(remotePath, int64(offset))
+				So(err, ShouldBeNil)
+				got, err := ioutil.ReadAll(rc)
+				So(err, ShouldBeNil)
+				So(got, ShouldResemble, data[offset:])
+
+				rc, err = a.Seek(remotePath, rc, 3)
+				So(err, ShouldBeNil)
+				got, err = ioutil.ReadAll(rc)
+				So(err, ShouldBeNil)
+				So(got, ShouldResemble, data[3:])
+				rc.Close()
+			}
+
+			rc, err := a.OpenFile(remotePath, int64(len(data)))
+			So(err, ShouldBeNil)
+			got, err := ioutil.ReadAll(rc)
+			So(err, ShouldBeNil)
+			So(got, ShouldBeEmpty)
+			rc.Close()
+		})
+
+		Convey("Empty files can be stored", func() {
+			err := a.UploadData(strings.NewReader(""), remotePath)
+			So(err, ShouldBeNil)
+			rc, err := a.OpenFile(remotePath, 0)
+			So(err, ShouldBeNil)
+			got, err := ioutil.ReadAll(rc)
+			So(err, ShouldBeNil)
+			So(got, ShouldBeEmpty)
+			rc.Close()
+		})
+
+		Convey("Tampering and truncation are detected", func() {
+			stored, err := ioutil.ReadFile(remotePath)
+			So(err, ShouldBeNil)
+
+			tampered := append([]byte{}, stored...)
+			tampered[encryptionHeaderSize+10] ^= 1
+			err = ioutil.WriteFile(remotePath, tampered, 0644)
+			So(err, ShouldBeNil)
+			rc, err := a.OpenFile(remotePath, 0)
+			So(err, ShouldBeNil)
+			_, err = ioutil.ReadAll(rc)
+			So(err, ShouldNotBeNil)
+			rc.Close()
+
+			err = ioutil.WriteFile(remotePath, stored[:encryptionHeaderSize+encryptedChunkSize], 0644)
+			So(err, ShouldBeNil)
+			rc, err = a.OpenFile(remotePath, 0)
+			So(err, ShouldBeNil)
+			_, err = ioutil.ReadAll(rc)
+			So(err, ShouldNotBeNil)
+			rc.Close()
+		})
+
+		Convey("The wrong key can't decrypt", func() {
+			b, err := newEncryptingAccessor(accessor, bytes.Repeat([]byte{8}, encryptionKeySize))
+			So(err, ShouldBeNil)
+			rc, err := b.OpenFile(remotePath, 0)
+			So(err, ShouldBeNil)
+			_, err = ioutil.ReadAll(rc)
+			So(err, ShouldNotBeNil)
+			rc.Close()
+		})
+
+		Convey("Unencrypted and missing files can't be opened", func() {
+			_, err := a.OpenFile(plainFile, 0)
+			So(err, ShouldNotBeNil)
+			_, err = a.OpenFile(a.RemotePath("missing.file"), 0)
+			So(err, ShouldNotBeNil)
+			So(a.ErrorIsNotExists(err), ShouldBeTrue)
+		})
+
+		Convey("It can be used by MuxFys", func() {
+			fs, _ := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "encMount"), CacheBase: tmpdir}, &RemoteConfig{Accessor: accessor, EncryptionKey: key, Write: true})
+			So(fs.files["enc.file"].Size, ShouldEqual, len(data))
+
+			file, status := fs.Open("enc.file", uint32(os.O_RDONLY), nil)
+			So(status, ShouldEqual, fuse.OK)
+			rr, status := file.Read(make([]byte, 100), encryptionChunkSize+5)
+			So(status, ShouldEqual, fuse.OK)
+			got, _ := rr.Bytes(nil)
+			So(got, ShouldResemble, data[encryptionChunkSize+5:encryptionChunkSize+105])
+			file.Release()
+		})
+	})
+}
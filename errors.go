@@ -0,0 +1,120 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+import (
+	"errors"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// These are the kinds of failure that the errors returned by MuxFys' non-FUSE
+// methods (such as ReadAt(), IsCached(), Commit() and RefreshDir()) and by
+// RemoteConfig.List() can be checked for with errors.Is(), regardless of
+// whether the failure came from the filesystem or a RemoteAccessor.
+var (
+	// ErrNotFound means the file or directory doesn't exist, either in the
+	// mount or on the remote.
+	ErrNotFound = errors.New("not found")
+
+	// ErrPermission means the operation isn't allowed, either because the
+	// mount or remote is read-only, or because the remote denied access.
+	ErrPermission = errors.New("permission denied")
+
+	// ErrRemoteUnavailable means the remote couldn't be used, eg. because of
+	// network problems, even after retrying, or because it is Pause()d.
+	ErrRemoteUnavailable = errors.New("remote unavailable")
+
+	// ErrNotCached means the data isn't, and can't be, in the local cache,
+	// such as when the remote doesn't have CacheData enabled.
+	ErrNotCached = errors.New("not cached")
+)
+
+// Error is the type of the errors that wrap one of ErrNotFound, ErrPermission,
+// ErrRemoteUnavailable or ErrNotCached. errors.Is() matches it against its Kind,
+// and also against its underlying cause, which for failed filesystem operations
+// is a syscall.Errno, and for failed remote calls is the RemoteAccessor's error.
+type Error struct {
+	// Kind is one of the Err* variables above, or nil if the failure doesn't
+	// fit any of them.
+	Kind error
+
+	// Err is the underlying cause.
+	Err error
+}
+
+// Error returns the message of the underlying cause.
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying cause.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is returns true if target is our Kind.
+func (e *Error) Is(target error) bool {
+	return e.Kind != nil && target == e.Kind
+}
+
+// statusErr converts a non-OK fuse.Status from one of our filesystem methods
+// to an *Error of the appropriate Kind.
+func statusErr(status fuse.Status) error {
+	return &Error{Kind: statusKind(status), Err: statusError(status)}
+}
+
+// statusKind tells you which of our Err* variables best describes the given
+// fuse.Status, returning nil if none do.
+func statusKind(status fuse.Status) error {
+	switch status {
+	case fuse.ENOENT:
+		return ErrNotFound
+	case fuse.EPERM, fuse.EACCES, fuse.EROFS:
+		return ErrPermission
+	case fuse.EIO, pausedStatus, fuse.Status(syscall.ETIMEDOUT), fuse.Status(syscall.ECONNREFUSED),
+		fuse.Status(syscall.ECONNRESET), fuse.Status(syscall.ENETUNREACH), fuse.Status(syscall.EHOSTUNREACH):
+		return ErrRemoteUnavailable
+	}
+	return nil
+}
+
+// accessorErr converts an error from one of our RemoteAccessor's methods to an
+// *Error of the appropriate Kind. Errors that aren't about a missing file, or
+// a denial of access (if the RemoteAccessor is a ForbiddenDetector), are taken
+// to mean the remote is unavailable.
+func (r *remote) accessorErr(err error) error {
+	kind := ErrRemoteUnavailable
+	switch {
+	case r.accessor.ErrorIsNotExists(err):
+		kind = ErrNotFound
+	case r.accessorForbids(err):
+		kind = ErrPermission
+	}
+	return &Error{Kind: kind, Err: err}
+}
+
+// accessorForbids tells you if our RemoteAccessor is a ForbiddenDetector that
+// considers the given error to be a denial of access, regardless of
+// RemoteConfig.TreatForbiddenAsMissing. It's the configured Accessor that is
+// asked, not any wrapper of it we use for eg. EncryptionKey.
+func (r *remote) accessorForbids(err error) bool {
+	return r.forbidDetector != nil && r.forbidDetector.ErrorIsForbidden(err)
+}
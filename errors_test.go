@@ -0,0 +1,150 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestErrors(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "muxfys_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	cacheBase := filepath.Join(tmpdir, "base")
+	err = os.MkdirAll(cacheBase, os.FileMode(0700))
+	if err != nil {
+		t.Fatal(err)
+	}
+	networkErr := errors.New("connection refused")
+
+	Convey("An Error matches its Kind and its cause", t, func() {
+		err := statusErr(fuse.ENOENT)
+		So(errors.Is(err, ErrNotFound), ShouldBeTrue)
+		So(errors.Is(err, syscall.ENOENT), ShouldBeTrue)
+		So(errors.Is(err, ErrPermission), ShouldBeFalse)
+		So(err.Error(), ShouldEqual, syscall.ENOENT.Error())
+
+		So(errors.Is(statusErr(fuse.EROFS), ErrPermission), ShouldBeTrue)
+		So(errors.Is(statusErr(fuse.EIO), ErrRemoteUnavailable), ShouldBeTrue)
+		So(errors.Is(statusErr(pausedStatus), ErrRemoteUnavailable), ShouldBeTrue)
+		err = statusErr(fuse.EINVAL)
+		So(errors.Is(err, ErrNotFound), ShouldBeFalse)
+		So(errors.Is(err, ErrPermission), ShouldBeFalse)
+		So(errors.Is(err, ErrRemoteUnavailable), ShouldBeFalse)
+		So(errors.Is(err, ErrNotCached), ShouldBeFalse)
+		So(errors.Is(err, syscall.EINVAL), ShouldBeTrue)
+	})
+
+	Convey("Denials of access are recognised when the Accessor is wrapped", t, func() {
+		accessor := &forbidAccessor{localAccessor: &localAccessor{target: tmpdir}}
+		for _, rc := range []*RemoteConfig{
+			{Accessor: accessor},
+			{Accessor: accessor, EncryptionKey: make([]byte, 32)},
+			{Accessor: accessor, TransparentGunzip: true},
+		} {
+			r, err := newRemote(rc, cacheBase, 1, pkgLogger)
+			So(err, ShouldBeNil)
+			err = r.accessorErr(errForbidden)
+			So(errors.Is(err, ErrPermission), ShouldBeTrue)
+			So(errors.Is(err, ErrRemoteUnavailable), ShouldBeFalse)
+		}
+	})
+
+	Convey("The non-FUSE methods of a MuxFys return errors you can check", t, func() {
+		ma := NewMemoryAccessor("errors")
+		ma.Put("a.file", []byte("a"))
+		ma.Put("dir/b.file", []byte("b"))
+		fi := NewFaultInjector(ma)
+		fs, _ := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "mnt"), CacheBase: cacheBase}, &RemoteConfig{Accessor: fi})
+
+		Convey("ReadAt() of a missing file gives ErrNotFound", func() {
+			_, err := fs.ReadAt("missing.file", make([]byte, 1), 0)
+			So(errors.Is(err, ErrNotFound), ShouldBeTrue)
+			So(errors.Is(err, syscall.ENOENT), ShouldBeTrue)
+		})
+
+		Convey("ReadAt() when the remote fails gives ErrRemoteUnavailable", func() {
+			fi.AddRule(FaultRule{Err: networkErr})
+			_, err := fs.ReadAt("a.file", make([]byte, 1), 0)
+			So(errors.Is(err, ErrRemoteUnavailable), ShouldBeTrue)
+			So(errors.Is(err, ErrNotFound), ShouldBeFalse)
+		})
+
+		Convey("IsCached() when not caching gives ErrNotCached", func() {
+			_, _, err := fs.IsCached("a.file")
+			So(errors.Is(err, ErrNotCached), ShouldBeTrue)
+			_, _, err = fs.IsCached("missing.file")
+			So(errors.Is(err, ErrNotFound), ShouldBeTrue)
+		})
+
+		Convey("RefreshDir() gives ErrNotFound for unknown dirs, and ErrRemoteUnavailable if listing fails", func() {
+			err := fs.RefreshDir("missing")
+			So(errors.Is(err, ErrNotFound), ShouldBeTrue)
+			_, status := fs.OpenDir("dir", nil)
+			So(status, ShouldEqual, fuse.OK)
+			fi.AddRule(FaultRule{Method: "ListEntries", Err: networkErr})
+			err = fs.RefreshDir("dir")
+			So(errors.Is(err, ErrRemoteUnavailable), ShouldBeTrue)
+		})
+	})
+
+	Convey("Commit() gives ErrRemoteUnavailable if uploads fail", t, func() {
+		fi := NewFaultInjector(NewMemoryAccessor("commit"))
+		fs, _ := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "mnt"), CacheBase: cacheBase}, &RemoteConfig{Accessor: fi, CacheData: true, Write: true})
+
+		file, status := fs.Create("new.file", uint32(os.O_WRONLY), 0644, nil)
+		So(status, ShouldEqual, fuse.OK)
+		_, status = file.Write([]byte("new"), 0)
+		So(status, ShouldEqual, fuse.OK)
+		file.Release()
+
+		fi.AddRule(FaultRule{Method: "UploadFile", Err: networkErr})
+		err = fs.Commit()
+		So(errors.Is(err, ErrRemoteUnavailable), ShouldBeTrue)
+
+		fi.ClearRules()
+		So(fs.Commit(), ShouldBeNil)
+	})
+
+	Convey("RemoteConfig.List() returns errors you can check", t, func() {
+		fi := NewFaultInjector(NewMemoryAccessor("list"))
+		rc := &RemoteConfig{Accessor: fi}
+
+		fi.AddRule(FaultRule{Method: "ListEntries", Err: networkErr})
+		_, err := rc.List(false)
+		So(errors.Is(err, ErrRemoteUnavailable), ShouldBeTrue)
+		So(errors.Is(err, networkErr), ShouldBeTrue)
+
+		fi.ClearRules()
+		fi.AddRule(FaultRule{Method: "ListEntries", NotExists: true})
+		_, err = rc.List(false)
+		So(errors.Is(err, ErrNotFound), ShouldBeTrue)
+		So(errors.Is(err, ErrInjectedNotExists), ShouldBeTrue)
+	})
+}
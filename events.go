@@ -0,0 +1,187 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+// This file contains the implementation of the Events sent to a
+// Config.EventHandler.
+
+import (
+	"io"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// eventBufferSize is the number of Events we'll queue up for a slow
+// EventHandler before we start dropping them.
+const eventBufferSize = 1000
+
+// EventType describes the kind of operation an Event is about.
+type EventType int
+
+// These are the EventTypes you might receive. EventOpen, EventCreate,
+// EventDelete and EventRename happen when a file is opened, created, deleted
+// or renamed (or a directory deleted or renamed) via the mount.
+// EventDownload and EventUpload happen when a whole file is transferred to or
+// from the remote.
+const (
+	EventOpen EventType = iota
+	EventDownload
+	EventUpload
+	EventCreate
+	EventDelete
+	EventRename
+)
+
+// String returns a lower-case name for the EventType.
+func (t EventType) String() string {
+	switch t {
+	case EventOpen:
+		return "open"
+	case EventDownload:
+		return "download"
+	case EventUpload:
+		return "upload"
+	case EventCreate:
+		return "create"
+	case EventDelete:
+		return "delete"
+	case EventRename:
+		return "rename"
+	}
+	return "unknown"
+}
+
+// Event describes an operation that has just completed.
+type Event struct {
+	Type EventType
+
+	// Path is the path of the file or directory relative to the mount point
+	// for EventOpen, EventCreate, EventDelete and EventRename, and the
+	// complete remote path for EventDownload and EventUpload.
+	Path string
+
+	// NewPath is the path Path was renamed to, for EventRename.
+	NewPath string
+
+	// Bytes is the size of the file opened or transferred.
+	Bytes int64
+
+	// Duration is how long the operation took.
+	Duration time.Duration
+
+	// Err is the error the operation failed with, or nil if it succeeded.
+	Err error
+}
+
+// eventQueue buffers the Events we emit() for our EventHandler, which gets
+// them from its channel. Once closed, further Events are discarded.
+type eventQueue struct {
+	ch     chan Event
+	mutex  sync.RWMutex
+	closed bool
+}
+
+// send queues the given Event without blocking, dropping it if the queue is
+// full or closed.
+func (q *eventQueue) send(e Event) {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+	if q.closed {
+		return
+	}
+	select {
+	case q.ch <- e:
+	default:
+	}
+}
+
+// close closes our channel, so that our EventHandler goroutine ends once it
+// has handled the Events already queued. It is safe to call more than once.
+func (q *eventQueue) close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if !q.closed {
+		q.closed = true
+		close(q.ch)
+	}
+}
+
+// startEvents starts sending the Events we emit() to the given handler, which
+// will be called for one Event at a time.
+func (fs *MuxFys) startEvents(handler func(Event)) {
+	q := &eventQueue{ch: make(chan Event, eventBufferSize)}
+	fs.events = q
+	go func() {
+		for e := range q.ch {
+			handler(e)
+		}
+	}()
+}
+
+// emit sends an Event to our EventHandler, if we have one, without blocking:
+// if the EventHandler has fallen too far behind, or we've been Destroy()ed,
+// the Event is dropped.
+func (fs *MuxFys) emit(e Event) {
+	if fs.events == nil {
+		return
+	}
+	fs.events.send(e)
+}
+
+// emitStatus is a convenience for emit() that creates an Event from the
+// given details, working out the Duration from start and the Err from status.
+func (fs *MuxFys) emitStatus(t EventType, path string, bytes int64, start time.Time, status fuse.Status) {
+	if fs.events == nil {
+		return
+	}
+	fs.emit(Event{Type: t, Path: path, Bytes: bytes, Duration: time.Since(start), Err: statusError(status)})
+}
+
+// statusError converts a fuse.Status to an error, returning nil for fuse.OK.
+func statusError(status fuse.Status) error {
+	if status == fuse.OK {
+		return nil
+	}
+	return syscall.Errno(status)
+}
+
+// event is like MuxFys.emitStatus(), for use by a remote.
+func (r *remote) event(t EventType, path string, bytes int64, start time.Time, status fuse.Status) {
+	if r.emit == nil {
+		return
+	}
+	r.emit(Event{Type: t, Path: path, Bytes: bytes, Duration: time.Since(start), Err: statusError(status)})
+}
+
+// countingReader is an io.ReadCloser that counts the bytes read through it.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+// Read implements io.Reader by reading from the underlying reader and adding
+// to our count.
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
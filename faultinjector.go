@@ -0,0 +1,290 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+// This file implements FaultInjector, a RemoteAccessor for testing how things
+// cope with an unreliable remote.
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrInjectedNotExists is the error returned by FaultInjector for calls
+// matching a FaultRule with NotExists set. The FaultInjector's
+// ErrorIsNotExists() returns true for it.
+var ErrInjectedNotExists = errors.New("injected fault: file does not exist")
+
+// FaultRule describes a fault that a FaultInjector should inject in to the
+// calls it receives.
+type FaultRule struct {
+	// Method is the name of the RemoteAccessor method the rule applies to,
+	// eg. "DownloadFile". The default of "" means every method that would
+	// access the remote.
+	Method string
+
+	// Path, if not empty, limits the rule to calls involving this remote path,
+	// ie. the source or dest of downloads, uploads and copies, the dir of
+	// ListEntries() or the path of other methods.
+	Path string
+
+	// Nth, if greater than 0, makes the rule only apply to the Nth call it
+	// matches (counting from 1). The default of 0 applies it to every call it
+	// matches.
+	Nth int
+
+	// Latency is the time to wait before the call is made or fails.
+	Latency time.Duration
+
+	// Err, if not nil, is returned instead of making the call.
+	Err error
+
+	// NotExists makes the call fail with ErrInjectedNotExists instead of
+	// being made.
+	NotExists bool
+
+	// TruncateReads, if greater than 0, makes the readers returned by
+	// OpenFile() and Seek() fail with io.ErrUnexpectedEOF after returning this
+	// many bytes, as if the connection had been lost.
+	TruncateReads int64
+}
+
+// FaultInjector is a RemoteAccessor that wraps another one, delegating calls to
+// it except when a FaultRule says they should fail, be slow or return partial
+// data. It lets you deterministically test how your code copes with an
+// unreliable remote file system or object store, by using a FaultInjector as
+// the Accessor of a RemoteConfig.
+//
+// Note that the optional interfaces (such as PartialUploader) of the wrapped
+// RemoteAccessor are not implemented by the FaultInjector.
+type FaultInjector struct {
+	RemoteAccessor
+	rules  []FaultRule
+	counts []int
+	calls  map[string]int
+	mutex  sync.Mutex
+}
+
+// NewFaultInjector wraps the given accessor so that faults are injected
+// according to the given rules. More rules can be added later with AddRule().
+func NewFaultInjector(accessor RemoteAccessor, rules ...FaultRule) *FaultInjector {
+	f := &FaultInjector{
+		RemoteAccessor: accessor,
+		calls:          make(map[string]int),
+	}
+	for _, rule := range rules {
+		f.AddRule(rule)
+	}
+	return f
+}
+
+// AddRule adds a FaultRule that will apply to subsequent calls. Its Nth call is
+// counted from when it was added.
+func (f *FaultInjector) AddRule(rule FaultRule) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.rules = append(f.rules, rule)
+	f.counts = append(f.counts, 0)
+}
+
+// ClearRules removes all FaultRules, so that all subsequent calls are
+// delegated.
+func (f *FaultInjector) ClearRules() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.rules = nil
+	f.counts = nil
+}
+
+// Calls returns how many times the given RemoteAccessor method has been
+// called, including calls that were faulted.
+func (f *FaultInjector) Calls(method string) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.calls[method]
+}
+
+// fault counts a call to the given method involving the given paths, and
+// works out which faults apply to it. It sleeps for any injected latency,
+// then returns the error the call should fail with, and the number of bytes
+// reads should be truncated to (0 meaning no truncation).
+func (f *FaultInjector) fault(method string, paths ...string) (truncate int64, err error) {
+	f.mutex.Lock()
+	f.calls[method]++
+	var latency time.Duration
+	for i, rule := range f.rules {
+		if !rule.matches(method, paths) {
+			continue
+		}
+		f.counts[i]++
+		if rule.Nth > 0 && f.counts[i] != rule.Nth {
+			continue
+		}
+		latency += rule.Latency
+		if err == nil {
+			if rule.NotExists {
+				err = ErrInjectedNotExists
+			} else if rule.Err != nil {
+				err = rule.Err
+			}
+		}
+		if rule.TruncateReads > 0 && (truncate == 0 || rule.TruncateReads < truncate) {
+			truncate = rule.TruncateReads
+		}
+	}
+	f.mutex.Unlock()
+
+	if latency > 0 {
+		<-time.After(latency)
+	}
+	return truncate, err
+}
+
+// matches tells you if this rule applies to a call to the given method
+// involving the given paths.
+func (r FaultRule) matches(method string, paths []string) bool {
+	if r.Method != "" && r.Method != method {
+		return false
+	}
+	if r.Path == "" {
+		return true
+	}
+	for _, path := range paths {
+		if path == r.Path {
+			return true
+		}
+	}
+	return false
+}
+
+// DownloadFile implements RemoteAccessor by delegating unless faulted.
+func (f *FaultInjector) DownloadFile(source, dest string) error {
+	if _, err := f.fault("DownloadFile", source); err != nil {
+		return err
+	}
+	return f.RemoteAccessor.DownloadFile(source, dest)
+}
+
+// UploadFile implements RemoteAccessor by delegating unless faulted.
+func (f *FaultInjector) UploadFile(source, dest, contentType string) error {
+	if _, err := f.fault("UploadFile", dest); err != nil {
+		return err
+	}
+	return f.RemoteAccessor.UploadFile(source, dest, contentType)
+}
+
+// UploadData implements RemoteAccessor by delegating unless faulted.
+func (f *FaultInjector) UploadData(data io.Reader, dest string) error {
+	if _, err := f.fault("UploadData", dest); err != nil {
+		return err
+	}
+	return f.RemoteAccessor.UploadData(data, dest)
+}
+
+// ListEntries implements RemoteAccessor by delegating unless faulted.
+func (f *FaultInjector) ListEntries(dir string) ([]RemoteAttr, error) {
+	if _, err := f.fault("ListEntries", dir); err != nil {
+		return nil, err
+	}
+	return f.RemoteAccessor.ListEntries(dir)
+}
+
+// OpenFile implements RemoteAccessor by delegating unless faulted, possibly
+// truncating the returned reader.
+func (f *FaultInjector) OpenFile(path string, offset int64) (io.ReadCloser, error) {
+	truncate, err := f.fault("OpenFile", path)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := f.RemoteAccessor.OpenFile(path, offset)
+	if err != nil || truncate == 0 {
+		return rc, err
+	}
+	return &truncatedReader{ReadCloser: rc, remaining: truncate}, nil
+}
+
+// Seek implements RemoteAccessor by delegating unless faulted, possibly
+// truncating the returned reader.
+func (f *FaultInjector) Seek(path string, rc io.ReadCloser, offset int64) (io.ReadCloser, error) {
+	truncate, err := f.fault("Seek", path)
+	if err != nil {
+		return nil, err
+	}
+	if tr, ok := rc.(*truncatedReader); ok {
+		rc = tr.ReadCloser
+	}
+	rc, err = f.RemoteAccessor.Seek(path, rc, offset)
+	if err != nil || truncate == 0 {
+		return rc, err
+	}
+	return &truncatedReader{ReadCloser: rc, remaining: truncate}, nil
+}
+
+// CopyFile implements RemoteAccessor by delegating unless faulted.
+func (f *FaultInjector) CopyFile(source, dest string) error {
+	if _, err := f.fault("CopyFile", source, dest); err != nil {
+		return err
+	}
+	return f.RemoteAccessor.CopyFile(source, dest)
+}
+
+// DeleteFile implements RemoteAccessor by delegating unless faulted.
+func (f *FaultInjector) DeleteFile(path string) error {
+	if _, err := f.fault("DeleteFile", path); err != nil {
+		return err
+	}
+	return f.RemoteAccessor.DeleteFile(path)
+}
+
+// DeleteIncompleteUpload implements RemoteAccessor by delegating unless
+// faulted.
+func (f *FaultInjector) DeleteIncompleteUpload(path string) error {
+	if _, err := f.fault("DeleteIncompleteUpload", path); err != nil {
+		return err
+	}
+	return f.RemoteAccessor.DeleteIncompleteUpload(path)
+}
+
+// ErrorIsNotExists implements RemoteAccessor by recognising
+// ErrInjectedNotExists as well as the wrapped accessor's own errors.
+func (f *FaultInjector) ErrorIsNotExists(err error) bool {
+	return err == ErrInjectedNotExists || f.RemoteAccessor.ErrorIsNotExists(err)
+}
+
+// truncatedReader is an io.ReadCloser that fails with io.ErrUnexpectedEOF
+// after a certain number of bytes have been read.
+type truncatedReader struct {
+	io.ReadCloser
+	remaining int64
+}
+
+// Read implements io.Reader.
+func (t *truncatedReader) Read(p []byte) (int, error) {
+	if t.remaining <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if int64(len(p)) > t.remaining {
+		p = p[:t.remaining]
+	}
+	n, err := t.ReadCloser.Read(p)
+	t.remaining -= int64(n)
+	return n, err
+}
@@ -0,0 +1,168 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/inconshreveable/log15"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFaultInjector(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "muxfys_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	source := filepath.Join(tmpdir, "source")
+	os.MkdirAll(source, os.FileMode(0777))
+	err = ioutil.WriteFile(filepath.Join(source, "a.file"), []byte("0123456789"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := &localAccessor{target: source}
+	aPath := local.RemotePath("a.file")
+	bPath := local.RemotePath("b.file")
+	dest := filepath.Join(tmpdir, "dest")
+
+	Convey("A FaultInjector without rules delegates everything", t, func() {
+		f := NewFaultInjector(local)
+		So(f.DownloadFile(aPath, dest), ShouldBeNil)
+		ras, err := f.ListEntries(source)
+		So(err, ShouldBeNil)
+		So(len(ras), ShouldEqual, 1)
+		So(f.Target(), ShouldEqual, source)
+		So(f.Calls("DownloadFile"), ShouldEqual, 1)
+		So(f.Calls("ListEntries"), ShouldEqual, 1)
+	})
+
+	Convey("A FaultInjector can fail the Nth call to a method", t, func() {
+		injected := errors.New("injected")
+		f := NewFaultInjector(local, FaultRule{Method: "DownloadFile", Nth: 2, Err: injected})
+		So(f.DownloadFile(aPath, dest), ShouldBeNil)
+		So(f.DownloadFile(aPath, dest), ShouldEqual, injected)
+		So(f.DownloadFile(aPath, dest), ShouldBeNil)
+		So(f.Calls("DownloadFile"), ShouldEqual, 3)
+
+		Convey("And can have its rules cleared", func() {
+			f.AddRule(FaultRule{Err: injected})
+			So(f.UploadFile(dest, bPath, ""), ShouldEqual, injected)
+			f.ClearRules()
+			So(f.UploadFile(dest, bPath, ""), ShouldBeNil)
+			So(f.DeleteFile(bPath), ShouldBeNil)
+		})
+	})
+
+	Convey("A FaultInjector can make paths not exist", t, func() {
+		f := NewFaultInjector(local, FaultRule{Path: aPath, NotExists: true})
+		_, err := f.OpenFile(aPath, 0)
+		So(err, ShouldEqual, ErrInjectedNotExists)
+		So(f.ErrorIsNotExists(err), ShouldBeTrue)
+		So(f.ErrorIsNotExists(errors.New("other")), ShouldBeFalse)
+		So(f.CopyFile(aPath, bPath), ShouldEqual, ErrInjectedNotExists)
+		_, err = f.ListEntries(source)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("A FaultInjector can add latency", t, func() {
+		f := NewFaultInjector(local, FaultRule{Method: "ListEntries", Latency: 50 * time.Millisecond})
+		start := time.Now()
+		_, err := f.ListEntries(source)
+		So(err, ShouldBeNil)
+		So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 50*time.Millisecond)
+	})
+
+	Convey("A FaultInjector can truncate reads", t, func() {
+		f := NewFaultInjector(local, FaultRule{TruncateReads: 4})
+		rc, err := f.OpenFile(aPath, 2)
+		So(err, ShouldBeNil)
+		content, err := ioutil.ReadAll(rc)
+		So(err, ShouldEqual, io.ErrUnexpectedEOF)
+		So(string(content), ShouldEqual, "2345")
+
+		rc, err = f.Seek(aPath, rc, 5)
+		So(err, ShouldBeNil)
+		content, err = ioutil.ReadAll(rc)
+		So(err, ShouldEqual, io.ErrUnexpectedEOF)
+		So(string(content), ShouldEqual, "5678")
+		So(rc.Close(), ShouldBeNil)
+	})
+
+	Convey("Remotes retry the calls a FaultInjector fails", t, func() {
+		f := NewFaultInjector(local, FaultRule{Method: "DownloadFile", Nth: 1, Err: errors.New("injected")})
+		logger := log15.New()
+		logger.SetHandler(log15.DiscardHandler())
+		r, err := newRemote(&RemoteConfig{Accessor: f, RetryBackoffMin: time.Millisecond}, filepath.Join(tmpdir, "cache"), 2, logger)
+		So(err, ShouldBeNil)
+		So(r.downloadFile(aPath, dest, 10), ShouldEqual, fuse.OK)
+		So(f.Calls("DownloadFile"), ShouldEqual, 2)
+
+		f.AddRule(FaultRule{Path: aPath, NotExists: true})
+		So(r.downloadFile(aPath, dest, 10), ShouldEqual, fuse.ENOENT)
+		So(f.Calls("DownloadFile"), ShouldEqual, 3)
+	})
+
+	Convey("Remote calls slower than SlowThreshold are logged", t, func() {
+		f := NewFaultInjector(local, FaultRule{Method: "ListEntries", Latency: 50 * time.Millisecond})
+		fs, r := newTestFS(&Config{Mount: filepath.Join(tmpdir, "slowMount"), CacheBase: tmpdir, SlowThreshold: 20 * time.Millisecond}, &RemoteConfig{Accessor: f})
+
+		So(r.downloadFile(aPath, dest, 10), ShouldEqual, fuse.OK)
+		So(fs.Logs(), ShouldBeEmpty)
+
+		_, status := r.listEntries(source + "/")
+		So(status, ShouldEqual, fuse.OK)
+		logs := fs.Logs()
+		So(len(logs), ShouldEqual, 1)
+		So(logs[0], ShouldContainSubstring, slowCallMsg)
+		So(logs[0], ShouldContainSubstring, "call=ListEntries")
+	})
+
+	Convey("Cached reads retry the rest of truncated reads", t, func() {
+		f := NewFaultInjector(local, FaultRule{Method: "OpenFile", Nth: 1, TruncateReads: 4})
+		fs, r := newTestFS(&Config{Mount: filepath.Join(tmpdir, "mount"), CacheBase: tmpdir, Retries: 1}, &RemoteConfig{Accessor: f, CacheData: true, RetryBackoffMin: time.Millisecond})
+		fs.Logger.SetHandler(log15.DiscardHandler())
+		localPath := r.getLocalPath(aPath)
+
+		p := make([]byte, 10)
+		n, err := fs.ReadAt("a.file", p, 0)
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 10)
+		So(string(p), ShouldEqual, "0123456789")
+		So(f.Calls("OpenFile"), ShouldEqual, 2)
+		So(r.Uncached(localPath, NewInterval(0, 10)), ShouldBeEmpty)
+
+		Convey("Only the bytes actually read are cached if retries run out", func() {
+			r.CacheDelete(localPath)
+			f.AddRule(FaultRule{Method: "OpenFile", TruncateReads: 4})
+			r.maxAttempts = 1
+			_, err = fs.ReadAt("a.file", p, 0)
+			So(err, ShouldNotBeNil)
+			So(r.Uncached(localPath, NewInterval(0, 10)), ShouldResemble, Intervals{NewInterval(4, 6)})
+		})
+	})
+}
@@ -27,6 +27,7 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/hanwen/go-fuse/v2/fuse"
@@ -34,9 +35,14 @@ import (
 	"github.com/inconshreveable/log15"
 )
 
+// fallocKeepSize is FALLOC_FL_KEEP_SIZE, the fallocate() mode flag for
+// reserving space without changing the size of the file.
+const fallocKeepSize = 0x01
+
 // remoteFile struct is muxfys' implementation of pathfs.File for reading data
 // directly from a remote file system or object store.
 type remoteFile struct {
+	byteCounter
 	nodefs.File
 	r             *remote
 	path          string
@@ -89,6 +95,28 @@ func (f *remoteFile) Read(buf []byte, offset int64) (fuse.ReadResult, fuse.Statu
 		return nil, fuse.OK
 	}
 
+	_, status := f.read(buf, offset)
+	if status != fuse.OK {
+		return fuse.ReadResultData([]byte{}), status
+	}
+	return fuse.ReadResultData(buf), status
+}
+
+// readAt is like Read(), but returns the number of bytes that were actually
+// read from the remote file in to buf, which will be less than len(buf) if the
+// read failed part way through, or reached the end of the file.
+func (f *remoteFile) readAt(buf []byte, offset int64) (int, fuse.Status) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if uint64(offset) >= f.attr.Size {
+		return 0, fuse.OK
+	}
+	return f.read(buf, offset)
+}
+
+// read is the implementation of Read() and readAt(). You must hold the mutex.
+func (f *remoteFile) read(buf []byte, offset int64) (int, fuse.Status) {
 	// handle out-of-order reads, which happen even when the user request is a
 	// serial read: we get offsets out of order
 	if f.readOffset != offset {
@@ -103,9 +131,9 @@ func (f *remoteFile) Read(buf []byte, offset int64) (fuse.ReadResult, fuse.Statu
 				skippedPos := f.readOffset
 				skipSize := offset - f.readOffset
 				skipped := make([]byte, skipSize)
-				status := f.fillBuffer(skipped, f.readOffset)
+				_, status := f.fillBuffer(skipped, f.readOffset)
 				if status != fuse.OK {
-					return nil, status
+					return 0, status
 				}
 				lb := int64(len(buf))
 				if skipSize <= lb {
@@ -125,13 +153,14 @@ func (f *remoteFile) Read(buf []byte, offset int64) (fuse.ReadResult, fuse.Statu
 				// service the request from the bytes we previously skipped
 				copy(buf, skipped)
 				delete(f.skips, offset)
-				return fuse.ReadResultData(buf), fuse.OK
+				f.countRead(readLength(len(buf), offset, f.attr.Size))
+				return len(buf), fuse.OK
 			} else {
 				// we'll have to seek and wipe our skips
 				var status fuse.Status
 				f.reader, status = f.r.seek(f.reader, offset, f.path)
 				if status != fuse.OK {
-					return nil, status
+					return 0, status
 				}
 				f.skips = make(map[int64][]byte)
 			}
@@ -141,33 +170,40 @@ func (f *remoteFile) Read(buf []byte, offset int64) (fuse.ReadResult, fuse.Statu
 
 	// if opened previously, read from existing reader and return
 	if f.reader != nil {
-		status := f.fillBuffer(buf, offset)
-		return fuse.ReadResultData(buf), status
+		n, status := f.fillBuffer(buf, offset)
+		if status == fuse.OK {
+			f.countRead(readLength(len(buf), offset, f.attr.Size))
+		}
+		return n, status
 	}
 
 	// otherwise open remote object (if it doesn't exist, we only get an error
 	// when we try to fillBuffer, but that's OK)
 	reader, status := f.r.getObject(f.path, offset)
 	if status != fuse.OK {
-		return fuse.ReadResultData([]byte{}), status
+		return 0, status
 	}
 
 	// store the reader to read from later
 	f.reader = reader
 
-	status = f.fillBuffer(buf, offset)
+	n, status := f.fillBuffer(buf, offset)
 	if status != fuse.OK {
-		return fuse.ReadResultData([]byte{}), status
+		return n, status
 	}
-	return fuse.ReadResultData(buf), status
+	f.countRead(readLength(len(buf), offset, f.attr.Size))
+	return n, status
 }
 
-// fillBuffer reads from our remote reader to the Read() buffer.
-func (f *remoteFile) fillBuffer(buf []byte, offset int64) (status fuse.Status) {
+// fillBuffer reads from our remote reader to the Read() buffer, returning the
+// number of bytes read.
+func (f *remoteFile) fillBuffer(buf []byte, offset int64) (bytesRead int, status fuse.Status) {
 	// io.ReadFull throws away errors if enough bytes were read; implement our
 	// own just in case weird stuff happens. It's also annoying in converting
 	// EOF errors to ErrUnexpectedEOF, which we don't do here
-	var bytesRead int
+	if !f.r.gate.enter(true) {
+		return 0, pausedStatus
+	}
 	min := len(buf)
 	var err error
 	for bytesRead < min && err == nil {
@@ -175,6 +211,7 @@ func (f *remoteFile) fillBuffer(buf []byte, offset int64) (status fuse.Status) {
 		nn, err = f.reader.Read(buf[bytesRead:])
 		bytesRead += nn
 	}
+	f.r.gate.leave()
 
 	if err != nil {
 		errc := f.reader.Close()
@@ -213,7 +250,7 @@ func (f *remoteFile) fillBuffer(buf []byte, offset int64) (status fuse.Status) {
 		f.readRetries = 0
 	}
 	f.readOffset += int64(bytesRead)
-	return fuse.OK
+	return bytesRead, fuse.OK
 }
 
 // Write supports serial writes of data directly to a remote file, where
@@ -228,9 +265,16 @@ func (f *remoteFile) Write(data []byte, offset int64) (uint32, fuse.Status) {
 		return uint32(0), fuse.OK
 	}
 
+	if f.r.exceedsMaxWrite(f.path, offset+int64(len(data))) {
+		return uint32(0), fuse.Status(syscall.EFBIG)
+	}
+
 	if offset != f.writeOffset {
 		// we can't handle non-serial writes
 		f.Warn("Write can't handle non-serial writes")
+		if f.r.streamWrites {
+			return uint32(0), fuse.ENOSYS
+		}
 		return uint32(0), fuse.EIO
 	}
 
@@ -242,6 +286,7 @@ func (f *remoteFile) Write(data []byte, offset int64) (uint32, fuse.Status) {
 	}
 
 	n, err := f.wpipe.Write(data)
+	f.countWritten(int64(n))
 
 	f.writeOffset += int64(n)
 	f.attr.Size += uint64(n)
@@ -308,6 +353,13 @@ func (f *remoteFile) Release() {
 	f.mutex.Lock()
 	defer f.mutex.Unlock()
 	f.skips = make(map[int64][]byte)
+	if f.reader != nil {
+		errc := f.reader.Close()
+		if errc != nil {
+			f.Warn("Release reader close failed", "err", errc)
+		}
+		f.reader = nil
+	}
 }
 
 // Fsync always returns OK as opposed to "not implemented" so that write-sync-
@@ -336,6 +388,7 @@ func (f *remoteFile) Truncate(size uint64) fuse.Status {
 // Atime, and on Read it copies data from remote to local disk if not requested
 // before.
 type cachedFile struct {
+	byteCounter
 	nodefs.File
 	r          *remote
 	remotePath string
@@ -359,6 +412,13 @@ func newCachedFile(r *remote, remotePath, localPath string, attr *fuse.Attr, fla
 		attr:       attr,
 		Logger:     logger.New("rpath", remotePath, "lpath", localPath),
 	}
+	if r.cacheCompress {
+		// our caller already made localPath plain, so this can't fail; we hold
+		// it until Release()
+		if _, err := r.holdPlain(localPath, true); err != nil {
+			f.Error("Could not decompress cache file", "err", err)
+		}
+	}
 	f.makeLoopback()
 	f.remoteFile = newRemoteFile(r, remotePath, attr, false, logger).(*remoteFile)
 	return f
@@ -385,9 +445,18 @@ func (f *cachedFile) InnerFile() nodefs.File {
 }
 
 // Write passes the real work to our InnerFile(), also updating our cached
-// attr.
+// attr. It fails with EFBIG if the file would exceed our remote's
+// MaxWriteBytes.
 func (f *cachedFile) Write(data []byte, offset int64) (uint32, fuse.Status) {
+	end := offset
+	if f.flags&os.O_APPEND != 0 && int64(f.attr.Size) > end {
+		end = int64(f.attr.Size)
+	}
+	if f.r.exceedsMaxWrite(f.remotePath, end+int64(len(data))) {
+		return uint32(0), fuse.Status(syscall.EFBIG)
+	}
 	n, s := f.InnerFile().Write(data, offset)
+	f.countWritten(int64(n))
 	size := uint64(offset) + uint64(n)
 	if size > f.attr.Size {
 		f.attr.Size = size // instead of += n, since offsets could come out of order
@@ -396,9 +465,64 @@ func (f *cachedFile) Write(data []byte, offset int64) (uint32, fuse.Status) {
 	f.attr.Mtime = mTime
 	f.attr.Atime = mTime
 	f.r.Cached(f.localPath, NewInterval(offset, int64(n)))
+	f.r.markModified(f.localPath, offset, offset+int64(n))
 	return n, s
 }
 
+// Truncate passes the real work to our InnerFile(), also updating our cached
+// attr and our knowledge of what has been cached and modified. Any bytes added
+// by extending the file are zeros we don't need to read from the remote file.
+// Extending the file beyond our remote's MaxWriteBytes fails with EFBIG.
+func (f *cachedFile) Truncate(size uint64) fuse.Status {
+	if size > f.attr.Size && f.r.exceedsMaxWrite(f.remotePath, int64(size)) {
+		return fuse.Status(syscall.EFBIG)
+	}
+	status := f.InnerFile().Truncate(size)
+	if status != fuse.OK {
+		return status
+	}
+	oldSize := f.attr.Size
+	f.r.markModified(f.localPath, int64(oldSize), int64(size))
+	if size > oldSize {
+		f.r.Cached(f.localPath, NewInterval(int64(oldSize), int64(size-oldSize)))
+	} else {
+		f.r.truncatedCache(f.localPath, int64(size))
+	}
+	f.attr.Size = size
+	f.attr.Mtime = uint64(time.Now().Unix())
+	return status
+}
+
+// Allocate passes the real work of reserving space to our InnerFile(), also
+// updating our cached attr and our knowledge of what has been cached and
+// modified if the file is extended, as with Truncate(). Only the default mode
+// (which may extend the file) and FALLOC_FL_KEEP_SIZE are supported, and only
+// for writeable remotes; otherwise it fails with ENOSYS. Extending the file
+// beyond our remote's MaxWriteBytes fails with EFBIG.
+func (f *cachedFile) Allocate(off uint64, size uint64, mode uint32) fuse.Status {
+	if !f.r.write {
+		return fuse.ENOSYS
+	}
+	if mode&^fallocKeepSize != 0 {
+		return fuse.ENOSYS
+	}
+	end := off + size
+	extends := mode&fallocKeepSize == 0 && end > f.attr.Size
+	if extends && f.r.exceedsMaxWrite(f.remotePath, int64(end)) {
+		return fuse.Status(syscall.EFBIG)
+	}
+	status := f.InnerFile().Allocate(off, size, mode)
+	if status != fuse.OK || !extends {
+		return status
+	}
+	oldSize := f.attr.Size
+	f.r.markModified(f.localPath, int64(oldSize), int64(end))
+	f.r.Cached(f.localPath, NewInterval(int64(oldSize), int64(end-oldSize)))
+	f.attr.Size = end
+	f.attr.Mtime = uint64(time.Now().Unix())
+	return status
+}
+
 // Utimens gets called by things like `touch -d "2006-01-02 15:04:05" filename`,
 // and we need to update our cached attr as well as the local file.
 func (f *cachedFile) Utimens(atime *time.Time, mtime *time.Time) (status fuse.Status) {
@@ -410,6 +534,17 @@ func (f *cachedFile) Utimens(atime *time.Time, mtime *time.Time) (status fuse.St
 	return status
 }
 
+// Release closes any reader of the remote file we were using to fill the cache,
+// then releases our InnerFile(). With CacheCompress, the cache file is then
+// compressed if this was its last handle.
+func (f *cachedFile) Release() {
+	f.remoteFile.Release()
+	f.InnerFile().Release()
+	if f.r.cacheCompress {
+		f.r.releasePlain(f.localPath)
+	}
+}
+
 // Read checks to see if we've previously stored these bytes in our local
 // cached file, and if so just defers to our InnerFile(). If not, gets the data
 // from the remote file and stores it in the cache file.
@@ -422,42 +557,99 @@ func (f *cachedFile) Read(buf []byte, offset int64) (fuse.ReadResult, fuse.Statu
 		return nil, fuse.OK
 	}
 
-	// find which bytes we haven't previously read
-	request := NewInterval(offset, int64(len(buf)))
-	if request.End >= int64(f.attr.Size-1) {
-		request.End = int64(f.attr.Size - 1)
-	}
-	newIvs := f.r.Uncached(f.localPath, request)
+	// find which bytes we haven't previously read, in whole blocks so that
+	// small reads don't each need a remote request
+	request := f.r.readBlocks(NewInterval(offset, int64(len(buf))), int64(f.attr.Size))
 
 	// *** have tried using a single RemoteFile per remote, and also trying to
 	// combine sets of reads on the same file, but performance is best just
 	// letting different reads on the same file interleave
 
-	// read remote data and store in cache file for the previously unread parts
-	for _, iv := range newIvs {
-		ivBuf := make([]byte, iv.Length())
-		_, status := f.remoteFile.Read(ivBuf, iv.Start)
+	// read remote data and store in cache file for the previously unread
+	// parts, one at a time. If another handle on the same file is already
+	// reading some of the same part, we wait for it to finish instead of
+	// reading it again ourselves, then work out what is still unread
+	for {
+		newIvs := f.r.Uncached(f.localPath, request)
+		if len(newIvs) == 0 {
+			break
+		}
+		iv := newIvs[0]
+
+		ir, claimed := f.r.claimRead(f.localPath, iv)
+		if !claimed {
+			<-ir.done
+			continue
+		}
+		status := f.cacheInterval(iv)
+		f.r.finishRead(f.localPath, ir)
 		if status != fuse.OK {
-			// we warn instead of error because this is a "normal" situation
-			// when trying to read from non-existent files
-			f.Warn("Read failed", "status", status)
 			return nil, status
 		}
+	}
 
-		// write the data to our cache file
-		if !f.openedRW {
-			f.flags |= os.O_RDWR
-			f.makeLoopback()
+	// read the whole region from the cache file and return
+	rr, status := f.InnerFile().Read(buf, offset)
+	if status == fuse.OK {
+		f.countRead(readLength(len(buf), offset, f.attr.Size))
+	}
+	return rr, status
+}
+
+// cacheInterval reads the given interval of the remote file and stores it in
+// our cache file. If the remote read fails part way through (eg. because the
+// connection dropped), the bytes that were read are cached and the rest are
+// read again, with backoff, up to the remote's maximum number of attempts.
+// Only bytes that were actually read get marked as cached.
+func (f *cachedFile) cacheInterval(iv Interval) fuse.Status {
+	ivBuf := make([]byte, iv.Length())
+	start := iv.Start
+	for attempts := 1; ; attempts++ {
+		n, status := f.remoteFile.readAt(ivBuf[start-iv.Start:], start)
+		if status == fuse.OK && start+int64(n) < iv.End+1 {
+			// a short read that didn't report an error
+			status = fuse.EIO
 		}
-		n, s := f.InnerFile().Write(ivBuf, iv.Start)
-		if s == fuse.OK && int64(n) == iv.Length() {
-			f.r.Cached(f.localPath, iv)
-		} else {
-			f.Error("Failed to write bytes to cache file", "read", iv.Length(), "wrote", n, "status", s)
-			return nil, s
+
+		if n > 0 {
+			if s := f.writeCache(ivBuf[start-iv.Start:start-iv.Start+int64(n)], start); s != fuse.OK {
+				return s
+			}
+			start += int64(n)
+		}
+		if status == fuse.OK {
+			return fuse.OK
 		}
+
+		if status == fuse.ENOENT || status == pausedStatus || status == fuse.EINTR || attempts >= f.r.maxAttempts {
+			// we warn instead of error because this is a "normal" situation
+			// when trying to read from non-existent files
+			f.Warn("Read failed", "status", status, "attempts", attempts)
+			return status
+		}
+		f.Warn("Read was incomplete, will retry", "offset", start, "status", status)
+		f.r.cbMutex.Lock()
+		dur := f.r.clientBackoff.Duration()
+		f.r.cbMutex.Unlock()
+		<-time.After(dur)
 	}
+}
 
-	// read the whole region from the cache file and return
-	return f.InnerFile().Read(buf, offset)
+// writeCache writes the given bytes read from the remote file at the given
+// offset to our cache file, and records that they are cached.
+func (f *cachedFile) writeCache(data []byte, offset int64) fuse.Status {
+	if !f.openedRW {
+		f.flags |= os.O_RDWR
+		f.makeLoopback()
+	}
+	n, s := f.InnerFile().Write(data, offset)
+	if s != fuse.OK || n != uint32(len(data)) {
+		f.Error("Failed to write bytes to cache file", "read", len(data), "wrote", n, "status", s)
+		if s == fuse.OK {
+			s = fuse.EIO
+		}
+		return s
+	}
+	f.r.Cached(f.localPath, NewInterval(offset, int64(len(data))))
+	return fuse.OK
 }
@@ -27,10 +27,13 @@ package muxfys
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -44,7 +47,20 @@ const (
 	blockSize   = uint64(4096)
 	totalBlocks = uint64(274877906944) // 1PB / blockSize
 	inodes      = uint64(1000000000)
-	ioSize      = uint32(1048576) // 1MB
+
+	// defaultReadBlockSize is the Config.ReadBlockSize if the Config doesn't
+	// say otherwise, and the min and max are the limits on what it can say.
+	defaultReadBlockSize = 1048576 // 1MB
+	minReadBlockSize     = 4096
+	maxReadBlockSize     = 1073741824 // 1GB
+
+	// maxNegativeCacheEntries is the most non-existent paths we'll remember
+	// when Config.NegativeCacheTTL is set.
+	maxNegativeCacheEntries = 10000
+
+	// openDirWorkers is the most remotes that will be listed at once when a
+	// directory they share is opened.
+	openDirWorkers = 8
 )
 
 // fileDetails checks the file is known and returns its attributes and the
@@ -68,37 +84,130 @@ func (fs *MuxFys) fileDetails(name string, shouldBeWritable bool) (*fuse.Attr, *
 }
 
 // StatFs returns a constant (faked) set of details describing a very large
-// file system.
+// file system, unless any of our remotes' accessors are UsageAccessors, in
+// which case the block counts reflect their combined usage and quota.
 func (fs *MuxFys) StatFs(name string) *fuse.StatfsOut {
-	return &fuse.StatfsOut{
+	out := &fuse.StatfsOut{
 		Blocks: blockSize,
 		Bfree:  totalBlocks,
 		Bavail: totalBlocks,
 		Files:  inodes,
 		Ffree:  inodes,
-		Bsize:  ioSize,
+		Bsize:  uint32(fs.readBlockSize),
 		// NameLen uint32
 		// Frsize  uint32
 		// Padding uint32
 		// Spare   [6]uint32
 	}
+
+	used, quota, known := fs.usage()
+	if !known {
+		return out
+	}
+
+	usedBlocks := (uint64(used) + blockSize - 1) / blockSize
+	free := totalBlocks
+	if quota > 0 {
+		quotaBlocks := uint64(quota) / blockSize
+		free = 0
+		if quotaBlocks > usedBlocks {
+			free = quotaBlocks - usedBlocks
+		}
+	}
+	out.Blocks = usedBlocks + free
+	out.Bfree = free
+	out.Bavail = free
+	out.Frsize = uint32(blockSize)
+	return out
+}
+
+// usage sums the usage of our remotes with UsageAccessors. quota is 0 if any of
+// them has no quota. known is false if none of them know their usage.
+func (fs *MuxFys) usage() (used, quota int64, known bool) {
+	limited := true
+	for _, r := range fs.remotes {
+		rUsed, rQuota, rKnown := r.usage()
+		if !rKnown {
+			continue
+		}
+		known = true
+		used += rUsed
+		if rQuota <= 0 {
+			limited = false
+		}
+		quota += rQuota
+	}
+	if !limited {
+		quota = 0
+	}
+	return used, quota, known
 }
 
 // OnMount prepares MuxFys for use once Mount() has been called.
 func (fs *MuxFys) OnMount(nodeFs *pathfs.PathNodeFs) {
 	fs.mapMutex.Lock()
 	defer fs.mapMutex.Unlock()
-	// we need to establish that the root directory is a directory; the next
-	// attempt by the user to get it's contents will actually do the remote call
-	// to get the directory entries
-	fs.dirs[""] = fs.remotes
+	// we need to establish that the root directory (and the MountSubpath of
+	// each remote) is a directory; the next attempt by the user to get it's
+	// contents will actually do the remote call to get the directory entries
+	fs.dirs[""] = []*remote{}
+	for _, r := range fs.remotes {
+		fs.addSubpathDirs(r)
+	}
+
+	// remotes configured with the path to a single file will present just that
+	// file in the root directory
+	for _, r := range fs.remotes {
+		r.findSingleFile()
+	}
+}
+
+// addSubpathDirs notes that the given remote has the directory that is its
+// MountSubpath, and that every directory in that path exists regardless of
+// what any remote has. Must be called while you have the mapMutex Locked.
+func (fs *MuxFys) addSubpathDirs(r *remote) {
+	for dir := r.mountSubpath; dir != ""; dir = filepath.Dir(dir) {
+		if dir == "." {
+			break
+		}
+		fs.subpathDirs[dir] = true
+		if _, exists := fs.dirs[dir]; !exists {
+			fs.dirs[dir] = []*remote{}
+		}
+	}
+	fs.addRemoteToDir(r, r.mountSubpath)
+}
+
+// addSubpathEntries adds entries for the directories in remote MountSubpaths
+// that are directly within the given directory, which should have just been
+// opened with openDir(). Must be called while you have the mapMutex Locked.
+func (fs *MuxFys) addSubpathEntries(name string) {
+	for dir := range fs.subpathDirs {
+		parent := filepath.Dir(dir)
+		if parent == "." {
+			parent = ""
+		}
+		if parent != name {
+			continue
+		}
+		if _, exists := fs.dirContents[name]; !exists {
+			fs.dirContents[name] = []fuse.DirEntry{}
+		}
+		fs.addDirEntry(name, fuse.DirEntry{Name: filepath.Base(dir), Mode: uint32(fuse.S_IFDIR)})
+	}
 }
 
 // GetAttr finds out about a given object, returning information from a
 // permanent cache if possible. context is not currently used.
+//
+// The attributes of a directory's entries are cached when it is listed, and
+// (since listings don't expire, only being redone by RefreshDir() or
+// remounting) are always trusted, so a listing followed by a GetAttr() of each
+// entry, as done by eg. `ls -l`, only lists the directory once.
 func (fs *MuxFys) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse.Status) {
 	fs.mapMutex.Lock()
 	defer fs.mapMutex.Unlock()
+	name = fs.lookupName(name)
 
 	if _, isDir := fs.dirs[name]; isDir {
 		return fs.dirAttr, fuse.OK
@@ -108,6 +217,10 @@ func (fs *MuxFys) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse.
 		return attr, fuse.OK
 	}
 
+	if fs.knownMissing(name) {
+		return nil, fuse.ENOENT
+	}
+
 	// rather than call StatObject on name to see if its a file, it's more
 	// efficient to try and open it's parent directory and see if that resulted
 	// in us caching name as one of the parent's contents
@@ -115,16 +228,19 @@ func (fs *MuxFys) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse.
 	if parent == "/" || parent == "." {
 		parent = ""
 	}
+	var listFailed, paused bool
 	if _, cached := fs.dirContents[parent]; !cached {
 		// we must populate the contents of parent first, doing the essential
 		// part of OpenDir()
 		if remotes, exists := fs.dirs[parent]; exists {
-			for _, r := range remotes {
-				status := fs.openDir(r, parent)
+			for _, status := range fs.openDirs(remotes, parent) {
 				if status != fuse.OK {
 					fs.Warn("GetAttr openDir failed", "path", parent, "status", status)
+					listFailed = true
+					paused = paused || status == pausedStatus
 				}
 			}
+			fs.addSubpathEntries(parent)
 		}
 
 		if _, isDir := fs.dirs[name]; isDir {
@@ -135,15 +251,73 @@ func (fs *MuxFys) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse.
 			return attr, fuse.OK
 		}
 	}
+
+	// it may be about to appear, if we've been configured to wait for that
+	if fs.awaitFile(name) {
+		if _, isDir := fs.dirs[name]; isDir {
+			return fs.dirAttr, fuse.OK
+		}
+		return fs.files[name], fuse.OK
+	}
+
+	// we can't be sure name doesn't exist if we failed to list its parent, so
+	// don't remember it as missing
+	if !listFailed {
+		fs.rememberMissing(name)
+	}
+	if paused {
+		// (it might exist once we're resumed)
+		return nil, pausedStatus
+	}
 	return nil, fuse.ENOENT
 }
 
+// knownMissing returns true if GetAttr() recently found that name doesn't
+// exist, and Config.NegativeCacheTTL hasn't yet passed. Must be called while
+// you have the mapMutex Locked.
+func (fs *MuxFys) knownMissing(name string) bool {
+	missed, exists := fs.negativeCache[name]
+	if !exists {
+		return false
+	}
+	if time.Since(missed) < fs.negativeCacheTTL {
+		return true
+	}
+	delete(fs.negativeCache, name)
+	return false
+}
+
+// rememberMissing notes that name doesn't exist, if Config.NegativeCacheTTL
+// was set. To bound memory usage, expired entries are discarded when we've
+// remembered maxNegativeCacheEntries names, along with arbitrary unexpired
+// ones if necessary. Must be called while you have the mapMutex Locked.
+func (fs *MuxFys) rememberMissing(name string) {
+	if fs.negativeCacheTTL <= 0 {
+		return
+	}
+	if len(fs.negativeCache) >= maxNegativeCacheEntries {
+		for missing, missed := range fs.negativeCache {
+			if time.Since(missed) >= fs.negativeCacheTTL {
+				delete(fs.negativeCache, missing)
+			}
+		}
+		for missing := range fs.negativeCache {
+			if len(fs.negativeCache) < maxNegativeCacheEntries/2 {
+				break
+			}
+			delete(fs.negativeCache, missing)
+		}
+	}
+	fs.negativeCache[name] = time.Now()
+}
+
 // OpenDir gets the contents of the given directory for eg. `ls` purposes. It
 // also caches the attributes of all the files within. context is not currently
 // used.
 func (fs *MuxFys) OpenDir(name string, context *fuse.Context) ([]fuse.DirEntry, fuse.Status) {
 	fs.mapMutex.Lock()
 	defer fs.mapMutex.Unlock()
+	name = fs.lookupName(name)
 
 	remotes, exists := fs.dirs[name]
 	if !exists {
@@ -157,24 +331,83 @@ func (fs *MuxFys) OpenDir(name string, context *fuse.Context) ([]fuse.DirEntry,
 
 	// openDir in all remotes that have this dir, then return the combined dir
 	// contents from the cache
-	for _, r := range remotes {
-		status := fs.openDir(r, name)
+	var paused bool
+	for _, status := range fs.openDirs(remotes, name) {
 		if status != fuse.OK {
 			fs.Warn("GetAttr openDir failed", "path", name, "status", status)
+			paused = paused || status == pausedStatus
 		}
 	}
+	fs.addSubpathEntries(name)
 
 	entries, cached = fs.dirContents[name]
 	if cached {
 		return entries, fuse.OK
 	}
+	if paused {
+		return nil, pausedStatus
+	}
 	return nil, fuse.ENOENT
 }
 
 // openDir gets the contents of the given name, treating it as a directory,
 // caching the attributes of its contents. Must be called while you have the
 // mapMutex Locked.
+//
+// It is safe to call this again for a directory that has already been opened:
+// the remote is re-queried and the results merged with what we already know.
+// Newly seen remote objects are added, and entries that came from this remote
+// but are now gone from it are dropped, unless they were created locally.
 func (fs *MuxFys) openDir(r *remote, name string) fuse.Status {
+	return fs.mergeDir(r, name, r.listDir(name))
+}
+
+// openDirs is like calling openDir() on each of the given remotes in turn, but
+// the (potentially slow) remote listings are done concurrently, at most
+// openDirWorkers at once. The listings are then merged in the order of remotes,
+// so that the first remote with a given file wins, as for openDir(). Returns
+// the status of each remote's openDir(). Must be called while you have the
+// mapMutex Locked.
+func (fs *MuxFys) openDirs(remotes []*remote, name string) []fuse.Status {
+	listings := make([]*dirListing, len(remotes))
+	sem := make(chan struct{}, openDirWorkers)
+	var wg sync.WaitGroup
+	for i, r := range remotes {
+		wg.Add(1)
+		go func(i int, r *remote) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() {
+				<-sem
+			}()
+			listings[i] = r.listDir(name)
+		}(i, r)
+	}
+	wg.Wait()
+
+	statuses := make([]fuse.Status, len(remotes))
+	for i, r := range remotes {
+		statuses[i] = fs.mergeDir(r, name, listings[i])
+	}
+	return statuses
+}
+
+// dirListing holds what listDir() found in a directory of a remote.
+type dirListing struct {
+	objects []RemoteAttr
+	status  fuse.Status
+	offline bool
+}
+
+// listDir gets the contents of the given directory from the remote, or from
+// our cache if the remote is unreachable. It doesn't use any of MuxFys' maps,
+// so you don't need the mapMutex.
+func (r *remote) listDir(name string) *dirListing {
+	if name == r.mountSubpath && r.singleFile != "" {
+		// there's nothing to list when the remote's root is a single file
+		return &dirListing{status: fuse.OK}
+	}
+
 	remotePath := r.getRemotePath(name)
 	if remotePath != "" {
 		remotePath += "/"
@@ -182,21 +415,74 @@ func (fs *MuxFys) openDir(r *remote, name string) fuse.Status {
 
 	objects, status := r.findObjects(remotePath)
 
+	// if we can't reach the remote, we might be able to list what we have
+	// cached instead, in which case we add to but don't otherwise change what
+	// we already know about this directory
+	offline := r.offline(status)
+	if offline {
+		cached, err := r.cachedObjects(remotePath)
+		if err == nil {
+			r.Warn("Remote unreachable, listing cached files instead", "path", remotePath, "status", status)
+			objects, status = cached, fuse.OK
+		}
+	}
+	return &dirListing{objects: objects, status: status, offline: offline}
+}
+
+// mergeDir is the part of openDir() that caches the contents of the given
+// directory according to the given listing of it from listDir(). Must be
+// called while you have the mapMutex Locked.
+func (fs *MuxFys) mergeDir(r *remote, name string, listing *dirListing) fuse.Status {
+	if name == r.mountSubpath && r.singleFile != "" {
+		fs.dropStaleEntries(r, name, fs.addSingleFile(r))
+		fs.addRemoteToDir(r, name)
+		return fuse.OK
+	}
+
+	remotePath := r.getRemotePath(name)
+	if remotePath != "" {
+		remotePath += "/"
+	}
+	objects, status, offline := listing.objects, listing.status, listing.offline
+
 	if status != fuse.OK || len(objects) == 0 {
-		if name == "" {
+		if name == r.mountSubpath {
 			// allow the root to be a non-existent directory
-			fs.dirs[name] = append(fs.dirs[name], r)
+			if status == fuse.OK && !offline {
+				fs.dropStaleEntries(r, name, nil)
+			}
+			fs.addRemoteToDir(r, name)
 			if _, exists := fs.dirContents[name]; !exists {
 				fs.dirContents[name] = []fuse.DirEntry{}
 			}
 			return fuse.OK
 		} else if status == fuse.OK {
+			if !offline {
+				fs.dropStaleEntries(r, name, nil)
+			}
 			return fuse.ENOENT
 		}
 		return status
 	}
 
+	var markers map[string]bool
+	if r.hideDirMarkers {
+		markers = dirMarkers(objects)
+	}
+
+	// S3 and the like can have both an object "a/b" and objects under "a/b/";
+	// since a name can't be both, the directory wins and the file is hidden
+	subDirs := make(map[string]bool)
+	for _, object := range objects {
+		if strings.HasSuffix(object.Name, "/") {
+			subDirs[object.Name] = true
+		}
+	}
+
 	var isDir bool
+	var longIndex int
+	var eager []string
+	seen := make(map[string]bool)
 	for _, object := range objects {
 		if object.Name == name {
 			continue
@@ -206,41 +492,68 @@ func (fs *MuxFys) openDir(r *remote, name string) fuse.Status {
 		d := fuse.DirEntry{
 			Name: object.Name[len(remotePath):],
 		}
-		if d.Name == "" {
+		if d.Name == "" || (object.Size == 0 && markers[object.Name+"/"]) {
 			continue
 		}
 
 		if strings.HasSuffix(d.Name, "/") {
 			d.Mode = uint32(fuse.S_IFDIR)
 			d.Name = d.Name[0 : len(d.Name)-1]
+			var ok bool
+			if d.Name, ok = r.presentLongName(name, d.Name, &longIndex); !ok {
+				continue
+			}
 			thisPath := filepath.Join(name, d.Name)
-			fs.dirs[thisPath] = append(fs.dirs[thisPath], r)
+			fs.addRemoteToDir(r, thisPath)
+			fs.hideFileUnderDir(thisPath)
 		} else {
 			d.Mode = uint32(fuse.S_IFREG)
+			var ok bool
+			if d.Name, ok = r.presentLongName(name, d.Name, &longIndex); !ok {
+				continue
+			}
 			thisPath := filepath.Join(name, d.Name)
-			mTime := uint64(object.MTime.Unix())
-			attr := &fuse.Attr{
-				Mode:  fuse.S_IFREG | uint32(fileMode),
-				Size:  uint64(object.Size),
-				Mtime: mTime,
-				Atime: mTime,
-				Ctime: mTime,
+			if relPath, _ := r.relPath(thisPath); r.filteredOut(relPath) {
+				continue
+			}
+			if subDirs[object.Name+"/"] || fs.isOtherDir(r, thisPath) {
+				r.Warn("File hidden by a directory with the same name", "path", thisPath)
+				continue
+			}
+			if owner, known := fs.fileToRemote[thisPath]; !known || (owner == r && !fs.createdFiles[thisPath] && !offline) {
+				mTime := uint64(object.MTime.Unix())
+				attr := &fuse.Attr{
+					Mode:  fuse.S_IFREG | recordedMode(object),
+					Size:  uint64(object.Size),
+					Mtime: mTime,
+					Atime: mTime,
+					Ctime: mTime,
+				}
+				fs.files[thisPath] = attr
+				fs.fileToRemote[thisPath] = r
+				if object.Size > 0 && object.Size < r.eagerCacheBelow {
+					eager = append(eager, thisPath)
+				}
+			} else if owner != r {
+				r.Warn("File hidden by the same file in an earlier remote", "path", thisPath)
 			}
-			fs.files[thisPath] = attr
-			fs.fileToRemote[thisPath] = r
 		}
-		fs.dirContents[name] = append(fs.dirContents[name], d)
+		seen[d.Name] = true
+		fs.addDirEntry(name, d)
 
 		// for efficiency, instead of breaking here, we'll keep looping and
-		// cache all the dir contents; this does mean we'll never see externally
-		// added new entries for this dir in the future
+		// cache all the dir contents
 	}
 
 	if !isDir {
 		return fuse.ENOENT
 	}
 
-	fs.dirs[name] = append(fs.dirs[name], r)
+	if !offline {
+		fs.dropStaleEntries(r, name, seen)
+		fs.eagerCache(r, eager)
+	}
+	fs.addRemoteToDir(r, name)
 	if _, exists := fs.dirContents[name]; !exists {
 		// empty dir, we must create an entry in this map
 		fs.dirContents[name] = []fuse.DirEntry{}
@@ -248,27 +561,250 @@ func (fs *MuxFys) openDir(r *remote, name string) fuse.Status {
 	return fuse.OK
 }
 
+// addSingleFile adds the file that a remote configured with the path to a
+// single file presents in the root directory, returning the entry name we
+// added (as a map suitable for dropStaleEntries()). Must be called while you
+// have the mapMutex Locked.
+func (fs *MuxFys) addSingleFile(r *remote) map[string]bool {
+	name := filepath.Join(r.mountSubpath, r.singleFile)
+	if owner, known := fs.fileToRemote[name]; !known || (owner == r && !fs.createdFiles[name]) {
+		mTime := uint64(r.singleFileAttr.MTime.Unix())
+		fs.files[name] = &fuse.Attr{
+			Mode:  fuse.S_IFREG | recordedMode(r.singleFileAttr),
+			Size:  uint64(r.singleFileAttr.Size),
+			Mtime: mTime,
+			Atime: mTime,
+			Ctime: mTime,
+		}
+		fs.fileToRemote[name] = r
+	}
+	fs.addDirEntry(r.mountSubpath, fuse.DirEntry{Name: r.singleFile, Mode: uint32(fuse.S_IFREG)})
+	return map[string]bool{r.singleFile: true}
+}
+
+// dirMarkers returns the set of names of the given objects that represent
+// directories.
+func dirMarkers(objects []RemoteAttr) map[string]bool {
+	markers := make(map[string]bool)
+	for _, object := range objects {
+		if object.Size == 0 && strings.HasSuffix(object.Name, "/") {
+			markers[object.Name] = true
+		}
+	}
+	return markers
+}
+
+// addRemoteToDir notes that the given remote has the directory name, if we
+// didn't already know that. Must be called while you have the mapMutex Locked.
+func (fs *MuxFys) addRemoteToDir(r *remote, name string) {
+	for _, existing := range fs.dirs[name] {
+		if existing == r {
+			return
+		}
+	}
+	fs.dirs[name] = append(fs.dirs[name], r)
+}
+
+// addDirEntry adds d to the entries of directory name, unless an entry with
+// the same name is already there. Must be called while you have the mapMutex
+// Locked.
+func (fs *MuxFys) addDirEntry(name string, d fuse.DirEntry) {
+	for i, existing := range fs.dirContents[name] {
+		if existing.Name == d.Name {
+			if d.Mode == uint32(fuse.S_IFDIR) {
+				// directories win over files with the same name
+				fs.dirContents[name][i].Mode = d.Mode
+			}
+			return
+		}
+	}
+	fs.dirContents[name] = append(fs.dirContents[name], d)
+}
+
+// isOtherDir tells you if name is already known to be a directory in a remote
+// other than r, or was created locally. Must be called while you have the
+// mapMutex Locked.
+func (fs *MuxFys) isOtherDir(r *remote, name string) bool {
+	if fs.createdDirs[name] {
+		return true
+	}
+	for _, existing := range fs.dirs[name] {
+		if existing != r {
+			return true
+		}
+	}
+	return false
+}
+
+// hideFileUnderDir forgets about any remote file we knew of with the same name
+// as the directory name, since the directory wins. Files created locally are
+// left alone. Must be called while you have the mapMutex Locked.
+func (fs *MuxFys) hideFileUnderDir(name string) {
+	owner, known := fs.fileToRemote[name]
+	if !known || fs.createdFiles[name] {
+		return
+	}
+	owner.Warn("File hidden by a directory with the same name", "path", name)
+	delete(fs.files, name)
+	delete(fs.fileToRemote, name)
+}
+
+// dropStaleEntries removes entries of directory name that came from the given
+// remote but that are not in seen (keyed on entry name), as long as they
+// weren't created locally. Must be called while you have the mapMutex Locked.
+func (fs *MuxFys) dropStaleEntries(r *remote, name string, seen map[string]bool) {
+	entries, exists := fs.dirContents[name]
+	if !exists {
+		return
+	}
+
+	kept := entries[:0]
+	for _, entry := range entries {
+		thisPath := filepath.Join(name, entry.Name)
+		if seen[entry.Name] || !fs.entryIsStale(r, thisPath, entry) {
+			kept = append(kept, entry)
+			continue
+		}
+
+		if entry.Mode == uint32(fuse.S_IFDIR) {
+			fs.forgetDir(thisPath)
+		} else {
+			delete(fs.files, thisPath)
+			delete(fs.fileToRemote, thisPath)
+		}
+	}
+	for i := len(kept); i < len(entries); i++ {
+		entries[i] = fuse.DirEntry{}
+	}
+	fs.dirContents[name] = kept
+}
+
+// entryIsStale tells you if the given entry at path came from the given remote
+// and was not created locally. For directories, the remote is forgotten as a
+// source of the directory, and it is only stale if no other remote has it.
+// Must be called while you have the mapMutex Locked.
+func (fs *MuxFys) entryIsStale(r *remote, path string, entry fuse.DirEntry) bool {
+	switch entry.Mode {
+	case uint32(fuse.S_IFDIR):
+		if fs.createdDirs[path] {
+			return false
+		}
+		remotes := fs.dirs[path]
+		kept := remotes[:0]
+		var had bool
+		for _, existing := range remotes {
+			if existing == r {
+				had = true
+				continue
+			}
+			kept = append(kept, existing)
+		}
+		if !had {
+			return false
+		}
+		fs.dirs[path] = kept
+		return len(kept) == 0 && !fs.subpathDirs[path]
+	case uint32(fuse.S_IFREG):
+		return fs.fileToRemote[path] == r && !fs.createdFiles[path]
+	}
+	return false
+}
+
+// forgetDir removes all knowledge of the given directory and everything within
+// it that wasn't created locally. Must be called while you have the mapMutex
+// Locked.
+func (fs *MuxFys) forgetDir(name string) {
+	prefix := name + "/"
+	for path := range fs.dirs {
+		if (path == name || strings.HasPrefix(path, prefix)) && !fs.createdDirs[path] {
+			delete(fs.dirs, path)
+			delete(fs.dirContents, path)
+		}
+	}
+	for path := range fs.files {
+		if strings.HasPrefix(path, prefix) && !fs.createdFiles[path] {
+			delete(fs.files, path)
+			delete(fs.fileToRemote, path)
+		}
+	}
+}
+
 // Open is what is called when any request to read a file is made. The file must
 // already have been stat'ed (eg. with a GetAttr() call), or we report the file
 // doesn't exist. context is not currently used. If CacheData has been
 // configured, we defer to openCached(). Otherwise the real implementation is in
 // remoteFile.
 func (fs *MuxFys) Open(name string, flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	start := time.Now()
+	name = fs.presentedName(name)
+	file, status := fs.open(name, flags, context)
+	if fs.events != nil {
+		var size int64
+		if attr, _, _ := fs.fileDetails(name, false); attr != nil {
+			size = int64(attr.Size)
+		}
+		fs.emitStatus(EventOpen, name, size, start, status)
+	}
+	return file, status
+}
+
+// open is the implementation of Open().
+func (fs *MuxFys) open(name string, flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
 	checkWritable := false
 	if int(flags)&os.O_WRONLY != 0 || int(flags)&os.O_RDWR != 0 || int(flags)&os.O_APPEND != 0 || int(flags)&os.O_CREATE != 0 || int(flags)&os.O_TRUNC != 0 {
 		checkWritable = true
 	}
-	attr, r, status := fs.fileDetails(name, checkWritable)
 	var file nodefs.File
+	if checkWritable && fs.readOnly {
+		return file, fuse.EROFS
+	}
+	attr, r, status := fs.fileDetails(name, checkWritable)
+	if status == fuse.ENOENT && fs.openRetry > 0 {
+		fs.mapMutex.Lock()
+		appeared := !fs.knownMissing(name) && fs.awaitFile(name)
+		fs.mapMutex.Unlock()
+		if appeared {
+			attr, r, status = fs.fileDetails(name, checkWritable)
+		}
+	}
 	if status != fuse.OK {
 		return file, status
 	}
 
-	if r.cacheData {
+	if checkWritable && r.streamWrites {
+		return fs.createStreamed(r, name, flags)
+	}
+
+	// compressed cache files are decompressed while open for writing, and
+	// files that were written to but not fully cached stay uncompressed
+	var plain bool
+	if r.cacheCompress {
+		localPath := r.getLocalPath(r.getRemotePath(name))
+		var err error
+		plain, err = r.holdPlain(localPath, checkWritable)
+		if err != nil {
+			fs.Error("Could not decompress cache file", "path", localPath, "err", err)
+			return file, fuse.EIO
+		}
+		if plain {
+			defer r.releasePlain(localPath)
+		}
+	}
+
+	if r.cacheCompress && !plain {
+		file, status = newCompressedFile(r, r.getRemotePath(name), attr, fs.Logger)
+	} else if r.memCache != nil {
+		// (also never writable)
+		file = newMemCachedFile(r, r.getRemotePath(name), attr, fs.Logger)
+	} else if r.cacheData {
 		file, status = fs.openCached(r, name, flags, context, attr, checkWritable)
 	} else {
 		file = newRemoteFile(r, r.getRemotePath(name), attr, false, fs.Logger)
 	}
+	if status != fuse.OK {
+		return file, status
+	}
+	file = fs.trackHandle(name, file.(countingFile))
 
 	if !r.write || (int(flags)&os.O_WRONLY == 0 && int(flags)&os.O_RDWR == 0) {
 		file = nodefs.NewReadOnlyFile(file)
@@ -291,9 +827,17 @@ func (fs *MuxFys) openCached(r *remote, name string, flags uint32, context *fuse
 	if err != nil {
 		fs.Error("openCached file mutex lock failed", "err", err)
 	}
+	if writeMode {
+		if err = r.unlinkShared(localPath); err != nil {
+			fs.Error("openCached unlink of shared cache file failed", "path", localPath, "err", err)
+			logClose(fs.Logger, fmutex, "openCached file mutex")
+			return nil, fuse.ToStatus(err)
+		}
+	}
 
 	localStats, err := os.Stat(localPath)
 	var create bool
+	var etag string
 	if err != nil {
 		err = os.Remove(localPath)
 		if err != nil && !os.IsNotExist(err) {
@@ -302,45 +846,79 @@ func (fs *MuxFys) openCached(r *remote, name string, flags uint32, context *fuse
 		create = true
 	} else if !writeMode {
 		// check the file is the right size
-		if localStats.Size() != int64(attr.Size) {
+		if r.sharedCache && localStats.Size() != int64(attr.Size) {
+			// another mount sharing our cache may have cached the file at a
+			// different size; use what it cached instead of starting again
+			r.Warn("Cached size differs, trusting shared cache", "path", name, "localSize", localStats.Size(), "remoteSize", attr.Size)
+			if localStats.Size() < int64(attr.Size) {
+				status := r.growCached(localPath, remotePath, localStats.Size(), int64(attr.Size))
+				if status != fuse.OK {
+					logClose(fs.Logger, fmutex, "openCached file mutex")
+					return nil, status
+				}
+			} else if attr.Size > 0 {
+				r.Cached(localPath, NewInterval(0, int64(attr.Size)))
+			}
+		} else if localStats.Size() != int64(attr.Size) {
 			r.Warn("Cached size differs", "path", name, "localSize", localStats.Size(), "remoteSize", attr.Size)
 			err = os.Remove(localPath)
 			if err != nil {
 				fs.Warn("openCached remove cache file failed", "path", localPath, "err", err)
 			}
 			create = true
-			if int(flags)&os.O_WRONLY != 0 || int(flags)&os.O_RDWR != 0 || int(flags)&os.O_APPEND != 0 || int(flags)&os.O_CREATE != 0 || int(flags)&os.O_TRUNC != 0 {
-				attr.Size = uint64(0)
-			}
 		} else if !r.cacheIsTmp {
-			// if the file already exists at the correct size, but we have no
-			// record of it being cached, assume another process sharing the
-			// same permanent cache folder already cached the whole file
-			iv := NewInterval(0, localStats.Size())
-			ivs := r.Uncached(localPath, iv)
-			if len(ivs) > 0 {
-				r.Cached(localPath, iv)
+			if r.canDownloadIfChanged() {
+				etag = r.cachedETag(localPath)
 			}
 
-			// *** doesn't this break if two different mount processes are
-			// trying to read the same file at the same time?... Maybe we'll
-			// need to store cached intervals in the lock file after all...
+			if etag != "" {
+				// the file may have changed since it was cached by a previous
+				// mount; we'll only download it again if it did
+				create = true
+			} else {
+				// if the file already exists at the correct size, but we have
+				// no record of it being cached, assume another process sharing
+				// the same permanent cache folder already cached the whole file
+				iv := NewInterval(0, localStats.Size())
+				ivs := r.Uncached(localPath, iv)
+				if len(ivs) > 0 {
+					r.Cached(localPath, iv)
+				}
+
+				// *** doesn't this break if two different mount processes are
+				// trying to read the same file at the same time?... Maybe we'll
+				// need to store cached intervals in the lock file after all...
+			}
 		}
 	}
 
+	// if our accessor can append to the remote file, we don't need the whole
+	// file to append to it
+	appending := r.cacheIsTmp && int(flags)&os.O_APPEND != 0 && attr.Size > 0 && r.canAppend()
+
 	if create {
 		r.CacheDelete(localPath)
+		r.modified.CacheDelete(localPath)
+		r.forgetAppend(localPath)
 
-		if !r.cacheIsTmp || int(flags)&os.O_APPEND != 0 {
+		if !r.cacheIsTmp || r.verifyChecksum || (int(flags)&os.O_APPEND != 0 && !appending) {
 			// download whole remote object to disk before user appends anything
 			// to it; if we just append to the sparse file then on upload we
 			// lose the contents of the original file. We also do this if we're
 			// not deleting our cache, ie. our cache dir was chosen by the user
-			// and could be in use simultaneously by other muxfys mounts
+			// and could be in use simultaneously by other muxfys mounts, or if
+			// we need to verify the checksum of the whole file
 			// *** alternatively we could store Invervals in the lock file...
-			if status := r.downloadFile(remotePath, localPath); status != fuse.OK {
+			notModified, status := r.downloadFileIfChanged(remotePath, localPath, etag, int64(attr.Size))
+			switch {
+			case etag != "" && r.offline(status):
+				// we already have the whole file from a previous mount
+				r.Warn("Remote unreachable, using cached file", "path", remotePath, "status", status)
+			case status != fuse.OK:
 				logClose(fs.Logger, fmutex, "openCached file mutex")
 				return nil, status
+			case notModified:
+				r.Info("Cached file is up to date", "path", remotePath)
 			}
 
 			// check size ok
@@ -378,8 +956,11 @@ func (fs *MuxFys) openCached(r *remote, name string, flags uint32, context *fuse
 				return nil, fuse.ToStatus(errt)
 			}
 			logClose(fs.Logger, f, "openCached created file", "path", localPath)
+			if attr.Size > 0 {
+				r.storeSparseETag(localPath, remotePath)
+			}
 		}
-	} else if r.cacheIsTmp && int(flags)&os.O_APPEND != 0 {
+	} else if r.cacheIsTmp && int(flags)&os.O_APPEND != 0 && !appending {
 		// cache everything in the file we haven't already read by reading the
 		// file the way a client would
 		iv := Interval{0, int64(attr.Size)}
@@ -437,8 +1018,14 @@ func (fs *MuxFys) openCached(r *remote, name string, flags uint32, context *fuse
 		}
 	}
 
+	if appending && len(r.Uncached(localPath, NewInterval(0, int64(attr.Size)))) > 0 {
+		r.startAppend(localPath, int64(attr.Size))
+	}
+
 	// if the flags suggest any kind of write-ability, treat it like we created
-	// the file
+	// the file. The sparse cache file made above is still the size of the
+	// remote file, so unless create() truncates it (O_TRUNC, or O_WRONLY
+	// without O_APPEND), reads of parts not yet written get the real content
 	if writeMode {
 		return fs.create(name, flags, uint32(fileMode), fmutex)
 	}
@@ -447,8 +1034,11 @@ func (fs *MuxFys) openCached(r *remote, name string, flags uint32, context *fuse
 	return newCachedFile(r, remotePath, localPath, attr, flags, fs.Logger), fuse.OK
 }
 
-// Chmod is ignored.
+// Chmod is ignored (but fails with EROFS in ReadOnly mode).
 func (fs *MuxFys) Chmod(name string, mode uint32, context *fuse.Context) fuse.Status {
+	if fs.readOnly {
+		return fuse.EROFS
+	}
 	_, _, status := fs.fileDetails(name, true)
 	if status == fuse.ENOENT {
 		fs.mapMutex.RLock()
@@ -460,8 +1050,11 @@ func (fs *MuxFys) Chmod(name string, mode uint32, context *fuse.Context) fuse.St
 	return status
 }
 
-// Chown is ignored.
+// Chown is ignored (but fails with EROFS in ReadOnly mode).
 func (fs *MuxFys) Chown(name string, uid uint32, gid uint32, context *fuse.Context) fuse.Status {
+	if fs.readOnly {
+		return fuse.EROFS
+	}
 	_, _, status := fs.fileDetails(name, true)
 	if status == fuse.ENOENT {
 		fs.mapMutex.RLock()
@@ -477,6 +1070,9 @@ func (fs *MuxFys) Chown(name string, uid uint32, gid uint32, context *fuse.Conte
 // configured with CacheData: you can create and use symlinks but they don't get
 // uploaded. context is not currently used.
 func (fs *MuxFys) Symlink(source string, dest string, context *fuse.Context) (status fuse.Status) {
+	if fs.readOnly {
+		return fuse.EROFS
+	}
 	if fs.writeRemote == nil || !fs.writeRemote.cacheData {
 		return fuse.ENOSYS
 	}
@@ -532,8 +1128,12 @@ func (fs *MuxFys) Readlink(name string, context *fuse.Context) (string, fuse.Sta
 	return out, fuse.ToStatus(err)
 }
 
-// SetXAttr is ignored.
+// SetXAttr is ignored, except that with Config.ExposeMetadataXattrs our own
+// metadata attributes can't be set.
 func (fs *MuxFys) SetXAttr(name string, attr string, data []byte, flags int, context *fuse.Context) fuse.Status {
+	if fs.exposeXattrs && strings.HasPrefix(attr, xattrPrefix) {
+		return fuse.ENOTSUP
+	}
 	_, _, status := fs.fileDetails(name, true)
 	if status == fuse.ENOENT {
 		fs.mapMutex.RLock()
@@ -545,8 +1145,12 @@ func (fs *MuxFys) SetXAttr(name string, attr string, data []byte, flags int, con
 	return status
 }
 
-// RemoveXAttr is ignored.
+// RemoveXAttr is ignored, except that with Config.ExposeMetadataXattrs our own
+// metadata attributes can't be removed.
 func (fs *MuxFys) RemoveXAttr(name string, attr string, context *fuse.Context) fuse.Status {
+	if fs.exposeXattrs && strings.HasPrefix(attr, xattrPrefix) {
+		return fuse.ENOTSUP
+	}
 	_, _, status := fs.fileDetails(name, true)
 	if status == fuse.ENOENT {
 		fs.mapMutex.RLock()
@@ -563,6 +1167,9 @@ func (fs *MuxFys) RemoveXAttr(name string, attr string, context *fuse.Context) f
 // like os.Chtimes() (that don't first Open()/Create() the file). context is not
 // currently used.
 func (fs *MuxFys) Utimens(name string, atime *time.Time, mtime *time.Time, context *fuse.Context) fuse.Status {
+	if fs.readOnly {
+		return fuse.EROFS
+	}
 	attr, r, status := fs.fileDetails(name, true)
 	if status == fuse.ENOENT {
 		fs.mapMutex.RLock()
@@ -593,6 +1200,9 @@ func (fs *MuxFys) Utimens(name string, atime *time.Time, mtime *time.Time, conte
 // are only uploaded at Unmount() time. If offset is > size of file, does
 // nothing and returns OK. context is not currently used.
 func (fs *MuxFys) Truncate(name string, offset uint64, context *fuse.Context) fuse.Status {
+	if fs.readOnly {
+		return fuse.EROFS
+	}
 	attr, r, status := fs.fileDetails(name, true)
 	if status != fuse.OK {
 		return status
@@ -616,15 +1226,30 @@ func (fs *MuxFys) Truncate(name string, offset uint64, context *fuse.Context) fu
 			return fuse.EIO
 		}
 		defer logClose(fs.Logger, fmutex, "Trucate mutex file")
+		if r.cacheCompress {
+			if _, err = r.holdPlain(localPath, true); err != nil {
+				fs.Error("Truncate decompress of cache file failed", "path", localPath, "err", err)
+				return fuse.EIO
+			}
+			defer r.releasePlain(localPath)
+		}
+		r.markModified(localPath, int64(attr.Size), int64(offset))
 
 		if _, err := os.Stat(localPath); err == nil {
 			// truncate local cached copy
-			err = os.Truncate(localPath, int64(offset))
+			err = r.unlinkShared(localPath)
+			if err == nil {
+				err = os.Truncate(localPath, int64(offset))
+			}
 			if err != nil {
 				fs.Error("Truncate cached file failed", "path", localPath, "err", err)
 				return fuse.ToStatus(err)
 			}
-			r.CacheTruncate(localPath, int64(offset))
+			if offset < attr.Size {
+				r.truncatedCache(localPath, int64(offset))
+			} else {
+				r.truncatedCache(localPath, int64(attr.Size))
+			}
 		} else {
 			// create a new empty file
 			localFile, err := os.Create(localPath)
@@ -635,7 +1260,7 @@ func (fs *MuxFys) Truncate(name string, offset uint64, context *fuse.Context) fu
 
 			if offset == 0 {
 				logClose(fs.Logger, localFile, "Trucate local file")
-				r.CacheTruncate(localPath, int64(offset))
+				r.truncatedCache(localPath, int64(offset))
 			} else {
 				// download offset bytes of remote file
 				object, status := r.getObject(remotePath, 0)
@@ -643,13 +1268,24 @@ func (fs *MuxFys) Truncate(name string, offset uint64, context *fuse.Context) fu
 					return status
 				}
 
+				if !r.gate.enter(true) {
+					logClose(fs.Logger, object, "Trucate remote object")
+					logClose(fs.Logger, localFile, "Trucate local file")
+					erru := syscall.Unlink(localPath)
+					if erru != nil {
+						fs.Error("Truncate cache file unlink failed", "err", erru)
+					}
+					return pausedStatus
+				}
 				written, err := io.CopyN(localFile, object, int64(offset))
+				r.gate.leave()
 				if err != nil || written != int64(offset) {
 					msg := "Could not copy bytes"
 					if err == nil {
 						msg = "Could not copy all bytes"
 					}
 					r.Error(msg, "size", offset, "source", remotePath, "dest", localPath, "err", err)
+					logClose(fs.Logger, object, "Trucate remote object")
 					logClose(fs.Logger, localFile, "Trucate local file")
 					erru := syscall.Unlink(localPath)
 					if erru != nil {
@@ -662,6 +1298,7 @@ func (fs *MuxFys) Truncate(name string, offset uint64, context *fuse.Context) fu
 				logClose(fs.Logger, object, "Trucate remote object")
 
 				r.CacheOverride(localPath, NewInterval(0, int64(offset)))
+				r.truncatedCache(localPath, int64(offset))
 			}
 		}
 
@@ -680,6 +1317,9 @@ func (fs *MuxFys) Truncate(name string, offset uint64, context *fuse.Context) fu
 // Mkdir for a directory that doesn't exist yet. neither mode nor context are
 // currently used.
 func (fs *MuxFys) Mkdir(name string, mode uint32, context *fuse.Context) fuse.Status {
+	if fs.readOnly {
+		return fuse.EROFS
+	}
 	if fs.writeRemote == nil {
 		return fuse.EPERM
 	}
@@ -690,6 +1330,9 @@ func (fs *MuxFys) Mkdir(name string, mode uint32, context *fuse.Context) fuse.St
 	if _, isDir := fs.dirs[name]; isDir {
 		return fuse.OK
 	}
+	if _, ok := fs.writeRemote.relPath(name); !ok {
+		return fuse.EPERM
+	}
 
 	// it's parent directory must already exist
 	parent := filepath.Dir(name)
@@ -733,9 +1376,21 @@ func (fs *MuxFys) Mkdir(name string, mode uint32, context *fuse.Context) fuse.St
 	return fuse.OK
 }
 
-// Rmdir only works for non-existent or empty dirs. context is not currently
-// used.
+// Rmdir only works for non-existent or empty dirs, unless configured with
+// AllowRecursiveRmdir, in which case non-empty dirs have all their contents
+// deleted from the writeable remote first. context is not currently used.
 func (fs *MuxFys) Rmdir(name string, context *fuse.Context) fuse.Status {
+	start := time.Now()
+	status := fs.rmdir(name)
+	fs.emitStatus(EventDelete, name, 0, start, status)
+	return status
+}
+
+// rmdir is the implementation of Rmdir().
+func (fs *MuxFys) rmdir(name string) fuse.Status {
+	if fs.readOnly {
+		return fuse.EROFS
+	}
 	if fs.writeRemote == nil {
 		return fuse.EPERM
 	}
@@ -745,13 +1400,20 @@ func (fs *MuxFys) Rmdir(name string, context *fuse.Context) fuse.Status {
 
 	if _, isDir := fs.dirs[name]; !isDir {
 		return fuse.ENOENT
-	} else if contents, exists := fs.dirContents[name]; exists && len(contents) > 0 {
+	} else if contents, exists := fs.dirContents[name]; exists && len(contents) > 0 && !fs.recursiveRmdir {
 		return fuse.ENOSYS
+	} else if relPath, ok := fs.writeRemote.relPath(name); !ok || relPath == "" {
+		return fuse.EPERM
 	}
 
 	remotePath := fs.writeRemote.getRemotePath(name)
 	var err error
-	if fs.writeRemote.cacheData {
+	if len(fs.dirContents[name]) > 0 {
+		status := fs.rmdirContents(name)
+		if status != fuse.OK {
+			return status
+		}
+	} else if fs.writeRemote.cacheData {
 		localPath := fs.writeRemote.getLocalPath(remotePath)
 		err = syscall.Rmdir(localPath)
 		if err != nil {
@@ -769,21 +1431,119 @@ func (fs *MuxFys) Rmdir(name string, context *fuse.Context) fuse.Status {
 	return fuse.OK
 }
 
+// rmdirContents deletes everything in the given non-empty directory from our
+// writeRemote, along with any cached or created files in it and everything we
+// know about its contents, for a recursive rmdir(). You must have the mapMutex
+// Locked.
+func (fs *MuxFys) rmdirContents(name string) fuse.Status {
+	r := fs.writeRemote
+	remotePath := r.getRemotePath(name)
+	status := r.deleteDir(remotePath + "/")
+	if status != fuse.OK {
+		return status
+	}
+
+	prefix := name + "/"
+	if r.cacheData {
+		localPath := r.getLocalPath(remotePath)
+		var err error
+		if r.cacheShard {
+			// cached files are not within the directory's local path, so we
+			// delete the ones we know about individually
+			for path, owner := range fs.fileToRemote {
+				if owner != r || !strings.HasPrefix(path, prefix) {
+					continue
+				}
+				fileLocalPath := r.getLocalPath(r.getRemotePath(path))
+				r.CacheDelete(fileLocalPath)
+				r.modified.CacheDelete(fileLocalPath)
+				r.forgetAppend(fileLocalPath)
+				r.forgetMetadata(fileLocalPath)
+				if errr := os.Remove(fileLocalPath); errr != nil && !os.IsNotExist(errr) {
+					fs.Warn("Rmdir could not delete cached file", "path", fileLocalPath, "err", errr)
+				}
+			}
+		} else {
+			err = filepath.Walk(localPath, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if !info.IsDir() {
+					r.CacheDelete(path)
+					r.modified.CacheDelete(path)
+					r.forgetAppend(path)
+					r.forgetMetadata(path)
+				}
+				return nil
+			})
+			if err != nil && !os.IsNotExist(err) {
+				fs.Warn("Rmdir could not walk cache", "path", localPath, "err", err)
+			}
+		}
+		err = os.RemoveAll(localPath)
+		if err != nil {
+			fs.Error("Rmdir failed", "path", localPath, "err", err)
+			return fuse.ToStatus(err)
+		}
+	}
+
+	for path := range fs.files {
+		if strings.HasPrefix(path, prefix) {
+			delete(fs.files, path)
+			delete(fs.fileToRemote, path)
+		}
+	}
+	for path := range fs.createdFiles {
+		if strings.HasPrefix(path, prefix) {
+			delete(fs.createdFiles, path)
+		}
+	}
+	for path := range fs.dirs {
+		if strings.HasPrefix(path, prefix) {
+			delete(fs.dirs, path)
+			delete(fs.dirContents, path)
+			delete(fs.createdDirs, path)
+		}
+	}
+	return fuse.OK
+}
+
 // Rename only works where oldPath is found in the writeable remote. For files,
-// first remotely copies oldPath to newPath (ignoring any local changes to
-// oldPath), renames any local cached (and possibly modified) copy of oldPath to
-// newPath, and finally deletes the remote oldPath; if oldPath had been
-// modified, its changes will only be uploaded to newPath at Unmount() time. For
-// directories, is only capable of renaming directories you have created whilst
-// mounted. context is not currently used.
+// first remotely copies oldPath to newPath, renames any local cached copy of
+// oldPath to newPath, and finally deletes the remote oldPath. If oldPath had
+// been created or modified and its local changes are yet to be uploaded, the
+// remote copy is skipped if the whole file is cached; its new content will only
+// be uploaded to newPath at Unmount() (or Commit()) time, and the remote
+// oldPath is only deleted once that upload succeeds. For directories, is only
+// capable of renaming directories you have created whilst mounted. context is
+// not currently used.
 func (fs *MuxFys) Rename(oldPath string, newPath string, context *fuse.Context) fuse.Status {
+	start := time.Now()
+	status := fs.rename(oldPath, newPath)
+	if fs.events != nil {
+		fs.emit(Event{Type: EventRename, Path: oldPath, NewPath: newPath, Duration: time.Since(start), Err: statusError(status)})
+	}
+	return status
+}
+
+// rename is the implementation of Rename().
+func (fs *MuxFys) rename(oldPath string, newPath string) fuse.Status {
+	if fs.readOnly {
+		return fuse.EROFS
+	}
 	if fs.writeRemote == nil {
 		return fuse.EPERM
 	}
 
-	fs.mapMutex.Lock()
+	fs.lockWhenNotUploading(oldPath, newPath)
 	defer fs.mapMutex.Unlock()
 
+	_, oldOK := fs.writeRemote.relPath(oldPath)
+	_, newOK := fs.writeRemote.relPath(newPath)
+	if !oldOK || !newOK {
+		return fuse.EPERM
+	}
+
 	var isDir bool
 	if _, isDir = fs.dirs[oldPath]; !isDir {
 		if _, isFile := fs.fileToRemote[oldPath]; !isFile {
@@ -830,10 +1590,20 @@ func (fs *MuxFys) Rename(oldPath string, newPath string, context *fuse.Context)
 			return fuse.ToStatus(err)
 		}
 	} else {
-		// first trigger a remote copy of oldPath to newPath
-		status := fs.writeRemote.copyFile(remotePathOld, remotePathNew)
-		if status != fuse.OK {
-			return status
+		// first trigger a remote copy of oldPath to newPath, unless oldPath
+		// has local changes that will be uploaded to newPath anyway, and is
+		// fully cached so we won't need to read any of it from the remote
+		pending := false
+		if fs.writeRemote.cacheData && fs.createdFiles[oldPath] {
+			size := int64(fs.files[oldPath].Size)
+			localPathOld := fs.writeRemote.getLocalPath(remotePathOld)
+			pending = size == 0 || len(fs.writeRemote.Uncached(localPathOld, NewInterval(0, size))) == 0
+		}
+		if !pending {
+			status := fs.writeRemote.copyFile(remotePathOld, remotePathNew)
+			if status != fuse.OK {
+				return status
+			}
 		}
 
 		if fs.writeRemote.cacheData {
@@ -861,12 +1631,32 @@ func (fs *MuxFys) Rename(oldPath string, newPath string, context *fuse.Context)
 			}
 			defer logClose(fs.Logger, fmutex2, "Rename file mutex")
 
+			if fs.writeRemote.cacheCompress {
+				// we move plain cache files, compressing the new one again
+				// afterwards if possible
+				for _, localPath := range []string{localPathOld, localPathNew} {
+					if _, err = fs.writeRemote.holdPlain(localPath, true); err != nil {
+						fs.Error("Rename decompress of cache file failed", "path", localPath, "err", err)
+						return fuse.EIO
+					}
+					defer fs.writeRemote.releasePlain(localPath)
+				}
+			}
+
 			// if we've cached oldPath, move to new cached file
 			err = os.Rename(localPathOld, localPathNew)
 			if err != nil {
 				fs.Error("Rename of cached files failed", "source", localPathOld, "dest", localPathNew, "err", err)
 			}
 			fs.writeRemote.CacheRename(localPathOld, localPathNew)
+			fs.writeRemote.modified.CacheRename(localPathOld, localPathNew)
+			fs.writeRemote.renameAppend(localPathOld, localPathNew)
+			fs.writeRemote.renameMetadata(localPathOld, localPathNew)
+			if pending {
+				// the remote newPath doesn't have oldPath's unmodified parts, so
+				// they must all be uploaded too
+				fs.writeRemote.markModified(localPathNew, 0, int64(fs.files[oldPath].Size))
+			}
 		}
 
 		// cache the existence of the new file
@@ -877,11 +1667,23 @@ func (fs *MuxFys) Rename(oldPath string, newPath string, context *fuse.Context)
 			delete(fs.createdFiles, oldPath)
 		}
 		fs.addNewEntryToItsDir(newPath, fuse.S_IFREG)
-
-		// finally unlink oldPath remotely
-		r := fs.fileToRemote[oldPath]
-		if r != nil {
-			r.deleteFile(remotePathOld)
+		renamedFrom := append(fs.renamedFrom[newPath], fs.renamedFrom[oldPath]...)
+		delete(fs.renamedFrom, oldPath)
+
+		// finally unlink oldPath remotely, unless its content has yet to reach
+		// newPath, in which case it's only unlinked once newPath is uploaded,
+		// so that a failed upload doesn't lose it
+		if pending {
+			fs.renamedFrom[newPath] = append(renamedFrom, oldPath)
+		} else {
+			fs.renamedFrom[newPath] = renamedFrom
+			r := fs.fileToRemote[oldPath]
+			if r != nil {
+				r.deleteFile(remotePathOld)
+			}
+		}
+		if len(fs.renamedFrom[newPath]) == 0 {
+			delete(fs.renamedFrom, newPath)
 		}
 		delete(fs.files, oldPath)
 		delete(fs.fileToRemote, oldPath)
@@ -893,14 +1695,44 @@ func (fs *MuxFys) Rename(oldPath string, newPath string, context *fuse.Context)
 	return fuse.ENOSYS
 }
 
+// takeRenamedFrom forgets the names that the created file with the given name
+// was renamed from before being uploaded, returning the remote paths of those
+// that should now be deleted, ie. those that haven't since been re-used. You
+// must hold the mapMutex lock when calling this.
+func (fs *MuxFys) takeRenamedFrom(name string) []string {
+	var remotePaths []string
+	for _, oldName := range fs.renamedFrom[name] {
+		if _, exists := fs.files[oldName]; exists {
+			continue
+		}
+		remotePaths = append(remotePaths, fs.writeRemote.getRemotePath(oldName))
+	}
+	delete(fs.renamedFrom, name)
+	return remotePaths
+}
+
 // Unlink deletes a file from the remote system, as well as any locally cached
 // copy. context is not currently used.
 func (fs *MuxFys) Unlink(name string, context *fuse.Context) fuse.Status {
+	start := time.Now()
+	status := fs.unlink(name)
+	fs.emitStatus(EventDelete, name, 0, start, status)
+	return status
+}
+
+// unlink is the implementation of Unlink().
+func (fs *MuxFys) unlink(name string) fuse.Status {
+	if fs.readOnly {
+		return fuse.EROFS
+	}
 	_, r, status := fs.fileDetails(name, true)
 	if status != fuse.OK {
 		return status
 	}
 
+	fs.lockWhenNotUploading(name)
+	defer fs.mapMutex.Unlock()
+
 	remotePath := r.getRemotePath(name)
 	if r.cacheData {
 		localPath := r.getLocalPath(remotePath)
@@ -911,18 +1743,24 @@ func (fs *MuxFys) Unlink(name string, context *fuse.Context) fuse.Status {
 		if err != nil {
 			fs.Warn("Unlink failed", "path", localPath, "err", err)
 		}
+		if r.cacheCompress {
+			r.forgetCompressed(localPath)
+		}
 		r.CacheDelete(localPath)
+		r.modified.CacheDelete(localPath)
+		r.forgetAppend(localPath)
+		r.forgetMetadata(localPath)
 	}
 
-	fs.mapMutex.Lock()
-	defer fs.mapMutex.Unlock()
-
 	delete(fs.createdFiles, name)
 
 	status = r.deleteFile(remotePath)
 	if status != fuse.OK {
 		return status
 	}
+	for _, oldRemotePath := range fs.takeRenamedFrom(name) {
+		r.deleteFile(oldRemotePath)
+	}
 
 	delete(fs.files, name)
 	delete(fs.fileToRemote, name)
@@ -931,16 +1769,88 @@ func (fs *MuxFys) Unlink(name string, context *fuse.Context) fuse.Status {
 	return fuse.OK
 }
 
-// Access is ignored.
+// Access checks the requested access mode against what we already know about
+// the file or directory, without making any remote calls. Files can only be
+// written to if they belong to a writeable remote, and directories if we have a
+// writeable remote. context is not currently used.
 func (fs *MuxFys) Access(name string, mode uint32, context *fuse.Context) fuse.Status {
-	return fuse.OK
+	write := mode&fuse.W_OK != 0
+	if write && fs.readOnly {
+		return fuse.EROFS
+	}
+
+	fs.mapMutex.RLock()
+	name = fs.lookupName(name)
+	_, isDir := fs.dirs[name]
+	fs.mapMutex.RUnlock()
+	if isDir {
+		if write && fs.writeRemote == nil {
+			return fuse.EACCES
+		}
+		return fuse.OK
+	}
+
+	_, _, status := fs.fileDetails(name, write)
+	if status == fuse.EPERM {
+		return fuse.EACCES
+	}
+	return status
 }
 
 // Create creates a new file. mode and context are not currently used. When
 // configured with CacheData the contents of the created file are only uploaded
 // at Unmount() time.
 func (fs *MuxFys) Create(name string, flags uint32, mode uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
-	return fs.create(name, flags, mode)
+	if fs.readOnly {
+		return nil, fuse.EROFS
+	}
+	start := time.Now()
+	status := fs.createConflicts(name, flags)
+	var file nodefs.File
+	if status == fuse.OK {
+		file, status = fs.create(name, flags, mode)
+	}
+	fs.emitStatus(EventCreate, name, 0, start, status)
+	return file, status
+}
+
+// createConflicts returns EEXIST if name already exists and flags includes
+// O_EXCL, or if our writeable remote is configured with NoOverwrite and name
+// exists remotely (ie. it isn't a file we created and haven't uploaded). Files
+// we don't know about are looked for with a remote stat in the latter case.
+func (fs *MuxFys) createConflicts(name string, flags uint32) fuse.Status {
+	r := fs.writeRemote
+	excl := int(flags)&os.O_EXCL != 0
+	if r == nil || (!excl && !r.noOverwrite) {
+		return fuse.OK
+	}
+
+	fs.mapMutex.RLock()
+	_, exists := fs.files[name]
+	created := fs.createdFiles[name]
+	fs.mapMutex.RUnlock()
+	if exists {
+		if excl || !created {
+			return fuse.Status(syscall.EEXIST)
+		}
+		return fuse.OK
+	}
+	if !r.noOverwrite {
+		return fuse.OK
+	}
+
+	if _, ok := r.relPath(name); !ok {
+		return fuse.OK
+	}
+	_, exists, status := r.statFile(r.getRemotePath(name))
+	if exists {
+		r.Warn("Not overwriting existing file", "path", name)
+		return fuse.Status(syscall.EEXIST)
+	}
+	if status == fuse.ENOENT {
+		return fuse.OK
+	}
+	return status
 }
 
 // create is the implementation of Create() that also takes an optional
@@ -950,6 +1860,16 @@ func (fs *MuxFys) create(name string, flags uint32, mode uint32, fmutex ...*file
 	if r == nil {
 		return nil, fuse.EPERM
 	}
+	if _, ok := r.relPath(name); !ok {
+		return nil, fuse.EPERM
+	}
+
+	if r.streamWrites {
+		if len(fmutex) == 1 {
+			logClose(fs.Logger, fmutex[0], "file mutex")
+		}
+		return fs.createStreamed(r, name, flags)
+	}
 
 	remotePath := r.getRemotePath(name)
 	var localPath string
@@ -970,6 +1890,19 @@ func (fs *MuxFys) create(name string, flags uint32, mode uint32, fmutex ...*file
 			}
 			defer logClose(fs.Logger, fm, "file mutex", "path", localPath)
 		}
+
+		if err := r.unlinkShared(localPath); err != nil {
+			fs.Error("create unlink of shared cache file failed", "path", localPath, "err", err)
+			return nil, fuse.ToStatus(err)
+		}
+
+		if r.cacheCompress {
+			if _, err := r.holdPlain(localPath, true); err != nil {
+				fs.Error("create decompress of cache file failed", "path", localPath, "err", err)
+				return nil, fuse.EIO
+			}
+			defer r.releasePlain(localPath)
+		}
 	}
 
 	fs.mapMutex.Lock()
@@ -982,7 +1915,7 @@ func (fs *MuxFys) create(name string, flags uint32, mode uint32, fmutex ...*file
 		fs.addNewEntryToItsDir(name, fuse.S_IFREG)
 
 		attr = &fuse.Attr{
-			Mode:  fuse.S_IFREG | uint32(fileMode),
+			Mode:  fuse.S_IFREG | r.createdFileMode(),
 			Size:  uint64(0),
 			Mtime: mTime,
 			Atime: mTime,
@@ -991,30 +1924,92 @@ func (fs *MuxFys) create(name string, flags uint32, mode uint32, fmutex ...*file
 		fs.files[name] = attr
 		fs.fileToRemote[name] = r
 	} else {
+		attr.Mode = fuse.S_IFREG | r.createdFileMode()
 		attr.Mtime = mTime
 		attr.Atime = mTime
 
-		// *** when not appending, don't we need to reset to 0? It seems to work
-		// without this, and we avoid incorrect reset to 0 when something
-		// opens many simultaneous non-append writes to write at different
-		// offsets.
-		// if int(flags)&os.O_APPEND == 0 {
-		// 	r.CacheDelete(localPath)
-		// 	attr.Size = uint64(0)
-		// }
+		// unless we've been asked to preserve the existing content by
+		// appending or opening for read and write, we must truncate to 0, or
+		// else writing something shorter than the existing file would leave
+		// the end of the old content in place and it would get uploaded
+		if int(flags)&os.O_TRUNC != 0 || (int(flags)&os.O_APPEND == 0 && int(flags)&os.O_RDWR == 0) {
+			if r.cacheData {
+				r.truncatedCache(localPath, 0)
+				r.markModified(localPath, 0, int64(attr.Size))
+				err := os.Truncate(localPath, 0)
+				if err != nil && !os.IsNotExist(err) {
+					fs.Error("create truncate cache file failed", "path", localPath, "err", err)
+					return nil, fuse.ToStatus(err)
+				}
+			}
+			attr.Size = uint64(0)
+		}
 	}
 	fs.createdFiles[name] = true
 
 	if r.cacheData {
-		return newCachedFile(r, remotePath, localPath, attr, uint32(int(flags)|os.O_CREATE), fs.Logger), fuse.OK
+		return fs.trackHandle(name, newCachedFile(r, remotePath, localPath, attr, uint32(int(flags)|os.O_CREATE), fs.Logger).(countingFile)), fuse.OK
+	}
+	return fs.trackHandle(name, newRemoteFile(r, remotePath, attr, true, fs.Logger).(countingFile)), fuse.OK
+}
+
+// createStreamed is the implementation of create() for remotes with
+// StreamWrites, returning a remoteFile that uploads data as it is written. Any
+// existing file is replaced.
+func (fs *MuxFys) createStreamed(r *remote, name string, flags uint32) (nodefs.File, fuse.Status) {
+	if int(flags)&os.O_RDWR != 0 || int(flags)&os.O_APPEND != 0 {
+		// we'd need the existing content, or to read back what was written
+		return nil, fuse.ENOSYS
+	}
+
+	remotePath := r.getRemotePath(name)
+	if r.cacheData {
+		// any cached copy of the existing file will be out of date
+		localPath := r.getLocalPath(remotePath)
+		r.CacheDelete(localPath)
+		r.modified.CacheDelete(localPath)
+		err := os.Remove(localPath)
+		if err != nil && !os.IsNotExist(err) {
+			fs.Warn("createStreamed remove cache file failed", "path", localPath, "err", err)
+		}
+	}
+
+	fs.mapMutex.Lock()
+	defer fs.mapMutex.Unlock()
+
+	attr, existed := fs.files[name]
+	mTime := uint64(time.Now().Unix())
+	if !existed {
+		fs.addNewEntryToItsDir(name, fuse.S_IFREG)
+
+		attr = &fuse.Attr{
+			Mode:  fuse.S_IFREG | r.createdFileMode(),
+			Mtime: mTime,
+			Atime: mTime,
+			Ctime: mTime,
+		}
+		fs.files[name] = attr
+		fs.fileToRemote[name] = r
+	} else {
+		attr.Size = uint64(0)
+		attr.Mtime = mTime
+		attr.Atime = mTime
 	}
-	return newRemoteFile(r, remotePath, attr, true, fs.Logger), fuse.OK
+
+	// nothing is staged on disk for Unmount() to upload, even if this file was
+	// previously created or truncated
+	delete(fs.createdFiles, name)
+
+	return fs.trackHandle(name, newRemoteFile(r, remotePath, attr, true, fs.Logger).(countingFile)), fuse.OK
 }
 
 // addNewEntryToItsDir adds a DirEntry for the file/dir named name to that
-// object's containing directory entries. mode should be fuse.S_IFREG or
-// fuse.S_IFDIR. Must be called while you have the mapMutex Locked.
+// object's containing directory entries, and forgets that name didn't exist.
+// mode should be fuse.S_IFREG or fuse.S_IFDIR. Must be called while you have
+// the mapMutex Locked.
 func (fs *MuxFys) addNewEntryToItsDir(name string, mode int) {
+	delete(fs.negativeCache, name)
+
 	d := fuse.DirEntry{
 		Name: filepath.Base(name),
 		Mode: uint32(mode),
@@ -1028,12 +2023,12 @@ func (fs *MuxFys) addNewEntryToItsDir(name string, mode int) {
 		// we must populate the contents of parent first, doing the essential
 		// part of OpenDir()
 		if remotes, exists := fs.dirs[parent]; exists {
-			for _, r := range remotes {
-				status := fs.openDir(r, parent)
+			for _, status := range fs.openDirs(remotes, parent) {
 				if status != fuse.OK {
 					fs.Warn("addNewEntryToItsDir openDir failed", "path", parent, "status", status)
 				}
 			}
+			fs.addSubpathEntries(parent)
 		}
 	}
 	fs.dirContents[parent] = append(fs.dirContents[parent], d)
@@ -1064,8 +2059,8 @@ func (fs *MuxFys) rmEntryFromItsDir(name string) {
 }
 
 // getFileMutex prepares a lock file for the given local path (in that path's
-// directory, creating the directory first if necessary), and returns a mutex
-// that you should Lock() and Close().
+// directory, creating the directory first if necessary, or in our LockDir if
+// configured), and returns a mutex that you should Lock() and Close().
 func (fs *MuxFys) getFileMutex(localPath string) (*filemutex.FileMutex, error) {
 	parent := filepath.Dir(localPath)
 	if _, err := os.Stat(parent); err != nil && os.IsNotExist(err) {
@@ -1075,7 +2070,12 @@ func (fs *MuxFys) getFileMutex(localPath string) (*filemutex.FileMutex, error) {
 			return nil, err
 		}
 	}
-	mutex, err := filemutex.New(filepath.Join(parent, ".muxfys_lock."+filepath.Base(localPath)))
+	lockPath := filepath.Join(parent, ".muxfys_lock."+filepath.Base(localPath))
+	if fs.lockDir != "" {
+		sum := sha256.Sum256([]byte(localPath))
+		lockPath = filepath.Join(fs.lockDir, ".muxfys_lock."+hex.EncodeToString(sum[:]))
+	}
+	mutex, err := filemutex.New(lockPath)
 	if err != nil {
 		fs.Error("Could not create lock file", "path", localPath, "err", err)
 	}
@@ -0,0 +1,101 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+// This file contains the implementation of sending go-fuse's debug output to
+// our logs, for Config.FuseDebug.
+
+import (
+	"io"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/inconshreveable/log15"
+)
+
+// fuseDebugMsg is what we log go-fuse's debug output as, when Config.FuseDebug
+// is on.
+const fuseDebugMsg = "FUSE debug"
+
+// go-fuse writes its debug output with the standard library logger, which is
+// global, so we keep track of which mounts want it, and what it was doing
+// before the first of them did.
+var (
+	fuseDebugMutex  sync.Mutex
+	fuseDebuggers   []*MuxFys
+	fuseDebugOutput io.Writer
+	fuseDebugFlags  int
+)
+
+// fuseDebugWriter is an io.Writer that logs each line written to it as a debug
+// message.
+type fuseDebugWriter struct {
+	logger log15.Logger
+}
+
+// Write implements io.Writer.
+func (w *fuseDebugWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		w.logger.Debug(fuseDebugMsg, "out", line)
+	}
+	return len(p), nil
+}
+
+// startFuseDebug, if we were configured with FuseDebug, makes the standard
+// library logger write to our logger, until stopFuseDebug() is called. If
+// multiple mounts want this at once, the output goes to the most recent.
+func (fs *MuxFys) startFuseDebug() {
+	if !fs.fuseDebug || fs.fuseDebugging {
+		return
+	}
+	fuseDebugMutex.Lock()
+	defer fuseDebugMutex.Unlock()
+	if len(fuseDebuggers) == 0 {
+		fuseDebugOutput = log.Writer()
+		fuseDebugFlags = log.Flags()
+	}
+	fuseDebuggers = append(fuseDebuggers, fs)
+	fs.fuseDebugging = true
+	log.SetFlags(0)
+	log.SetOutput(&fuseDebugWriter{logger: fs.Logger})
+}
+
+// stopFuseDebug undoes startFuseDebug(), restoring the standard library
+// logger once no mount wants its output any more.
+func (fs *MuxFys) stopFuseDebug() {
+	if !fs.fuseDebugging {
+		return
+	}
+	fuseDebugMutex.Lock()
+	defer fuseDebugMutex.Unlock()
+	for i, other := range fuseDebuggers {
+		if other == fs {
+			fuseDebuggers = append(fuseDebuggers[:i], fuseDebuggers[i+1:]...)
+			break
+		}
+	}
+	fs.fuseDebugging = false
+	if len(fuseDebuggers) > 0 {
+		log.SetOutput(&fuseDebugWriter{logger: fuseDebuggers[len(fuseDebuggers)-1].Logger})
+		return
+	}
+	log.SetFlags(fuseDebugFlags)
+	log.SetOutput(fuseDebugOutput)
+}
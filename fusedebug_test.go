@@ -0,0 +1,83 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFuseDebug(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "muxfys_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	logsContaining := func(fs *MuxFys, substr string) int {
+		var n int
+		for _, l := range fs.Logs() {
+			if strings.Contains(l, substr) {
+				n++
+			}
+		}
+		return n
+	}
+
+	Convey("FuseDebug sends the standard library logger's output to Logs() while mounted", t, func() {
+		output, flags := log.Writer(), log.Flags()
+
+		fs, err := New(&Config{Mount: filepath.Join(tmpdir, "a"), FuseDebug: true})
+		So(err, ShouldBeNil)
+		other, err := New(&Config{Mount: filepath.Join(tmpdir, "b"), FuseDebug: true})
+		So(err, ShouldBeNil)
+		off, err := New(&Config{Mount: filepath.Join(tmpdir, "c")})
+		So(err, ShouldBeNil)
+
+		off.startFuseDebug()
+		So(off.fuseDebugging, ShouldBeFalse)
+		So(log.Writer(), ShouldEqual, output)
+
+		fs.startFuseDebug()
+		log.Printf("rx 1: LOOKUP")
+		So(logsContaining(fs, `msg="FUSE debug"`), ShouldEqual, 1)
+		So(logsContaining(fs, `out="rx 1: LOOKUP"`), ShouldEqual, 1)
+		So(logsContaining(fs, "lvl=dbug"), ShouldEqual, 1)
+
+		other.startFuseDebug()
+		log.Printf("rx 2: GETATTR")
+		So(logsContaining(other, "rx 2: GETATTR"), ShouldEqual, 1)
+		other.stopFuseDebug()
+		log.Printf("rx 3: OPEN")
+		So(logsContaining(fs, "rx 3: OPEN"), ShouldEqual, 1)
+		So(logsContaining(other, "rx 3: OPEN"), ShouldEqual, 0)
+
+		fs.stopFuseDebug()
+		fs.stopFuseDebug()
+		So(log.Writer(), ShouldEqual, output)
+		So(log.Flags(), ShouldEqual, flags)
+		So(off.Logs(), ShouldBeEmpty)
+	})
+}
@@ -0,0 +1,273 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+// This file implements RemoteConfig.TransparentGunzip. Remote files with a
+// ".gz" extension are presented decompressed, at the same path. Their
+// decompressed size comes from their "original-size" metadata if they have
+// it, or otherwise from decompressing them once. gzip files can't be
+// decompressed from an arbitrary offset without an index, which we don't
+// have, so reads from an offset decompress from the start of the file and
+// discard what comes before it, and seeking backwards starts again.
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// gzipExtension is the extension of remote files that TransparentGunzip
+	// decompresses.
+	gzipExtension = ".gz"
+
+	// originalSizeMetadataKey is the user metadata key (eg. the
+	// "x-amz-meta-original-size" header of S3 objects) that we look for to
+	// learn the decompressed size of a gzip file without decompressing it.
+	originalSizeMetadataKey = "original-size"
+)
+
+// gunzipAccessor is a RemoteAccessor that wraps another one, decompressing
+// the gzip files it downloads.
+type gunzipAccessor struct {
+	RemoteAccessor
+	sizes map[string]gunzipSize
+	mutex sync.Mutex
+}
+
+// gunzipSize is the decompressed size of a gzip file, as of when it was the
+// remote file described by attr.
+type gunzipSize struct {
+	attr RemoteAttr
+	size int64
+}
+
+// newGunzipAccessor wraps the given accessor so that gzip files are presented
+// decompressed.
+func newGunzipAccessor(accessor RemoteAccessor) *gunzipAccessor {
+	return &gunzipAccessor{RemoteAccessor: accessor, sizes: make(map[string]gunzipSize)}
+}
+
+// isGzip tells you if the given remote path has the extension of the files we
+// decompress.
+func isGzip(path string) bool {
+	return strings.HasSuffix(path, gzipExtension)
+}
+
+// ListEntries implements RemoteAccessor by reporting the decompressed sizes of
+// gzip files.
+func (a *gunzipAccessor) ListEntries(dir string) ([]RemoteAttr, error) {
+	ras, err := a.RemoteAccessor.ListEntries(dir)
+	if err != nil {
+		return nil, err
+	}
+	for i := range ras {
+		if strings.HasSuffix(ras[i].Name, "/") || !isGzip(ras[i].Name) {
+			continue
+		}
+		size, err := a.decompressedSize(ras[i])
+		if err != nil {
+			return nil, err
+		}
+		ras[i].Size = size
+		ras[i].MD5 = ""
+	}
+	return ras, nil
+}
+
+// decompressedSize returns the size the given gzip file will have when we
+// decompress it, remembering it so that we only decompress the file to find
+// out once, unless it changes. Files that turn out not to be gzip files are
+// left as they are, so have their own size.
+func (a *gunzipAccessor) decompressedSize(ra RemoteAttr) (int64, error) {
+	a.mutex.Lock()
+	known, ok := a.sizes[ra.Name]
+	a.mutex.Unlock()
+	if ok && known.attr.Size == ra.Size && known.attr.MTime.Equal(ra.MTime) && known.attr.MD5 == ra.MD5 {
+		return known.size, nil
+	}
+
+	size, ok := originalSize(ra.Metadata)
+	if !ok {
+		if fs, isStater := a.RemoteAccessor.(FileStater); isStater {
+			stated, err := fs.StatFile(ra.Name)
+			if err != nil {
+				return 0, err
+			}
+			size, ok = originalSize(stated.Metadata)
+		}
+	}
+	if !ok {
+		rc, err := a.OpenFile(ra.Name, 0)
+		if err != nil {
+			return 0, err
+		}
+		size, err = io.Copy(ioutil.Discard, rc)
+		rc.Close()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	a.mutex.Lock()
+	a.sizes[ra.Name] = gunzipSize{attr: ra, size: size}
+	a.mutex.Unlock()
+	return size, nil
+}
+
+// originalSize returns the size recorded in the given metadata under our
+// originalSizeMetadataKey, and whether there was a valid one.
+func originalSize(metadata map[string]string) (int64, bool) {
+	val, ok := metadata[originalSizeMetadataKey]
+	if !ok {
+		return 0, false
+	}
+	size, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || size < 0 {
+		return 0, false
+	}
+	return size, true
+}
+
+// DownloadFile implements RemoteAccessor by decompressing the remote source
+// file as it is downloaded to dest, if it's a gzip file.
+func (a *gunzipAccessor) DownloadFile(source, dest string) error {
+	if !isGzip(source) {
+		return a.RemoteAccessor.DownloadFile(source, dest)
+	}
+	reader, err := a.OpenFile(source, 0)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	err = os.MkdirAll(filepath.Dir(dest), os.FileMode(dirMode))
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, reader)
+	errc := f.Close()
+	if err == nil {
+		err = errc
+	}
+	if err != nil {
+		errr := os.Remove(dest)
+		if errr != nil && !os.IsNotExist(errr) {
+			err = fmt.Errorf("%s (and removing the partial download failed: %s)", err, errr)
+		}
+	}
+	return err
+}
+
+// OpenFile implements RemoteAccessor by returning a reader that decompresses
+// the remote file from the given decompressed offset, if it's a gzip file.
+func (a *gunzipAccessor) OpenFile(path string, offset int64) (io.ReadCloser, error) {
+	if !isGzip(path) {
+		return a.RemoteAccessor.OpenFile(path, offset)
+	}
+	rc, err := a.RemoteAccessor.OpenFile(path, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(rc)
+	magic, err := br.Peek(2)
+	if err != nil && err != io.EOF {
+		// (some accessors only report non-existent files on first read)
+		rc.Close()
+		return nil, err
+	}
+	if len(magic) < 2 || magic[0] != 0x1f || magic[1] != 0x8b {
+		// not actually a gzip file, so we present it as it is
+		rc.Close()
+		return a.RemoteAccessor.OpenFile(path, offset)
+	}
+
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	gr := &gunzipReader{rc: rc, zr: zr}
+	err = gr.skip(offset)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return gr, nil
+}
+
+// Seek implements RemoteAccessor. Readers of gzip files are seeked forwards by
+// decompressing and discarding, and backwards by opening the file again.
+func (a *gunzipAccessor) Seek(path string, rc io.ReadCloser, offset int64) (io.ReadCloser, error) {
+	gr, ok := rc.(*gunzipReader)
+	if !ok {
+		return a.RemoteAccessor.Seek(path, rc, offset)
+	}
+	if offset >= gr.pos {
+		return gr, gr.skip(offset - gr.pos)
+	}
+	err := gr.Close()
+	if err != nil {
+		return nil, err
+	}
+	return a.OpenFile(path, offset)
+}
+
+// gunzipReader is an io.ReadCloser that decompresses a gzip file opened by a
+// gunzipAccessor.
+type gunzipReader struct {
+	rc  io.ReadCloser
+	zr  *gzip.Reader
+	pos int64
+}
+
+// skip discards the next n decompressed bytes. Reaching the end of the file
+// first is not an error; subsequent Read()s will return io.EOF.
+func (g *gunzipReader) skip(n int64) error {
+	skipped, err := io.CopyN(ioutil.Discard, g.zr, n)
+	g.pos += skipped
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// Read implements io.Reader by decompressing.
+func (g *gunzipReader) Read(p []byte) (int, error) {
+	n, err := g.zr.Read(p)
+	g.pos += int64(n)
+	return n, err
+}
+
+// Close implements io.Closer by closing the underlying remote file.
+func (g *gunzipReader) Close() error {
+	return g.rc.Close()
+}
@@ -0,0 +1,152 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// originalSizeAccessor is a RemoteAccessor that implements FileStater,
+// reporting the given original-size metadata for files.
+type originalSizeAccessor struct {
+	RemoteAccessor
+	sizes map[string]int
+}
+
+// StatFile implements FileStater.
+func (a *originalSizeAccessor) StatFile(path string) (RemoteAttr, error) {
+	ra := RemoteAttr{Name: path}
+	if size, ok := a.sizes[path]; ok {
+		ra.Metadata = map[string]string{originalSizeMetadataKey: strconv.Itoa(size)}
+	}
+	return ra, nil
+}
+
+// gzipData returns the gzip compression of the given data, as one gzip member
+// per part.
+func gzipData(parts ...[]byte) []byte {
+	var buf bytes.Buffer
+	for _, part := range parts {
+		zw := gzip.NewWriter(&buf)
+		zw.Write(part)
+		zw.Close()
+	}
+	return buf.Bytes()
+}
+
+func TestTransparentGunzip(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "muxfys_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	cacheBase := filepath.Join(tmpdir, "base")
+	err = os.MkdirAll(cacheBase, os.FileMode(0700))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var content []byte
+	for i := 0; len(content) < 200000; i++ {
+		content = append(content, fmt.Sprintf(">seq%d\nACGTACGTACGT\n", i)...)
+	}
+	half := len(content) / 2
+
+	Convey("TransparentGunzip can't be used with Write", t, func() {
+		_, err := newRemote(&RemoteConfig{Accessor: NewMemoryAccessor("gz"), TransparentGunzip: true, Write: true}, cacheBase, 1, pkgLogger)
+		So(err, ShouldNotBeNil)
+	})
+
+	for _, cacheData := range []bool{false, true} {
+		Convey(fmt.Sprintf("With TransparentGunzip and CacheData %v, .gz files are read decompressed", cacheData), t, func() {
+			ma := NewMemoryAccessor("gz")
+			ma.Put("ref.fa.gz", gzipData(content))
+			ma.Put("multi.gz", gzipData(content[:half], content[half:]))
+			ma.Put("fake.gz", []byte("not gzip"))
+			ma.Put("plain.txt", []byte("plain"))
+			fs, _ := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "mnt"), CacheBase: cacheBase}, &RemoteConfig{Accessor: ma, TransparentGunzip: true, CacheData: cacheData})
+
+			for name, size := range map[string]int{"ref.fa.gz": len(content), "multi.gz": len(content), "fake.gz": 8, "plain.txt": 5} {
+				attr, status := fs.GetAttr(name, nil)
+				So(status, ShouldEqual, fuse.OK)
+				So(attr.Size, ShouldEqual, size)
+			}
+
+			for _, name := range []string{"ref.fa.gz", "multi.gz"} {
+				buf := make([]byte, 100)
+				for _, off := range []int{half - 50, 10, 0, len(content) - 100} {
+					n, err := fs.ReadAt(name, buf, int64(off))
+					So(err, ShouldBeNil)
+					So(n, ShouldEqual, 100)
+					So(string(buf), ShouldEqual, string(content[off:off+100]))
+				}
+
+				all := make([]byte, len(content))
+				n, err := fs.ReadAt(name, all, 0)
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, len(content))
+				So(bytes.Equal(all, content), ShouldBeTrue)
+			}
+
+			buf := make([]byte, 8)
+			n, err := fs.ReadAt("fake.gz", buf, 0)
+			So(err, ShouldBeNil)
+			So(string(buf[:n]), ShouldEqual, "not gzip")
+			n, err = fs.ReadAt("plain.txt", buf, 1)
+			So(string(buf[:n]), ShouldEqual, "lain")
+
+			if cacheData {
+				cached, size, err := fs.IsCached("ref.fa.gz")
+				So(err, ShouldBeNil)
+				So(cached, ShouldBeTrue)
+				So(size, ShouldEqual, len(content))
+			}
+		})
+	}
+
+	Convey("With TransparentGunzip, decompressed sizes come from original-size metadata if available", t, func() {
+		ma := NewMemoryAccessor("gz")
+		ma.Put("ref.fa.gz", gzipData(content))
+		fi := NewFaultInjector(ma)
+		osa := &originalSizeAccessor{RemoteAccessor: fi, sizes: map[string]int{"ref.fa.gz": len(content)}}
+		rc := &RemoteConfig{Accessor: osa, TransparentGunzip: true}
+
+		ras, err := rc.List(false)
+		So(err, ShouldBeNil)
+		So(len(ras), ShouldEqual, 1)
+		So(ras[0].Size, ShouldEqual, len(content))
+		So(fi.Calls("OpenFile"), ShouldEqual, 0)
+
+		rc.Accessor = &originalSizeAccessor{RemoteAccessor: fi}
+		ras, err = rc.List(false)
+		So(err, ShouldBeNil)
+		So(ras[0].Size, ShouldEqual, len(content))
+		So(fi.Calls("OpenFile"), ShouldEqual, 1)
+	})
+}
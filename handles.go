@@ -0,0 +1,164 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+// This file contains the implementation of the tracking of open file handles
+// and the bytes read and written via them.
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse/nodefs"
+)
+
+// byteCounter counts the bytes read and written via a file handle. It is
+// embedded as the first field of our nodefs.File implementations, so that its
+// counters are 64-bit aligned for atomic access on 32-bit platforms.
+type byteCounter struct {
+	read    int64
+	written int64
+}
+
+// countRead adds to the number of bytes read.
+func (c *byteCounter) countRead(n int64) {
+	atomic.AddInt64(&c.read, n)
+}
+
+// countWritten adds to the number of bytes written.
+func (c *byteCounter) countWritten(n int64) {
+	atomic.AddInt64(&c.written, n)
+}
+
+// BytesRead returns the number of bytes that have been read via this file
+// handle.
+func (c *byteCounter) BytesRead() int64 {
+	return atomic.LoadInt64(&c.read)
+}
+
+// BytesWritten returns the number of bytes that have been written via this
+// file handle.
+func (c *byteCounter) BytesWritten() int64 {
+	return atomic.LoadInt64(&c.written)
+}
+
+// readLength returns how many bytes a successful read of length bytes from
+// offset would have got from a file of the given size.
+func readLength(length int, offset int64, size uint64) int64 {
+	remaining := int64(size) - offset
+	if remaining < 0 {
+		return 0
+	}
+	if int64(length) > remaining {
+		return remaining
+	}
+	return int64(length)
+}
+
+// countingFile is implemented by our nodefs.Files, which all embed a
+// byteCounter.
+type countingFile interface {
+	nodefs.File
+	BytesRead() int64
+	BytesWritten() int64
+}
+
+// HandleInfo describes a file handle that is currently open via the mount.
+type HandleInfo struct {
+	// Path is the path of the file relative to the mount point.
+	Path string
+
+	// Opened is when the file was opened or created.
+	Opened time.Time
+
+	// BytesRead is the number of bytes read via this handle so far.
+	BytesRead int64
+
+	// BytesWritten is the number of bytes written via this handle so far.
+	BytesWritten int64
+}
+
+// openHandle wraps one of our countingFiles, so that we can keep track of it
+// until it is released.
+type openHandle struct {
+	countingFile
+	fs     *MuxFys
+	path   string
+	opened time.Time
+}
+
+// InnerFile returns the file we wrap.
+func (h *openHandle) InnerFile() nodefs.File {
+	return h.countingFile
+}
+
+// Release releases the file we wrap, and forgets about it.
+func (h *openHandle) Release() {
+	h.countingFile.Release()
+	h.fs.handlesMutex.Lock()
+	defer h.fs.handlesMutex.Unlock()
+	delete(h.fs.handles, h)
+}
+
+// trackHandle starts keeping track of the given newly opened file, returning
+// a nodefs.File that should be used in its place.
+func (fs *MuxFys) trackHandle(name string, file countingFile) nodefs.File {
+	h := &openHandle{countingFile: file, fs: fs, path: name, opened: time.Now()}
+	fs.handlesMutex.Lock()
+	defer fs.handlesMutex.Unlock()
+	fs.handles[h] = true
+	return h
+}
+
+// hasOpenHandle tells you if the file with the given name (relative to the
+// mount point) currently has any tracked handles open.
+func (fs *MuxFys) hasOpenHandle(name string) bool {
+	fs.handlesMutex.Lock()
+	defer fs.handlesMutex.Unlock()
+	for h := range fs.handles {
+		if h.path == name {
+			return true
+		}
+	}
+	return false
+}
+
+// OpenHandles returns a snapshot of the file handles currently open via the
+// mount, in the order they were opened, including how many bytes have been
+// read and written via each. This can help you tell the difference between an
+// application that is reading a file slowly, and one that read a little and
+// gave up.
+func (fs *MuxFys) OpenHandles() []HandleInfo {
+	fs.handlesMutex.Lock()
+	infos := make([]HandleInfo, 0, len(fs.handles))
+	for h := range fs.handles {
+		infos = append(infos, HandleInfo{
+			Path:         h.path,
+			Opened:       h.opened,
+			BytesRead:    h.BytesRead(),
+			BytesWritten: h.BytesWritten(),
+		})
+	}
+	fs.handlesMutex.Unlock()
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Opened.Before(infos[j].Opened)
+	})
+	return infos
+}
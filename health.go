@@ -0,0 +1,178 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+// This file contains the implementation of MuxFys.HealthCheck().
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// defaultHealthCheckTimeout is how long HealthCheck() waits for each check if
+// the supplied context has no deadline.
+const defaultHealthCheckTimeout = 10 * time.Second
+
+// HealthCheckFailure describes which of the checks done by HealthCheck()
+// failed.
+type HealthCheckFailure int
+
+// These are the HealthCheckFailures a HealthCheckError might have.
+// HealthNotMounted means Mount() hasn't been called, or we've since unmounted.
+// HealthMountUnresponsive means the mount point could not be stat'ed in time,
+// or isn't being served by us. HealthRemoteUnreachable means a remote could
+// not be listed in time (only checked if Config.HealthCheckRemotes was true).
+const (
+	HealthNotMounted HealthCheckFailure = iota
+	HealthMountUnresponsive
+	HealthRemoteUnreachable
+)
+
+// String returns a lower-case description of the HealthCheckFailure.
+func (f HealthCheckFailure) String() string {
+	switch f {
+	case HealthNotMounted:
+		return "not mounted"
+	case HealthMountUnresponsive:
+		return "mount unresponsive"
+	case HealthRemoteUnreachable:
+		return "remote unreachable"
+	}
+	return "unknown"
+}
+
+// HealthCheckError is the error returned by HealthCheck().
+type HealthCheckError struct {
+	Failure HealthCheckFailure
+
+	// Target is the Target() of the remote that failed, for
+	// HealthRemoteUnreachable.
+	Target string
+
+	// Err is the underlying problem, if any.
+	Err error
+}
+
+// Error returns a description of what failed.
+func (e *HealthCheckError) Error() string {
+	msg := "muxfys health check failed: " + e.Failure.String()
+	if e.Target != "" {
+		msg += " [" + e.Target + "]"
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap returns the underlying problem, if any.
+func (e *HealthCheckError) Unwrap() error {
+	return e.Err
+}
+
+// HealthCheck lets you detect a wedged mount. It checks that we're mounted and
+// that the mount point is still being served, by stat'ing it, and if
+// Config.HealthCheckRemotes was true, that the root of each remote can be
+// listed. Each check must complete before ctx is done, or within 10 seconds if
+// ctx has no deadline.
+//
+// It returns nil if all is well, or else a *HealthCheckError, whose Failure
+// tells you which check failed. After a HealthMountUnresponsive failure, a
+// stat of the mount point may remain blocked in the background.
+func (fs *MuxFys) HealthCheck(ctx context.Context) error {
+	if _, hasDeadline := ctx.Deadline(); !hasDeadline {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultHealthCheckTimeout)
+		defer cancel()
+	}
+
+	fs.mutex.Lock()
+	mounted := fs.mounted
+	remotes := fs.remotes
+	fs.mutex.Unlock()
+	if !mounted {
+		return &HealthCheckError{Failure: HealthNotMounted}
+	}
+
+	err := withContext(ctx, fs.checkMountPoint)
+	if err != nil {
+		return &HealthCheckError{Failure: HealthMountUnresponsive, Err: err}
+	}
+
+	if !fs.healthCheckRemotes {
+		return nil
+	}
+	for _, r := range remotes {
+		err = withContext(ctx, r.checkReachable)
+		if err != nil {
+			return &HealthCheckError{Failure: HealthRemoteUnreachable, Target: r.accessor.Target(), Err: err}
+		}
+	}
+	return nil
+}
+
+// checkMountPoint stats our mount point, and checks it is on a different device
+// to its parent directory, as it should be if it is still mounted.
+func (fs *MuxFys) checkMountPoint() error {
+	info, err := os.Stat(fs.mountPoint)
+	if err != nil {
+		return err
+	}
+	parentInfo, err := os.Stat(filepath.Dir(fs.mountPoint))
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	parentStat, parentOK := parentInfo.Sys().(*syscall.Stat_t)
+	if ok && parentOK && stat.Dev == parentStat.Dev {
+		return errors.New("mount point is not mounted")
+	}
+	return nil
+}
+
+// checkReachable lists the root of the remote, to confirm we can still talk to
+// it.
+func (r *remote) checkReachable() error {
+	remotePath := r.getRemotePath("")
+	if remotePath != "" {
+		remotePath += "/"
+	}
+	_, err := r.accessor.ListEntries(remotePath)
+	return err
+}
+
+// withContext runs f in a goroutine, returning its error, or ctx's error if ctx
+// is done before f completes (in which case f is left running).
+func withContext(ctx context.Context, f func() error) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- f()
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timed out: %s", ctx.Err())
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// blockingAccessor is a localAccessor whose ListEntries() blocks until its
+// channel is closed.
+type blockingAccessor struct {
+	*localAccessor
+	block chan bool
+}
+
+// ListEntries implements RemoteAccessor by blocking.
+func (a *blockingAccessor) ListEntries(dir string) ([]RemoteAttr, error) {
+	<-a.block
+	return a.localAccessor.ListEntries(dir)
+}
+
+func TestHealthCheck(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "muxfys_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	source := filepath.Join(tmpdir, "source")
+	os.MkdirAll(source, os.FileMode(0777))
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+
+	failure := func(err error) HealthCheckFailure {
+		var herr *HealthCheckError
+		So(errors.As(err, &herr), ShouldBeTrue)
+		return herr.Failure
+	}
+
+	Convey("HealthCheck() fails if not mounted", t, func() {
+		fs, err := New(&Config{Mount: filepath.Join(tmpdir, "healthMount"), CacheBase: tmpdir})
+		So(err, ShouldBeNil)
+		err = fs.HealthCheck(context.Background())
+		So(err, ShouldNotBeNil)
+		So(failure(err), ShouldEqual, HealthNotMounted)
+		So(err.Error(), ShouldEqual, "muxfys health check failed: not mounted")
+
+		Convey("Or if the mount point isn't being served", func() {
+			fs.mounted = true
+			err = fs.HealthCheck(context.Background())
+			So(err, ShouldNotBeNil)
+			So(failure(err), ShouldEqual, HealthMountUnresponsive)
+		})
+	})
+
+	Convey("Remotes can be checked for reachability", t, func() {
+		r, err := newRemote(&RemoteConfig{Accessor: &localAccessor{target: source}}, tmpdir, 1, logger)
+		So(err, ShouldBeNil)
+		So(r.checkReachable(), ShouldBeNil)
+
+		r, err = newRemote(&RemoteConfig{Accessor: &localAccessor{target: filepath.Join(tmpdir, "missing")}}, tmpdir, 1, logger)
+		So(err, ShouldBeNil)
+		So(r.checkReachable(), ShouldNotBeNil)
+	})
+
+	Convey("Checks time out", t, func() {
+		accessor := &blockingAccessor{localAccessor: &localAccessor{target: source}, block: make(chan bool)}
+		defer close(accessor.block)
+		r, err := newRemote(&RemoteConfig{Accessor: accessor}, tmpdir, 1, logger)
+		So(err, ShouldBeNil)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		err = withContext(ctx, r.checkReachable)
+		So(err, ShouldNotBeNil)
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+
+		herr := &HealthCheckError{Failure: HealthRemoteUnreachable, Target: "s3://bucket", Err: err}
+		So(herr.Error(), ShouldStartWith, "muxfys health check failed: remote unreachable [s3://bucket]: timed out")
+		So(errors.Unwrap(herr), ShouldEqual, err)
+	})
+}
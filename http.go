@@ -0,0 +1,451 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+// This file contains a read-only implementation of RemoteAccessor for files
+// served over HTTP(S).
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// hrefRegexp finds the links in an autoindex style directory listing.
+var hrefRegexp = regexp.MustCompile(`(?i)<a\s[^>]*href\s*=\s*["']([^"']+)["']`)
+
+// HTTPConfig struct lets you provide details of the web server directory you
+// wish to mount.
+type HTTPConfig struct {
+	// Target is the URL of the directory you wish to mount, eg.
+	// https://domain.com/data/subdir.
+	Target string
+
+	// Manifest is optional, and should be the path to a local file, or an
+	// http(s) URL, containing a JSON list of HTTPManifestEntry describing all
+	// the files under Target. Supply this when the web server doesn't provide
+	// directory listings (such as Apache's autoindex).
+	Manifest string
+}
+
+// HTTPManifestEntry describes one file in an HTTPConfig Manifest, eg.
+// {"path":"subdir/file.txt","size":1024,"mtime":"2021-07-16T10:00:00Z"}.
+type HTTPManifestEntry struct {
+	// Path of the file, relative to Target.
+	Path string `json:"path"`
+
+	// Size of the file in bytes.
+	Size int64 `json:"size"`
+
+	// MTime is optional, the time the file was last modified.
+	MTime time.Time `json:"mtime"`
+}
+
+// httpStatusError is returned when a web server responds with an unexpected
+// status code.
+type httpStatusError struct {
+	method string
+	url    string
+	code   int
+	status string
+}
+
+// Error implements the error interface.
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.method, e.url, e.status)
+}
+
+// HTTPAccessor implements the RemoteAccessor interface for files served over
+// HTTP(S). It is read-only: only use it in RemoteConfigs that do not have Write
+// set. Methods that would alter the remote return errors wrapping
+// syscall.ENOSYS.
+type HTTPAccessor struct {
+	client   *http.Client
+	scheme   string
+	host     string
+	target   string
+	basePath string
+	manifest []RemoteAttr
+}
+
+// NewHTTPAccessor creates an HTTPAccessor for reading files from a web server.
+func NewHTTPAccessor(config *HTTPConfig) (*HTTPAccessor, error) {
+	if config.Target == "" {
+		return nil, fmt.Errorf("no Target defined")
+	}
+	u, err := url.Parse(config.Target)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("Target [%s] is not an http(s) URL", config.Target)
+	}
+
+	basePath := strings.Trim(u.Path, "/")
+	if basePath != "" {
+		basePath = path.Clean(basePath)
+	}
+
+	a := &HTTPAccessor{
+		client:   &http.Client{},
+		scheme:   u.Scheme,
+		host:     u.Host,
+		target:   config.Target,
+		basePath: basePath,
+	}
+
+	if config.Manifest != "" {
+		err = a.loadManifest(config.Manifest)
+		if err != nil {
+			return nil, fmt.Errorf("could not load manifest %s: %s", config.Manifest, err)
+		}
+		return a, nil
+	}
+
+	// test that we can actually get a listing
+	_, err = a.ListEntries(a.dirPath(basePath))
+	if err != nil {
+		err = fmt.Errorf("could not list %s: %s", config.Target, err)
+	}
+
+	return a, err
+}
+
+// loadManifest reads the JSON list of HTTPManifestEntry in the given local
+// file or URL, and stores the entries as RemoteAttrs with full paths.
+func (a *HTTPAccessor) loadManifest(manifest string) error {
+	var data []byte
+	var err error
+	if strings.HasPrefix(manifest, "http://") || strings.HasPrefix(manifest, "https://") {
+		var resp *http.Response
+		resp, err = a.get(manifest, 0)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		data, err = ioutil.ReadAll(resp.Body)
+	} else {
+		data, err = ioutil.ReadFile(manifest)
+	}
+	if err != nil {
+		return err
+	}
+
+	var entries []HTTPManifestEntry
+	err = json.Unmarshal(data, &entries)
+	if err != nil {
+		return err
+	}
+
+	a.manifest = make([]RemoteAttr, 0, len(entries))
+	for _, entry := range entries {
+		p := strings.Trim(entry.Path, "/")
+		if p == "" {
+			continue
+		}
+		a.manifest = append(a.manifest, RemoteAttr{
+			Name:  path.Join(a.basePath, p),
+			Size:  entry.Size,
+			MTime: entry.MTime,
+		})
+	}
+	return nil
+}
+
+// dirPath returns the given remote path with a trailing slash, unless it is the
+// root.
+func (a *HTTPAccessor) dirPath(remotePath string) string {
+	if remotePath == "" {
+		return ""
+	}
+	return remotePath + "/"
+}
+
+// url returns the URL of the given remote path.
+func (a *HTTPAccessor) url(remotePath string) string {
+	u := &url.URL{
+		Scheme: a.scheme,
+		Host:   a.host,
+		Path:   "/" + remotePath,
+	}
+	return u.String()
+}
+
+// get does a GET request on the given URL, optionally with a Range header
+// starting at offset, returning an error if the response isn't a success.
+func (a *HTTPAccessor) get(u string, offset int64) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, &httpStatusError{method: req.Method, url: u, code: resp.StatusCode, status: resp.Status}
+	}
+	return resp, nil
+}
+
+// head does a HEAD request on the given remote path to find its size and
+// modification time.
+func (a *HTTPAccessor) head(remotePath string) (RemoteAttr, error) {
+	u := a.url(remotePath)
+	ra := RemoteAttr{Name: remotePath}
+	resp, err := a.client.Head(u)
+	if err != nil {
+		return ra, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ra, &httpStatusError{method: http.MethodHead, url: u, code: resp.StatusCode, status: resp.Status}
+	}
+
+	if resp.ContentLength > 0 {
+		ra.Size = resp.ContentLength
+	}
+	if lm := resp.Header.Get("Last-Modified"); lm != "" {
+		if mtime, errp := http.ParseTime(lm); errp == nil {
+			ra.MTime = mtime
+		}
+	}
+	return ra, nil
+}
+
+// DownloadFile implements RemoteAccessor by doing a GET request.
+func (a *HTTPAccessor) DownloadFile(source, dest string) (err error) {
+	resp, err := a.get(a.url(source), 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	err = os.MkdirAll(filepath.Dir(dest), os.FileMode(dirMode))
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		errc := f.Close()
+		if err == nil {
+			err = errc
+		}
+	}()
+
+	_, err = io.Copy(f, resp.Body)
+	return err
+}
+
+// UploadFile implements RemoteAccessor by returning an error, since we are
+// read-only.
+func (a *HTTPAccessor) UploadFile(source, dest, contentType string) error {
+	return a.notSupported("upload", dest)
+}
+
+// UploadData implements RemoteAccessor by returning an error, since we are
+// read-only.
+func (a *HTTPAccessor) UploadData(data io.Reader, dest string) error {
+	return a.notSupported("upload", dest)
+}
+
+// ListEntries implements RemoteAccessor by using our manifest if we have one,
+// or otherwise by parsing the links in the directory listing provided by the
+// web server, then finding the size and modification time of each file with
+// HEAD requests.
+func (a *HTTPAccessor) ListEntries(dir string) ([]RemoteAttr, error) {
+	if a.manifest != nil {
+		return a.listManifest(dir), nil
+	}
+
+	resp, err := a.get(a.url(dir), 0)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var ras []RemoteAttr
+	seen := make(map[string]bool)
+	for _, match := range hrefRegexp.FindAllSubmatch(body, -1) {
+		name, erru := url.PathUnescape(string(match[1]))
+		if erru != nil || !listedChild(name) || seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		if strings.HasSuffix(name, "/") {
+			ras = append(ras, RemoteAttr{Name: dir + name})
+			continue
+		}
+
+		ra, errh := a.head(dir + name)
+		if errh != nil {
+			return nil, errh
+		}
+		ras = append(ras, ra)
+	}
+
+	return ras, nil
+}
+
+// listedChild returns true if the given link from a directory listing is a
+// file or sub-directory of that directory, as opposed to a parent directory,
+// sorting link or external link.
+func listedChild(name string) bool {
+	name = strings.TrimSuffix(name, "/")
+	switch {
+	case name == "", name == ".", name == "..":
+		return false
+	case strings.ContainsAny(name, "/?#:"):
+		return false
+	}
+	return true
+}
+
+// listManifest returns the files and sub-directories in our manifest that are
+// directly within dir.
+func (a *HTTPAccessor) listManifest(dir string) []RemoteAttr {
+	var ras []RemoteAttr
+	seen := make(map[string]bool)
+	for _, ra := range a.manifest {
+		if !strings.HasPrefix(ra.Name, dir) {
+			continue
+		}
+		rest := ra.Name[len(dir):]
+		if i := strings.Index(rest, "/"); i >= 0 {
+			subDir := dir + rest[:i+1]
+			if !seen[subDir] {
+				seen[subDir] = true
+				ras = append(ras, RemoteAttr{Name: subDir})
+			}
+			continue
+		}
+		ras = append(ras, ra)
+	}
+	return ras
+}
+
+// OpenFile implements RemoteAccessor by doing a GET request, with a Range
+// header to start reading from offset. If the server ignores the Range header,
+// we skip to offset ourselves.
+func (a *HTTPAccessor) OpenFile(path string, offset int64) (io.ReadCloser, error) {
+	resp, err := a.get(a.url(path), offset)
+	if err != nil {
+		if serr, ok := err.(*httpStatusError); ok && serr.code == http.StatusRequestedRangeNotSatisfiable {
+			// offset was at or beyond the end of the file
+			return ioutil.NopCloser(strings.NewReader("")), nil
+		}
+		return nil, err
+	}
+
+	if offset > 0 && resp.StatusCode == http.StatusOK {
+		_, err = io.CopyN(ioutil.Discard, resp.Body, offset)
+		if err != nil && err != io.EOF {
+			resp.Body.Close()
+			return nil, err
+		}
+	}
+
+	return resp.Body, nil
+}
+
+// Seek implements RemoteAccessor by closing the given reader and opening a new
+// one starting at offset.
+func (a *HTTPAccessor) Seek(path string, rc io.ReadCloser, offset int64) (io.ReadCloser, error) {
+	err := rc.Close()
+	if err != nil {
+		return nil, err
+	}
+	return a.OpenFile(path, offset)
+}
+
+// CopyFile implements RemoteAccessor by returning an error, since we are
+// read-only.
+func (a *HTTPAccessor) CopyFile(source, dest string) error {
+	return a.notSupported("copy", dest)
+}
+
+// DeleteFile implements RemoteAccessor by returning an error, since we are
+// read-only.
+func (a *HTTPAccessor) DeleteFile(path string) error {
+	return a.notSupported("delete", path)
+}
+
+// DeleteIncompleteUpload implements RemoteAccessor by doing nothing, since we
+// never upload.
+func (a *HTTPAccessor) DeleteIncompleteUpload(path string) error {
+	return nil
+}
+
+// notSupported returns the error we give for write operations.
+func (a *HTTPAccessor) notSupported(op, path string) error {
+	return &os.PathError{Op: op, Path: a.url(path), Err: syscall.ENOSYS}
+}
+
+// ErrorIsNotExists implements RemoteAccessor by looking for 404 and 410
+// responses.
+func (a *HTTPAccessor) ErrorIsNotExists(err error) bool {
+	serr, ok := err.(*httpStatusError)
+	return ok && (serr.code == http.StatusNotFound || serr.code == http.StatusGone)
+}
+
+// ErrorIsNoQuota implements RemoteAccessor by always returning false, since we
+// never write.
+func (a *HTTPAccessor) ErrorIsNoQuota(err error) bool {
+	return false
+}
+
+// Target implements RemoteAccessor by returning the initial target we were
+// configured with.
+func (a *HTTPAccessor) Target() string {
+	return a.target
+}
+
+// RemotePath implements RemoteAccessor by using the path of the initially
+// configured target.
+func (a *HTTPAccessor) RemotePath(relPath string) string {
+	return filepath.Join(a.basePath, relPath)
+}
+
+// LocalPath implements RemoteAccessor by including the initially configured
+// host in the return value.
+func (a *HTTPAccessor) LocalPath(baseDir, remotePath string) string {
+	return filepath.Join(baseDir, a.host, remotePath)
+}
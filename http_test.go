@@ -0,0 +1,220 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHTTP(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "muxfys_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	root := filepath.Join(tmpdir, "www")
+	os.MkdirAll(filepath.Join(root, "data", "sub"), os.FileMode(0777))
+	ioutil.WriteFile(filepath.Join(root, "data", "a.file"), []byte("abcdefghij"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "data", "sub", "b.file"), []byte("b"), 0644)
+
+	fileServer := http.FileServer(http.Dir(root))
+	server := httptest.NewServer(fileServer)
+	defer server.Close()
+
+	// a server that doesn't do directory listings or Range requests
+	plainServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/") {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		content, err := ioutil.ReadFile(filepath.Join(root, filepath.FromSlash(r.URL.Path)))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(content)
+	}))
+	defer plainServer.Close()
+
+	Convey("You can't make an HTTPAccessor with a bad Target", t, func() {
+		_, err := NewHTTPAccessor(&HTTPConfig{})
+		So(err, ShouldNotBeNil)
+		_, err = NewHTTPAccessor(&HTTPConfig{Target: "ftp://domain.com/data"})
+		So(err, ShouldNotBeNil)
+		_, err = NewHTTPAccessor(&HTTPConfig{Target: server.URL + "/missing"})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("listedChild only accepts files and sub-directories", t, func() {
+		for _, name := range []string{"a.file", "sub/", ".hidden", "with space"} {
+			So(listedChild(name), ShouldBeTrue)
+		}
+		for _, name := range []string{"", "../", "./", "/data/", "?C=N;O=D", "#top", "http://other.com/", "sub/b.file"} {
+			So(listedChild(name), ShouldBeFalse)
+		}
+	})
+
+	Convey("You can make an HTTPAccessor for a server with directory listings", t, func() {
+		a, err := NewHTTPAccessor(&HTTPConfig{Target: server.URL + "/data/"})
+		So(err, ShouldBeNil)
+		So(a.RemotePath("sub"), ShouldEqual, "data/sub")
+		So(a.LocalPath("/cache", "data/a.file"), ShouldEqual, filepath.Join("/cache", a.host, "data/a.file"))
+
+		Convey("ListEntries gives files with sizes and sub-directories", func() {
+			ras, err := a.ListEntries("data/")
+			So(err, ShouldBeNil)
+			So(ras, ShouldHaveLength, 2)
+			sort.Slice(ras, func(i, j int) bool { return ras[i].Name < ras[j].Name })
+			So(ras[0].Name, ShouldEqual, "data/a.file")
+			So(ras[0].Size, ShouldEqual, 10)
+			So(ras[0].MTime.IsZero(), ShouldBeFalse)
+			So(ras[1].Name, ShouldEqual, "data/sub/")
+
+			ras, err = a.ListEntries("data/sub/")
+			So(err, ShouldBeNil)
+			So(ras, ShouldHaveLength, 1)
+			So(ras[0].Name, ShouldEqual, "data/sub/b.file")
+			So(ras[0].Size, ShouldEqual, 1)
+		})
+
+		Convey("OpenFile and Seek use ranged reads", func() {
+			rc, err := a.OpenFile("data/a.file", 4)
+			So(err, ShouldBeNil)
+			content, err := ioutil.ReadAll(rc)
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, "efghij")
+
+			rc, err = a.Seek("data/a.file", rc, 8)
+			So(err, ShouldBeNil)
+			content, err = ioutil.ReadAll(rc)
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, "ij")
+
+			rc, err = a.Seek("data/a.file", rc, 10)
+			So(err, ShouldBeNil)
+			content, err = ioutil.ReadAll(rc)
+			So(err, ShouldBeNil)
+			So(content, ShouldBeEmpty)
+			rc.Close()
+		})
+
+		Convey("DownloadFile works", func() {
+			dest := filepath.Join(tmpdir, "download", "a.file")
+			err := a.DownloadFile("data/a.file", dest)
+			So(err, ShouldBeNil)
+			content, err := ioutil.ReadFile(dest)
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, "abcdefghij")
+		})
+
+		Convey("Missing files are recognised", func() {
+			_, err := a.OpenFile("data/missing.file", 0)
+			So(err, ShouldNotBeNil)
+			So(a.ErrorIsNotExists(err), ShouldBeTrue)
+			So(a.ErrorIsNoQuota(err), ShouldBeFalse)
+		})
+
+		Convey("Write operations are not supported", func() {
+			for _, err := range []error{
+				a.UploadFile(filepath.Join(root, "data", "a.file"), "data/new.file", ""),
+				a.UploadData(strings.NewReader("new"), "data/new.file"),
+				a.CopyFile("data/a.file", "data/new.file"),
+				a.DeleteFile("data/a.file"),
+			} {
+				So(err, ShouldNotBeNil)
+				perr, ok := err.(*os.PathError)
+				So(ok, ShouldBeTrue)
+				So(perr.Err, ShouldEqual, syscall.ENOSYS)
+			}
+			_, err := os.Stat(filepath.Join(root, "data", "a.file"))
+			So(err, ShouldBeNil)
+		})
+
+		Convey("It can be used by MuxFys", func() {
+			fs, r := newTestFS(&Config{Mount: filepath.Join(tmpdir, "httpMount"), CacheBase: tmpdir}, &RemoteConfig{Accessor: a})
+			fs.mapMutex.Lock()
+			defer fs.mapMutex.Unlock()
+			So(fs.openDir(r, ""), ShouldEqual, fuse.OK)
+			So(dirEntryNames(fs.dirContents[""]), ShouldResemble, []string{"a.file", "sub"})
+			So(fs.files["a.file"].Size, ShouldEqual, 10)
+			So(fs.openDir(r, "sub"), ShouldEqual, fuse.OK)
+			So(dirEntryNames(fs.dirContents["sub"]), ShouldResemble, []string{"b.file"})
+		})
+	})
+
+	Convey("You can make an HTTPAccessor using a manifest", t, func() {
+		manifest := filepath.Join(tmpdir, "manifest.json")
+		err := ioutil.WriteFile(manifest, []byte(`[
+			{"path": "a.file", "size": 10, "mtime": "2021-07-16T10:00:00Z"},
+			{"path": "sub/b.file", "size": 1}
+		]`), 0644)
+		So(err, ShouldBeNil)
+
+		_, err = NewHTTPAccessor(&HTTPConfig{Target: plainServer.URL + "/data"})
+		So(err, ShouldNotBeNil)
+
+		a, err := NewHTTPAccessor(&HTTPConfig{Target: plainServer.URL + "/data", Manifest: manifest})
+		So(err, ShouldBeNil)
+
+		ras, err := a.ListEntries("data/")
+		So(err, ShouldBeNil)
+		So(ras, ShouldHaveLength, 2)
+		So(ras[0].Name, ShouldEqual, "data/a.file")
+		So(ras[0].Size, ShouldEqual, 10)
+		So(ras[0].MTime.Year(), ShouldEqual, 2021)
+		So(ras[1].Name, ShouldEqual, "data/sub/")
+
+		ras, err = a.ListEntries("data/sub/")
+		So(err, ShouldBeNil)
+		So(ras, ShouldHaveLength, 1)
+		So(ras[0].Name, ShouldEqual, "data/sub/b.file")
+
+		ras, err = a.ListEntries("data/missing/")
+		So(err, ShouldBeNil)
+		So(ras, ShouldBeEmpty)
+
+		Convey("The manifest can also be a URL", func() {
+			os.Rename(manifest, filepath.Join(root, "manifest.json"))
+			a2, err := NewHTTPAccessor(&HTTPConfig{Target: plainServer.URL + "/data", Manifest: plainServer.URL + "/manifest.json"})
+			So(err, ShouldBeNil)
+			So(a2.manifest, ShouldResemble, a.manifest)
+		})
+
+		Convey("OpenFile works even if the server ignores Range headers", func() {
+			rc, err := a.OpenFile("data/a.file", 4)
+			So(err, ShouldBeNil)
+			defer rc.Close()
+			content, err := ioutil.ReadAll(rc)
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, "efghij")
+		})
+	})
+}
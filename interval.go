@@ -48,20 +48,24 @@ comparisons as we can in other methods.
     }
 */
 
-// Interval struct is used to describe something with a start and end. End must
-// be greater than start.
+// Interval struct is used to describe something with a start and end, such as a
+// range of bytes in a file. Both Start and End are inclusive, so an Interval
+// with Start 0 and End 9 has a Length() of 10. An Interval whose End is less
+// than its Start is empty (has a Length() of 0 or less); Intervals' methods
+// treat such an Interval as covering nothing.
 type Interval struct {
 	Start int64
 	End   int64
 }
 
 // NewInterval is a convenience for creating a new Interval when you have a
-// length instead of an end.
+// length instead of an end. A length of 0 gives you an empty Interval.
 func NewInterval(start, length int64) Interval {
 	return Interval{start, start + length - 1}
 }
 
-// Length returns the length of this interval.
+// Length returns the length of this interval, which is 0 or less if it is
+// empty.
 func (i *Interval) Length() int64 {
 	return i.End - i.Start + 1
 }
@@ -94,15 +98,22 @@ func (i *Interval) Merge(j Interval) bool {
 	return true
 }
 
-// Intervals type is a slice of Interval.
+// Intervals type is a slice of Interval. The methods of Intervals rely on (and
+// maintain) the invariant that the slice is sorted by Start and contains no
+// empty Intervals, nor any that overlap with or are adjacent to each other.
+// You get this for free by starting with an empty Intervals and only adding to
+// it with Merge(). None of the methods alter the Intervals they are called on.
 type Intervals []Interval
 
 // Merge adds another interval to this slice of intervals, merging with any
-// prior intervals if it overlaps with or is adjacent to them. Returns the new
-// slice of intervals, which have the property of not overlapping with or being
-// adjacent to each other. They are also sorted by Start if Merge() was used to
-// add all of them.
+// prior intervals if it overlaps with or is adjacent to them, eg. merging 5-9
+// in to [0-4, 10-14] gives [0-14]. Returns the new slice of intervals, which
+// maintains our invariant. Merging an empty interval returns our intervals
+// unchanged.
 func (ivs Intervals) Merge(iv Interval) Intervals {
+	if iv.Length() < 1 {
+		return ivs
+	}
 	if len(ivs) == 0 {
 		return Intervals{iv}
 	}
@@ -130,8 +141,13 @@ func (ivs Intervals) Merge(iv Interval) Intervals {
 }
 
 // Difference returns any portions of iv that do not overlap with any of our
-// intervals. Assumes that all of our intervals have been Merge()d in.
+// intervals, sorted by Start. There may be several, eg. the difference of 0-14
+// from [5-9] is [0-4, 10-14]. If iv is entirely covered by our intervals, or is
+// empty, the result is empty.
 func (ivs Intervals) Difference(iv Interval) Intervals {
+	if iv.Length() < 1 {
+		return Intervals{}
+	}
 	if len(ivs) == 0 {
 		return Intervals{iv}
 	}
@@ -160,23 +176,26 @@ func (ivs Intervals) Difference(iv Interval) Intervals {
 	return diffs
 }
 
-// Truncate removes all intervals that start after the given position, and
-// truncates any intervals that overlap with the position. Assumes that all of
-// our intervals have been Merge()d in.
-func (ivs Intervals) Truncate(pos int64) Intervals {
-	if pos == 0 {
+// Truncate returns our intervals as they would be for a file truncated to the
+// given size, ie. covering no position at or beyond size. Since Ends are
+// inclusive, any interval that extends past the new end of the file ends at
+// size-1, eg. truncating [0-4, 10-14] to size 12 gives [0-4, 10-11]. A size of
+// 0 or less returns empty intervals.
+func (ivs Intervals) Truncate(size int64) Intervals {
+	if size <= 0 {
 		return Intervals{}
 	}
 
+	last := size - 1
 	for i, iv := range ivs {
-		if iv.Start > pos {
-			ivs = ivs[:i]
-			break
+		if iv.Start > last {
+			return ivs[:i]
 		}
-		if iv.End > pos {
-			ivs = ivs[:i+1]
-			ivs[i].End = pos
-			break
+		if iv.End > last {
+			truncated := make(Intervals, i+1)
+			copy(truncated, ivs[:i+1])
+			truncated[i].End = last
+			return truncated
 		}
 	}
 
@@ -424,7 +424,7 @@ func TestIntervals(t *testing.T) {
 
 			ivs = ivs.Truncate(17)
 
-			expected := Intervals{oneThree, sevenTen, Interval{15, 17}}
+			expected := Intervals{oneThree, sevenTen, Interval{15, 16}}
 			So(ivs, ShouldResemble, expected)
 
 			ivs = ivs.Truncate(13)
@@ -435,6 +435,83 @@ func TestIntervals(t *testing.T) {
 			ivs = ivs.Truncate(0)
 			So(ivs, ShouldResemble, Intervals{})
 		})
+
+		Convey("Truncate doesn't alter the original intervals", func() {
+			ivs := Intervals{oneThree, sevenTen}
+			So(ivs.Truncate(8), ShouldResemble, Intervals{oneThree, Interval{7, 7}})
+			So(ivs.Truncate(7), ShouldResemble, Intervals{oneThree})
+			So(ivs.Truncate(5), ShouldResemble, Intervals{oneThree})
+			So(ivs.Truncate(10), ShouldResemble, Intervals{oneThree, Interval{7, 9}})
+			So(ivs.Truncate(11), ShouldResemble, ivs)
+			So(ivs.Truncate(100), ShouldResemble, ivs)
+			So(ivs.Truncate(-1), ShouldResemble, Intervals{})
+			So(ivs, ShouldResemble, Intervals{oneThree, sevenTen})
+		})
+	})
+
+	Convey("Intervals handle edge cases", t, func() {
+		Convey("Zero-length intervals are empty and ignored", func() {
+			empty := NewInterval(5, 0)
+			So(empty.Length(), ShouldEqual, 0)
+
+			var ivs Intervals
+			So(ivs.Merge(empty), ShouldBeEmpty)
+			So(ivs.Difference(empty), ShouldBeEmpty)
+
+			ivs = ivs.Merge(NewInterval(0, 5))
+			So(ivs.Merge(empty), ShouldResemble, Intervals{{0, 4}})
+			So(ivs.Merge(NewInterval(20, 0)), ShouldResemble, Intervals{{0, 4}})
+			So(ivs.Difference(NewInterval(10, 0)), ShouldBeEmpty)
+		})
+
+		Convey("Single position intervals work", func() {
+			one := NewInterval(3, 1)
+			So(one, ShouldResemble, Interval{3, 3})
+			So(one.Length(), ShouldEqual, 1)
+
+			ivs := Intervals{}.Merge(one)
+			So(ivs.Difference(one), ShouldBeEmpty)
+			So(ivs.Difference(Interval{2, 4}), ShouldResemble, Intervals{{2, 2}, {4, 4}})
+			So(ivs.Merge(Interval{4, 4}), ShouldResemble, Intervals{{3, 4}})
+			So(ivs.Truncate(4), ShouldResemble, Intervals{{3, 3}})
+			So(ivs.Truncate(3), ShouldBeEmpty)
+		})
+
+		Convey("Adjacent intervals merge, but don't overlap", func() {
+			a := Interval{0, 4}
+			b := Interval{5, 9}
+			So(a.Overlaps(b), ShouldBeFalse)
+			So(a.OverlapsOrAdjacent(b), ShouldBeTrue)
+			So(Intervals{a}.Merge(b), ShouldResemble, Intervals{{0, 9}})
+			So(Intervals{b}.Merge(a), ShouldResemble, Intervals{{0, 9}})
+			So(Intervals{a}.Difference(b), ShouldResemble, Intervals{b})
+
+			gap := Interval{6, 9}
+			So(a.OverlapsOrAdjacent(gap), ShouldBeFalse)
+			So(Intervals{a}.Merge(gap), ShouldResemble, Intervals{a, gap})
+			So(Intervals{gap}.Merge(a), ShouldResemble, Intervals{a, gap})
+		})
+
+		Convey("Merging an interval that fills a gap joins its neighbours", func() {
+			ivs := Intervals{{0, 4}, {10, 14}}
+			So(ivs.Merge(Interval{5, 9}), ShouldResemble, Intervals{{0, 14}})
+			So(ivs.Merge(Interval{6, 8}), ShouldResemble, Intervals{{0, 4}, {6, 8}, {10, 14}})
+			So(ivs, ShouldResemble, Intervals{{0, 4}, {10, 14}})
+		})
+
+		Convey("Difference can split an interval in two or more", func() {
+			ivs := Intervals{{5, 9}}
+			So(ivs.Difference(Interval{0, 14}), ShouldResemble, Intervals{{0, 4}, {10, 14}})
+
+			ivs = ivs.Merge(Interval{20, 24})
+			So(ivs.Difference(Interval{0, 29}), ShouldResemble, Intervals{{0, 4}, {10, 19}, {25, 29}})
+			So(ivs.Difference(Interval{7, 22}), ShouldResemble, Intervals{{10, 19}})
+			So(ivs.Difference(Interval{5, 24}), ShouldResemble, Intervals{{10, 19}})
+			So(ivs.Difference(Interval{6, 8}), ShouldBeEmpty)
+			So(ivs.Difference(Interval{30, 39}), ShouldResemble, Intervals{{30, 39}})
+			So(ivs.Difference(Interval{0, 2}), ShouldResemble, Intervals{{0, 2}})
+			So(ivs, ShouldResemble, Intervals{{5, 9}, {20, 24}})
+		})
 	})
 
 	Convey("Merging many intervals is fast", t, func() {
@@ -466,7 +543,7 @@ func TestIntervals(t *testing.T) {
 		doneSwaps := 0
 		swapped := make(map[int]bool)
 		for {
-			swap := rand.Intn(len(inputs))
+			swap := rand.Intn(len(inputs) - 1)
 			if _, done := swapped[swap]; done {
 				continue
 			}
@@ -0,0 +1,181 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+// This file contains the implementation of Link(), which makes copies of files
+// since object stores don't have hard links.
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// Link creates newName as a copy of oldName, where both are in the writeable
+// remote. Since object stores don't have real hard links, this is done with a
+// remote copy (skipped if oldName has local changes yet to be uploaded and is
+// fully cached, in which case newName is only uploaded at Unmount() time). The
+// two files are independent thereafter: later changes to one are not seen in
+// the other. When configured with CacheData, any cached copy of oldName is hard
+// linked to newName's to save space, until either of them is written to.
+// context is not currently used.
+func (fs *MuxFys) Link(oldName string, newName string, context *fuse.Context) fuse.Status {
+	if fs.readOnly {
+		return fuse.EROFS
+	}
+	r := fs.writeRemote
+	if r == nil {
+		return fuse.EPERM
+	}
+
+	fs.mapMutex.Lock()
+	defer fs.mapMutex.Unlock()
+
+	if _, ok := r.relPath(newName); !ok {
+		return fuse.EPERM
+	}
+	attr, isFile := fs.files[oldName]
+	if !isFile {
+		if _, isDir := fs.dirs[oldName]; isDir {
+			return fuse.EPERM
+		}
+		return fuse.ENOENT
+	}
+	if fs.fileToRemote[oldName] != r {
+		return fuse.EXDEV
+	}
+	if _, exists := fs.files[newName]; exists {
+		return fuse.Status(syscall.EEXIST)
+	}
+	if _, exists := fs.dirs[newName]; exists {
+		return fuse.Status(syscall.EEXIST)
+	}
+
+	remotePathOld := r.getRemotePath(oldName)
+	remotePathNew := r.getRemotePath(newName)
+	created := fs.createdFiles[oldName]
+	pending := false
+	if r.cacheData && created {
+		size := int64(attr.Size)
+		pending = size == 0 || len(r.Uncached(r.getLocalPath(remotePathOld), NewInterval(0, size))) == 0
+	}
+	if !pending {
+		status := r.copyFile(remotePathOld, remotePathNew)
+		if status != fuse.OK {
+			return status
+		}
+	}
+
+	if r.cacheData {
+		localPathOld := r.getLocalPath(remotePathOld)
+		localPathNew := r.getLocalPath(remotePathNew)
+
+		fmutex, err := fs.getFileMutex(localPathOld)
+		if err != nil {
+			return fuse.EIO
+		}
+		err = fmutex.Lock()
+		if err != nil {
+			fs.Error("Link file mutex lock failed", "path", localPathOld, "err", err)
+			return fuse.EIO
+		}
+		defer logClose(fs.Logger, fmutex, "Link file mutex")
+
+		if r.cacheCompress {
+			// we share a plain copy of oldName's cached data; oldName's is then
+			// compressed again in to a new file
+			if _, err = r.holdPlain(localPathOld, true); err != nil {
+				fs.Error("Link decompress of cache file failed", "path", localPathOld, "err", err)
+				return fuse.EIO
+			}
+			defer r.releasePlain(localPathOld)
+		}
+
+		// share oldName's cached data, if any; failure just means newName will
+		// be read from the remote
+		err = os.MkdirAll(filepath.Dir(localPathNew), os.FileMode(dirMode))
+		if err == nil {
+			err = os.Link(localPathOld, localPathNew)
+		}
+		switch {
+		case err == nil:
+			r.CacheLink(localPathOld, localPathNew)
+			if created {
+				r.modified.CacheLink(localPathOld, localPathNew)
+				r.copyAppend(localPathOld, localPathNew)
+			}
+		case !os.IsNotExist(err):
+			fs.Warn("Link of cached files failed", "source", localPathOld, "dest", localPathNew, "err", err)
+		}
+		if pending {
+			// the remote newName doesn't exist, so all of it must be uploaded
+			r.markModified(localPathNew, 0, int64(attr.Size))
+		}
+	}
+
+	newAttr := *attr
+	fs.files[newName] = &newAttr
+	fs.fileToRemote[newName] = r
+	if created && r.cacheData {
+		fs.createdFiles[newName] = true
+	}
+	fs.addNewEntryToItsDir(newName, fuse.S_IFREG)
+	return fuse.OK
+}
+
+// unlinkShared makes sure the given cache file isn't hard linked to another
+// (as done by Link()), by replacing it with a copy of itself, so that writing
+// to it won't change the other file.
+func (r *remote) unlinkShared(localPath string) error {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); !ok || stat.Nlink < 2 {
+		return nil
+	}
+
+	tmpPath := localPath + ".muxfys_unlink"
+	src, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer logClose(r.Logger, src, "unlink shared source", "path", localPath)
+	dst, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if errc := dst.Close(); err == nil {
+		err = errc
+	}
+	if err != nil {
+		if errr := os.Remove(tmpPath); errr != nil {
+			r.Warn("Removal of temporary copy failed", "path", tmpPath, "err", errr)
+		}
+		return err
+	}
+	return os.Rename(tmpPath, localPath)
+}
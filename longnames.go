@@ -0,0 +1,182 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+// This file contains the implementation of RemoteConfig.LongNameStrategy.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// nameMax is the longest file name, in bytes, that can be presented via fuse
+// (NAME_MAX).
+const nameMax = 255
+
+// localNameMax is the longest name we give files in our cache dir, leaving
+// room for the prefixes of the files we keep alongside cached files (eg.
+// ".muxfys_upload.").
+const localNameMax = nameMax - 16
+
+// maxKeptExt is the longest file extension (including the dot) that we keep
+// when shortening a long name.
+const maxKeptExt = 32
+
+// LongNameStrategy describes what to do with object keys that have a path
+// component longer than NAME_MAX (255 bytes), which can't be presented via
+// fuse.
+type LongNameStrategy int
+
+// These are the possible LongNameStrategy values for
+// RemoteConfig.LongNameStrategy.
+//
+// LongNameError, the default, leaves such files and directories out of
+// directory listings (logging a warning about them), so accessing them gives an
+// error.
+//
+// LongNameHash presents a deterministic shortened name: as much of the start of
+// the name as will fit, followed by "~" and a hash of the whole name, followed
+// by the name's extension. Eg. "[300 x's].txt" is presented as something like
+// "xxx...xxx~1a2b3c4d5e6f7a8b.txt".
+//
+// LongNameTruncate presents as much of the start of the name as will fit,
+// followed by "~" and the position of the name amongst the other long names in
+// its directory listing, followed by the name's extension. These names are
+// easier to read, but can change if long-named objects are added or removed,
+// and can collide with real objects that happen to have the same name (in which
+// case only one of them will be accessible).
+const (
+	LongNameError LongNameStrategy = iota
+	LongNameHash
+	LongNameTruncate
+)
+
+// shorten returns the name we present the given name at, if it's longer than
+// NAME_MAX. index is the 1-based position of name amongst the long names in
+// its directory. ok is false if we shouldn't present the name at all.
+func (s LongNameStrategy) shorten(name string, index int) (short string, ok bool) {
+	switch s {
+	case LongNameHash:
+		return hashShortened(name, nameMax), true
+	case LongNameTruncate:
+		return shortened(name, "~"+strconv.Itoa(index), nameMax), true
+	}
+	return "", false
+}
+
+// hashShortened returns the LongNameHash shortened form of name, no longer than
+// max bytes.
+func hashShortened(name string, max int) string {
+	sum := sha256.Sum256([]byte(name))
+	return shortened(name, "~"+hex.EncodeToString(sum[:8]), max)
+}
+
+// shortened returns as much of the start of name as will fit in max bytes,
+// followed by suffix and name's extension.
+func shortened(name, suffix string, max int) string {
+	ext := filepath.Ext(name)
+	if len(ext) > maxKeptExt {
+		ext = ""
+	}
+	prefix := name[:max-len(suffix)-len(ext)]
+	for !utf8.ValidString(prefix) {
+		prefix = prefix[:len(prefix)-1]
+	}
+	return prefix + suffix + ext
+}
+
+// presentLongName is used by openDir() to decide what to present an entry named
+// name in the given directory at (both paths relative to the mount point). It
+// returns name unchanged if it isn't too long, otherwise remembering the real
+// path of the name we return, for getRemotePath(). longIndex is incremented
+// each time we encounter a long name, and should start at 0 for each directory
+// listing. ok is false if the entry shouldn't be presented.
+func (r *remote) presentLongName(dir, name string, longIndex *int) (presented string, ok bool) {
+	if len(name) <= nameMax {
+		return name, true
+	}
+	*longIndex++
+	short, ok := r.longNameStrategy.shorten(name, *longIndex)
+	if !ok {
+		r.Warn("Name too long to be presented", "dir", dir, "name", name)
+		return "", false
+	}
+
+	relDir, _ := r.relPath(dir)
+	realPath := r.unshortened(relDir)
+	if realPath != "" {
+		realPath += "/"
+	}
+	relShort := short
+	if relDir != "" {
+		relShort = relDir + "/" + short
+	}
+
+	r.keysMutex.Lock()
+	defer r.keysMutex.Unlock()
+	if r.longNames == nil {
+		r.longNames = make(map[string]string)
+	}
+	r.longNames[relShort] = realPath + name
+	return short, true
+}
+
+// unshortened converts a path relative to our root, that may include names
+// shortened by presentLongName(), back to the real path relative to our root.
+func (r *remote) unshortened(relPath string) string {
+	r.keysMutex.Lock()
+	defer r.keysMutex.Unlock()
+	if len(r.longNames) == 0 {
+		return relPath
+	}
+	for prefix := relPath; prefix != ""; {
+		if realPath, exists := r.longNames[prefix]; exists {
+			return realPath + relPath[len(prefix):]
+		}
+		i := strings.LastIndex(prefix, "/")
+		if i < 0 {
+			break
+		}
+		prefix = prefix[:i]
+	}
+	return relPath
+}
+
+// shortLocalPath returns the given local path with any components that are too
+// long to be created on the local file system (alongside the other files we
+// keep in the cache dir) replaced by hash-shortened names, so that files with
+// long names can still be cached. It is
+// returned unchanged if we were not configured with a LongNameStrategy that
+// presents such files.
+func (r *remote) shortLocalPath(localPath string) string {
+	if r.longNameStrategy == LongNameError || len(localPath) <= localNameMax {
+		return localPath
+	}
+	parts := strings.Split(localPath, string(filepath.Separator))
+	for i, part := range parts {
+		if len(part) > localNameMax {
+			parts[i] = hashShortened(part, localNameMax)
+		}
+	}
+	return strings.Join(parts, string(filepath.Separator))
+}
@@ -0,0 +1,172 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// longName is what longAccessor presents "LONG" in file names as.
+var longName = strings.Repeat("x", 297)
+
+// longAccessor is a localAccessor that presents files with "LONG" in their
+// names as having names longer than NAME_MAX.
+type longAccessor struct {
+	*localAccessor
+}
+
+func (a *longAccessor) localName(path string) string {
+	return strings.Replace(path, longName, "LONG", -1)
+}
+
+func (a *longAccessor) ListEntries(dir string) ([]RemoteAttr, error) {
+	ras, err := a.localAccessor.ListEntries(a.localName(dir))
+	for i := range ras {
+		ras[i].Name = dir + strings.Replace(filepath.Base(ras[i].Name), "LONG", longName, -1)
+		if strings.HasSuffix(ras[i].Name, longName+"dir") {
+			ras[i].Name += "/"
+		}
+	}
+	return ras, err
+}
+
+func (a *longAccessor) OpenFile(path string, offset int64) (io.ReadCloser, error) {
+	return a.localAccessor.OpenFile(a.localName(path), offset)
+}
+
+func (a *longAccessor) DownloadFile(source, dest string) error {
+	return a.localAccessor.DownloadFile(a.localName(source), dest)
+}
+
+func TestLongNames(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "muxfys_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	source := filepath.Join(tmpdir, "source")
+	os.MkdirAll(filepath.Join(source, "LONGdir"), os.FileMode(0777))
+	ioutil.WriteFile(filepath.Join(source, "LONG.txt"), []byte("long"), 0644)
+	ioutil.WriteFile(filepath.Join(source, "short.txt"), []byte("short"), 0644)
+	ioutil.WriteFile(filepath.Join(source, "LONGdir", "inner.txt"), []byte("inner"), 0644)
+	accessor := &longAccessor{&localAccessor{target: source}}
+	longFile := longName + ".txt"
+	longDir := longName + "dir"
+
+	Convey("LongNameStrategy shortens names deterministically", t, func() {
+		So(len(longFile), ShouldEqual, 301)
+
+		short, ok := LongNameHash.shorten(longFile, 1)
+		So(ok, ShouldBeTrue)
+		So(len(short), ShouldEqual, nameMax)
+		So(short, ShouldStartWith, strings.Repeat("x", 234)+"~")
+		So(short, ShouldEndWith, ".txt")
+		again, _ := LongNameHash.shorten(longFile, 2)
+		So(again, ShouldEqual, short)
+		other, _ := LongNameHash.shorten(longName+"y.txt", 1)
+		So(other, ShouldNotEqual, short)
+
+		short, ok = LongNameTruncate.shorten(longFile, 3)
+		So(ok, ShouldBeTrue)
+		So(short, ShouldEqual, strings.Repeat("x", 249)+"~3.txt")
+
+		_, ok = LongNameError.shorten(longFile, 1)
+		So(ok, ShouldBeFalse)
+
+		multi := strings.Repeat("é", 150)
+		short, _ = LongNameTruncate.shorten(multi, 1)
+		So(len(short), ShouldBeLessThanOrEqualTo, nameMax)
+		So(short, ShouldEqual, strings.Repeat("é", 126)+"~1")
+	})
+
+	mount := func(strategy LongNameStrategy, cacheData bool) (*MuxFys, *remote) {
+		fs, r := newTestFS(&Config{Mount: filepath.Join(tmpdir, "mount"), CacheBase: tmpdir}, &RemoteConfig{Accessor: accessor, LongNameStrategy: strategy, CacheData: cacheData})
+		return fs, r
+	}
+
+	names := func(fs *MuxFys, dir string) []string {
+		entries, status := fs.OpenDir(dir, nil)
+		So(status, ShouldEqual, fuse.OK)
+		var n []string
+		for _, entry := range entries {
+			n = append(n, entry.Name)
+		}
+		sort.Strings(n)
+		return n
+	}
+
+	read := func(fs *MuxFys, name string) string {
+		attr, status := fs.GetAttr(name, nil)
+		So(status, ShouldEqual, fuse.OK)
+		file, status := fs.Open(name, uint32(os.O_RDONLY), nil)
+		So(status, ShouldEqual, fuse.OK)
+		defer file.Release()
+		size := int(attr.Size)
+		rr, status := file.Read(make([]byte, size), 0)
+		So(status, ShouldEqual, fuse.OK)
+		b, _ := rr.Bytes(make([]byte, size))
+		return string(b)
+	}
+
+	Convey("By default, names longer than NAME_MAX are not listed", t, func() {
+		fs, _ := mount(LongNameError, false)
+		So(names(fs, ""), ShouldResemble, []string{"short.txt"})
+		So(read(fs, "short.txt"), ShouldEqual, "short")
+	})
+
+	Convey("With LongNameHash, long names are presented shortened and can be read", t, func() {
+		for _, cacheData := range []bool{false, true} {
+			fs, r := mount(LongNameHash, cacheData)
+			shortFile, _ := LongNameHash.shorten(longFile, 0)
+			shortDir, _ := LongNameHash.shorten(longDir, 0)
+			So(names(fs, ""), ShouldResemble, []string{"short.txt", shortDir, shortFile})
+			So(read(fs, shortFile), ShouldEqual, "long")
+			So(r.getRemotePath(shortFile), ShouldEqual, filepath.Join(source, longFile))
+
+			So(names(fs, shortDir), ShouldResemble, []string{"inner.txt"})
+			So(read(fs, shortDir+"/inner.txt"), ShouldEqual, "inner")
+			So(r.getRemotePath(shortDir+"/inner.txt"), ShouldEqual, filepath.Join(source, longDir, "inner.txt"))
+
+			if cacheData {
+				So(len(filepath.Base(r.getLocalPath(r.getRemotePath(shortFile)))), ShouldBeLessThanOrEqualTo, localNameMax)
+			}
+		}
+	})
+
+	Convey("With LongNameTruncate, long names are presented with an index", t, func() {
+		fs, r := mount(LongNameTruncate, false)
+		shortFile := strings.Repeat("x", 249) + "~1.txt"
+		shortDir := strings.Repeat("x", 253) + "~2"
+		So(names(fs, ""), ShouldResemble, []string{"short.txt", shortDir, shortFile})
+		So(read(fs, shortFile), ShouldEqual, "long")
+		So(names(fs, shortDir), ShouldResemble, []string{"inner.txt"})
+		So(read(fs, shortDir+"/inner.txt"), ShouldEqual, "inner")
+		So(r.getRemotePath(shortDir), ShouldEqual, filepath.Join(source, longDir))
+	})
+}
@@ -0,0 +1,210 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+// This file implements the in-memory cache used when RemoteConfig
+// CacheInMemory is true. Remote files are cached in blocks of memCacheBlockSize
+// bytes, and the least recently used blocks of all the files of a remote are
+// discarded once the remote's MemCacheMaxBytes would be exceeded.
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/hanwen/go-fuse/v2/fuse/nodefs"
+	"github.com/inconshreveable/log15"
+)
+
+const (
+	// memCacheBlockSize is the number of bytes of a file stored in each block
+	// of an in-memory cache.
+	memCacheBlockSize = 262144
+
+	// defaultMemCacheMaxBytes is the MemCacheMaxBytes used if not otherwise
+	// specified.
+	defaultMemCacheMaxBytes = 268435456
+)
+
+// memBlockKey identifies a block of a remote file.
+type memBlockKey struct {
+	path  string
+	block int64
+}
+
+// memBlock is a block of a remote file held in memory.
+type memBlock struct {
+	key  memBlockKey
+	data []byte
+}
+
+// memCache holds blocks of remote files in memory, discarding the least
+// recently used ones to stay within maxBytes.
+type memCache struct {
+	maxBytes int64
+	bytes    int64
+	blocks   map[memBlockKey]*list.Element
+	lru      *list.List
+	sizes    map[string]int64
+	mutex    sync.Mutex
+}
+
+// newMemCache creates a new empty memCache that will hold up to maxBytes.
+func newMemCache(maxBytes int64) *memCache {
+	return &memCache{
+		maxBytes: maxBytes,
+		blocks:   make(map[memBlockKey]*list.Element),
+		lru:      list.New(),
+		sizes:    make(map[string]int64),
+	}
+}
+
+// get returns the data of the given block of the file at path, if we have it
+// and the file is still the given size.
+func (mc *memCache) get(path string, size, block int64) ([]byte, bool) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+	if mc.sizes[path] != size {
+		return nil, false
+	}
+	e, exists := mc.blocks[memBlockKey{path, block}]
+	if !exists {
+		return nil, false
+	}
+	mc.lru.MoveToFront(e)
+	return e.Value.(*memBlock).data, true
+}
+
+// put stores the data of the given block of the file at path, which is of the
+// given size, discarding the least recently used blocks if necessary.
+func (mc *memCache) put(path string, size, block int64, data []byte) {
+	if int64(len(data)) > mc.maxBytes {
+		return
+	}
+
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+	if known, exists := mc.sizes[path]; exists && known != size {
+		// the file has changed, so everything we stored for it is stale
+		for e := mc.lru.Front(); e != nil; {
+			next := e.Next()
+			if e.Value.(*memBlock).key.path == path {
+				mc.remove(e)
+			}
+			e = next
+		}
+	}
+	mc.sizes[path] = size
+
+	key := memBlockKey{path, block}
+	if _, exists := mc.blocks[key]; exists {
+		return
+	}
+	for mc.bytes+int64(len(data)) > mc.maxBytes {
+		mc.remove(mc.lru.Back())
+	}
+	mc.blocks[key] = mc.lru.PushFront(&memBlock{key: key, data: data})
+	mc.bytes += int64(len(data))
+}
+
+// remove discards the given block. You must hold the mutex when calling this.
+func (mc *memCache) remove(e *list.Element) {
+	mb := mc.lru.Remove(e).(*memBlock)
+	delete(mc.blocks, mb.key)
+	mc.bytes -= int64(len(mb.data))
+}
+
+// memCachedFile is muxfys' implementation of pathfs.File for reading data from
+// a remote file via an in-memory cache. It is read-only.
+type memCachedFile struct {
+	byteCounter
+	nodefs.File
+	r          *remote
+	remotePath string
+	size       int64
+	remoteFile *remoteFile
+	mutex      sync.Mutex
+	log15.Logger
+}
+
+// newMemCachedFile makes a memCachedFile that returns reads from memory where
+// possible, and otherwise reads from remotePath and stores what it read in
+// memory.
+func newMemCachedFile(r *remote, remotePath string, attr *fuse.Attr, logger log15.Logger) nodefs.File {
+	return &memCachedFile{
+		File:       nodefs.NewDefaultFile(),
+		r:          r,
+		remotePath: remotePath,
+		size:       int64(attr.Size),
+		remoteFile: newRemoteFile(r, remotePath, attr, false, logger).(*remoteFile),
+		Logger:     logger.New("rpath", remotePath),
+	}
+}
+
+// Read gets the blocks of the remote file that hold the requested bytes from
+// memory, first reading and storing any blocks that aren't in memory from the
+// remote file.
+func (f *memCachedFile) Read(buf []byte, offset int64) (fuse.ReadResult, fuse.Status) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if offset >= f.size {
+		// nothing to read
+		return nil, fuse.OK
+	}
+	end := offset + int64(len(buf))
+	if end > f.size {
+		end = f.size
+	}
+
+	var n int
+	for block := offset / memCacheBlockSize; block*memCacheBlockSize < end; block++ {
+		data, cached := f.r.memCache.get(f.remotePath, f.size, block)
+		if !cached {
+			start := block * memCacheBlockSize
+			length := f.size - start
+			if length > memCacheBlockSize {
+				length = memCacheBlockSize
+			}
+			data = make([]byte, length)
+			_, status := f.remoteFile.Read(data, start)
+			if status != fuse.OK {
+				// we warn instead of error because this is a "normal" situation
+				// when trying to read from non-existent files
+				f.Warn("Read failed", "status", status)
+				return nil, status
+			}
+			f.r.memCache.put(f.remotePath, f.size, block, data)
+		}
+
+		from := offset + int64(n) - block*memCacheBlockSize
+		n += copy(buf[n:end-offset], data[from:])
+	}
+
+	f.countRead(int64(n))
+	return fuse.ReadResultData(buf[:n]), fuse.OK
+}
+
+// Release is called before the file handle is forgotten, so we free the remote
+// file.
+func (f *memCachedFile) Release() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.remoteFile.Release()
+}
@@ -0,0 +1,181 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/inconshreveable/log15"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMemCache(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "muxfys_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	source := filepath.Join(tmpdir, "source")
+	os.MkdirAll(source, os.FileMode(0777))
+
+	// a file spanning a few blocks, with a short final block
+	data := make([]byte, 2*memCacheBlockSize+1000)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	sourceFile := filepath.Join(source, "big.file")
+	err = ioutil.WriteFile(sourceFile, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attr := &fuse.Attr{Size: uint64(len(data))}
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	accessor := &localAccessor{target: source}
+
+	read := func(r *remote, offset, length int) ([]byte, fuse.Status) {
+		file := newMemCachedFile(r, sourceFile, attr, logger)
+		defer file.Release()
+		rr, status := file.Read(make([]byte, length), int64(offset))
+		if status != fuse.OK || rr == nil {
+			return nil, status
+		}
+		b, _ := rr.Bytes(nil)
+		return b, status
+	}
+
+	failRemote := func(fail bool) {
+		resetMutex.Lock()
+		resetFail = fail
+		resetMutex.Unlock()
+	}
+
+	Convey("CacheInMemory can't be used with Write or other cache options", t, func() {
+		_, err := newRemote(&RemoteConfig{Accessor: accessor, CacheInMemory: true, Write: true}, tmpdir, 1, logger)
+		So(err, ShouldNotBeNil)
+		_, err = newRemote(&RemoteConfig{Accessor: accessor, CacheInMemory: true, CacheData: true}, tmpdir, 1, logger)
+		So(err, ShouldNotBeNil)
+		_, err = newRemote(&RemoteConfig{Accessor: accessor, CacheInMemory: true, CacheDir: tmpdir}, tmpdir, 1, logger)
+		So(err, ShouldNotBeNil)
+		_, err = newRemote(&RemoteConfig{Accessor: accessor, CacheInMemory: true, MemCacheMaxBytes: -1}, tmpdir, 1, logger)
+		So(err, ShouldNotBeNil)
+
+		r, err := newRemote(&RemoteConfig{Accessor: accessor, CacheInMemory: true}, tmpdir, 1, logger)
+		So(err, ShouldBeNil)
+		So(r.cacheData, ShouldBeFalse)
+		So(r.memCache.maxBytes, ShouldEqual, defaultMemCacheMaxBytes)
+	})
+
+	Convey("A memCache discards the least recently used blocks", t, func() {
+		mc := newMemCache(10)
+		mc.put("a", 100, 0, []byte("1234"))
+		mc.put("a", 100, 1, []byte("5678"))
+		_, cached := mc.get("a", 100, 0)
+		So(cached, ShouldBeTrue)
+		mc.put("b", 4, 0, []byte("abcd"))
+		So(mc.bytes, ShouldEqual, 8)
+
+		_, cached = mc.get("a", 100, 1)
+		So(cached, ShouldBeFalse)
+		b, cached := mc.get("a", 100, 0)
+		So(cached, ShouldBeTrue)
+		So(string(b), ShouldEqual, "1234")
+		_, cached = mc.get("b", 4, 0)
+		So(cached, ShouldBeTrue)
+
+		mc.put("c", 11, 0, []byte("too big data"))
+		_, cached = mc.get("c", 11, 0)
+		So(cached, ShouldBeFalse)
+
+		Convey("A change in size invalidates a file's blocks", func() {
+			_, cached = mc.get("a", 99, 0)
+			So(cached, ShouldBeFalse)
+			mc.put("a", 99, 1, []byte("new"))
+			_, cached = mc.get("a", 99, 0)
+			So(cached, ShouldBeFalse)
+			b, cached = mc.get("a", 99, 1)
+			So(cached, ShouldBeTrue)
+			So(string(b), ShouldEqual, "new")
+			So(mc.bytes, ShouldEqual, 7)
+		})
+	})
+
+	Convey("With CacheInMemory, reads are cached in memory", t, func() {
+		r, err := newRemote(&RemoteConfig{Accessor: accessor, CacheInMemory: true, MemCacheMaxBytes: 2 * memCacheBlockSize}, tmpdir, 1, logger)
+		So(err, ShouldBeNil)
+		defer failRemote(false)
+
+		// a read spanning the first 2 blocks
+		offset := memCacheBlockSize - 100
+		b, status := read(r, offset, 200)
+		So(status, ShouldEqual, fuse.OK)
+		So(b, ShouldResemble, data[offset:offset+200])
+		So(r.memCache.bytes, ShouldEqual, 2*memCacheBlockSize)
+
+		_, cached := r.memCache.get(sourceFile, int64(len(data)), 0)
+		So(cached, ShouldBeTrue)
+
+		failRemote(true)
+		b, status = read(r, 5, 1000)
+		So(status, ShouldEqual, fuse.OK)
+		So(b, ShouldResemble, data[5:1005])
+		failRemote(false)
+
+		// reads past the end are truncated, and reading the last block evicts
+		// the least recently used block 1
+		b, status = read(r, len(data)-10, 100)
+		So(status, ShouldEqual, fuse.OK)
+		So(b, ShouldResemble, data[len(data)-10:])
+		b, status = read(r, len(data), 100)
+		So(status, ShouldEqual, fuse.OK)
+		So(b, ShouldBeEmpty)
+		So(r.memCache.bytes, ShouldEqual, memCacheBlockSize+1000)
+		_, cached = r.memCache.get(sourceFile, int64(len(data)), 1)
+		So(cached, ShouldBeFalse)
+		_, cached = r.memCache.get(sourceFile, int64(len(data)), 0)
+		So(cached, ShouldBeTrue)
+
+		// nothing was written to disk
+		entries, err := ioutil.ReadDir(tmpdir)
+		So(err, ShouldBeNil)
+		So(len(entries), ShouldEqual, 1)
+	})
+
+	Convey("Open() uses the in-memory cache", t, func() {
+		fs, r := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "memMount"), CacheBase: tmpdir}, &RemoteConfig{Accessor: accessor, CacheInMemory: true})
+
+		file, status := fs.Open("big.file", uint32(os.O_RDONLY), nil)
+		So(status, ShouldEqual, fuse.OK)
+		rr, status := file.Read(make([]byte, 10), 20)
+		So(status, ShouldEqual, fuse.OK)
+		b, _ := rr.Bytes(nil)
+		So(b, ShouldResemble, data[20:30])
+		file.Release()
+		So(r.memCache.bytes, ShouldEqual, memCacheBlockSize)
+
+		_, status = fs.Open("big.file", uint32(os.O_WRONLY), nil)
+		So(status, ShouldEqual, fuse.EPERM)
+	})
+}
@@ -0,0 +1,320 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+// This file contains an implementation of RemoteAccessor that stores objects
+// in memory, for use in tests, demos and benchmarks.
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryListPageSize is the number of objects ListEntries() considers per page,
+// releasing the lock between pages so that large listings don't block writers.
+const memoryListPageSize = 1000
+
+// MemoryAccessor implements the RemoteAccessor interface by storing objects in
+// memory, with no disk or network access (other than downloading to and
+// uploading from local files when asked). Objects exist at paths relative to
+// the root of the accessor (eg. "dir/file.txt"), and directories are implied by
+// the paths of the objects within them.
+//
+// It is fast and deterministic, so useful for testing and benchmarking code
+// that uses muxfys, and for demos. It also implements FileStater and
+// ReaderUploader. It is safe for concurrent use.
+type MemoryAccessor struct {
+	name    string
+	objects map[string][]byte
+	mtimes  map[string]time.Time
+	keys    []string
+	mutex   sync.RWMutex
+}
+
+// NewMemoryAccessor creates a MemoryAccessor with no objects. The name is only
+// used to distinguish it from other MemoryAccessors in logs and cache dirs.
+func NewMemoryAccessor(name string) *MemoryAccessor {
+	return &MemoryAccessor{
+		name:    name,
+		objects: make(map[string][]byte),
+		mtimes:  make(map[string]time.Time),
+	}
+}
+
+// Put stores a copy of the given data as the object at the given path,
+// replacing any existing object there, with a modification time of now.
+func (a *MemoryAccessor) Put(path string, data []byte) {
+	a.put(path, append([]byte{}, data...))
+}
+
+// put stores data at path without copying it. Stored slices are never
+// modified, so they can be shared between objects and readers.
+func (a *MemoryAccessor) put(path string, data []byte) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if _, exists := a.objects[path]; !exists {
+		i := sort.SearchStrings(a.keys, path)
+		a.keys = append(a.keys, "")
+		copy(a.keys[i+1:], a.keys[i:])
+		a.keys[i] = path
+	}
+	a.objects[path] = data
+	a.mtimes[path] = time.Now()
+}
+
+// Get returns a copy of the data of the object at the given path. exists is
+// false if there is no such object.
+func (a *MemoryAccessor) Get(path string) (data []byte, exists bool) {
+	data, _, err := a.get(path, "get")
+	if err != nil {
+		return nil, false
+	}
+	return append([]byte{}, data...), true
+}
+
+// get returns the stored data and mtime of the object at path, or an error
+// for which ErrorIsNotExists() returns true.
+func (a *MemoryAccessor) get(path, op string) ([]byte, time.Time, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	data, exists := a.objects[path]
+	if !exists {
+		return nil, time.Time{}, a.notExists(op, path)
+	}
+	return data, a.mtimes[path], nil
+}
+
+// notExists returns the error we give when there's no object at path.
+func (a *MemoryAccessor) notExists(op, path string) error {
+	return &os.PathError{Op: op, Path: a.Target() + "/" + path, Err: os.ErrNotExist}
+}
+
+// DownloadFile implements RemoteAccessor by writing the object's data to dest.
+func (a *MemoryAccessor) DownloadFile(source, dest string) error {
+	data, _, err := a.get(source, "download")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(dest), os.FileMode(dirMode))
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dest, data, os.FileMode(fileMode))
+}
+
+// UploadFile implements RemoteAccessor by reading source in to memory.
+func (a *MemoryAccessor) UploadFile(source, dest, contentType string) error {
+	data, err := ioutil.ReadFile(source)
+	if err != nil {
+		return err
+	}
+	a.put(dest, data)
+	return nil
+}
+
+// UploadData implements RemoteAccessor by reading data in to memory.
+func (a *MemoryAccessor) UploadData(data io.Reader, dest string) error {
+	b, err := ioutil.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	a.put(dest, b)
+	return nil
+}
+
+// UploadReader implements ReaderUploader by reading data in to memory. opts
+// are not recorded.
+func (a *MemoryAccessor) UploadReader(ctx context.Context, data io.Reader, size int64, dest string, opts UploadOptions) error {
+	b, err := ioutil.ReadAll(io.LimitReader(data, size))
+	if err != nil {
+		return err
+	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	a.put(dest, b)
+	return nil
+}
+
+// ListEntries implements RemoteAccessor by going through our objects in sorted
+// order, a page at a time.
+func (a *MemoryAccessor) ListEntries(dir string) ([]RemoteAttr, error) {
+	var ras []RemoteAttr
+	marker, inclusive := dir, true
+	seen := make(map[string]bool)
+	for {
+		keys, lastPage := a.listPage(dir, marker, inclusive)
+		if len(keys) == 0 {
+			break
+		}
+		for _, ra := range keys {
+			rest := ra.Name[len(dir):]
+			if i := strings.Index(rest, "/"); i >= 0 {
+				subDir := dir + rest[:i+1]
+				if !seen[subDir] {
+					seen[subDir] = true
+					ras = append(ras, RemoteAttr{Name: subDir})
+				}
+				continue
+			}
+			ras = append(ras, ra)
+		}
+		if lastPage {
+			break
+		}
+		marker, inclusive = keys[len(keys)-1].Name, false
+	}
+	return ras, nil
+}
+
+// listPage returns the details of up to memoryListPageSize objects with a
+// prefix of dir that sort after marker (or are marker, if inclusive). lastPage
+// is true if there are no more.
+func (a *MemoryAccessor) listPage(dir, marker string, inclusive bool) (ras []RemoteAttr, lastPage bool) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	i := sort.SearchStrings(a.keys, marker)
+	for ; i < len(a.keys) && len(ras) < memoryListPageSize; i++ {
+		key := a.keys[i]
+		if key == marker && !inclusive {
+			continue
+		}
+		if !strings.HasPrefix(key, dir) {
+			return ras, true
+		}
+		ras = append(ras, RemoteAttr{Name: key, Size: int64(len(a.objects[key])), MTime: a.mtimes[key]})
+	}
+	return ras, i >= len(a.keys)
+}
+
+// memoryObject is what MemoryAccessor.OpenFile() returns.
+type memoryObject struct {
+	*bytes.Reader
+}
+
+// Close implements io.Closer by doing nothing.
+func (o *memoryObject) Close() error {
+	return nil
+}
+
+// OpenFile implements RemoteAccessor by returning a reader of the object's
+// data, starting at offset.
+func (a *MemoryAccessor) OpenFile(path string, offset int64) (io.ReadCloser, error) {
+	data, _, err := a.get(path, "open")
+	if err != nil {
+		return nil, err
+	}
+	o := &memoryObject{bytes.NewReader(data)}
+	_, err = o.Seek(offset, io.SeekStart)
+	return o, err
+}
+
+// Seek implements RemoteAccessor by seeking the given reader, if it came from
+// OpenFile(), or otherwise closing it and opening a new one.
+func (a *MemoryAccessor) Seek(path string, rc io.ReadCloser, offset int64) (io.ReadCloser, error) {
+	if o, ok := rc.(*memoryObject); ok {
+		_, err := o.Seek(offset, io.SeekStart)
+		return o, err
+	}
+	err := rc.Close()
+	if err != nil {
+		return nil, err
+	}
+	return a.OpenFile(path, offset)
+}
+
+// CopyFile implements RemoteAccessor by storing source's data at dest as well.
+func (a *MemoryAccessor) CopyFile(source, dest string) error {
+	data, _, err := a.get(source, "copy")
+	if err != nil {
+		return err
+	}
+	a.put(dest, data)
+	return nil
+}
+
+// DeleteFile implements RemoteAccessor by forgetting the object.
+func (a *MemoryAccessor) DeleteFile(path string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if _, exists := a.objects[path]; !exists {
+		return a.notExists("delete", path)
+	}
+	delete(a.objects, path)
+	delete(a.mtimes, path)
+	i := sort.SearchStrings(a.keys, path)
+	a.keys = append(a.keys[:i], a.keys[i+1:]...)
+	return nil
+}
+
+// DeleteIncompleteUpload implements RemoteAccessor by deleting the object if
+// it exists.
+func (a *MemoryAccessor) DeleteIncompleteUpload(path string) error {
+	err := a.DeleteFile(path)
+	if a.ErrorIsNotExists(err) {
+		return nil
+	}
+	return err
+}
+
+// StatFile implements FileStater.
+func (a *MemoryAccessor) StatFile(path string) (RemoteAttr, error) {
+	data, mtime, err := a.get(path, "stat")
+	if err != nil {
+		return RemoteAttr{}, err
+	}
+	return RemoteAttr{Name: path, Size: int64(len(data)), MTime: mtime}, nil
+}
+
+// ErrorIsNotExists implements RemoteAccessor by deferring to os.
+func (a *MemoryAccessor) ErrorIsNotExists(err error) bool {
+	return os.IsNotExist(err)
+}
+
+// ErrorIsNoQuota implements RemoteAccessor by always returning false, since we
+// have no quota.
+func (a *MemoryAccessor) ErrorIsNoQuota(err error) bool {
+	return false
+}
+
+// Target implements RemoteAccessor by returning a memory:// url including our
+// name.
+func (a *MemoryAccessor) Target() string {
+	return "memory://" + a.name
+}
+
+// RemotePath implements RemoteAccessor by returning the cleaned relPath, since
+// our objects are stored at paths relative to our root.
+func (a *MemoryAccessor) RemotePath(relPath string) string {
+	return strings.TrimPrefix(filepath.Clean("/"+relPath), "/")
+}
+
+// LocalPath implements RemoteAccessor by including our name in the return
+// value.
+func (a *MemoryAccessor) LocalPath(baseDir, remotePath string) string {
+	return filepath.Join(baseDir, "memory", a.name, remotePath)
+}
@@ -0,0 +1,221 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMemoryAccessor(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "muxfys_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	Convey("A MemoryAccessor stores objects in memory", t, func() {
+		a := NewMemoryAccessor("test")
+		So(a.Target(), ShouldEqual, "memory://test")
+		So(a.RemotePath(""), ShouldEqual, "")
+		So(a.RemotePath("/dir//a.txt"), ShouldEqual, "dir/a.txt")
+		So(a.LocalPath("/cache", "dir/a.txt"), ShouldEqual, "/cache/memory/test/dir/a.txt")
+
+		data := []byte("abcdef")
+		a.Put("a.txt", data)
+		data[0] = 'z'
+		a.Put("dir/b.txt", []byte("b"))
+		a.Put("dir/sub/c.txt", []byte("c"))
+
+		got, exists := a.Get("a.txt")
+		So(exists, ShouldBeTrue)
+		So(string(got), ShouldEqual, "abcdef")
+		_, exists = a.Get("missing.txt")
+		So(exists, ShouldBeFalse)
+
+		ras, err := a.ListEntries("")
+		So(err, ShouldBeNil)
+		So(len(ras), ShouldEqual, 2)
+		So(ras[0].Name, ShouldEqual, "a.txt")
+		So(ras[0].Size, ShouldEqual, 6)
+		So(ras[0].MTime.IsZero(), ShouldBeFalse)
+		So(ras[1].Name, ShouldEqual, "dir/")
+
+		ras, err = a.ListEntries("dir/")
+		So(err, ShouldBeNil)
+		So(len(ras), ShouldEqual, 2)
+		So(ras[0].Name, ShouldEqual, "dir/b.txt")
+		So(ras[1].Name, ShouldEqual, "dir/sub/")
+
+		ras, err = a.ListEntries("nodir/")
+		So(err, ShouldBeNil)
+		So(ras, ShouldBeEmpty)
+
+		Convey("Files can be read from an offset, and seeked", func() {
+			rc, err := a.OpenFile("a.txt", 2)
+			So(err, ShouldBeNil)
+			b, err := ioutil.ReadAll(rc)
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, "cdef")
+
+			rc, err = a.Seek("a.txt", rc, 1)
+			So(err, ShouldBeNil)
+			b = make([]byte, 2)
+			_, err = rc.Read(b)
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, "bc")
+			So(rc.Close(), ShouldBeNil)
+
+			rc, err = a.OpenFile("a.txt", 10)
+			So(err, ShouldBeNil)
+			b, err = ioutil.ReadAll(rc)
+			So(err, ShouldBeNil)
+			So(b, ShouldBeEmpty)
+
+			_, err = a.OpenFile("missing.txt", 0)
+			So(err, ShouldNotBeNil)
+			So(a.ErrorIsNotExists(err), ShouldBeTrue)
+		})
+
+		Convey("Files can be copied, deleted and statted", func() {
+			So(a.CopyFile("a.txt", "dir/copy.txt"), ShouldBeNil)
+			got, _ := a.Get("dir/copy.txt")
+			So(string(got), ShouldEqual, "abcdef")
+
+			So(a.DeleteFile("a.txt"), ShouldBeNil)
+			_, exists := a.Get("a.txt")
+			So(exists, ShouldBeFalse)
+			So(a.ErrorIsNotExists(a.DeleteFile("a.txt")), ShouldBeTrue)
+			So(a.DeleteIncompleteUpload("a.txt"), ShouldBeNil)
+			So(a.ErrorIsNotExists(a.CopyFile("a.txt", "b.txt")), ShouldBeTrue)
+
+			ra, err := a.StatFile("dir/copy.txt")
+			So(err, ShouldBeNil)
+			So(ra.Size, ShouldEqual, 6)
+			_, err = a.StatFile("dir")
+			So(a.ErrorIsNotExists(err), ShouldBeTrue)
+
+			ras, err := a.ListEntries("")
+			So(err, ShouldBeNil)
+			So(len(ras), ShouldEqual, 1)
+			So(ras[0].Name, ShouldEqual, "dir/")
+		})
+
+		Convey("Files can be downloaded and uploaded", func() {
+			local := filepath.Join(tmpdir, "local", "a.txt")
+			So(a.DownloadFile("a.txt", local), ShouldBeNil)
+			b, err := ioutil.ReadFile(local)
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, "abcdef")
+			So(a.ErrorIsNotExists(a.DownloadFile("missing.txt", local)), ShouldBeTrue)
+
+			So(a.UploadFile(local, "up/a.txt", ""), ShouldBeNil)
+			got, _ := a.Get("up/a.txt")
+			So(string(got), ShouldEqual, "abcdef")
+
+			f, err := os.Open(local)
+			So(err, ShouldBeNil)
+			defer f.Close()
+			So(a.UploadData(f, "a.txt"), ShouldBeNil)
+			ras, _ := a.ListEntries("")
+			So(len(ras), ShouldEqual, 3)
+
+			So(a.UploadReader(context.Background(), strings.NewReader("ghijkl"), 3, "up/b.txt", UploadOptions{}), ShouldBeNil)
+			got, _ = a.Get("up/b.txt")
+			So(string(got), ShouldEqual, "ghi")
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			So(a.UploadReader(ctx, strings.NewReader("mno"), 3, "up/c.txt", UploadOptions{}), ShouldNotBeNil)
+			_, exists := a.Get("up/c.txt")
+			So(exists, ShouldBeFalse)
+		})
+	})
+
+	Convey("A MemoryAccessor lists large directories a page at a time", t, func() {
+		a := NewMemoryAccessor("large")
+		n := memoryListPageSize*2 + 10
+		for i := 0; i < n; i++ {
+			a.Put(fmt.Sprintf("dir/%05d.txt", i), []byte("x"))
+		}
+		a.Put("dir/", nil)
+		for i := 0; i < memoryListPageSize+1; i++ {
+			a.Put(fmt.Sprintf("dir/sub/%05d.txt", i), []byte("x"))
+		}
+		a.Put("dirz.txt", []byte("x"))
+
+		ras, err := a.ListEntries("dir/")
+		So(err, ShouldBeNil)
+		So(len(ras), ShouldEqual, n+2)
+		So(ras[0].Name, ShouldEqual, "dir/")
+		So(ras[1].Name, ShouldEqual, "dir/00000.txt")
+		So(ras[n].Name, ShouldEqual, fmt.Sprintf("dir/%05d.txt", n-1))
+		So(ras[n+1].Name, ShouldEqual, "dir/sub/")
+
+		ras, err = a.ListEntries("")
+		So(err, ShouldBeNil)
+		So(len(ras), ShouldEqual, 2)
+	})
+
+	Convey("A MemoryAccessor can be used by a remote", t, func() {
+		a := NewMemoryAccessor("mounted")
+		a.Put("dir/a.txt", []byte("hello"))
+
+		fs, _ := newTestFS(&Config{Mount: filepath.Join(tmpdir, "mount"), CacheBase: tmpdir}, &RemoteConfig{Accessor: a, CacheData: true, Write: true})
+
+		entries, status := fs.OpenDir("", nil)
+		So(status, ShouldEqual, fuse.OK)
+		So(len(entries), ShouldEqual, 1)
+		So(entries[0].Name, ShouldEqual, "dir")
+
+		entries, status = fs.OpenDir("dir", nil)
+		So(status, ShouldEqual, fuse.OK)
+		So(len(entries), ShouldEqual, 1)
+		So(entries[0].Name, ShouldEqual, "a.txt")
+
+		attr, status := fs.GetAttr("dir/a.txt", nil)
+		So(status, ShouldEqual, fuse.OK)
+		So(attr.Size, ShouldEqual, 5)
+		file, status := fs.Open("dir/a.txt", uint32(os.O_RDONLY), nil)
+		So(status, ShouldEqual, fuse.OK)
+		rr, status := file.Read(make([]byte, 5), 0)
+		So(status, ShouldEqual, fuse.OK)
+		b, _ := rr.Bytes(make([]byte, 5))
+		So(string(b), ShouldEqual, "hello")
+		file.Release()
+
+		file, status = fs.Create("dir/new.txt", uint32(os.O_WRONLY), uint32(0644), nil)
+		So(status, ShouldEqual, fuse.OK)
+		_, status = file.Write([]byte("new"), 0)
+		So(status, ShouldEqual, fuse.OK)
+		file.Release()
+		So(fs.uploadCreated(), ShouldBeNil)
+		got, exists := a.Get("dir/new.txt")
+		So(exists, ShouldBeTrue)
+		So(string(got), ShouldEqual, "new")
+	})
+}
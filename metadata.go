@@ -0,0 +1,117 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+// This file contains the implementation of MuxFys.CreateWithMetadata().
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// CreateWithMetadata creates (or truncates) the file at the given path
+// (relative to the mount point) just like creating it via the mount would, but
+// also has the given key/value metadata stored with the file when it gets
+// uploaded to the remote. You then write the file's content via the mount as
+// normal.
+//
+// The writeable remote must have CacheData enabled (and not StreamWrites), and
+// its Accessor must be a MetadataUploader (for S3, the keys become
+// "X-Amz-Meta-" headers); if not, the error wraps syscall.ENOSYS. The metadata
+// means the file is always uploaded whole (even if it is later opened for
+// appending), and is forgotten if the file is deleted before then.
+func (fs *MuxFys) CreateWithMetadata(name string, meta map[string]string) (err error) {
+	r := fs.writeRemote
+	if r == nil {
+		return &os.PathError{Op: "create", Path: name, Err: syscall.EPERM}
+	}
+	if !storesMetadata(r.accessor) || !r.cacheData || r.streamWrites {
+		return &os.PathError{Op: "create", Path: name, Err: syscall.ENOSYS}
+	}
+
+	path := strings.TrimPrefix(filepath.Clean("/"+name), "/")
+	file, status := fs.Create(path, uint32(os.O_WRONLY|os.O_CREATE|os.O_TRUNC), uint32(fileMode), nil)
+	if status != fuse.OK {
+		return fmt.Errorf("could not create %s: %s", name, status)
+	}
+
+	metadata := make(map[string]string, len(meta))
+	for key, val := range meta {
+		metadata[key] = val
+	}
+	r.setMetadata(r.getLocalPath(r.getRemotePath(path)), metadata)
+	file.Release()
+	return nil
+}
+
+// setMetadata records the metadata that should be stored with the remote file
+// when the given cache file is next uploaded.
+func (r *remote) setMetadata(localPath string, metadata map[string]string) {
+	r.metadataMutex.Lock()
+	defer r.metadataMutex.Unlock()
+	r.metadata[localPath] = metadata
+}
+
+// storesMetadata returns true if the given accessor is a MetadataUploader that
+// can currently store metadata.
+func storesMetadata(accessor RemoteAccessor) bool {
+	mu, ok := accessor.(MetadataUploader)
+	return ok && mu.StoresMetadata()
+}
+
+// uploadMetadata returns the metadata to upload the given cache file with, if
+// we have any and our accessor can store it. That includes the recording of
+// our UploadFileMode, if set.
+func (r *remote) uploadMetadata(localPath string) map[string]string {
+	if !storesMetadata(r.accessor) {
+		return nil
+	}
+	r.metadataMutex.Lock()
+	defer r.metadataMutex.Unlock()
+	metadata := r.metadata[localPath]
+	if modeMetadata := r.modeMetadata(); modeMetadata != nil {
+		for key, val := range metadata {
+			modeMetadata[key] = val
+		}
+		return modeMetadata
+	}
+	return metadata
+}
+
+// forgetMetadata forgets any metadata recorded for the given cache file.
+func (r *remote) forgetMetadata(localPath string) {
+	r.metadataMutex.Lock()
+	defer r.metadataMutex.Unlock()
+	delete(r.metadata, localPath)
+}
+
+// renameMetadata moves any recorded metadata from oldPath to newPath.
+func (r *remote) renameMetadata(oldPath, newPath string) {
+	r.metadataMutex.Lock()
+	defer r.metadataMutex.Unlock()
+	if metadata, ok := r.metadata[oldPath]; ok {
+		r.metadata[newPath] = metadata
+		delete(r.metadata, oldPath)
+	}
+}
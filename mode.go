@@ -0,0 +1,58 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+// This file contains the implementation of RemoteConfig.UploadFileMode.
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// modeMetadataKey is the user metadata key that we record the permissions of
+// uploaded files under when RemoteConfig.UploadFileMode is set, as octal.
+const modeMetadataKey = "mode"
+
+// createdFileMode returns the permissions that files we create should have.
+func (r *remote) createdFileMode() uint32 {
+	if r.uploadFileMode != 0 {
+		return uint32(r.uploadFileMode)
+	}
+	return uint32(fileMode)
+}
+
+// modeMetadata returns the user metadata that records our UploadFileMode, or
+// nil if that isn't set.
+func (r *remote) modeMetadata() map[string]string {
+	if r.uploadFileMode == 0 {
+		return nil
+	}
+	return map[string]string{modeMetadataKey: fmt.Sprintf("%04o", uint32(r.uploadFileMode))}
+}
+
+// recordedMode returns the permissions recorded in the given object's user
+// metadata (as when it was uploaded with an UploadFileMode), or our default
+// fileMode if none are recorded.
+func recordedMode(object RemoteAttr) uint32 {
+	if mode, err := strconv.ParseUint(object.Metadata[modeMetadataKey], 8, 32); err == nil && os.FileMode(mode)&^os.ModePerm == 0 && mode != 0 {
+		return uint32(mode)
+	}
+	return uint32(fileMode)
+}
@@ -19,7 +19,8 @@
 /*
 Package muxfys is a pure Go library that lets you in-process temporarily
 fuse-mount remote file systems or object stores as a "filey" system. Currently
-only support for S3-like systems has been implemented.
+support for S3-like systems, Backblaze B2, OpenStack Swift and (read-only) web
+servers has been implemented.
 
 It has high performance, and is easy to use with nothing else to install, and no
 root permissions needed (except to initially install/configure fuse: on old
@@ -120,15 +121,20 @@ RemoteConfig.
 package muxfys
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"os/signal"
 	"os/user"
+	"path"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -146,6 +152,18 @@ const (
 	fileMode    = 0600
 	dirSize     = uint64(4096)
 	symlinkSize = uint64(7)
+
+	// fuseConfPath is where fusermount reads its config from, which says if
+	// non-root users may mount with allow_other.
+	fuseConfPath = "/etc/fuse.conf"
+
+	// defaultKernelCacheTimeout is how long the kernel caches attributes and
+	// entries if the Config doesn't say otherwise.
+	defaultKernelCacheTimeout = time.Second
+
+	// defaultMountTimeout is how long Mount() waits for the mount to become
+	// ready if the Config doesn't say otherwise.
+	defaultMountTimeout = 30 * time.Second
 )
 
 var (
@@ -155,6 +173,14 @@ var (
 	deathSignals     = []os.Signal{os.Interrupt, syscall.SIGTERM}
 )
 
+// ErrDestroyed is returned by Mount(), Unmount() and Destroy() if the MuxFys
+// has been Destroy()ed.
+var ErrDestroyed = errors.New("muxfys has been destroyed")
+
+// ErrMountTimeout is returned by Mount() if the mount did not become ready
+// within the Config.MountTimeout.
+var ErrMountTimeout = errors.New("mount did not become ready in time")
+
 func init() {
 	pkgLogger.SetHandler(l15h.ChangeableHandler(logHandlerSetter))
 }
@@ -178,34 +204,305 @@ type Config struct {
 	// CacheDir undefined. Defaults to the current working directory.
 	CacheBase string
 
+	// CacheInTmpfs makes the cache directories described under CacheBase get
+	// created in a memory-backed tmpfs file system (such as /dev/shm) instead,
+	// for speed. A tmpfs is only used if it has at least CacheMaxBytes free and
+	// the system has at least that much memory available, so that filling the
+	// cache won't run the system out of memory. Otherwise CacheBase is used,
+	// with a warning logged. tmpfs is only detected on linux.
+	CacheInTmpfs bool
+
+	// CacheMaxBytes is the most data you expect the cache directories created
+	// in CacheBase (or tmpfs) to hold at once, which CacheInTmpfs checks there
+	// is room for. It is not otherwise enforced. The default of 0 means 1GB.
+	CacheMaxBytes int64
+
+	// LockDir, if set, is a writable directory (created if necessary) that the
+	// lock files used to coordinate access to cache files are created in,
+	// named after a hash of each cache file's path. Otherwise they're created
+	// alongside the cache files, which fails, or is undesirable, for caches on
+	// read-only or network file systems, such as a shared cache on read-only
+	// NFS.
+	LockDir string
+
 	// Verbose results in every remote request getting an entry in the output of
 	// Logs(). Errors always appear there.
 	Verbose bool
+
+	// FuseDebug turns on go-fuse's debug output, which details every request
+	// from the kernel and our response to it, and sends it to Logs() (and
+	// any SetLogHandler() handler) as debug level messages, interleaved with
+	// our own, instead of to STDERR. go-fuse writes this output with the
+	// standard library's log package, so while we're mounted, anything else
+	// logged that way also ends up in our logs.
+	FuseDebug bool
+
+	// MountOptions lets you override some of the options used to fuse mount.
+	// If not supplied, defaults to allowing other users access, with an FsName
+	// of "MuxFys" and the default MaxWrite. In that case, if 'user_allow_other'
+	// isn't set in /etc/fuse.conf, or mounting with access for other users
+	// fails, a warning is logged and the mount only allows you access.
+	MountOptions *MountOptions
+
+	// ReadOnly guarantees that nothing can be altered via the mount, regardless
+	// of the Write setting of your RemoteConfigs: every operation that would
+	// create, alter or delete a file or directory fails with EROFS.
+	ReadOnly bool
+
+	// EventHandler, if supplied, will be called with an Event every time a
+	// file is opened, created, deleted, renamed, downloaded or uploaded. It is
+	// called from a single goroutine, one Event at a time; if it is too slow to
+	// keep up, Events are dropped instead of slowing down the mount.
+	EventHandler func(Event)
+
+	// NegativeCacheTTL, if greater than 0, is how long to remember that a path
+	// doesn't exist, so that looking it up again reports it as not existing
+	// without consulting any remotes. This helps with tools (such as
+	// compilers searching for headers) that probe many non-existent paths.
+	// Creating a file or directory at such a path forgets that it didn't
+	// exist, as does unmounting. Paths are not remembered if their parent
+	// directory couldn't be listed. The default of 0 disables this.
+	NegativeCacheTTL time.Duration
+
+	// OpenRetry, if greater than 0, is how long to keep waiting for a file to
+	// appear when it is looked up or opened but isn't in its (known) parent
+	// directory. Its parent is listed again with backoff until the file
+	// appears or this time has passed. This helps pipelines where one process
+	// reads files that another just wrote to the remote, but makes every
+	// lookup of a genuinely non-existent file take this long, so consider
+	// also setting NegativeCacheTTL; paths remembered as not existing are not
+	// waited for. The default of 0 disables this.
+	OpenRetry time.Duration
+
+	// HealthCheckRemotes makes HealthCheck() also confirm that each remote is
+	// reachable, by listing its root directory.
+	HealthCheckRemotes bool
+
+	// CleanupStaleCaches makes New() delete the cache directories in CacheBase
+	// that were created for RemoteConfigs with CacheData true but CacheDir
+	// undefined, by processes on this host that are no longer running (eg.
+	// because they were killed before they could Unmount()). Such directories
+	// are otherwise left behind, filling up CacheBase over time.
+	CleanupStaleCaches bool
+
+	// PauseFailsFast makes transfers of file data attempted while Pause()d
+	// fail with EAGAIN, instead of waiting until Resume() is called. (Other
+	// remote calls always fail while paused; see Pause().)
+	PauseFailsFast bool
+
+	// ExposeMetadataXattrs enables read-only extended attributes on files in
+	// the mount: "user.s3.<key>" gives the value of each key of the file's
+	// RemoteAttr.Metadata (see Xattrs()), "user.s3.size" gives its size, and
+	// "user.s3.etag" its ETag (if known). This lets tools like getfattr read
+	// object metadata. The metadata of a file is retrieved from the remote the
+	// first time one of its attributes is requested, and again only if the
+	// file changes. Setting or removing these attributes fails with ENOTSUP.
+	ExposeMetadataXattrs bool
+
+	// AutoRemount makes Mount() start a watchdog that checks the mount point
+	// every 5 seconds. If the kernel has dropped our fuse connection, leaving
+	// a mount that only gives "Transport endpoint is not connected" errors,
+	// the dead mount is torn down and mounted again with the same remotes.
+	// Files you created or altered that haven't been uploaded yet are kept,
+	// but open file handles are lost. See Stats() for the number of times
+	// this happened.
+	AutoRemount bool
+
+	// AttrTimeout, EntryTimeout and NegativeTimeout are how long the kernel
+	// may cache, respectively, the attributes of files and directories, the
+	// existence of names in directories, and the non-existence of names,
+	// before asking us again. Longer timeouts mean fewer calls in to muxfys
+	// and so better performance, which suits remotes whose contents never
+	// change; shorter ones mean that changes to the remote (eg. seen after
+	// RefreshDir()) become visible sooner. The default of 0 means 1 second,
+	// while a negative value means no caching at all.
+	AttrTimeout     time.Duration
+	EntryTimeout    time.Duration
+	NegativeTimeout time.Duration
+
+	// UploadIncludeGlobs, if supplied, limits the files you created or
+	// altered that get uploaded by Unmount() to those matching at least one of
+	// these glob patterns (in the syntax of path.Match()). Patterns without a
+	// "/" are matched against file names, while those with a "/" are matched
+	// against the whole path relative to the mount point. Files that aren't
+	// uploaded are discarded, as if they had never been written. This lets
+	// you keep scratch files local-only.
+	UploadIncludeGlobs []string
+
+	// UploadExcludeGlobs is like UploadIncludeGlobs, but prevents the upload
+	// of files that match any of these patterns. It applies after
+	// UploadIncludeGlobs.
+	UploadExcludeGlobs []string
+
+	// ContentTypeFunc, if set, is consulted for the content type to record
+	// for each file uploaded from the cache, being given the file's path in
+	// the remote. This lets you handle types that depend on multi-part
+	// extensions (eg. ".g.vcf.gz") or where a file is. If it returns "", the
+	// type is sniffed from the start of the file's content, as it is when
+	// ContentTypeFunc is not set.
+	ContentTypeFunc func(path string) string
+
+	// AllowRecursiveRmdir makes removing a non-empty directory (eg. with
+	// rm -rf) delete everything in it from the writeable remote, instead of
+	// failing with ENOSYS. All the objects under the directory's prefix are
+	// deleted, along with any cached or created files in it.
+	AllowRecursiveRmdir bool
+
+	// SlowThreshold, if greater than 0, results in every remote request that
+	// takes longer than this getting a warning in the output of Logs(), even
+	// if Verbose is off. This helps you find out what caused a stall without
+	// the noise of logging every request.
+	SlowThreshold time.Duration
+
+	// MountPrefix, if supplied, is a directory relative to the mount point
+	// that the whole combined tree of all your remotes appears in, eg. "work".
+	// The rest of the mount is empty apart from the directories in this path.
+	// Each remote's RemoteConfig.MountSubpath is then relative to this
+	// directory. Paths you supply to methods like ReadAt() remain relative to
+	// the mount point itself, so would start with this prefix.
+	MountPrefix string
+
+	// MountTimeout is how long Mount() waits for the mount to be ready to
+	// serve requests. If it isn't ready in time, the mount is abandoned and
+	// Mount() returns ErrMountTimeout, so a hung mount doesn't block forever.
+	// The default of 0 means 30 seconds, while a negative value means waiting
+	// as long as it takes.
+	MountTimeout time.Duration
+
+	// ReadBlockSize is the size in bytes of the blocks that reads of remote
+	// files are made in when caching data: a read of any part of a block that
+	// isn't yet cached fetches the whole block. It is also the preferred IO
+	// size reported by StatFs. Larger blocks amortise round trips to high
+	// latency remotes, while smaller ones waste less when reading small parts
+	// of files. It must be a power of 2 between 4KB and 1GB. The default of 0
+	// means 1MB.
+	ReadBlockSize int
+}
+
+// MountOptions struct describes the fuse mount options you are allowed to
+// change. Supply one of these to Config.MountOptions. Unlike when MountOptions
+// is not supplied at all, the zero value of a MountOptions does not allow
+// other users access.
+type MountOptions struct {
+	// AllowOther lets users other than yourself (including root) access the
+	// mount. This requires 'user_allow_other' to be set in /etc/fuse.conf or
+	// equivalent.
+	AllowOther bool
+
+	// FsName is shown in the first column of eg. `df` output. Defaults to
+	// "MuxFys".
+	FsName string
+
+	// MaxWrite is the maximum size in bytes of individual write requests. The
+	// default of 0 uses go-fuse's default; it can't be negative or more than
+	// 128KB.
+	MaxWrite int
+
+	// Options are passed as -o options to fusermount (or osxfuse's equivalent),
+	// eg. "volname=myvolume" on macOS or "max_read=131072" on Linux. Don't
+	// include "allow_other" here; use AllowOther instead.
+	Options []string
+}
+
+// fuseMountOptions converts our MountOptions to the fuse.MountOptions that
+// will be used to mount, returning an error if the options are invalid. Works
+// on a nil MountOptions, returning our defaults.
+func (mo *MountOptions) fuseMountOptions() (*fuse.MountOptions, error) {
+	fmo := &fuse.MountOptions{
+		AllowOther:           true,
+		FsName:               "MuxFys",
+		Name:                 "MuxFys",
+		RememberInodes:       true,
+		DisableXAttrs:        true,
+		IgnoreSecurityLabels: true,
+		Debug:                false,
+	}
+	if mo == nil {
+		return fmo, nil
+	}
+
+	if mo.MaxWrite < 0 || mo.MaxWrite > fuse.MAX_KERNEL_WRITE {
+		return nil, fmt.Errorf("MountOptions MaxWrite must be between 0 and %d", fuse.MAX_KERNEL_WRITE)
+	}
+	for _, opt := range mo.Options {
+		if opt == "" || strings.Contains(opt, ",") {
+			return nil, fmt.Errorf("MountOptions Options must be non-empty and not contain commas: [%s]", opt)
+		}
+		if opt == "allow_other" {
+			return nil, fmt.Errorf("MountOptions Options must not include allow_other; set AllowOther instead")
+		}
+	}
+
+	fmo.AllowOther = mo.AllowOther
+	if mo.FsName != "" {
+		fmo.FsName = mo.FsName
+	}
+	fmo.MaxWrite = mo.MaxWrite
+	fmo.Options = append(fmo.Options, mo.Options...)
+	return fmo, nil
 }
 
 // MuxFys struct is the main filey system object.
 type MuxFys struct {
 	pathfs.FileSystem
-	mountPoint      string
-	cacheBase       string
-	dirAttr         *fuse.Attr
-	server          *fuse.Server
-	mutex           sync.Mutex
-	mapMutex        sync.RWMutex
-	dirs            map[string][]*remote
-	dirContents     map[string][]fuse.DirEntry
-	files           map[string]*fuse.Attr
-	fileToRemote    map[string]*remote
-	createdFiles    map[string]bool
-	createdDirs     map[string]bool
-	mounted         bool
-	handlingSignals bool
-	deathSignals    chan os.Signal
-	ignoreSignals   chan bool
-	remotes         []*remote
-	writeRemote     *remote
-	maxAttempts     int
-	logStore        *l15h.Store
+	mountPoint         string
+	cacheBase          string
+	lockDir            string
+	mountOpts          *MountOptions
+	readOnly           bool
+	dirAttr            *fuse.Attr
+	server             *fuse.Server
+	mutex              sync.Mutex
+	mapMutex           sync.RWMutex
+	dirs               map[string][]*remote
+	dirContents        map[string][]fuse.DirEntry
+	files              map[string]*fuse.Attr
+	fileToRemote       map[string]*remote
+	createdFiles       map[string]bool
+	createdDirs        map[string]bool
+	subpathDirs        map[string]bool
+	renamedFrom        map[string][]string
+	uploading          map[string]chan struct{}
+	negativeCache      map[string]time.Time
+	negativeCacheTTL   time.Duration
+	openRetry          time.Duration
+	healthCheckRemotes bool
+	pauseFailsFast     bool
+	autoRemount        bool
+	exposeXattrs       bool
+	fuseDebug          bool
+	fuseDebugging      bool
+	xattrs             map[string]*fileXattrs
+	xattrsMutex        sync.Mutex
+	remountInterval    time.Duration
+	attrTimeout        time.Duration
+	entryTimeout       time.Duration
+	negativeTimeout    time.Duration
+	uploadInclude      []string
+	uploadExclude      []string
+	recursiveRmdir     bool
+	slowThreshold      time.Duration
+	readBlockSize      int64
+	contentTypeFunc    func(path string) string
+	eagerSem           chan struct{}
+	eagerWG            sync.WaitGroup
+	mountPrefix        string
+	mountTimeout       time.Duration
+	stopWatchdog       chan bool
+	remounts           int
+	handles            map[*openHandle]bool
+	handlesMutex       sync.Mutex
+	mounted            bool
+	unmounted          chan struct{}
+	destroyed          bool
+	handlingSignals    bool
+	deathSignals       chan os.Signal
+	ignoreSignals      chan bool
+	remotes            []*remote
+	writeRemote        *remote
+	maxAttempts        int
+	logStore           *l15h.Store
+	events             *eventQueue
 	log15.Logger
 }
 
@@ -214,6 +511,30 @@ type MuxFys struct {
 // then Unmount() when you're done. You might check Logs() afterwards. The other
 // methods of MuxFys can be ignored in most cases.
 func New(config *Config) (*MuxFys, error) {
+	if config.NegativeCacheTTL < 0 {
+		return nil, fmt.Errorf("NegativeCacheTTL can't be negative")
+	}
+	if config.OpenRetry < 0 {
+		return nil, fmt.Errorf("OpenRetry can't be negative")
+	}
+	if config.CacheMaxBytes < 0 {
+		return nil, fmt.Errorf("CacheMaxBytes can't be negative")
+	}
+
+	readBlockSize := int64(config.ReadBlockSize)
+	if readBlockSize == 0 {
+		readBlockSize = defaultReadBlockSize
+	}
+	if readBlockSize < minReadBlockSize || readBlockSize > maxReadBlockSize || readBlockSize&(readBlockSize-1) != 0 {
+		return nil, fmt.Errorf("ReadBlockSize must be a power of 2 between 4KB and 1GB")
+	}
+
+	for _, glob := range append(append([]string{}, config.UploadIncludeGlobs...), config.UploadExcludeGlobs...) {
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("bad glob pattern [%s]: %s", glob, err)
+		}
+	}
+
 	mountPoint := config.Mount
 	if mountPoint == "" {
 		mountPoint = "mnt"
@@ -259,22 +580,94 @@ func New(config *Config) (*MuxFys, error) {
 	if config.Verbose {
 		logLevel = log15.LvlInfo
 	}
-	l15h.AddHandler(logger, log15.LvlFilterHandler(logLevel, l15h.CallerInfoHandler(l15h.StoreHandler(store, log15.LogfmtFormat()))))
+	filter := func(rec *log15.Record) bool {
+		// slow calls are always of interest, regardless of Verbose
+		return rec.Lvl <= logLevel || rec.Msg == slowCallMsg || (config.FuseDebug && rec.Msg == fuseDebugMsg)
+	}
+	l15h.AddHandler(logger, log15.FilterHandler(filter, l15h.CallerInfoHandler(l15h.StoreHandler(store, log15.LogfmtFormat()))))
+
+	if config.CacheInTmpfs {
+		cacheBase = tmpfsCacheBase(cacheBase, config.CacheMaxBytes, logger)
+	}
+
+	lockDir := config.LockDir
+	if lockDir != "" {
+		lockDir, err = homedir.Expand(lockDir)
+		if err != nil {
+			return nil, err
+		}
+		lockDir, err = filepath.Abs(lockDir)
+		if err != nil {
+			return nil, err
+		}
+		err = os.MkdirAll(lockDir, os.FileMode(dirMode))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	mountTimeout := config.MountTimeout
+	if mountTimeout == 0 {
+		mountTimeout = defaultMountTimeout
+	}
 
 	// initialize ourselves
 	fs := &MuxFys{
-		FileSystem:   pathfs.NewDefaultFileSystem(),
-		mountPoint:   mountPoint,
-		cacheBase:    cacheBase,
-		dirs:         make(map[string][]*remote),
-		dirContents:  make(map[string][]fuse.DirEntry),
-		files:        make(map[string]*fuse.Attr),
-		fileToRemote: make(map[string]*remote),
-		createdFiles: make(map[string]bool),
-		createdDirs:  make(map[string]bool),
-		maxAttempts:  config.Retries + 1,
-		logStore:     store,
-		Logger:       logger,
+		FileSystem:         pathfs.NewDefaultFileSystem(),
+		mountPoint:         mountPoint,
+		cacheBase:          cacheBase,
+		lockDir:            lockDir,
+		mountOpts:          config.MountOptions,
+		readOnly:           config.ReadOnly,
+		dirs:               make(map[string][]*remote),
+		dirContents:        make(map[string][]fuse.DirEntry),
+		files:              make(map[string]*fuse.Attr),
+		fileToRemote:       make(map[string]*remote),
+		createdFiles:       make(map[string]bool),
+		createdDirs:        make(map[string]bool),
+		subpathDirs:        make(map[string]bool),
+		renamedFrom:        make(map[string][]string),
+		uploading:          make(map[string]chan struct{}),
+		negativeCache:      make(map[string]time.Time),
+		negativeCacheTTL:   config.NegativeCacheTTL,
+		openRetry:          config.OpenRetry,
+		healthCheckRemotes: config.HealthCheckRemotes,
+		pauseFailsFast:     config.PauseFailsFast,
+		autoRemount:        config.AutoRemount,
+		exposeXattrs:       config.ExposeMetadataXattrs,
+		fuseDebug:          config.FuseDebug,
+		xattrs:             make(map[string]*fileXattrs),
+		remountInterval:    defaultRemountInterval,
+		attrTimeout:        kernelCacheTimeout(config.AttrTimeout),
+		entryTimeout:       kernelCacheTimeout(config.EntryTimeout),
+		negativeTimeout:    kernelCacheTimeout(config.NegativeTimeout),
+		uploadInclude:      config.UploadIncludeGlobs,
+		uploadExclude:      config.UploadExcludeGlobs,
+		recursiveRmdir:     config.AllowRecursiveRmdir,
+		slowThreshold:      config.SlowThreshold,
+		readBlockSize:      readBlockSize,
+		contentTypeFunc:    config.ContentTypeFunc,
+		eagerSem:           make(chan struct{}, eagerCacheWorkers),
+		mountPrefix:        strings.TrimPrefix(path.Clean("/"+config.MountPrefix), "/"),
+		mountTimeout:       mountTimeout,
+		handles:            make(map[*openHandle]bool),
+		maxAttempts:        config.Retries + 1,
+		logStore:           store,
+		Logger:             logger,
+	}
+
+	if config.EventHandler != nil {
+		fs.startEvents(config.EventHandler)
+	}
+
+	if config.CleanupStaleCaches {
+		removed, errc := cleanupStaleCaches(cacheBase)
+		for _, dir := range removed {
+			fs.Info("Deleted stale cache", "dir", dir)
+		}
+		if errc != nil {
+			fs.Warn("Stale cache cleanup failed", "err", errc)
+		}
 	}
 
 	// we'll always use the same attributes for our directories
@@ -290,18 +683,37 @@ func New(config *Config) (*MuxFys, error) {
 	return fs, err
 }
 
+// kernelCacheTimeout converts one of the timeouts in a Config to the timeout
+// we tell fuse to use.
+func kernelCacheTimeout(timeout time.Duration) time.Duration {
+	switch {
+	case timeout == 0:
+		return defaultKernelCacheTimeout
+	case timeout < 0:
+		return 0
+	}
+	return timeout
+}
+
 // Mount carries out the mounting of your supplied RemoteConfigs to your
-// configured mount point. On return, the files in your remote(s) will be
-// accessible.
+// configured mount point. It waits until the mount is actually serving
+// requests (or Config.MountTimeout passes), so on successful return the files
+// in your remote(s) will be accessible.
 //
 // Once mounted, you can't mount again until you Unmount().
 //
+// If one of your RemoteConfigs has Write enabled, Mount first checks that its
+// remote can really be written to (see RemoteConfig.SkipWriteCheck), returning
+// an error if not.
+//
 // If more than 1 RemoteConfig is supplied, the remotes will become multiplexed:
 // your mount point will show the combined contents of all your remote systems.
 // If multiple remotes have a directory with the same name, that directory's
 // contents will in in turn show the contents of all those directories. If
 // multiple remotes have a file with the same name in the same directory, reads
-// will come from the first remote you configured that has that file.
+// will come from the first remote you configured that has that file. Use
+// RemoteConfig.MountSubpath to have a remote's contents appear in a particular
+// directory of the mount instead of at the mount point itself.
 func (fs *MuxFys) Mount(rcs ...*RemoteConfig) error {
 	if len(rcs) == 0 {
 		return fmt.Errorf("at least one RemoteConfig must be supplied")
@@ -309,66 +721,209 @@ func (fs *MuxFys) Mount(rcs ...*RemoteConfig) error {
 
 	fs.mutex.Lock()
 	defer fs.mutex.Unlock()
+	if fs.destroyed {
+		return ErrDestroyed
+	}
 	if fs.mounted {
 		return fmt.Errorf("can't mount more that once at a time")
 	}
 
+	// check our mount options are valid before creating any remotes
+	_, err := fs.mountOpts.fuseMountOptions()
+	if err != nil {
+		return err
+	}
+
 	// create a remote for every RemoteConfig
+	var checkWrite bool
 	for _, c := range rcs {
-		r, err := newRemote(c.Accessor, c.CacheData, c.CacheDir, fs.cacheBase, c.Write, fs.maxAttempts, fs.Logger)
+		r, err := fs.createRemote(c)
 		if err != nil {
+			fs.forgetRemotes()
 			return err
 		}
 
 		fs.remotes = append(fs.remotes, r)
 		if r.write {
 			if fs.writeRemote != nil {
+				fs.forgetRemotes()
 				return fmt.Errorf("you can't have more than one writeable remote")
 			}
 			fs.writeRemote = r
+			checkWrite = !c.SkipWriteCheck
+		}
+	}
+
+	// fail now if we won't be able to upload anything
+	if checkWrite {
+		if err = fs.writeRemote.checkWriteable(); err != nil {
+			fs.forgetRemotes()
+			return err
+		}
+	}
+
+	err = fs.serve()
+	if err != nil {
+		fs.forgetRemotes()
+		return err
+	}
+
+	fs.mounted = true
+	fs.unmounted = make(chan struct{})
+	if fs.autoRemount {
+		fs.stopWatchdog = make(chan bool)
+		go fs.watchMount(fs.stopWatchdog)
+	}
+	return err
+}
+
+// forgetRemotes deletes the caches we created for our remotes and forgets
+// them, so that a failed Mount() can be tried again.
+func (fs *MuxFys) forgetRemotes() {
+	for _, r := range fs.remotes {
+		if r.cacheIsTmp {
+			if errd := r.deleteCache(); errd != nil {
+				r.Warn("Cache deletion failed", "err", errd)
+			}
+		}
+		releaseS3Client(r.clientKey)
+	}
+	fs.remotes = nil
+	fs.writeRemote = nil
+}
+
+// createRemote creates a remote for the given RemoteConfig, set up to be one of
+// our remotes, with its MountSubpath placed within our MountPrefix.
+func (fs *MuxFys) createRemote(c *RemoteConfig) (*remote, error) {
+	r, err := newRemote(c, fs.cacheBase, fs.maxAttempts, fs.Logger)
+	if err != nil {
+		return nil, err
+	}
+	r.emit = fs.emit
+	r.slowThreshold = fs.slowThreshold
+	r.readBlockSize = fs.readBlockSize
+	r.contentTypeFunc = fs.contentTypeFunc
+	r.mountSubpath = path.Join(fs.mountPrefix, r.mountSubpath)
+	if c.SeedCacheFrom != "" {
+		if err = r.seedCache(c.SeedCacheFrom); err != nil {
+			return nil, err
 		}
 	}
 
+	// keep any shared client alive while we're mounted, even if the user
+	// Close()s the S3Accessor
+	if a, ok := c.Accessor.(*S3Accessor); ok {
+		r.clientKey = a.clientKey
+		retainS3Client(r.clientKey)
+	}
+	return r, nil
+}
+
+// serve creates a fuse server for ourselves on our mount point, and starts
+// serving requests, returning once the mount is ready.
+func (fs *MuxFys) serve() error {
+	mOpts, err := fs.mountOpts.fuseMountOptions()
+	if err != nil {
+		return err
+	}
+	if fs.exposeXattrs {
+		mOpts.DisableXAttrs = false
+	}
+	mOpts.Debug = fs.fuseDebug
+
 	uid, gid, err := userAndGroup()
 	if err != nil {
 		return err
 	}
 
 	opts := &nodefs.Options{
-		NegativeTimeout: time.Second,
-		AttrTimeout:     time.Second,
-		EntryTimeout:    time.Second,
+		NegativeTimeout: fs.negativeTimeout,
+		AttrTimeout:     fs.attrTimeout,
+		EntryTimeout:    fs.entryTimeout,
 		Owner: &fuse.Owner{
 			Uid: uid,
 			Gid: gid,
 		},
-		Debug: false,
+		Debug: fs.fuseDebug,
 	}
-	pathFsOpts := &pathfs.PathNodeFsOptions{ClientInodes: false} // false means we can't hardlink, but our inodes are stable *** does it matter if they're unstable?
+	// (we need ClientInodes for pathfs to pass on Link() calls, but since we
+	// never set Ino in our attrs, our inodes are otherwise unaffected)
+	pathFsOpts := &pathfs.PathNodeFsOptions{ClientInodes: true}
 	pathFs := pathfs.NewPathNodeFs(fs, pathFsOpts)
 	conn := nodefs.NewFileSystemConnector(pathFs.Root(), opts)
-	mOpts := &fuse.MountOptions{
-		AllowOther:           true,
-		FsName:               "MuxFys",
-		Name:                 "MuxFys",
-		RememberInodes:       true,
-		DisableXAttrs:        true,
-		IgnoreSecurityLabels: true,
-		Debug:                false,
+	defaultAllowOther := fs.mountOpts == nil && mOpts.AllowOther
+	if defaultAllowOther && !allowOtherPermitted(fuseConfPath) {
+		fs.Warn("Mounting without allow_other, since it isn't permitted", "conf", fuseConfPath)
+		mOpts.AllowOther = false
 	}
+	fs.startFuseDebug()
 	fs.server, err = fuse.NewServer(conn.RawFS(), fs.mountPoint, mOpts)
+	if err != nil && mOpts.AllowOther && defaultAllowOther && strings.Contains(err.Error(), "fusermount exited") {
+		fs.Warn("Mount with allow_other failed, trying without it", "err", err)
+		mOpts.AllowOther = false
+		fs.server, err = fuse.NewServer(conn.RawFS(), fs.mountPoint, mOpts)
+	}
 	if err != nil {
+		fs.stopFuseDebug()
 		return err
 	}
 
 	go fs.server.Serve()
-	err = fs.server.WaitMount()
+	err = waitWithTimeout(fs.server.WaitMount, fs.mountTimeout)
+	if err == ErrMountTimeout {
+		fs.Error("Mount did not become ready", "timeout", fs.mountTimeout)
+		if erru := fs.server.Unmount(); erru != nil {
+			fs.Error("Unmount of abandoned mount failed", "err", erru)
+		}
+	}
 	if err != nil {
+		fs.stopFuseDebug()
+	}
+	return err
+}
+
+// waitWithTimeout calls wait and returns its error, unless it takes longer
+// than timeout to return, in which case ErrMountTimeout is returned instead.
+// A negative timeout means waiting for as long as it takes.
+func waitWithTimeout(wait func() error, timeout time.Duration) error {
+	if timeout < 0 {
+		return wait()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- wait()
+	}()
+	select {
+	case err := <-done:
 		return err
+	case <-time.After(timeout):
+		return ErrMountTimeout
 	}
+}
 
-	fs.mounted = true
-	return err
+// allowOtherPermitted tells you if a fuse mount by us may use the allow_other
+// option, according to the fuse config file at the given path. It is always
+// permitted for root, and on systems other than linux.
+func allowOtherPermitted(confPath string) bool {
+	if runtime.GOOS != "linux" || os.Geteuid() == 0 {
+		return true
+	}
+	return fuseConfAllowsOther(confPath)
+}
+
+// fuseConfAllowsOther tells you if the fuse config file at the given path sets
+// 'user_allow_other'.
+func fuseConfAllowsOther(confPath string) bool {
+	content, err := ioutil.ReadFile(confPath)
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.TrimSpace(line) == "user_allow_other" {
+			return true
+		}
+	}
+	return false
 }
 
 // userAndGroup returns the current uid and gid; we only ever mount with dir and
@@ -445,25 +1000,84 @@ func (fs *MuxFys) UnmountOnDeath() {
 // If a remote was not configured with a specific CacheDir but CacheData was
 // true, the CacheDir will be deleted.
 func (fs *MuxFys) Unmount(doNotUpload ...bool) error {
+	return fs.UnmountContext(context.Background(), doNotUpload...)
+}
+
+// UploadsPendingError is returned by UnmountContext() when its context was
+// done before all the files you created or altered could be uploaded.
+type UploadsPendingError struct {
+	// Err is the context's error, eg. context.DeadlineExceeded.
+	Err error
+
+	// Pending maps the paths (relative to the mount point) of the files that
+	// didn't get uploaded to the cache files that still hold their data.
+	Pending map[string]string
+}
+
+// Error lists the files that didn't get uploaded.
+func (e *UploadsPendingError) Error() string {
+	names := make([]string, 0, len(e.Pending))
+	for name := range e.Pending {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Sprintf("%s before %d files were uploaded: %s", e.Err, len(names), strings.Join(names, ", "))
+}
+
+// Unwrap returns the context's error, so you can test for it with errors.Is().
+func (e *UploadsPendingError) Unwrap() error {
+	return e.Err
+}
+
+// UnmountContext is like Unmount(), but bounds the time spent uploading the
+// files you created or altered with the given context. Once the context is
+// done, no more uploads are started and any in-progress upload is aborted
+// (if its remote accessor supports that for the way the file is being
+// uploaded; accessors that are neither ResumableUploaders nor ReaderUploaders
+// have files streamed to them instead, so their content type isn't
+// recorded). The unmount still completes, but an
+// *UploadsPendingError is returned, telling you which files weren't uploaded.
+// Their cache files are not deleted, even if the CacheDir would have been.
+func (fs *MuxFys) UnmountContext(ctx context.Context, doNotUpload ...bool) error {
 	fs.mutex.Lock()
 	defer fs.mutex.Unlock()
+	if fs.destroyed {
+		return ErrDestroyed
+	}
 
 	if fs.handlingSignals {
 		fs.ignoreSignals <- true
 	}
 
+	if fs.stopWatchdog != nil {
+		close(fs.stopWatchdog)
+		fs.stopWatchdog = nil
+	}
+
+	// don't let fuse operations be blocked by a Pause()
+	for _, r := range fs.remotes {
+		r.gate.resume()
+	}
+
+	// let any EagerCacheBelow downloads finish before we delete caches
+	fs.eagerWG.Wait()
+
 	var err error
+	wasMounted := fs.mounted
 	if fs.mounted {
 		err = fs.server.Unmount()
 		if err == nil {
 			fs.mounted = false
+			fs.stopFuseDebug()
 		}
 		// <-time.After(10 * time.Second)
 	}
 
+	var pending map[string]string
 	if !(len(doNotUpload) == 1 && doNotUpload[0]) {
 		// upload files that got opened for writing
-		uerr := fs.uploadCreated()
+		var uerr error
+		pending, uerr = fs.uploadCreatedContext(ctx, false)
 		if uerr != nil {
 			if err == nil {
 				err = uerr
@@ -473,8 +1087,13 @@ func (fs *MuxFys) Unmount(doNotUpload ...bool) error {
 		}
 	}
 
-	// delete any cachedirs we created
+	// delete any cachedirs we created, unless they hold data we couldn't
+	// upload
 	for _, remote := range fs.remotes {
+		if remote == fs.writeRemote && len(pending) > 0 {
+			remote.Warn("Not deleting cache holding files that weren't uploaded", "dir", remote.cacheDir)
+			continue
+		}
 		if remote.cacheIsTmp {
 			errd := remote.deleteCache()
 			if errd != nil {
@@ -496,20 +1115,413 @@ func (fs *MuxFys) Unmount(doNotUpload ...bool) error {
 	fs.fileToRemote = make(map[string]*remote)
 	fs.createdFiles = make(map[string]bool)
 	fs.createdDirs = make(map[string]bool)
+	fs.subpathDirs = make(map[string]bool)
+	fs.renamedFrom = make(map[string][]string)
+	fs.negativeCache = make(map[string]time.Time)
 	fs.mapMutex.Unlock()
-
-	// forget our remotes so we can be remounted with other remotes
+	fs.xattrsMutex.Lock()
+	fs.xattrs = make(map[string]*fileXattrs)
+	fs.xattrsMutex.Unlock()
+
+	// forget our remotes so we can be remounted with other remotes, letting go
+	// of any shared clients they were using
+	for _, r := range fs.remotes {
+		releaseS3Client(r.clientKey)
+	}
 	fs.remotes = nil
 	fs.writeRemote = nil
 
+	if wasMounted && !fs.mounted {
+		close(fs.unmounted)
+		fs.unmounted = nil
+	}
+
+	if len(pending) > 0 {
+		if err != nil {
+			fs.Error("Unmount failed", "err", err)
+		}
+		return &UploadsPendingError{Err: ctx.Err(), Pending: pending}
+	}
 	return err
 }
 
+// Mounted tells you if we are currently mounted, ie. Mount() has succeeded and
+// Unmount() has not yet unmounted.
+func (fs *MuxFys) Mounted() bool {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	return fs.mounted
+}
+
+// Commit uploads the files you created or altered since mounting (or since the
+// last Commit()), like Unmount() would, but leaves you mounted with your cache
+// intact, so you can persist the outputs of one phase of work before starting
+// the next. Files that currently have a handle open are not uploaded, since
+// they may still be written to; they will be uploaded by a later Commit() or
+// Unmount(), as will files altered while Commit() was uploading them. The mount
+// remains usable while the uploads are in progress. Only does anything if your writeable remote has CacheData
+// configured (otherwise files were already uploaded as they were written). If
+// any uploads failed because the remote couldn't be reached, the error matches
+// ErrRemoteUnavailable with errors.Is().
+func (fs *MuxFys) Commit() error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	if fs.destroyed {
+		return ErrDestroyed
+	}
+	_, err := fs.uploadCreatedContext(context.Background(), true)
+	return err
+}
+
+// WaitUnmounted returns a channel that is closed when Unmount() (or Destroy())
+// completes, having unmounted the current mount, including uploading any files
+// you created or altered. Receive from it to block until teardown is over. If
+// we're not mounted, the returned channel is already closed.
+func (fs *MuxFys) WaitUnmounted() <-chan struct{} {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	if fs.unmounted == nil {
+		closed := make(chan struct{})
+		close(closed)
+		return closed
+	}
+	return fs.unmounted
+}
+
+// RefreshDir lists the directory at the given path (relative to the mount
+// point) again, so that changes made to it remotely since it was last listed,
+// such as new files, become visible. Unlike remounting, nothing else we know
+// about is forgotten, and files you created in the directory that haven't been
+// uploaded yet remain.
+//
+// It returns an error if path isn't a directory we know about, or if a remote
+// couldn't list it.
+func (fs *MuxFys) RefreshDir(path string) error {
+	name := strings.TrimPrefix(filepath.Clean("/"+path), "/")
+
+	fs.mapMutex.Lock()
+	defer fs.mapMutex.Unlock()
+	remotes, exists := fs.dirs[name]
+	if !exists {
+		return &Error{Kind: ErrNotFound, Err: fmt.Errorf("%s is not a known directory", path)}
+	}
+
+	// a new file may be one we previously found not to exist
+	for missing := range fs.negativeCache {
+		parent := filepath.Dir(missing)
+		if parent == "." {
+			parent = ""
+		}
+		if parent == name {
+			delete(fs.negativeCache, missing)
+		}
+	}
+
+	if _, listed := fs.dirContents[name]; !listed {
+		// it will be listed afresh when next opened anyway
+		return nil
+	}
+
+	var failures []string
+	var failed fuse.Status
+	for i, status := range fs.openDirs(remotes, name) {
+		if status != fuse.OK && status != fuse.ENOENT {
+			failures = append(failures, fmt.Sprintf("%s (%s)", remotes[i].accessor.Target(), status))
+			failed = status
+		}
+	}
+	fs.addSubpathEntries(name)
+	if len(failures) > 0 {
+		return &Error{Kind: statusKind(failed), Err: fmt.Errorf("could not list %s in %s", path, strings.Join(failures, ", "))}
+	}
+	return nil
+}
+
+// Xattrs returns the user metadata of the remote object behind the file at the
+// given path (relative to the mount point), such as the values of S3
+// "x-amz-meta-*" headers, keyed on lower-cased names without that prefix. The
+// metadata is retrieved from the remote each time you call this.
+//
+// Files created via the mount that haven't been uploaded yet, and files from
+// remotes whose Accessor isn't a FileStater, have no metadata. It returns an
+// error if there is no file at path.
+func (fs *MuxFys) Xattrs(path string) (map[string]string, error) {
+	name := strings.TrimPrefix(filepath.Clean("/"+path), "/")
+	attr, status := fs.GetAttr(name, nil)
+	if status != fuse.OK {
+		return nil, fmt.Errorf("could not stat %s: %w", path, statusErr(status))
+	}
+	if !attr.IsRegular() {
+		return nil, fmt.Errorf("%s is not a file", path)
+	}
+	_, r, status := fs.fileDetails(name, false)
+	if status != fuse.OK {
+		return nil, fmt.Errorf("could not stat %s: %w", path, statusErr(status))
+	}
+
+	fs.mapMutex.RLock()
+	created := fs.createdFiles[name]
+	fs.mapMutex.RUnlock()
+	metadata := make(map[string]string)
+	if created {
+		return metadata, nil
+	}
+
+	ra, _, status := r.statFile(r.getRemotePath(name))
+	if status != fuse.OK {
+		return nil, fmt.Errorf("could not get the metadata of %s: %w", path, statusErr(status))
+	}
+	for key, val := range ra.Metadata {
+		metadata[key] = val
+	}
+	return metadata, nil
+}
+
+// SetObjectTags replaces the tags of the remote file at the given path
+// (relative to the mount point) with the given ones. The file's remote must
+// have an Accessor that is a TaggingAccessor (as S3Accessor is). Files you
+// created or altered that haven't been uploaded yet can't be tagged this way;
+// see RemoteConfig.ObjectTags instead.
+func (fs *MuxFys) SetObjectTags(path string, tags map[string]string) error {
+	name := strings.TrimPrefix(filepath.Clean("/"+path), "/")
+	attr, status := fs.GetAttr(name, nil)
+	if status != fuse.OK {
+		return fmt.Errorf("could not stat %s: %w", path, statusErr(status))
+	}
+	if !attr.IsRegular() {
+		return fmt.Errorf("%s is not a file", path)
+	}
+	_, r, status := fs.fileDetails(name, false)
+	if status != fuse.OK {
+		return fmt.Errorf("could not stat %s: %w", path, statusErr(status))
+	}
+
+	fs.mapMutex.RLock()
+	created := fs.createdFiles[name]
+	fs.mapMutex.RUnlock()
+	if created {
+		return fmt.Errorf("%s has not been uploaded yet", path)
+	}
+	if r.tagger == nil {
+		return fmt.Errorf("the remote of %s does not support tags", path)
+	}
+
+	status = r.setTags(r.getRemotePath(name), tags)
+	if status != fuse.OK {
+		return fmt.Errorf("could not tag %s: %w", path, statusErr(status))
+	}
+	return nil
+}
+
+// IsCached tells you if the whole of the file at the given path (relative to
+// the mount point) is currently in the local cache, along with how many of its
+// bytes are cached. This is useful if you want to prefer running work where its
+// inputs have already been cached. It returns an error if the path is not a
+// known file (errors.Is(err, ErrNotFound)), or if its remote does not have
+// CacheData enabled (errors.Is(err, ErrNotCached)).
+func (fs *MuxFys) IsCached(path string) (bool, int64, error) {
+	name := strings.TrimPrefix(filepath.Clean("/"+path), "/")
+	attr, status := fs.GetAttr(name, nil)
+	if status != fuse.OK {
+		return false, 0, fmt.Errorf("could not stat %s: %w", path, statusErr(status))
+	}
+	if !attr.IsRegular() {
+		return false, 0, fmt.Errorf("%s is not a file", path)
+	}
+	_, r, status := fs.fileDetails(name, false)
+	if status != fuse.OK {
+		return false, 0, fmt.Errorf("could not stat %s: %w", path, statusErr(status))
+	}
+	if !r.cacheData {
+		return false, 0, &Error{Kind: ErrNotCached, Err: fmt.Errorf("the remote of %s does not cache data", path)}
+	}
+
+	size := int64(attr.Size)
+	fs.mapMutex.RLock()
+	created := fs.createdFiles[name]
+	fs.mapMutex.RUnlock()
+	if created {
+		// files we created only exist in our cache until uploaded
+		return true, size, nil
+	}
+
+	cached := size
+	for _, iv := range r.Uncached(r.getLocalPath(r.getRemotePath(name)), NewInterval(0, size)) {
+		cached -= iv.Length()
+	}
+	return cached == size, cached, nil
+}
+
+// InFlight returns details of the downloads and uploads currently in progress,
+// that you could Cancel(). These are the reads of remote files (including those
+// that fill the cache of CacheData remotes), downloads of whole files that we
+// stream ourselves (because of MaxBytesPerSecond or a ProgressFunc), the
+// uploads of files that are uploaded as they are written, and the uploads of
+// cached files.
+func (fs *MuxFys) InFlight() []Transfer {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	var inflight []Transfer
+	for _, r := range fs.remotes {
+		inflight = append(inflight, r.transfers.list()...)
+	}
+	return inflight
+}
+
+// Cancel aborts the in-flight transfer(s) with the given Transfer.ID (see
+// InFlight()), without otherwise affecting the mount. The aborted read gets an
+// EINTR error (even if it was blocked waiting on the remote system), while an
+// aborted upload fails, and is not retried. The upload of a cached file can
+// only be aborted part way if the Accessor is a ReaderUploader or
+// ResumableUploader. Returns an error if there is no such transfer.
+func (fs *MuxFys) Cancel(id string) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	var found bool
+	for _, r := range fs.remotes {
+		if r.transfers.cancel(id) {
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("no in-flight transfer with ID %s", id)
+	}
+	fs.Warn("Transfer cancelled by user", "id", id)
+	return nil
+}
+
+// ReadAt reads len(p) bytes from the file at the given path (relative to the
+// mount point) starting at byte offset off, without going through FUSE. This is
+// useful if you already know the byte ranges you want from a large file. The
+// read works exactly as if the file had been opened via the mount point and
+// read, so a CacheData remote will use and add to its cache.
+//
+// Like io.ReaderAt, when fewer than len(p) bytes are read the error explains
+// why; it is io.EOF if the end of the file was reached. Otherwise you can check
+// it with errors.Is() against ErrNotFound, ErrPermission and
+// ErrRemoteUnavailable.
+func (fs *MuxFys) ReadAt(path string, p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("could not read %s: negative offset %d", path, off)
+	}
+	name := strings.TrimPrefix(filepath.Clean("/"+path), "/")
+	attr, status := fs.GetAttr(name, nil)
+	if status != fuse.OK {
+		return 0, fmt.Errorf("could not stat %s: %w", path, statusErr(status))
+	}
+	if !attr.IsRegular() {
+		return 0, fmt.Errorf("%s is not a file", path)
+	}
+
+	if uint64(off) >= attr.Size {
+		return 0, io.EOF
+	}
+
+	// like the kernel, we don't ask our files to read beyond their end
+	want := p
+	if remaining := attr.Size - uint64(off); uint64(len(p)) > remaining {
+		want = p[:remaining]
+	}
+
+	file, status := fs.Open(name, uint32(os.O_RDONLY), nil)
+	if status != fuse.OK {
+		return 0, fmt.Errorf("could not open %s: %w", path, statusErr(status))
+	}
+	defer file.Release()
+
+	var n int
+	for n < len(want) {
+		rr, status := file.Read(want[n:], off+int64(n))
+		if status != fuse.OK {
+			return n, fmt.Errorf("could not read %s: %w", path, statusErr(status))
+		}
+		if rr == nil {
+			break
+		}
+		b, status := rr.Bytes(want[n:])
+		if status == fuse.OK {
+			n += copy(want[n:], b)
+		}
+		rr.Done()
+		if status != fuse.OK {
+			return n, fmt.Errorf("could not read %s: %w", path, statusErr(status))
+		}
+		if len(b) == 0 {
+			break
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Destroy is the final teardown of a MuxFys you won't use again, releasing
+// everything it holds. Unmount() is designed to let you Mount() again, so
+// keeps some things around; Destroy() calls Unmount() (so any files you
+// created or altered still get uploaded), then also stops the EventHandler
+// goroutine, discards Logs() and stops logging.
+//
+// If the Unmount() fails, its error is returned and nothing else is done, so
+// that you can try to Destroy() again. Otherwise, afterwards Mount(),
+// Unmount() and Destroy() return ErrDestroyed.
+//
+// The remotes (and so the RemoteAccessors of your RemoteConfigs) were already
+// forgotten by Unmount(), which also stopped us using the minio clients
+// S3Accessors share. The RemoteAccessors themselves belong to you; Close() your
+// S3Accessors so that their clients are freed once nothing else uses them.
+func (fs *MuxFys) Destroy() error {
+	fs.mutex.Lock()
+	destroyed := fs.destroyed
+	fs.mutex.Unlock()
+	if destroyed {
+		return ErrDestroyed
+	}
+
+	err := fs.Unmount()
+	if err != nil {
+		return err
+	}
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	fs.destroyed = true
+	fs.server = nil
+	if fs.events != nil {
+		fs.events.close()
+	}
+	fs.handlesMutex.Lock()
+	fs.handles = make(map[*openHandle]bool)
+	fs.handlesMutex.Unlock()
+	fs.mapMutex.Lock()
+	fs.negativeCache = make(map[string]time.Time)
+	fs.mapMutex.Unlock()
+	fs.logStore.Clear()
+	fs.Logger.SetHandler(log15.DiscardHandler())
+	return nil
+}
+
 // uploadCreated uploads any files that previously got created. Only functions
 // in CacheData mode.
 func (fs *MuxFys) uploadCreated() error {
+	_, err := fs.uploadCreatedContext(context.Background(), false)
+	return err
+}
+
+// uploadCreatedContext is like uploadCreated(), but stops uploading once the
+// given context is done, returning the names of the files that weren't
+// uploaded because of that, mapped to their cache files. If skipOpen is true,
+// files that still have a handle open are left to be uploaded later.
+//
+// Uploads are done without holding mapMutex, so that fuse operations aren't
+// blocked while we're still mounted, eg. during a Commit(). Instead, the files
+// are marked as uploading, so that deleting or renaming one waits for its
+// upload to finish, rather than the upload re-creating it remotely afterwards.
+func (fs *MuxFys) uploadCreatedContext(ctx context.Context, skipOpen bool) (map[string]string, error) {
+	pending := make(map[string]string)
 	if fs.writeRemote != nil && fs.writeRemote.cacheData {
 		fails := 0
+		var failed fuse.Status
 
 		// since mtimes in S3 are stored as the upload time, we sort our created
 		// files by their mtime to at least upload them in the correct order
@@ -524,26 +1536,198 @@ func (fs *MuxFys) uploadCreated() error {
 			})
 		}
 
+		var toUpload []string
 		for _, name := range createdFiles {
 			remotePath := fs.writeRemote.getRemotePath(name)
 			localPath := fs.writeRemote.getLocalPath(remotePath)
 
-			// upload file
-			status := fs.writeRemote.uploadFile(localPath, remotePath)
-			if status != fuse.OK {
-				fails++
+			// discard files we've been asked not to upload
+			if fs.excludedFromUpload(name) {
+				fs.Info("Not uploading excluded file", "path", name)
+				fs.writeRemote.CacheDelete(localPath)
+				fs.writeRemote.modified.CacheDelete(localPath)
+				if err := os.Remove(localPath); err != nil && !os.IsNotExist(err) {
+					fs.Warn("Removal of excluded file's cache failed", "path", localPath, "err", err)
+				}
+				delete(fs.createdFiles, name)
 				continue
 			}
 
+			// leave files that are still being written to for later
+			if skipOpen && fs.hasOpenHandle(name) {
+				fs.Info("Not uploading file that is still open", "path", name)
+				continue
+			}
+
+			toUpload = append(toUpload, name)
+			fs.uploading[name] = make(chan struct{})
+		}
+		fs.mapMutex.Unlock()
+
+		uploaded := make(map[string]os.FileInfo)
+		for _, name := range toUpload {
+			func() {
+				defer fs.doneUploading(name)
+				remotePath := fs.writeRemote.getRemotePath(name)
+				localPath := fs.writeRemote.getLocalPath(remotePath)
+
+				// refuse to upload files that are too big
+				info, err := os.Stat(localPath)
+				if err == nil && fs.writeRemote.exceedsMaxWrite(remotePath, info.Size()) {
+					fails++
+					failed = fuse.Status(syscall.EFBIG)
+					return
+				}
+
+				// upload file, unless we've run out of time
+				if ctx.Err() != nil {
+					pending[name] = localPath
+					return
+				}
+				status := fs.writeRemote.uploadFileContext(ctx, localPath, remotePath)
+				if status != fuse.OK {
+					if ctx.Err() != nil {
+						pending[name] = localPath
+					} else {
+						fails++
+						failed = status
+					}
+					return
+				}
+
+				uploaded[name] = info
+			}()
+		}
+
+		// forget about the files we uploaded, unless they were altered while
+		// we were uploading them, in which case they'll need uploading again
+		fs.mapMutex.Lock()
+		for name, before := range uploaded {
+			if !fs.createdFiles[name] || (skipOpen && fs.hasOpenHandle(name)) {
+				continue
+			}
+			if before != nil {
+				after, err := os.Stat(fs.writeRemote.getLocalPath(fs.writeRemote.getRemotePath(name)))
+				if err != nil || after.Size() != before.Size() || !after.ModTime().Equal(before.ModTime()) {
+					continue
+				}
+			}
 			delete(fs.createdFiles, name)
 		}
+		var oldRemotePaths []string
+		for name := range uploaded {
+			oldRemotePaths = append(oldRemotePaths, fs.takeRenamedFrom(name)...)
+		}
 		fs.mapMutex.Unlock()
 
+		// now that their new paths exist remotely, delete the old paths of
+		// files that were renamed before being uploaded
+		for _, remotePath := range oldRemotePaths {
+			fs.writeRemote.deleteFile(remotePath)
+		}
+
 		if fails > 0 {
-			return fmt.Errorf("failed to upload %d files", fails)
+			return pending, &Error{Kind: statusKind(failed), Err: fmt.Errorf("failed to upload %d files", fails)}
 		}
 	}
-	return nil
+	return pending, nil
+}
+
+// doneUploading unmarks the named file as being uploaded by
+// uploadCreatedContext(), waking up anything waiting for it to finish.
+func (fs *MuxFys) doneUploading(name string) {
+	fs.mapMutex.Lock()
+	defer fs.mapMutex.Unlock()
+	if done, uploading := fs.uploading[name]; uploading {
+		close(done)
+		delete(fs.uploading, name)
+	}
+}
+
+// lockWhenNotUploading gets the mapMutex lock once none of the named files are
+// being uploaded by uploadCreatedContext(), so that they can be deleted or
+// renamed without their uploads then re-creating them remotely. You must not
+// already hold the mapMutex lock when calling this.
+func (fs *MuxFys) lockWhenNotUploading(names ...string) {
+	for {
+		fs.mapMutex.Lock()
+		var done chan struct{}
+		for _, name := range names {
+			if done = fs.uploading[name]; done != nil {
+				break
+			}
+		}
+		if done == nil {
+			return
+		}
+		fs.mapMutex.Unlock()
+		<-done
+	}
+}
+
+// excludedFromUpload tells you if the created file with the given name
+// (relative to the mount point) shouldn't be uploaded, according to our
+// UploadIncludeGlobs and UploadExcludeGlobs.
+func (fs *MuxFys) excludedFromUpload(name string) bool {
+	if len(fs.uploadInclude) > 0 && !matchesGlob(fs.uploadInclude, name) {
+		return true
+	}
+	return matchesGlob(fs.uploadExclude, name)
+}
+
+// TargetInfo describes one of the remotes a MuxFys is using, as returned by
+// MuxFys.Targets().
+type TargetInfo struct {
+	// Target is the Target() of the remote's RemoteAccessor.
+	Target string
+
+	// Write is true if this is the writeable remote.
+	Write bool
+
+	// CacheData is true if data from the remote is cached on local disk, in
+	// CacheDir.
+	CacheData bool
+	CacheDir  string
+
+	// CacheIsTmp is true if CacheDir was created by us, and will be deleted on
+	// Unmount().
+	CacheIsTmp bool
+
+	// MountSubpath is the directory within the mount that the remote's
+	// contents appear in, or "" for the mount point itself.
+	MountSubpath string
+
+	// RetryBackoffMin, RetryBackoffMax, RetryBackoffFactor and
+	// RetryJitter are the effective settings of the backoff between retries
+	// of failed remote calls.
+	RetryBackoffMin    time.Duration
+	RetryBackoffMax    time.Duration
+	RetryBackoffFactor float64
+	RetryJitter        bool
+}
+
+// Targets returns details of the remotes currently mounted, in the order they
+// were supplied to Mount(). It returns nothing if we're not mounted.
+func (fs *MuxFys) Targets() []TargetInfo {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	infos := make([]TargetInfo, 0, len(fs.remotes))
+	for _, r := range fs.remotes {
+		infos = append(infos, TargetInfo{
+			Target:       r.accessor.Target(),
+			Write:        r.write,
+			CacheData:    r.cacheData,
+			CacheDir:     r.cacheDir,
+			CacheIsTmp:   r.cacheIsTmp,
+			MountSubpath: r.mountSubpath,
+
+			RetryBackoffMin:    r.clientBackoff.Min,
+			RetryBackoffMax:    r.clientBackoff.Max,
+			RetryBackoffFactor: r.clientBackoff.Factor,
+			RetryJitter:        r.clientBackoff.Jitter,
+		})
+	}
+	return infos
 }
 
 // Logs returns messages generated while mounted; you might call it after
@@ -551,7 +1735,7 @@ func (fs *MuxFys) uploadCreated() error {
 //
 // By default these will only be errors that occurred, but if this MuxFys was
 // configured with Verbose on, it will also contain informational and warning
-// messages.
+// messages, and with FuseDebug on, go-fuse's debug output.
 //
 // If the muxfys package was configured with a log Handler (see
 // SetLogHandler()), these same messages would have been logged as they
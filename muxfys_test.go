@@ -19,6 +19,9 @@
 package muxfys
 
 import (
+	"context"
+	"crypto/md5"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -26,12 +29,17 @@ import (
 	"os"
 	"os/user"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"testing"
 	"time"
 
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/hanwen/go-fuse/v2/fuse/nodefs"
 	"github.com/inconshreveable/log15"
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -224,6 +232,635 @@ func (a *localAccessor) LocalPath(baseDir, remotePath string) string {
 	return filepath.Join(baseDir, remotePath)
 }
 
+// etagAccessor is a localAccessor that implements ConditionalDownloader, using
+// the MD5 of a file's contents as its ETag.
+type etagAccessor struct {
+	*localAccessor
+	downloads int
+}
+
+// DownloadFileIfChanged implements ConditionalDownloader.
+func (a *etagAccessor) DownloadFileIfChanged(source, dest, etag string) (string, error) {
+	content, err := ioutil.ReadFile(source)
+	if err != nil {
+		return "", err
+	}
+	newETag := fmt.Sprintf("%x", md5.Sum(content))
+	if etag == newETag {
+		return "", ErrNotModified
+	}
+	a.downloads++
+	return newETag, a.copyFile(source, dest)
+}
+
+// offlineAccessor is an etagAccessor that can be made unreachable.
+type offlineAccessor struct {
+	*etagAccessor
+	down bool
+}
+
+// errUnreachable is returned by an offlineAccessor that is down.
+var errUnreachable = errors.New("connection refused")
+
+// DownloadFile implements RemoteAccessor by failing if we're down.
+func (a *offlineAccessor) DownloadFile(source, dest string) error {
+	if a.down {
+		return errUnreachable
+	}
+	return a.etagAccessor.DownloadFile(source, dest)
+}
+
+// DownloadFileIfChanged implements ConditionalDownloader by failing if we're
+// down.
+func (a *offlineAccessor) DownloadFileIfChanged(source, dest, etag string) (string, error) {
+	if a.down {
+		return "", errUnreachable
+	}
+	return a.etagAccessor.DownloadFileIfChanged(source, dest, etag)
+}
+
+// ListEntries implements RemoteAccessor by failing if we're down.
+func (a *offlineAccessor) ListEntries(dir string) ([]RemoteAttr, error) {
+	if a.down {
+		return nil, errUnreachable
+	}
+	return a.etagAccessor.ListEntries(dir)
+}
+
+// OpenFile implements RemoteAccessor by failing if we're down.
+func (a *offlineAccessor) OpenFile(path string, offset int64) (io.ReadCloser, error) {
+	if a.down {
+		return nil, errUnreachable
+	}
+	return a.etagAccessor.OpenFile(path, offset)
+}
+
+// checksumAccessor is a localAccessor that implements ChecksumAccessor,
+// storing SHA256s in memory. It can pretend files were multipart uploaded, so
+// have no MD5, and can corrupt the files it downloads.
+type checksumAccessor struct {
+	*localAccessor
+	shas      map[string]string
+	multipart bool
+	corrupt   bool
+}
+
+// DownloadFile implements RemoteAccessor, flipping the first byte of the
+// download if we're corrupting files.
+func (a *checksumAccessor) DownloadFile(source, dest string) error {
+	err := a.localAccessor.DownloadFile(source, dest)
+	if err != nil || !a.corrupt {
+		return err
+	}
+	content, err := ioutil.ReadFile(dest)
+	if err != nil {
+		return err
+	}
+	content[0]++
+	return ioutil.WriteFile(dest, content, 0644)
+}
+
+// UploadReader implements ChecksumAccessor.
+func (a *checksumAccessor) UploadReader(ctx context.Context, data io.Reader, size int64, dest string, opts UploadOptions) error {
+	a.shas[dest] = opts.SHA256
+	return a.UploadData(data, dest)
+}
+
+// Checksums implements ChecksumAccessor.
+func (a *checksumAccessor) Checksums(path string) (string, string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+	md5sum := fmt.Sprintf("%x", md5.Sum(content))
+	if a.multipart {
+		md5sum = "abc-2"
+	}
+	return md5sum, a.shas[path], nil
+}
+
+// appendAccessor is a localAccessor that implements Appender, counting the
+// downloads and appends it does. It can pretend it can't append.
+type appendAccessor struct {
+	*localAccessor
+	downloads   int
+	appends     int
+	unsupported bool
+}
+
+// DownloadFile implements RemoteAccessor.
+func (a *appendAccessor) DownloadFile(source, dest string) error {
+	a.downloads++
+	return a.localAccessor.DownloadFile(source, dest)
+}
+
+// CanAppend implements Appender.
+func (a *appendAccessor) CanAppend() bool {
+	return true
+}
+
+// AppendFile implements Appender by appending to the local dest file.
+func (a *appendAccessor) AppendFile(source, dest string, offset int64) error {
+	info, err := os.Stat(dest)
+	if err != nil {
+		return err
+	}
+	if a.unsupported || info.Size() != offset {
+		return ErrAppendUnsupported
+	}
+	content, err := ioutil.ReadFile(source)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	a.appends++
+	_, err = f.Write(content[offset:])
+	return err
+}
+
+// markerAccessor is a localAccessor that lists a fixed set of entries, so that
+// we can test object store directory markers.
+type markerAccessor struct {
+	*localAccessor
+	entries []RemoteAttr
+}
+
+// ListEntries implements RemoteAccessor by returning our fixed entries that
+// are in dir.
+func (a *markerAccessor) ListEntries(dir string) ([]RemoteAttr, error) {
+	var ras []RemoteAttr
+	for _, entry := range a.entries {
+		if strings.HasPrefix(entry.Name, dir) {
+			ras = append(ras, entry)
+		}
+	}
+	return ras, nil
+}
+
+// partialAccessor is a localAccessor that implements PartialUploader by only
+// writing the modified bytes to dest. Its files' ETags are the MD5s of their
+// content.
+type partialAccessor struct {
+	*localAccessor
+	modified []Intervals
+	uploads  int
+}
+
+// etag returns the ETag of the given file.
+func (a *partialAccessor) etag(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", md5.Sum(content)), nil
+}
+
+// DownloadFileIfChanged implements ConditionalDownloader.
+func (a *partialAccessor) DownloadFileIfChanged(source, dest, etag string) (string, error) {
+	newETag, err := a.etag(source)
+	if err != nil {
+		return "", err
+	}
+	if newETag == etag {
+		return "", ErrNotModified
+	}
+	return newETag, a.DownloadFile(source, dest)
+}
+
+// StatFile implements FileStater.
+func (a *partialAccessor) StatFile(path string) (RemoteAttr, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return RemoteAttr{}, err
+	}
+	etag, err := a.etag(path)
+	return RemoteAttr{Name: path, Size: info.Size(), MTime: info.ModTime(), MD5: etag}, err
+}
+
+// UploadModified implements PartialUploader.
+func (a *partialAccessor) UploadModified(source, dest, contentType, etag string, modified Intervals) error {
+	sourceInfo, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	destInfo, err := os.Stat(dest)
+	if err != nil || destInfo.Size() != sourceInfo.Size() {
+		return ErrPartialUploadUnsupported
+	}
+	if destETag, erre := a.etag(dest); erre != nil || destETag != etag {
+		return ErrPartialUploadUnsupported
+	}
+	a.modified = append(a.modified, modified)
+
+	content, err := ioutil.ReadFile(source)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(dest, os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	for _, iv := range modified {
+		_, err = f.WriteAt(content[iv.Start:iv.End+1], iv.Start)
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// UploadFile implements RemoteAccessor by counting full uploads.
+func (a *partialAccessor) UploadFile(source, dest, contentType string) error {
+	a.uploads++
+	return a.localAccessor.UploadFile(source, dest, contentType)
+}
+
+// resumableAccessor is a localAccessor that implements ResumableUploader,
+// pretending to upload in 2 parts.
+type resumableAccessor struct {
+	*localAccessor
+	fail    bool
+	resumed []*UploadState
+	aborted []string
+}
+
+// UploadFileResumable implements ResumableUploader.
+func (a *resumableAccessor) UploadFileResumable(ctx context.Context, source, dest string, opts UploadOptions, state *UploadState, save func(*UploadState)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	a.resumed = append(a.resumed, state)
+	if state == nil {
+		state = &UploadState{UploadID: fmt.Sprintf("up%d", len(a.resumed))}
+		save(state)
+	}
+	state.Parts = []UploadedPart{{Number: 1, ETag: "p1"}}
+	save(state)
+	if a.fail {
+		return fmt.Errorf("upload interrupted")
+	}
+	return a.copyFile(source, dest)
+}
+
+// AbortUpload implements ResumableUploader.
+func (a *resumableAccessor) AbortUpload(dest string, state *UploadState) error {
+	a.aborted = append(a.aborted, state.UploadID)
+	return nil
+}
+
+// slowAccessor is a localAccessor that counts calls to OpenFile(), which is
+// slow.
+type slowAccessor struct {
+	*localAccessor
+	opens int32
+}
+
+// OpenFile implements RemoteAccessor by counting and sleeping before opening.
+func (a *slowAccessor) OpenFile(path string, offset int64) (io.ReadCloser, error) {
+	atomic.AddInt32(&a.opens, 1)
+	<-time.After(100 * time.Millisecond)
+	return a.localAccessor.OpenFile(path, offset)
+}
+
+// statAccessor is a localAccessor that implements FileStater, giving every
+// file the same metadata.
+type statAccessor struct {
+	*localAccessor
+	metadata map[string]string
+}
+
+// StatFile implements FileStater by deferring to os, treating directories as
+// not existing.
+func (a *statAccessor) StatFile(path string) (RemoteAttr, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return RemoteAttr{}, err
+	}
+	if info.IsDir() {
+		return RemoteAttr{}, &os.PathError{Op: "stat", Path: path, Err: os.ErrNotExist}
+	}
+	return RemoteAttr{Name: path, Size: info.Size(), MTime: info.ModTime(), Metadata: a.metadata}, nil
+}
+
+// exclusiveAccessor is a statAccessor that implements ExclusiveUploader,
+// counting the conditional uploads.
+type exclusiveAccessor struct {
+	*statAccessor
+	conditional int
+}
+
+// UploadFileIfAbsent implements ExclusiveUploader.
+func (a *exclusiveAccessor) UploadFileIfAbsent(source, dest string, opts UploadOptions) error {
+	a.conditional++
+	if _, err := os.Stat(dest); err == nil {
+		return ErrAlreadyExists
+	}
+	return a.UploadFile(source, dest, opts.ContentType)
+}
+
+// copierAccessor is a localAccessor that implements ServerSideCopier for
+// copying from other localAccessors, counting its copies and failing to copy
+// certain paths.
+type copierAccessor struct {
+	*localAccessor
+	copies int
+	fail   map[string]bool
+}
+
+// CanCopyFrom implements ServerSideCopier.
+func (a *copierAccessor) CanCopyFrom(src RemoteAccessor) bool {
+	_, ok := src.(*localAccessor)
+	return ok
+}
+
+// CopyFileFrom implements ServerSideCopier.
+func (a *copierAccessor) CopyFileFrom(src RemoteAccessor, source, dest string) error {
+	if a.fail[dest] {
+		return fmt.Errorf("copy failed")
+	}
+	a.copies++
+	return a.copyFile(source, dest)
+}
+
+// aclAccessor is a localAccessor that implements CannedACLSetter, recording
+// the ACL it was given.
+type aclAccessor struct {
+	*localAccessor
+	acl string
+}
+
+// SetCannedACL implements CannedACLSetter.
+func (a *aclAccessor) SetCannedACL(acl string) {
+	a.acl = acl
+}
+
+// tagAccessor is a localAccessor that implements TaggingAccessor, recording
+// the tags it was asked to set.
+type tagAccessor struct {
+	*localAccessor
+	tags map[string]map[string]string
+}
+
+// SetTags implements TaggingAccessor.
+func (a *tagAccessor) SetTags(path string, tags map[string]string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	a.tags[path] = tags
+	return nil
+}
+
+// pinAccessor is a localAccessor that implements VersionPinner, recording the
+// time it was pinned to.
+type pinAccessor struct {
+	*localAccessor
+	asOf time.Time
+}
+
+// PinVersionsAsOf implements VersionPinner.
+func (a *pinAccessor) PinVersionsAsOf(t time.Time) {
+	a.asOf = t
+}
+
+// errForbidden is returned by forbidAccessor for its forbidden paths.
+var errForbidden = errors.New("access denied")
+
+// forbidAccessor is a localAccessor that implements ForbiddenDetector, denying
+// access to reads of certain paths.
+type forbidAccessor struct {
+	*localAccessor
+	forbidden map[string]bool
+}
+
+// DownloadFile implements RemoteAccessor.
+func (a *forbidAccessor) DownloadFile(source, dest string) error {
+	if a.forbidden[source] {
+		return errForbidden
+	}
+	return a.localAccessor.DownloadFile(source, dest)
+}
+
+// OpenFile implements RemoteAccessor.
+func (a *forbidAccessor) OpenFile(path string, offset int64) (io.ReadCloser, error) {
+	if a.forbidden[path] {
+		return nil, errForbidden
+	}
+	return a.localAccessor.OpenFile(path, offset)
+}
+
+// ErrorIsForbidden implements ForbiddenDetector.
+func (a *forbidAccessor) ErrorIsForbidden(err error) bool {
+	return err == errForbidden
+}
+
+// usageAccessor is a localAccessor that implements UsageAccessor, counting the
+// calls to Usage().
+type usageAccessor struct {
+	*localAccessor
+	used  int64
+	quota int64
+	err   error
+	calls int
+}
+
+// Usage implements UsageAccessor.
+func (a *usageAccessor) Usage() (int64, int64, error) {
+	a.calls++
+	return a.used, a.quota, a.err
+}
+
+// selectAccessor is a localAccessor that implements SelectAccessor, returning
+// a description of the query instead of actually running it.
+type selectAccessor struct {
+	*localAccessor
+}
+
+// Select implements SelectAccessor.
+func (a *selectAccessor) Select(path, expression string) (io.ReadCloser, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(strings.NewReader(expression + " on " + filepath.Base(path))), nil
+}
+
+// metadataAccessor is a localAccessor that implements MetadataUploader,
+// remembering the metadata each file was uploaded with.
+type metadataAccessor struct {
+	*localAccessor
+	metadata map[string]map[string]string
+}
+
+// UploadReader implements ReaderUploader.
+func (a *metadataAccessor) UploadReader(ctx context.Context, data io.Reader, size int64, dest string, opts UploadOptions) error {
+	err := a.UploadData(data, dest)
+	if err == nil {
+		a.metadata[dest] = opts.Metadata
+	}
+	return err
+}
+
+// StoresMetadata implements MetadataUploader.
+func (a *metadataAccessor) StoresMetadata() bool {
+	return true
+}
+
+// ListEntries implements RemoteAccessor, including the metadata of files
+// uploaded with UploadReader().
+func (a *metadataAccessor) ListEntries(dir string) ([]RemoteAttr, error) {
+	ras, err := a.localAccessor.ListEntries(dir)
+	for i, ra := range ras {
+		ras[i].Metadata = a.metadata[ra.Name]
+	}
+	return ras, err
+}
+
+// stallingAccessor is a MemoryAccessor whose file reads block until they are
+// closed, and whose UploadReader() blocks until its context is done.
+type stallingAccessor struct {
+	*MemoryAccessor
+}
+
+// OpenFile implements RemoteAccessor.
+func (a *stallingAccessor) OpenFile(path string, offset int64) (io.ReadCloser, error) {
+	pr, _ := io.Pipe()
+	return pr, nil
+}
+
+// UploadReader implements ReaderUploader.
+func (a *stallingAccessor) UploadReader(ctx context.Context, data io.Reader, size int64, dest string, opts UploadOptions) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// appendMetadataAccessor is a MemoryAccessor that implements both Appender and
+// MetadataUploader, remembering the metadata each file was uploaded with and
+// counting its appends.
+type appendMetadataAccessor struct {
+	*MemoryAccessor
+	metadata map[string]map[string]string
+	appends  int
+}
+
+// CanAppend implements Appender.
+func (a *appendMetadataAccessor) CanAppend() bool {
+	return true
+}
+
+// AppendFile implements Appender.
+func (a *appendMetadataAccessor) AppendFile(source, dest string, offset int64) error {
+	existing, exists := a.Get(dest)
+	if !exists || int64(len(existing)) != offset {
+		return ErrAppendUnsupported
+	}
+	content, err := ioutil.ReadFile(source)
+	if err != nil {
+		return err
+	}
+	a.appends++
+	a.Put(dest, append(existing, content[offset:]...))
+	return nil
+}
+
+// UploadReader implements ReaderUploader.
+func (a *appendMetadataAccessor) UploadReader(ctx context.Context, data io.Reader, size int64, dest string, opts UploadOptions) error {
+	err := a.MemoryAccessor.UploadReader(ctx, data, size, dest, opts)
+	if err == nil {
+		a.metadata[dest] = opts.Metadata
+	}
+	return err
+}
+
+// StoresMetadata implements MetadataUploader.
+func (a *appendMetadataAccessor) StoresMetadata() bool {
+	return true
+}
+
+// contentTypeAccessor is a localAccessor that records the content types
+// files are uploaded with.
+type contentTypeAccessor struct {
+	*localAccessor
+	contentTypes map[string]string
+}
+
+// UploadFile implements RemoteAccessor.
+func (a *contentTypeAccessor) UploadFile(source, dest, contentType string) error {
+	a.contentTypes[dest] = contentType
+	return a.localAccessor.UploadFile(source, dest, contentType)
+}
+
+// UploadReader implements ReaderUploader.
+func (a *contentTypeAccessor) UploadReader(ctx context.Context, data io.Reader, size int64, dest string, opts UploadOptions) error {
+	a.contentTypes[dest] = opts.ContentType
+	return a.localAccessor.UploadData(data, dest)
+}
+
+// slowListAccessor is a localAccessor that takes delay to list a directory.
+type slowListAccessor struct {
+	*localAccessor
+	delay time.Duration
+}
+
+// ListEntries implements RemoteAccessor.
+func (a *slowListAccessor) ListEntries(dir string) ([]RemoteAttr, error) {
+	<-time.After(a.delay)
+	return a.localAccessor.ListEntries(dir)
+}
+
+// concurrentListAccessor is a RemoteAccessor that takes delay to list a
+// directory, recording the most listings that were in flight at once.
+type concurrentListAccessor struct {
+	RemoteAccessor
+	delay    time.Duration
+	inflight int32
+	max      int32
+}
+
+// ListEntries implements RemoteAccessor.
+func (a *concurrentListAccessor) ListEntries(dir string) ([]RemoteAttr, error) {
+	n := atomic.AddInt32(&a.inflight, 1)
+	defer atomic.AddInt32(&a.inflight, -1)
+	for {
+		max := atomic.LoadInt32(&a.max)
+		if n <= max || atomic.CompareAndSwapInt32(&a.max, max, n) {
+			break
+		}
+	}
+	<-time.After(a.delay)
+	return a.RemoteAccessor.ListEntries(dir)
+}
+
+// slowUploadAccessor is a localAccessor that reads the data it uploads with
+// UploadData() one byte at a time, waiting delay before each read.
+type slowUploadAccessor struct {
+	*localAccessor
+	delay time.Duration
+}
+
+// UploadData implements RemoteAccessor.
+func (a *slowUploadAccessor) UploadData(data io.Reader, dest string) error {
+	return a.localAccessor.UploadData(&slowReader{data, a.delay}, dest)
+}
+
+// slowReader reads one byte at a time, waiting delay before each read.
+type slowReader struct {
+	io.Reader
+	delay time.Duration
+}
+
+// Read implements io.Reader.
+func (sr *slowReader) Read(p []byte) (int, error) {
+	<-time.After(sr.delay)
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return sr.Reader.Read(p)
+}
+
 func TestMuxFys(t *testing.T) {
 	user, errt := user.Current()
 	if errt != nil {
@@ -325,6 +962,37 @@ func TestMuxFys(t *testing.T) {
 				So(err.Error(), ShouldEqual, "can't mount more that once at a time")
 			})
 
+			Convey("Mounted() is true until Unmount() completes and closes WaitUnmounted()", func() {
+				So(fs.Mounted(), ShouldBeTrue)
+				wait := fs.WaitUnmounted()
+				closed := false
+				select {
+				case <-wait:
+					closed = true
+				default:
+				}
+				So(closed, ShouldBeFalse)
+
+				err := fs.Unmount()
+				So(err, ShouldBeNil)
+				So(fs.Mounted(), ShouldBeFalse)
+				select {
+				case <-wait:
+					closed = true
+				case <-time.After(time.Second):
+				}
+				So(closed, ShouldBeTrue)
+			})
+
+			Convey("You can HealthCheck() the mount", func() {
+				So(fs.HealthCheck(context.Background()), ShouldBeNil)
+				err := fs.Unmount()
+				So(err, ShouldBeNil)
+				err = fs.HealthCheck(context.Background())
+				So(err, ShouldNotBeNil)
+				So(err.(*HealthCheckError).Failure, ShouldEqual, HealthNotMounted)
+			})
+
 			Convey("You can Unmount()", func() {
 				err := fs.Unmount()
 				So(err, ShouldBeNil)
@@ -427,6 +1095,31 @@ func TestMuxFys(t *testing.T) {
 				So(checkEmpty(cacheBase), ShouldBeTrue)
 			})
 
+			Convey("You can fallocate() space for files", func() {
+				if runtime.GOOS != "linux" {
+					return
+				}
+				path := filepath.Join(explicitMount, "allocated.file")
+				f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+				So(err, ShouldBeNil)
+				_, err = f.WriteString("abc")
+				So(err, ShouldBeNil)
+				err = fallocate(f, 10)
+				So(err, ShouldBeNil)
+				f.Close()
+				defer os.Remove(filepath.Join(sourcePoint, "allocated.file"))
+
+				info, err := os.Stat(path)
+				So(err, ShouldBeNil)
+				So(info.Size(), ShouldEqual, 10)
+
+				err = fs.Unmount()
+				So(err, ShouldBeNil)
+				data, err := ioutil.ReadFile(filepath.Join(sourcePoint, "allocated.file"))
+				So(err, ShouldBeNil)
+				So(data, ShouldResemble, append([]byte("abc"), make([]byte, 7)...))
+			})
+
 			Convey("Unmounting after creating files uploads them", func() {
 				sourceFile1 := filepath.Join(sourcePoint, "created1.file")
 				_, err := os.Stat(sourceFile1)
@@ -857,16 +1550,3397 @@ func TestMuxFys(t *testing.T) {
 			So(err, ShouldNotBeNil)
 		})
 
-		Convey("UnmountOnDeath does nothing prior to mounting", func() {
-			So(fs.handlingSignals, ShouldBeFalse)
-			fs.UnmountOnDeath()
-			So(fs.handlingSignals, ShouldBeFalse)
-		})
-	})
-
-	Convey("You can make a New MuxFys with a default Mount", t, func() {
-		defaultMnt := filepath.Join(tmpdir, "mnt")
-		fs, err := New(&Config{})
+		Convey("You can't Mount() with invalid MountOptions", func() {
+			remoteConfig := &RemoteConfig{
+				Accessor: accessor,
+			}
+			fs.mountOpts = &MountOptions{MaxWrite: -1}
+			err := fs.Mount(remoteConfig)
+			So(err, ShouldNotBeNil)
+			So(fs.mounted, ShouldBeFalse)
+
+			fs.mountOpts = &MountOptions{Options: []string{"allow_other"}}
+			err = fs.Mount(remoteConfig)
+			So(err, ShouldNotBeNil)
+
+			fs.mountOpts = &MountOptions{Options: []string{"ro,noatime"}}
+			err = fs.Mount(remoteConfig)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("MountOptions are merged with the defaults", func() {
+			var mo *MountOptions
+			fmo, err := mo.fuseMountOptions()
+			So(err, ShouldBeNil)
+			So(fmo.AllowOther, ShouldBeTrue)
+			So(fmo.FsName, ShouldEqual, "MuxFys")
+			So(fmo.DisableXAttrs, ShouldBeTrue)
+
+			mo = &MountOptions{MaxWrite: 4096, Options: []string{"volname=test"}}
+			fmo, err = mo.fuseMountOptions()
+			So(err, ShouldBeNil)
+			So(fmo.AllowOther, ShouldBeFalse)
+			So(fmo.FsName, ShouldEqual, "MuxFys")
+			So(fmo.MaxWrite, ShouldEqual, 4096)
+			So(fmo.Options, ShouldResemble, []string{"volname=test"})
+		})
+
+		Convey("UnmountOnDeath does nothing prior to mounting", func() {
+			So(fs.handlingSignals, ShouldBeFalse)
+			fs.UnmountOnDeath()
+			So(fs.handlingSignals, ShouldBeFalse)
+		})
+	})
+
+	Convey("Re-opening a directory merges in remote changes", t, func() {
+		mergeSource := filepath.Join(tmpdir, "mergeSource")
+		os.MkdirAll(filepath.Join(mergeSource, "sub"), os.FileMode(0777))
+		defer os.RemoveAll(mergeSource)
+		ioutil.WriteFile(filepath.Join(mergeSource, "a.file"), []byte("a"), 0644)
+		ioutil.WriteFile(filepath.Join(mergeSource, "sub", "b.file"), []byte("b"), 0644)
+
+		fs, r := newTestFS(&Config{Mount: filepath.Join(tmpdir, "mergeMount"), CacheBase: cacheBase}, &RemoteConfig{Accessor: &localAccessor{target: mergeSource}, Write: true})
+
+		fs.mapMutex.Lock()
+		defer fs.mapMutex.Unlock()
+		So(fs.openDir(r, ""), ShouldEqual, fuse.OK)
+		So(fs.openDir(r, "sub"), ShouldEqual, fuse.OK)
+		So(dirEntryNames(fs.dirContents[""]), ShouldResemble, []string{"a.file", "sub"})
+		So(fs.dirs[""], ShouldHaveLength, 1)
+
+		ioutil.WriteFile(filepath.Join(mergeSource, "c.file"), []byte("cc"), 0644)
+		os.Remove(filepath.Join(mergeSource, "a.file"))
+		os.RemoveAll(filepath.Join(mergeSource, "sub"))
+		fs.files["local.file"] = &fuse.Attr{}
+		fs.fileToRemote["local.file"] = r
+		fs.createdFiles["local.file"] = true
+		fs.addDirEntry("", fuse.DirEntry{Name: "local.file", Mode: uint32(fuse.S_IFREG)})
+
+		So(fs.openDir(r, ""), ShouldEqual, fuse.OK)
+		So(dirEntryNames(fs.dirContents[""]), ShouldResemble, []string{"c.file", "local.file"})
+		So(fs.dirs[""], ShouldHaveLength, 1)
+		So(fs.files["c.file"].Size, ShouldEqual, 2)
+		_, exists := fs.files["a.file"]
+		So(exists, ShouldBeFalse)
+		_, exists = fs.dirs["sub"]
+		So(exists, ShouldBeFalse)
+		_, exists = fs.files["sub/b.file"]
+		So(exists, ShouldBeFalse)
+
+		So(fs.openDir(r, ""), ShouldEqual, fuse.OK)
+		So(dirEntryNames(fs.dirContents[""]), ShouldResemble, []string{"c.file", "local.file"})
+	})
+
+	Convey("With ReadOnly, every mutating operation fails with EROFS", t, func() {
+		roSource := filepath.Join(tmpdir, "roSource")
+		os.MkdirAll(filepath.Join(roSource, "sub"), os.FileMode(0777))
+		defer os.RemoveAll(roSource)
+		ioutil.WriteFile(filepath.Join(roSource, "a.file"), []byte("a"), 0644)
+
+		fs, _ := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "roMount"), CacheBase: cacheBase, ReadOnly: true}, &RemoteConfig{Accessor: &localAccessor{target: roSource}, CacheData: true, Write: true})
+
+		now := time.Now()
+		_, status := fs.Create("new.file", uint32(os.O_RDWR|os.O_CREATE), 0644, nil)
+		So(status, ShouldEqual, fuse.EROFS)
+		So(fs.Mkdir("new", 0755, nil), ShouldEqual, fuse.EROFS)
+		So(fs.Rmdir("sub", nil), ShouldEqual, fuse.EROFS)
+		So(fs.Rename("a.file", "b.file", nil), ShouldEqual, fuse.EROFS)
+		So(fs.Unlink("a.file", nil), ShouldEqual, fuse.EROFS)
+		So(fs.Truncate("a.file", 0, nil), ShouldEqual, fuse.EROFS)
+		So(fs.Symlink("a.file", "link", nil), ShouldEqual, fuse.EROFS)
+		So(fs.Chmod("a.file", 0777, nil), ShouldEqual, fuse.EROFS)
+		So(fs.Chown("a.file", 0, 0, nil), ShouldEqual, fuse.EROFS)
+		So(fs.Utimens("a.file", &now, &now, nil), ShouldEqual, fuse.EROFS)
+		for _, flags := range []int{os.O_WRONLY, os.O_RDWR, os.O_APPEND, os.O_TRUNC} {
+			_, status = fs.Open("a.file", uint32(flags), nil)
+			So(status, ShouldEqual, fuse.EROFS)
+		}
+
+		_, err := os.Stat(filepath.Join(roSource, "a.file"))
+		So(err, ShouldBeNil)
+		_, err = os.Stat(filepath.Join(roSource, "sub"))
+		So(err, ShouldBeNil)
+
+		file, status := fs.Open("a.file", uint32(os.O_RDONLY), nil)
+		So(status, ShouldEqual, fuse.OK)
+		file.Release()
+	})
+
+	Convey("Re-opening an existing file for writing truncates it unless preserving content", t, func() {
+		truncSource := filepath.Join(tmpdir, "truncSource")
+		os.MkdirAll(truncSource, os.FileMode(0777))
+		defer os.RemoveAll(truncSource)
+		sourceFile := filepath.Join(truncSource, "a.file")
+		truncCache := filepath.Join(tmpdir, "truncCache")
+
+		overwrite := func(flags int) (string, uint64) {
+			defer os.RemoveAll(truncCache)
+			err := ioutil.WriteFile(sourceFile, []byte("a much longer original line"), 0644)
+			So(err, ShouldBeNil)
+
+			fs, _ := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "truncMount"), CacheBase: cacheBase}, &RemoteConfig{Accessor: &localAccessor{target: truncSource}, CacheDir: truncCache, Write: true})
+
+			file, status := fs.Open("a.file", uint32(flags), nil)
+			So(status, ShouldEqual, fuse.OK)
+			written, status := file.Write([]byte("short"), 0)
+			So(status, ShouldEqual, fuse.OK)
+			So(written, ShouldEqual, 5)
+			file.Release()
+
+			So(fs.uploadCreated(), ShouldBeNil)
+			content, err := ioutil.ReadFile(sourceFile)
+			So(err, ShouldBeNil)
+			return string(content), fs.files["a.file"].Size
+		}
+
+		content, size := overwrite(os.O_WRONLY | os.O_TRUNC)
+		So(content, ShouldEqual, "short")
+		So(size, ShouldEqual, 5)
+		content, size = overwrite(os.O_WRONLY)
+		So(content, ShouldEqual, "short")
+		So(size, ShouldEqual, 5)
+		content, _ = overwrite(os.O_RDWR | os.O_TRUNC)
+		So(content, ShouldEqual, "short")
+		content, size = overwrite(os.O_RDWR)
+		So(content, ShouldEqual, "shorth longer original line")
+		So(size, ShouldEqual, 27)
+	})
+
+	Convey("NoOverwrite stops existing remote files being replaced", t, func() {
+		noSource := filepath.Join(tmpdir, "noOverwriteSource")
+		os.MkdirAll(noSource, os.FileMode(0777))
+		defer os.RemoveAll(noSource)
+		existing := filepath.Join(noSource, "a.file")
+		err := ioutil.WriteFile(existing, []byte("original"), 0644)
+		So(err, ShouldBeNil)
+
+		local := &localAccessor{target: noSource}
+		stater := &statAccessor{localAccessor: local}
+		exclusive := &exclusiveAccessor{statAccessor: stater}
+
+		_, err = newRemote(&RemoteConfig{Accessor: exclusive, Write: true, StreamWrites: true, NoOverwrite: true}, cacheBase, 1, pkgLogger)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "NoOverwrite can't be used with StreamWrites")
+
+		mount := func(accessor RemoteAccessor, noOverwrite, listed bool) *MuxFys {
+			config := &Config{Mount: filepath.Join(tmpdir, "noOverwriteMount"), CacheBase: cacheBase}
+			rc := &RemoteConfig{Accessor: accessor, CacheData: true, Write: true, NoOverwrite: noOverwrite}
+			if listed {
+				fs, _ := mountTestFS(config, rc)
+				return fs
+			}
+			fs, _ := newTestFS(config, rc)
+			return fs
+		}
+
+		create := func(fs *MuxFys, name string, flags int, content string) fuse.Status {
+			file, status := fs.Create(name, uint32(flags), uint32(fileMode), nil)
+			if status != fuse.OK {
+				return status
+			}
+			_, status = file.Write([]byte(content), 0)
+			So(status, ShouldEqual, fuse.OK)
+			file.Release()
+			return status
+		}
+
+		read := func(name string) string {
+			content, err := ioutil.ReadFile(filepath.Join(noSource, name))
+			So(err, ShouldBeNil)
+			return string(content)
+		}
+
+		eexist := fuse.Status(syscall.EEXIST)
+
+		Convey("Create() fails for files that exist", func() {
+			fs := mount(stater, true, true)
+			So(create(fs, "a.file", os.O_WRONLY, "new"), ShouldEqual, eexist)
+
+			fs = mount(stater, true, false)
+			So(create(fs, "a.file", os.O_WRONLY, "new"), ShouldEqual, eexist)
+			So(fs.uploadCreated(), ShouldBeNil)
+			So(read("a.file"), ShouldEqual, "original")
+
+			Convey("But you can create and re-create new files until uploaded", func() {
+				So(create(fs, "b.file", os.O_WRONLY, "first"), ShouldEqual, fuse.OK)
+				So(create(fs, "b.file", os.O_WRONLY, "second"), ShouldEqual, fuse.OK)
+				So(fs.uploadCreated(), ShouldBeNil)
+				So(read("b.file"), ShouldEqual, "second")
+				So(create(fs, "b.file", os.O_WRONLY, "third"), ShouldEqual, eexist)
+				So(read("b.file"), ShouldEqual, "second")
+			})
+		})
+
+		Convey("Uploads don't replace files created by others in the meantime", func() {
+			for _, accessor := range []RemoteAccessor{stater, exclusive} {
+				fs := mount(accessor, true, true)
+				So(create(fs, "c.file", os.O_WRONLY, "mine"), ShouldEqual, fuse.OK)
+				err := ioutil.WriteFile(filepath.Join(noSource, "c.file"), []byte("theirs"), 0644)
+				So(err, ShouldBeNil)
+				So(fs.uploadCreated(), ShouldNotBeNil)
+				So(read("c.file"), ShouldEqual, "theirs")
+				os.Remove(filepath.Join(noSource, "c.file"))
+			}
+			So(exclusive.conditional, ShouldEqual, 1)
+		})
+
+		Convey("Without it, only O_EXCL prevents re-creating existing files", func() {
+			fs := mount(local, false, true)
+			So(create(fs, "a.file", os.O_WRONLY|os.O_CREATE|os.O_EXCL, "new"), ShouldEqual, eexist)
+			So(create(fs, "a.file", os.O_WRONLY, "new"), ShouldEqual, fuse.OK)
+			So(fs.uploadCreated(), ShouldBeNil)
+			So(read("a.file"), ShouldEqual, "new")
+		})
+	})
+
+	Convey("Access() enforces the requested access mode", t, func() {
+		accessSource := filepath.Join(tmpdir, "accessSource")
+		os.MkdirAll(filepath.Join(accessSource, "sub"), os.FileMode(0777))
+		defer os.RemoveAll(accessSource)
+		ioutil.WriteFile(filepath.Join(accessSource, "a.file"), []byte("a"), 0644)
+
+		fs, r := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "accessMount"), CacheBase: cacheBase}, &RemoteConfig{Accessor: &localAccessor{target: accessSource}})
+
+		So(fs.Access("a.file", fuse.R_OK, nil), ShouldEqual, fuse.OK)
+		So(fs.Access("a.file", fuse.F_OK, nil), ShouldEqual, fuse.OK)
+		So(fs.Access("a.file", fuse.W_OK, nil), ShouldEqual, fuse.EACCES)
+		So(fs.Access("a.file", fuse.R_OK|fuse.W_OK, nil), ShouldEqual, fuse.EACCES)
+		So(fs.Access("", fuse.R_OK|fuse.X_OK, nil), ShouldEqual, fuse.OK)
+		So(fs.Access("sub", fuse.X_OK, nil), ShouldEqual, fuse.OK)
+		So(fs.Access("sub", fuse.W_OK, nil), ShouldEqual, fuse.EACCES)
+		So(fs.Access("missing.file", fuse.R_OK, nil), ShouldEqual, fuse.ENOENT)
+		So(fs.Access("missing.file", fuse.W_OK, nil), ShouldEqual, fuse.ENOENT)
+
+		Convey("Writing is allowed with a writeable remote", func() {
+			r.write = true
+			fs.writeRemote = r
+			So(fs.Access("a.file", fuse.R_OK|fuse.W_OK, nil), ShouldEqual, fuse.OK)
+			So(fs.Access("sub", fuse.W_OK, nil), ShouldEqual, fuse.OK)
+			So(fs.Access("missing.file", fuse.W_OK, nil), ShouldEqual, fuse.ENOENT)
+
+			Convey("Unless ReadOnly", func() {
+				fs.readOnly = true
+				So(fs.Access("a.file", fuse.W_OK, nil), ShouldEqual, fuse.EROFS)
+				So(fs.Access("a.file", fuse.R_OK, nil), ShouldEqual, fuse.OK)
+			})
+		})
+	})
+
+	Convey("An EventHandler is told about file operations", t, func() {
+		eventSource := filepath.Join(tmpdir, "eventSource")
+		os.MkdirAll(eventSource, os.FileMode(0777))
+		defer os.RemoveAll(eventSource)
+		ioutil.WriteFile(filepath.Join(eventSource, "a.file"), []byte("abc"), 0644)
+		ioutil.WriteFile(filepath.Join(eventSource, "d.file"), []byte("defg"), 0644)
+
+		var events []Event
+		var eMutex sync.Mutex
+		handler := func(e Event) {
+			eMutex.Lock()
+			defer eMutex.Unlock()
+			events = append(events, e)
+		}
+		waitForEvents := func(n int) []Event {
+			limit := time.After(5 * time.Second)
+			for {
+				eMutex.Lock()
+				if len(events) >= n {
+					got := events
+					events = nil
+					eMutex.Unlock()
+					return got
+				}
+				eMutex.Unlock()
+				select {
+				case <-limit:
+					return nil
+				case <-time.After(10 * time.Millisecond):
+				}
+			}
+		}
+
+		fs, _ := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "eventMount"), CacheBase: cacheBase, EventHandler: handler}, &RemoteConfig{Accessor: &localAccessor{target: eventSource}, CacheData: true, Write: true})
+
+		file, status := fs.Open("a.file", uint32(os.O_RDONLY), nil)
+		So(status, ShouldEqual, fuse.OK)
+		file.Release()
+		_, status = fs.Open("missing.file", uint32(os.O_RDONLY), nil)
+		So(status, ShouldEqual, fuse.ENOENT)
+		got := waitForEvents(2)
+		So(got, ShouldHaveLength, 2)
+		So(got[0].Type, ShouldEqual, EventOpen)
+		So(got[0].Path, ShouldEqual, "a.file")
+		So(got[0].Bytes, ShouldEqual, 3)
+		So(got[0].Err, ShouldBeNil)
+		So(got[1].Path, ShouldEqual, "missing.file")
+		So(got[1].Err, ShouldEqual, syscall.ENOENT)
+
+		file, status = fs.Open("d.file", uint32(os.O_WRONLY|os.O_APPEND), nil)
+		So(status, ShouldEqual, fuse.OK)
+		file.Release()
+		got = waitForEvents(2)
+		So(got, ShouldHaveLength, 2)
+		So(got[0].Type, ShouldEqual, EventDownload)
+		So(got[0].Path, ShouldEqual, filepath.Join(eventSource, "d.file"))
+		So(got[0].Bytes, ShouldEqual, 4)
+		So(got[1].Type, ShouldEqual, EventOpen)
+
+		file, status = fs.Create("b.file", uint32(os.O_WRONLY|os.O_CREATE), 0644, nil)
+		So(status, ShouldEqual, fuse.OK)
+		_, status = file.Write([]byte("hello"), 0)
+		So(status, ShouldEqual, fuse.OK)
+		file.Release()
+		So(fs.uploadCreated(), ShouldBeNil)
+		So(fs.Rename("b.file", "c.file", nil), ShouldEqual, fuse.OK)
+		So(fs.Unlink("c.file", nil), ShouldEqual, fuse.OK)
+		got = waitForEvents(5)
+		So(got, ShouldHaveLength, 5)
+		types := make([]EventType, len(got))
+		for i, e := range got {
+			types[i] = e.Type
+		}
+		So(types, ShouldResemble, []EventType{EventCreate, EventUpload, EventUpload, EventRename, EventDelete})
+		So(got[0].Path, ShouldEqual, "b.file")
+		So(got[3].Path, ShouldEqual, "b.file")
+		So(got[3].NewPath, ShouldEqual, "c.file")
+		So(got[4].Path, ShouldEqual, "c.file")
+		So(EventRename.String(), ShouldEqual, "rename")
+
+		Convey("Events are dropped instead of blocking if the handler is slow", func() {
+			received := make(chan bool, 1)
+			block := make(chan bool)
+			handler := func(e Event) {
+				select {
+				case received <- true:
+				default:
+				}
+				<-block
+			}
+			fs2, err := New(&Config{Mount: filepath.Join(tmpdir, "eventMount2"), CacheBase: cacheBase, EventHandler: handler})
+			So(err, ShouldBeNil)
+			defer fs2.events.close()
+			defer close(block)
+
+			// the handler takes the first event and blocks, so then the buffer
+			// can be filled
+			fs2.emit(Event{Type: EventOpen})
+			<-received
+			for i := 0; i < eventBufferSize; i++ {
+				fs2.emit(Event{Type: EventOpen})
+			}
+			So(len(fs2.events.ch), ShouldEqual, eventBufferSize)
+
+			done := make(chan bool)
+			go func() {
+				fs2.emit(Event{Type: EventOpen})
+				done <- true
+			}()
+			var returned bool
+			select {
+			case <-done:
+				returned = true
+			case <-time.After(5 * time.Second):
+			}
+			So(returned, ShouldBeTrue)
+			So(len(fs2.events.ch), ShouldEqual, eventBufferSize)
+		})
+	})
+
+	Convey("Rename only copies files remotely if they have no local changes", t, func() {
+		renameSource := filepath.Join(tmpdir, "renameSource")
+		os.MkdirAll(renameSource, os.FileMode(0777))
+		defer os.RemoveAll(renameSource)
+		ioutil.WriteFile(filepath.Join(renameSource, "a.file"), []byte("old content"), 0644)
+		ioutil.WriteFile(filepath.Join(renameSource, "b.file"), []byte("unchanged"), 0644)
+
+		fi := NewFaultInjector(&localAccessor{target: renameSource})
+		fs, _ := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "renameMount"), CacheBase: cacheBase}, &RemoteConfig{Accessor: fi, CacheData: true, Write: true})
+
+		remoteContent := func(name string) string {
+			content, errr := ioutil.ReadFile(filepath.Join(renameSource, name))
+			if errr != nil {
+				return errr.Error()
+			}
+			return string(content)
+		}
+
+		Convey("A modified file is uploaded to the new path instead of being copied", func() {
+			file, status := fs.Open("a.file", uint32(os.O_RDWR|os.O_APPEND), nil)
+			So(status, ShouldEqual, fuse.OK)
+			_, status = file.Write([]byte("new"), 0)
+			So(status, ShouldEqual, fuse.OK)
+			file.Release()
+
+			So(fs.Rename("a.file", "c.file", nil), ShouldEqual, fuse.OK)
+			_, err := os.Stat(filepath.Join(renameSource, "c.file"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+			So(remoteContent("a.file"), ShouldEqual, "old content")
+			So(fs.createdFiles, ShouldResemble, map[string]bool{"c.file": true})
+
+			Convey("The old path is only deleted once the new path is uploaded", func() {
+				So(fs.uploadCreated(), ShouldBeNil)
+				So(remoteContent("c.file"), ShouldEqual, "old contentnew")
+				_, err = os.Stat(filepath.Join(renameSource, "a.file"))
+				So(os.IsNotExist(err), ShouldBeTrue)
+			})
+
+			Convey("The old path survives a failed upload of the new path", func() {
+				fi.AddRule(FaultRule{Method: "UploadFile", Err: errors.New("connection refused")})
+				So(fs.uploadCreated(), ShouldNotBeNil)
+				_, err = os.Stat(filepath.Join(renameSource, "c.file"))
+				So(os.IsNotExist(err), ShouldBeTrue)
+				So(remoteContent("a.file"), ShouldEqual, "old content")
+			})
+
+			Convey("Renaming again still deletes the original path after upload", func() {
+				So(fs.Rename("c.file", "e.file", nil), ShouldEqual, fuse.OK)
+				So(remoteContent("a.file"), ShouldEqual, "old content")
+				So(fs.uploadCreated(), ShouldBeNil)
+				So(remoteContent("e.file"), ShouldEqual, "old contentnew")
+				_, err = os.Stat(filepath.Join(renameSource, "a.file"))
+				So(os.IsNotExist(err), ShouldBeTrue)
+			})
+
+			Convey("Re-creating the old path stops it being deleted", func() {
+				file, status := fs.Create("a.file", uint32(os.O_WRONLY|os.O_CREATE), 0644, nil)
+				So(status, ShouldEqual, fuse.OK)
+				_, status = file.Write([]byte("recreated"), 0)
+				So(status, ShouldEqual, fuse.OK)
+				file.Release()
+				So(fs.uploadCreated(), ShouldBeNil)
+				So(remoteContent("c.file"), ShouldEqual, "old contentnew")
+				So(remoteContent("a.file"), ShouldEqual, "recreated")
+			})
+		})
+
+		Convey("A newly created file can be renamed before it is uploaded", func() {
+			file, status := fs.Create("new.file", uint32(os.O_WRONLY|os.O_CREATE), 0644, nil)
+			So(status, ShouldEqual, fuse.OK)
+			_, status = file.Write([]byte("hello"), 0)
+			So(status, ShouldEqual, fuse.OK)
+			file.Release()
+
+			So(fs.Rename("new.file", "renamed.file", nil), ShouldEqual, fuse.OK)
+			So(fs.uploadCreated(), ShouldBeNil)
+			So(remoteContent("renamed.file"), ShouldEqual, "hello")
+		})
+
+		Convey("An unmodified file is copied remotely", func() {
+			So(fs.Rename("b.file", "d.file", nil), ShouldEqual, fuse.OK)
+			So(remoteContent("d.file"), ShouldEqual, "unchanged")
+			_, err := os.Stat(filepath.Join(renameSource, "b.file"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+			So(fs.createdFiles, ShouldBeEmpty)
+		})
+	})
+
+	Convey("Link makes independent copies of files", t, func() {
+		linkSource := filepath.Join(tmpdir, "linkSource")
+		os.MkdirAll(filepath.Join(linkSource, "dir"), os.FileMode(0777))
+		defer os.RemoveAll(linkSource)
+		ioutil.WriteFile(filepath.Join(linkSource, "a.file"), []byte("original"), 0644)
+
+		fs, r := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "linkMount"), CacheBase: cacheBase}, &RemoteConfig{Accessor: &localAccessor{target: linkSource}, CacheData: true, Write: true})
+
+		remoteContent := func(name string) string {
+			content, errr := ioutil.ReadFile(filepath.Join(linkSource, name))
+			if errr != nil {
+				return errr.Error()
+			}
+			return string(content)
+		}
+		localPath := func(name string) string {
+			return r.getLocalPath(r.getRemotePath(name))
+		}
+
+		So(fs.Link("missing.file", "b.file", nil), ShouldEqual, fuse.ENOENT)
+		So(fs.Link("dir", "b.file", nil), ShouldEqual, fuse.EPERM)
+		So(fs.Link("a.file", "dir", nil), ShouldEqual, fuse.Status(syscall.EEXIST))
+
+		Convey("An unmodified file is copied remotely, sharing its cache", func() {
+			file, status := fs.Open("a.file", uint32(os.O_RDONLY), nil)
+			So(status, ShouldEqual, fuse.OK)
+			_, status = file.Read(make([]byte, 8), 0)
+			So(status, ShouldEqual, fuse.OK)
+			file.Release()
+
+			So(fs.Link("a.file", "b.file", nil), ShouldEqual, fuse.OK)
+			So(remoteContent("b.file"), ShouldEqual, "original")
+			So(fs.files["b.file"].Size, ShouldEqual, 8)
+			So(fs.createdFiles, ShouldBeEmpty)
+			So(fs.Link("a.file", "b.file", nil), ShouldEqual, fuse.Status(syscall.EEXIST))
+			info, err := os.Stat(localPath("b.file"))
+			So(err, ShouldBeNil)
+			So(info.Sys().(*syscall.Stat_t).Nlink, ShouldEqual, 2)
+			So(r.Uncached(localPath("b.file"), NewInterval(0, 8)), ShouldBeEmpty)
+
+			Convey("Writing to one doesn't change the other", func() {
+				file, status := fs.Open("b.file", uint32(os.O_RDWR), nil)
+				So(status, ShouldEqual, fuse.OK)
+				_, status = file.Write([]byte("XX"), 0)
+				So(status, ShouldEqual, fuse.OK)
+				file.Release()
+				So(fs.uploadCreated(), ShouldBeNil)
+
+				So(remoteContent("b.file"), ShouldEqual, "XXiginal")
+				So(remoteContent("a.file"), ShouldEqual, "original")
+				content, err := ioutil.ReadFile(localPath("a.file"))
+				So(err, ShouldBeNil)
+				So(string(content), ShouldEqual, "original")
+			})
+		})
+
+		Convey("A newly created file is linked before it is uploaded", func() {
+			file, status := fs.Create("new.file", uint32(os.O_WRONLY|os.O_CREATE), 0644, nil)
+			So(status, ShouldEqual, fuse.OK)
+			_, status = file.Write([]byte("hello"), 0)
+			So(status, ShouldEqual, fuse.OK)
+			file.Release()
+
+			So(fs.Link("new.file", "linked.file", nil), ShouldEqual, fuse.OK)
+			So(remoteContent("linked.file"), ShouldContainSubstring, "no such file")
+			So(fs.createdFiles, ShouldResemble, map[string]bool{"new.file": true, "linked.file": true})
+			So(fs.uploadCreated(), ShouldBeNil)
+			So(remoteContent("new.file"), ShouldEqual, "hello")
+			So(remoteContent("linked.file"), ShouldEqual, "hello")
+		})
+	})
+
+	Convey("Files can be appended to without downloading them with an Appender", t, func() {
+		appendSource := filepath.Join(tmpdir, "appendSource")
+		os.MkdirAll(appendSource, os.FileMode(0777))
+		defer os.RemoveAll(appendSource)
+		sourceFile := filepath.Join(appendSource, "log.file")
+		ioutil.WriteFile(sourceFile, []byte("0123456789"), 0644)
+
+		accessor := &appendAccessor{localAccessor: &localAccessor{target: appendSource}}
+		fs, r := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "appendMount"), CacheBase: cacheBase}, &RemoteConfig{Accessor: accessor, CacheData: true, Write: true})
+
+		appendData := func(data string) {
+			file, status := fs.Open("log.file", uint32(os.O_WRONLY|os.O_APPEND), nil)
+			So(status, ShouldEqual, fuse.OK)
+			attr := &fuse.Attr{}
+			So(file.GetAttr(attr), ShouldEqual, fuse.OK)
+			_, status = file.Write([]byte(data), int64(attr.Size))
+			So(status, ShouldEqual, fuse.OK)
+			file.Release()
+		}
+		appendData("abc")
+		appendData("de")
+		So(accessor.downloads, ShouldEqual, 0)
+		localPath := r.getLocalPath(sourceFile)
+		base, appending := r.appendBase(localPath)
+		So(appending, ShouldBeTrue)
+		So(base, ShouldEqual, 10)
+
+		Convey("Only the appended data is uploaded", func() {
+			So(fs.uploadCreated(), ShouldBeNil)
+			So(accessor.appends, ShouldEqual, 1)
+			content, err := ioutil.ReadFile(sourceFile)
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, "0123456789abcde")
+			_, appending = r.appendBase(localPath)
+			So(appending, ShouldBeFalse)
+		})
+
+		Convey("If appending isn't possible, the rest of the file is downloaded and the whole file uploaded", func() {
+			accessor.unsupported = true
+			So(fs.uploadCreated(), ShouldBeNil)
+			So(accessor.appends, ShouldEqual, 0)
+			content, err := ioutil.ReadFile(sourceFile)
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, "0123456789abcde")
+		})
+
+		Convey("Modifying the existing data makes the whole file be uploaded", func() {
+			So(fs.Truncate("log.file", 5, nil), ShouldEqual, fuse.OK)
+			So(fs.uploadCreated(), ShouldBeNil)
+			So(accessor.appends, ShouldEqual, 0)
+			content, err := ioutil.ReadFile(sourceFile)
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, "01234")
+		})
+	})
+
+	Convey("Remotes with MaxWriteBytes limit the size of written files", t, func() {
+		maxSource := filepath.Join(tmpdir, "maxSource")
+		os.MkdirAll(maxSource, os.FileMode(0777))
+		defer os.RemoveAll(maxSource)
+
+		accessor := &localAccessor{target: maxSource}
+		_, err := newRemote(&RemoteConfig{Accessor: accessor, Write: true, MaxWriteBytes: -1}, cacheBase, 1, pkgLogger)
+		So(err, ShouldNotBeNil)
+		fs, r := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "maxMount"), CacheBase: cacheBase}, &RemoteConfig{Accessor: accessor, CacheData: true, Write: true, MaxWriteBytes: 10})
+
+		file, status := fs.Create("big.file", uint32(os.O_RDWR|os.O_CREATE), 0644, nil)
+		So(status, ShouldEqual, fuse.OK)
+		n, status := file.Write([]byte("12345678"), 0)
+		So(status, ShouldEqual, fuse.OK)
+		So(n, ShouldEqual, 8)
+		_, status = file.Write([]byte("9abcd"), 8)
+		So(status, ShouldEqual, fuse.Status(syscall.EFBIG))
+		So(file.Truncate(11), ShouldEqual, fuse.Status(syscall.EFBIG))
+		So(file.Truncate(10), ShouldEqual, fuse.OK)
+		file.Release()
+
+		Convey("Files that are too large aren't uploaded", func() {
+			localPath := r.getLocalPath(r.getRemotePath("big.file"))
+			So(ioutil.WriteFile(localPath, make([]byte, 11), 0644), ShouldBeNil)
+			So(fs.uploadCreated(), ShouldNotBeNil)
+			_, err = os.Stat(filepath.Join(maxSource, "big.file"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+
+			So(ioutil.WriteFile(localPath, make([]byte, 10), 0644), ShouldBeNil)
+			So(fs.uploadCreated(), ShouldBeNil)
+			info, err := os.Stat(filepath.Join(maxSource, "big.file"))
+			So(err, ShouldBeNil)
+			So(info.Size(), ShouldEqual, 10)
+		})
+	})
+
+	Convey("UploadIncludeGlobs and UploadExcludeGlobs limit what gets uploaded", t, func() {
+		_, err := New(&Config{Mount: filepath.Join(tmpdir, "uploadGlobMount"), CacheBase: cacheBase, UploadExcludeGlobs: []string{"["}})
+		So(err, ShouldNotBeNil)
+
+		globSource := filepath.Join(tmpdir, "uploadGlobSource")
+		os.MkdirAll(filepath.Join(globSource, "sub"), os.FileMode(0777))
+		defer os.RemoveAll(globSource)
+
+		fs, r := mountTestFS(&Config{
+			Mount:              filepath.Join(tmpdir, "uploadGlobMount"),
+			CacheBase:          cacheBase,
+			UploadIncludeGlobs: []string{"*.txt", "sub/*"},
+			UploadExcludeGlobs: []string{"scratch.*"},
+		}, &RemoteConfig{Accessor: &localAccessor{target: globSource}, CacheData: true, Write: true})
+
+		names := []string{"out.txt", "out.dat", "scratch.txt", "sub/out.dat", "sub/scratch.dat"}
+		for _, name := range names {
+			file, status := fs.Create(name, uint32(os.O_RDWR|os.O_CREATE), 0644, nil)
+			So(status, ShouldEqual, fuse.OK)
+			_, status = file.Write([]byte("data"), 0)
+			So(status, ShouldEqual, fuse.OK)
+			file.Release()
+		}
+
+		So(fs.uploadCreated(), ShouldBeNil)
+		So(fs.createdFiles, ShouldBeEmpty)
+		for _, name := range names {
+			_, err = os.Stat(filepath.Join(globSource, name))
+			uploaded := name == "out.txt" || name == "sub/out.dat"
+			So(err == nil, ShouldEqual, uploaded)
+			_, err = os.Stat(r.getLocalPath(r.getRemotePath(name)))
+			So(err == nil, ShouldEqual, uploaded)
+		}
+	})
+
+	Convey("Remotes with StreamWrites upload created files without caching them", t, func() {
+		streamSource := filepath.Join(tmpdir, "streamSource")
+		os.MkdirAll(streamSource, os.FileMode(0777))
+		defer os.RemoveAll(streamSource)
+		err := ioutil.WriteFile(filepath.Join(streamSource, "old.file"), []byte("old content"), 0644)
+		So(err, ShouldBeNil)
+
+		accessor := &localAccessor{target: streamSource}
+		_, err = newRemote(&RemoteConfig{Accessor: accessor, StreamWrites: true}, cacheBase, 1, pkgLogger)
+		So(err, ShouldNotBeNil)
+		fs, r := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "streamMount"), CacheBase: cacheBase}, &RemoteConfig{Accessor: accessor, CacheData: true, Write: true, StreamWrites: true})
+
+		_, status := fs.Create("rw.file", uint32(os.O_RDWR|os.O_CREATE), 0644, nil)
+		So(status, ShouldEqual, fuse.ENOSYS)
+
+		file, status := fs.Create("new.file", uint32(os.O_WRONLY|os.O_CREATE), 0644, nil)
+		So(status, ShouldEqual, fuse.OK)
+		n, status := file.Write([]byte("abc"), 0)
+		So(status, ShouldEqual, fuse.OK)
+		So(n, ShouldEqual, 3)
+		_, status = file.Write([]byte("xyz"), 1)
+		So(status, ShouldEqual, fuse.ENOSYS)
+		_, status = file.Write([]byte("def"), 3)
+		So(status, ShouldEqual, fuse.OK)
+		So(file.Flush(), ShouldEqual, fuse.OK)
+		file.Release()
+
+		content, err := ioutil.ReadFile(filepath.Join(streamSource, "new.file"))
+		So(err, ShouldBeNil)
+		So(string(content), ShouldEqual, "abcdef")
+		_, err = os.Stat(r.getLocalPath(r.getRemotePath("new.file")))
+		So(os.IsNotExist(err), ShouldBeTrue)
+		attr, status := fs.GetAttr("new.file", nil)
+		So(status, ShouldEqual, fuse.OK)
+		So(attr.Size, ShouldEqual, 6)
+
+		Convey("Existing files can be replaced, but not appended to", func() {
+			_, status := fs.Open("old.file", uint32(os.O_WRONLY|os.O_APPEND), nil)
+			So(status, ShouldEqual, fuse.ENOSYS)
+
+			file, status := fs.Open("old.file", uint32(os.O_WRONLY|os.O_TRUNC), nil)
+			So(status, ShouldEqual, fuse.OK)
+			_, status = file.Write([]byte("new"), 0)
+			So(status, ShouldEqual, fuse.OK)
+			So(file.Flush(), ShouldEqual, fuse.OK)
+			file.Release()
+
+			content, err := ioutil.ReadFile(filepath.Join(streamSource, "old.file"))
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, "new")
+			So(fs.uploadCreated(), ShouldBeNil)
+			content, err = ioutil.ReadFile(filepath.Join(streamSource, "old.file"))
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, "new")
+		})
+	})
+
+	Convey("Remotes with a SharedCache only download the missing end of grown files", t, func() {
+		sharedSource := filepath.Join(tmpdir, "sharedSource")
+		os.MkdirAll(sharedSource, os.FileMode(0777))
+		defer os.RemoveAll(sharedSource)
+		sharedCache := filepath.Join(tmpdir, "sharedCache")
+		defer os.RemoveAll(sharedCache)
+		sourceFile := filepath.Join(sharedSource, "growing.file")
+		err := ioutil.WriteFile(sourceFile, []byte("abc"), 0644)
+		So(err, ShouldBeNil)
+
+		accessor := &localAccessor{target: sharedSource}
+		_, err = newRemote(&RemoteConfig{Accessor: accessor, SharedCache: true}, cacheBase, 1, pkgLogger)
+		So(err, ShouldNotBeNil)
+		_, err = newRemote(&RemoteConfig{Accessor: accessor, CacheDir: sharedCache, SharedCache: true, Write: true}, cacheBase, 1, pkgLogger)
+		So(err, ShouldNotBeNil)
+
+		read := func(shared bool) string {
+			fs, _ := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "sharedMount"), CacheBase: cacheBase}, &RemoteConfig{Accessor: accessor, CacheDir: sharedCache, SharedCache: shared})
+
+			// like the kernel, don't read beyond the size we report
+			attr, status := fs.GetAttr("growing.file", nil)
+			So(status, ShouldEqual, fuse.OK)
+			file, status := fs.Open("growing.file", uint32(os.O_RDONLY), nil)
+			So(status, ShouldEqual, fuse.OK)
+			defer file.Release()
+			rr, status := file.Read(make([]byte, attr.Size), 0)
+			So(status, ShouldEqual, fuse.OK)
+			b, _ := rr.Bytes(make([]byte, attr.Size))
+			return string(b)
+		}
+		So(read(true), ShouldEqual, "abc")
+
+		// alter what's cached so we can tell if it gets downloaded again
+		localPath := filepath.Join(sharedCache, sourceFile)
+		err = ioutil.WriteFile(localPath, []byte("xyz"), 0644)
+		So(err, ShouldBeNil)
+		err = ioutil.WriteFile(sourceFile, []byte("abcdef"), 0644)
+		So(err, ShouldBeNil)
+		So(read(true), ShouldEqual, "xyzdef")
+
+		err = ioutil.WriteFile(sourceFile, []byte("abcd"), 0644)
+		So(err, ShouldBeNil)
+		So(read(true), ShouldEqual, "xyzd")
+
+		Convey("Without SharedCache, the whole file is downloaded again", func() {
+			err = ioutil.WriteFile(sourceFile, []byte("abcdefgh"), 0644)
+			So(err, ShouldBeNil)
+			So(read(false), ShouldEqual, "abcdefgh")
+		})
+	})
+
+	Convey("Files modified in place only have their modified parts uploaded", t, func() {
+		partialSource := filepath.Join(tmpdir, "partialSource")
+		os.MkdirAll(partialSource, os.FileMode(0777))
+		defer os.RemoveAll(partialSource)
+		sourceFile := filepath.Join(partialSource, "a.file")
+		partialCache := filepath.Join(tmpdir, "partialCache")
+
+		modify := func(config *RemoteConfig, modify func(file nodefs.File)) string {
+			defer os.RemoveAll(partialCache)
+			err := ioutil.WriteFile(sourceFile, []byte("0123456789abcdefghij"), 0644)
+			So(err, ShouldBeNil)
+
+			if !config.CacheData {
+				config.CacheDir = partialCache
+			}
+			config.Write = true
+			fs, r := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "partialMount"), CacheBase: cacheBase}, config)
+
+			file, status := fs.Open("a.file", uint32(os.O_RDWR), nil)
+			So(status, ShouldEqual, fuse.OK)
+			modify(file)
+			file.Release()
+
+			So(fs.uploadCreated(), ShouldBeNil)
+			So(r.modified.intervals(r.getLocalPath(sourceFile)), ShouldBeEmpty)
+			content, err := ioutil.ReadFile(sourceFile)
+			So(err, ShouldBeNil)
+			return string(content)
+		}
+
+		write := func(data string, offset int64) func(file nodefs.File) {
+			return func(file nodefs.File) {
+				_, status := file.Write([]byte(data), offset)
+				So(status, ShouldEqual, fuse.OK)
+			}
+		}
+
+		accessor := &partialAccessor{localAccessor: &localAccessor{target: partialSource}}
+		content := modify(&RemoteConfig{Accessor: accessor}, func(file nodefs.File) {
+			write("XY", 2)(file)
+			write("Z", 15)(file)
+			write("W", 4)(file)
+		})
+		So(content, ShouldEqual, "01XYW56789abcdeZghij")
+		So(accessor.modified, ShouldResemble, []Intervals{{{2, 4}, {15, 15}}})
+		So(accessor.uploads, ShouldEqual, 0)
+
+		Convey("Truncating marks the removed bytes as modified", func() {
+			accessor = &partialAccessor{localAccessor: &localAccessor{target: partialSource}}
+			content = modify(&RemoteConfig{Accessor: accessor}, func(file nodefs.File) {
+				So(file.Truncate(18), ShouldEqual, fuse.OK)
+				write("KLM", 17)(file)
+			})
+			So(content, ShouldEqual, "0123456789abcdefgKLM")
+			So(accessor.modified, ShouldResemble, []Intervals{{{17, 19}}})
+		})
+
+		Convey("Files that changed size are uploaded in full", func() {
+			accessor = &partialAccessor{localAccessor: &localAccessor{target: partialSource}}
+			content = modify(&RemoteConfig{Accessor: accessor}, write("KLM", 19))
+			So(content, ShouldEqual, "0123456789abcdefghiKLM")
+			So(accessor.modified, ShouldBeEmpty)
+			So(accessor.uploads, ShouldEqual, 1)
+		})
+
+		Convey("Sparsely cached files can be partially uploaded", func() {
+			accessor = &partialAccessor{localAccessor: &localAccessor{target: partialSource}}
+			content = modify(&RemoteConfig{Accessor: accessor, CacheData: true}, write("XY", 2))
+			So(content, ShouldEqual, "01XY456789abcdefghij")
+			So(accessor.modified, ShouldResemble, []Intervals{{{2, 3}}})
+			So(accessor.uploads, ShouldEqual, 0)
+		})
+
+		Convey("Files changed remotely since they were cached are uploaded in full", func() {
+			accessor = &partialAccessor{localAccessor: &localAccessor{target: partialSource}}
+			content = modify(&RemoteConfig{Accessor: accessor}, func(file nodefs.File) {
+				write("XY", 2)(file)
+				So(ioutil.WriteFile(sourceFile, []byte("ABCDEFGHIJKLMNOPQRST"), 0644), ShouldBeNil)
+			})
+			So(content, ShouldEqual, "01XY456789abcdefghij")
+			So(accessor.modified, ShouldBeEmpty)
+			So(accessor.uploads, ShouldEqual, 1)
+		})
+
+		Convey("Partial uploads can be disabled", func() {
+			accessor = &partialAccessor{localAccessor: &localAccessor{target: partialSource}}
+			content = modify(&RemoteConfig{Accessor: accessor, DisablePartialUploads: true}, write("XY", 2))
+			So(content, ShouldEqual, "01XY456789abcdefghij")
+			So(accessor.modified, ShouldBeEmpty)
+			So(accessor.uploads, ShouldEqual, 1)
+		})
+	})
+
+	Convey("Interrupted uploads are resumed", t, func() {
+		resumeSource := filepath.Join(tmpdir, "resumeSource")
+		os.MkdirAll(resumeSource, os.FileMode(0777))
+		defer os.RemoveAll(resumeSource)
+		resumeCache := filepath.Join(tmpdir, "resumeCache")
+		defer os.RemoveAll(resumeCache)
+
+		fs, err := New(&Config{Mount: filepath.Join(tmpdir, "resumeMount"), CacheBase: cacheBase})
+		So(err, ShouldBeNil)
+		accessor := &resumableAccessor{localAccessor: &localAccessor{target: resumeSource}, fail: true}
+		r, err := newRemote(&RemoteConfig{Accessor: accessor, CacheDir: resumeCache, Write: true}, cacheBase, 1, fs.Logger)
+		So(err, ShouldBeNil)
+
+		remotePath := r.getRemotePath("a.file")
+		localPath := r.getLocalPath(remotePath)
+		So(os.MkdirAll(filepath.Dir(localPath), os.FileMode(0700)), ShouldBeNil)
+		So(ioutil.WriteFile(localPath, []byte("abc"), 0600), ShouldBeNil)
+
+		So(r.uploadFile(localPath, remotePath), ShouldEqual, fuse.EIO)
+		So(accessor.resumed, ShouldResemble, []*UploadState{nil})
+		_, err = os.Stat(uploadRecordPath(localPath))
+		So(err, ShouldBeNil)
+
+		Convey("Resuming uses the recorded state and then forgets it", func() {
+			accessor.fail = false
+			So(r.uploadFile(localPath, remotePath), ShouldEqual, fuse.OK)
+			So(accessor.resumed, ShouldHaveLength, 2)
+			So(accessor.resumed[1], ShouldResemble, &UploadState{UploadID: "up1", Parts: []UploadedPart{{Number: 1, ETag: "p1"}}})
+			So(accessor.aborted, ShouldBeEmpty)
+			content, err := ioutil.ReadFile(remotePath)
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, "abc")
+			_, err = os.Stat(uploadRecordPath(localPath))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("Uploads that can be cancelled are still resumed", func() {
+			accessor.fail = false
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			So(r.uploadFileContext(ctx, localPath, remotePath), ShouldEqual, fuse.OK)
+			So(accessor.resumed, ShouldHaveLength, 2)
+			So(accessor.resumed[1], ShouldResemble, &UploadState{UploadID: "up1", Parts: []UploadedPart{{Number: 1, ETag: "p1"}}})
+		})
+
+		Convey("If the local file changed, the old upload is aborted", func() {
+			accessor.fail = false
+			So(ioutil.WriteFile(localPath, []byte("abcd"), 0600), ShouldBeNil)
+			So(r.uploadFile(localPath, remotePath), ShouldEqual, fuse.OK)
+			So(accessor.aborted, ShouldResemble, []string{"up1"})
+			So(accessor.resumed, ShouldResemble, []*UploadState{nil, nil})
+			_, err = os.Stat(uploadRecordPath(localPath))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+	})
+
+	Convey("Simultaneous reads of the same uncached data only read it once", t, func() {
+		slowSource := filepath.Join(tmpdir, "slowSource")
+		os.MkdirAll(slowSource, os.FileMode(0777))
+		defer os.RemoveAll(slowSource)
+		content := []byte("0123456789abcdefghij")
+		err := ioutil.WriteFile(filepath.Join(slowSource, "a.file"), content, 0644)
+		So(err, ShouldBeNil)
+
+		accessor := &slowAccessor{localAccessor: &localAccessor{target: slowSource}}
+		fs, r := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "slowMount"), CacheBase: cacheBase}, &RemoteConfig{Accessor: accessor, CacheData: true})
+		r.readBlockSize = 0 // (so reads aren't rounded up to whole blocks)
+
+		read := func(offset, length int64) ([]byte, fuse.Status) {
+			file, status := fs.Open("a.file", uint32(os.O_RDONLY), nil)
+			if status != fuse.OK {
+				return nil, status
+			}
+			defer file.Release()
+			rr, status := file.Read(make([]byte, length), offset)
+			if status != fuse.OK {
+				return nil, status
+			}
+			b, status := rr.Bytes(make([]byte, length))
+			return append([]byte(nil), b...), status
+		}
+
+		var wg sync.WaitGroup
+		results := make([][]byte, 2)
+		statuses := make([]fuse.Status, 2)
+		for i := range results {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i], statuses[i] = read(5, 10)
+			}(i)
+		}
+		wg.Wait()
+
+		for i := range results {
+			So(statuses[i], ShouldEqual, fuse.OK)
+			So(string(results[i]), ShouldEqual, string(content[5:15]))
+		}
+		So(atomic.LoadInt32(&accessor.opens), ShouldEqual, 1)
+		So(r.inflight, ShouldBeEmpty)
+
+		Convey("Overlapping reads only read the part not already being read", func() {
+			var got []byte
+			var status fuse.Status
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				got, status = read(0, 20)
+			}()
+			b, s := read(12, 5)
+			wg.Wait()
+
+			So(s, ShouldEqual, fuse.OK)
+			So(string(b), ShouldEqual, string(content[12:17]))
+			So(status, ShouldEqual, fuse.OK)
+			So(string(got), ShouldEqual, string(content))
+			So(atomic.LoadInt32(&accessor.opens), ShouldBeBetweenOrEqual, 2, 3)
+			So(r.Uncached(r.getLocalPath(r.getRemotePath("a.file")), NewInterval(0, 20)), ShouldBeEmpty)
+		})
+	})
+
+	Convey("A new MuxFys is not Mounted(), and WaitUnmounted() doesn't block", t, func() {
+		fs, err := New(&Config{Mount: filepath.Join(tmpdir, "lifecycleMount"), CacheBase: cacheBase})
+		So(err, ShouldBeNil)
+		So(fs.Mounted(), ShouldBeFalse)
+		closed := false
+		select {
+		case <-fs.WaitUnmounted():
+			closed = true
+		case <-time.After(time.Second):
+		}
+		So(closed, ShouldBeTrue)
+	})
+
+	Convey("Uncached reads fetch whole blocks of the Config's ReadBlockSize", t, func() {
+		for _, size := range []int{1, 2048, 6000, 2 * maxReadBlockSize} {
+			_, err := New(&Config{Mount: filepath.Join(tmpdir, "blockMount"), CacheBase: cacheBase, ReadBlockSize: size})
+			So(err, ShouldNotBeNil)
+		}
+
+		blockSource := filepath.Join(tmpdir, "blockSource")
+		os.MkdirAll(blockSource, os.FileMode(0777))
+		defer os.RemoveAll(blockSource)
+		content := []byte(strings.Repeat("0123456789", 1000))
+		err := ioutil.WriteFile(filepath.Join(blockSource, "a.file"), content, 0644)
+		So(err, ShouldBeNil)
+
+		fs, err := New(&Config{Mount: filepath.Join(tmpdir, "blockMount"), CacheBase: cacheBase})
+		So(err, ShouldBeNil)
+		So(fs.StatFs("").Bsize, ShouldEqual, defaultReadBlockSize)
+
+		fs, r := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "blockMount"), CacheBase: cacheBase, ReadBlockSize: 4096}, &RemoteConfig{Accessor: &localAccessor{target: blockSource}, CacheData: true})
+		So(fs.StatFs("").Bsize, ShouldEqual, 4096)
+		So(r.readBlockSize, ShouldEqual, 4096)
+
+		file, status := fs.Open("a.file", uint32(os.O_RDONLY), nil)
+		So(status, ShouldEqual, fuse.OK)
+		defer file.Release()
+		rr, status := file.Read(make([]byte, 4), 5000)
+		So(status, ShouldEqual, fuse.OK)
+		b, _ := rr.Bytes(make([]byte, 4))
+		So(string(b), ShouldEqual, "0123")
+
+		localPath := r.getLocalPath(r.getRemotePath("a.file"))
+		So(r.Uncached(localPath, NewInterval(0, 10000)), ShouldResemble, Intervals{{0, 4095}, {8192, 9999}})
+
+		_, status = file.Read(make([]byte, 4), 9000)
+		So(status, ShouldEqual, fuse.OK)
+		So(r.Uncached(localPath, NewInterval(0, 10000)), ShouldResemble, Intervals{{0, 4095}})
+	})
+
+	Convey("You can List() the contents of a RemoteConfig without mounting", t, func() {
+		listSource := filepath.Join(tmpdir, "listSource")
+		os.MkdirAll(filepath.Join(listSource, "sub", "deeper"), os.FileMode(0777))
+		defer os.RemoveAll(listSource)
+		err := ioutil.WriteFile(filepath.Join(listSource, "a.file"), []byte("aa"), 0644)
+		So(err, ShouldBeNil)
+		err = ioutil.WriteFile(filepath.Join(listSource, "sub", "b.file"), []byte("bbb"), 0644)
+		So(err, ShouldBeNil)
+		err = ioutil.WriteFile(filepath.Join(listSource, "sub", "deeper", "c.file"), []byte("c"), 0644)
+		So(err, ShouldBeNil)
+
+		rc := &RemoteConfig{Accessor: &localAccessor{target: listSource}, CacheData: true, Write: true}
+		names := func(ras []RemoteAttr) map[string]int64 {
+			m := make(map[string]int64)
+			for _, ra := range ras {
+				m[ra.Name] = ra.Size
+			}
+			return m
+		}
+
+		ras, err := rc.List(false)
+		So(err, ShouldBeNil)
+		So(len(ras), ShouldEqual, 2)
+		m := names(ras)
+		So(m, ShouldContainKey, "sub/")
+		So(m["a.file"], ShouldEqual, 2)
+		for _, ra := range ras {
+			if ra.Name == "a.file" {
+				So(ra.MTime.IsZero(), ShouldBeFalse)
+			}
+		}
+
+		ras, err = rc.List(true)
+		So(err, ShouldBeNil)
+		So(len(ras), ShouldEqual, 5)
+		m = names(ras)
+		So(m, ShouldContainKey, "sub/deeper/")
+		So(m["sub/b.file"], ShouldEqual, 3)
+		So(m["sub/deeper/c.file"], ShouldEqual, 1)
+
+		// nothing was cached
+		matches, err := filepath.Glob(filepath.Join(tmpdir, tmpCachePrefix+"*"))
+		So(err, ShouldBeNil)
+		So(matches, ShouldBeEmpty)
+
+		_, err = (&RemoteConfig{Accessor: &localAccessor{target: filepath.Join(tmpdir, "missing")}}).List(true)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("RemoteConfig.List(true) lists a deep tree within ListConcurrency", t, func() {
+		ma := NewMemoryAccessor("deep")
+		for i := 0; i < 50; i++ {
+			for j := 0; j < 40; j++ {
+				ma.Put(fmt.Sprintf("d%d/s%d/f", i, j), []byte("f"))
+			}
+		}
+		ca := &concurrentListAccessor{RemoteAccessor: ma, delay: time.Millisecond}
+
+		_, err := (&RemoteConfig{Accessor: ca, ListConcurrency: -1}).List(true)
+		So(err, ShouldNotBeNil)
+
+		ras, err := (&RemoteConfig{Accessor: ca, ListConcurrency: 4}).List(true)
+		So(err, ShouldBeNil)
+		So(len(ras), ShouldEqual, 50+50*40*2)
+		So(ca.max, ShouldBeGreaterThan, 1)
+		So(ca.max, ShouldBeLessThanOrEqualTo, 4)
+
+		ca.max = 0
+		serial, err := (&RemoteConfig{Accessor: ca, ListConcurrency: 1}).List(true)
+		So(err, ShouldBeNil)
+		So(ca.max, ShouldEqual, 1)
+		So(serial, ShouldResemble, ras)
+
+		ca.max = 0
+		_, err = (&RemoteConfig{Accessor: ca}).List(true)
+		So(err, ShouldBeNil)
+		So(ca.max, ShouldBeLessThanOrEqualTo, defaultListConcurrency)
+	})
+
+	Convey("You can CopyTreeTo() another RemoteConfig without mounting", t, func() {
+		copySource := filepath.Join(tmpdir, "copySource")
+		os.MkdirAll(filepath.Join(copySource, "sub", "deeper"), os.FileMode(0777))
+		defer os.RemoveAll(copySource)
+		files := map[string]string{"a.file": "aa", "sub/b.file": "bbb", "sub/deeper/c.file": "c"}
+		for name, content := range files {
+			err := ioutil.WriteFile(filepath.Join(copySource, name), []byte(content), 0644)
+			So(err, ShouldBeNil)
+		}
+		rc := &RemoteConfig{Accessor: &localAccessor{target: copySource}}
+
+		copyDest := filepath.Join(tmpdir, "copyDest")
+		defer os.RemoveAll(copyDest)
+		copied := func() map[string]string {
+			m := make(map[string]string)
+			err := filepath.Walk(copyDest, func(path string, info os.FileInfo, err error) error {
+				if err != nil || info.IsDir() {
+					return err
+				}
+				content, err := ioutil.ReadFile(path)
+				m[path[len(copyDest)+1:]] = string(content)
+				return err
+			})
+			So(err, ShouldBeNil)
+			return m
+		}
+
+		So(rc.CopyTreeTo(nil), ShouldNotBeNil)
+
+		Convey("By streaming files", func() {
+			err := rc.CopyTreeTo(&RemoteConfig{Accessor: &localAccessor{target: copyDest}})
+			So(err, ShouldBeNil)
+			So(copied(), ShouldResemble, files)
+		})
+
+		Convey("By copying files remotely", func() {
+			accessor := &copierAccessor{localAccessor: &localAccessor{target: copyDest}}
+			err := rc.CopyTreeTo(&RemoteConfig{Accessor: accessor})
+			So(err, ShouldBeNil)
+			So(copied(), ShouldResemble, files)
+			So(accessor.copies, ShouldEqual, 3)
+		})
+
+		Convey("Failures are reported per file", func() {
+			accessor := &copierAccessor{localAccessor: &localAccessor{target: copyDest}}
+			accessor.fail = map[string]bool{accessor.RemotePath("sub/b.file"): true}
+			err := rc.CopyTreeTo(&RemoteConfig{Accessor: accessor})
+			So(err, ShouldNotBeNil)
+			cte, ok := err.(CopyTreeError)
+			So(ok, ShouldBeTrue)
+			So(len(cte), ShouldEqual, 1)
+			So(cte, ShouldContainKey, "sub/b.file")
+			So(err.Error(), ShouldEqual, "failed to copy 1 files: sub/b.file: copy failed")
+			So(copied(), ShouldResemble, map[string]string{"a.file": "aa", "sub/deeper/c.file": "c"})
+		})
+	})
+
+	Convey("IncludeGlobs and ExcludeGlobs limit the files that appear", t, func() {
+		globSource := filepath.Join(tmpdir, "globSource")
+		os.MkdirAll(filepath.Join(globSource, "sub", "deeper"), os.FileMode(0777))
+		defer os.RemoveAll(globSource)
+		for _, name := range []string{"a.bam", "a.bai", "b.txt", "sub/c.bam", "sub/deeper/d.bam", "sub/d.txt"} {
+			err := ioutil.WriteFile(filepath.Join(globSource, name), []byte("data"), 0644)
+			So(err, ShouldBeNil)
+		}
+
+		rc := &RemoteConfig{Accessor: &localAccessor{target: globSource}, IncludeGlobs: []string{"*.bam", "*.bai"}, ExcludeGlobs: []string{"sub/*.bam"}}
+		_, err := newRemote(&RemoteConfig{Accessor: rc.Accessor, IncludeGlobs: []string{"["}}, cacheBase, 1, pkgLogger)
+		So(err, ShouldNotBeNil)
+
+		fs, _ := newTestFS(&Config{Mount: filepath.Join(tmpdir, "globMount"), CacheBase: cacheBase}, rc)
+
+		entryNames := func(dir string) []string {
+			entries, status := fs.OpenDir(dir, nil)
+			So(status, ShouldEqual, fuse.OK)
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Name)
+			}
+			sort.Strings(names)
+			return names
+		}
+		So(entryNames(""), ShouldResemble, []string{"a.bai", "a.bam", "sub"})
+		So(entryNames("sub"), ShouldResemble, []string{"deeper"})
+		So(entryNames("sub/deeper"), ShouldResemble, []string{"d.bam"})
+
+		_, status := fs.GetAttr("b.txt", nil)
+		So(status, ShouldEqual, fuse.ENOENT)
+		_, status = fs.Open("sub/c.bam", uint32(os.O_RDONLY), nil)
+		So(status, ShouldEqual, fuse.ENOENT)
+
+		ras, err := rc.List(true)
+		So(err, ShouldBeNil)
+		var names []string
+		for _, ra := range ras {
+			names = append(names, ra.Name)
+		}
+		sort.Strings(names)
+		So(names, ShouldResemble, []string{"a.bai", "a.bam", "sub/", "sub/deeper/", "sub/deeper/d.bam"})
+	})
+
+	Convey("RefreshDir() lists a single directory again", t, func() {
+		refreshSource := filepath.Join(tmpdir, "refreshSource")
+		os.MkdirAll(filepath.Join(refreshSource, "run42"), os.FileMode(0777))
+		os.MkdirAll(filepath.Join(refreshSource, "run43"), os.FileMode(0777))
+		defer os.RemoveAll(refreshSource)
+		err := ioutil.WriteFile(filepath.Join(refreshSource, "run42", "a.file"), []byte("a"), 0644)
+		So(err, ShouldBeNil)
+		err = ioutil.WriteFile(filepath.Join(refreshSource, "run43", "c.file"), []byte("c"), 0644)
+		So(err, ShouldBeNil)
+
+		fs, _ := newTestFS(&Config{Mount: filepath.Join(tmpdir, "refreshMount"), CacheBase: cacheBase, NegativeCacheTTL: time.Minute}, &RemoteConfig{Accessor: &localAccessor{target: refreshSource}, CacheData: true, Write: true})
+
+		entryNames := func(dir string) []string {
+			entries, status := fs.OpenDir(dir, nil)
+			So(status, ShouldEqual, fuse.OK)
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Name)
+			}
+			sort.Strings(names)
+			return names
+		}
+		So(entryNames(""), ShouldResemble, []string{"run42", "run43"})
+		So(entryNames("run42"), ShouldResemble, []string{"a.file"})
+		So(entryNames("run43"), ShouldResemble, []string{"c.file"})
+		_, status := fs.GetAttr("run42/b.file", nil)
+		So(status, ShouldEqual, fuse.ENOENT)
+
+		file, status := fs.Create("run42/new.file", uint32(os.O_RDWR|os.O_CREATE), 0644, nil)
+		So(status, ShouldEqual, fuse.OK)
+		file.Release()
+
+		err = ioutil.WriteFile(filepath.Join(refreshSource, "run42", "b.file"), []byte("bb"), 0644)
+		So(err, ShouldBeNil)
+		err = os.Remove(filepath.Join(refreshSource, "run42", "a.file"))
+		So(err, ShouldBeNil)
+		err = ioutil.WriteFile(filepath.Join(refreshSource, "run43", "d.file"), []byte("d"), 0644)
+		So(err, ShouldBeNil)
+		So(entryNames("run42"), ShouldResemble, []string{"a.file", "new.file"})
+
+		So(fs.RefreshDir("/run42/"), ShouldBeNil)
+		So(entryNames("run42"), ShouldResemble, []string{"b.file", "new.file"})
+		attr, status := fs.GetAttr("run42/b.file", nil)
+		So(status, ShouldEqual, fuse.OK)
+		So(attr.Size, ShouldEqual, 2)
+		So(entryNames("run43"), ShouldResemble, []string{"c.file"})
+
+		So(fs.RefreshDir("missing"), ShouldNotBeNil)
+	})
+
+	Convey("CacheMemoryStats() reports what we have cached in memory", t, func() {
+		memSource := filepath.Join(tmpdir, "memStatsSource")
+		os.MkdirAll(filepath.Join(memSource, "dir"), os.FileMode(0777))
+		defer os.RemoveAll(memSource)
+		for _, name := range []string{"a.file", "b.file", filepath.Join("dir", "c.file")} {
+			err := ioutil.WriteFile(filepath.Join(memSource, name), []byte("a"), 0644)
+			So(err, ShouldBeNil)
+		}
+
+		config := &Config{Mount: filepath.Join(tmpdir, "memStatsMount"), CacheBase: cacheBase}
+		empty, err := New(config)
+		So(err, ShouldBeNil)
+		So(empty.CacheMemoryStats(), ShouldResemble, MemStats{})
+
+		fs, _ := newTestFS(config, &RemoteConfig{Accessor: &localAccessor{target: memSource}})
+
+		_, status := fs.OpenDir("", nil)
+		So(status, ShouldEqual, fuse.OK)
+		ms := fs.CacheMemoryStats()
+		So(ms.Files, ShouldEqual, 2)
+		So(ms.Dirs, ShouldEqual, 2)
+		So(ms.DirListings, ShouldEqual, 1)
+		So(ms.DirEntries, ShouldEqual, 3)
+		So(ms.MissingPaths, ShouldEqual, 0)
+		So(ms.Bytes, ShouldBeGreaterThan, 2*attrSize)
+
+		_, status = fs.OpenDir("dir", nil)
+		So(status, ShouldEqual, fuse.OK)
+		ms2 := fs.CacheMemoryStats()
+		So(ms2.Files, ShouldEqual, 3)
+		So(ms2.DirListings, ShouldEqual, 2)
+		So(ms2.DirEntries, ShouldEqual, 4)
+		So(ms2.Bytes, ShouldBeGreaterThan, ms.Bytes+attrSize)
+	})
+
+	Convey("Xattrs() returns the metadata of remote files", t, func() {
+		xattrSource := filepath.Join(tmpdir, "xattrSource")
+		os.MkdirAll(filepath.Join(xattrSource, "sub"), os.FileMode(0777))
+		defer os.RemoveAll(xattrSource)
+		err := ioutil.WriteFile(filepath.Join(xattrSource, "a.file"), []byte("a"), 0644)
+		So(err, ShouldBeNil)
+
+		accessor := &statAccessor{localAccessor: &localAccessor{target: xattrSource}, metadata: map[string]string{"sample": "s1"}}
+		fs, r := newTestFS(&Config{Mount: filepath.Join(tmpdir, "xattrMount"), CacheBase: cacheBase}, &RemoteConfig{Accessor: accessor, CacheData: true, Write: true})
+
+		metadata, err := fs.Xattrs("/a.file")
+		So(err, ShouldBeNil)
+		So(metadata, ShouldResemble, map[string]string{"sample": "s1"})
+
+		_, err = fs.Xattrs("sub")
+		So(err, ShouldNotBeNil)
+		_, err = fs.Xattrs("missing.file")
+		So(err, ShouldNotBeNil)
+
+		file, status := fs.Create("new.file", uint32(os.O_RDWR|os.O_CREATE), 0644, nil)
+		So(status, ShouldEqual, fuse.OK)
+		file.Release()
+		metadata, err = fs.Xattrs("new.file")
+		So(err, ShouldBeNil)
+		So(metadata, ShouldBeEmpty)
+
+		Convey("Accessors that aren't FileStaters give no metadata", func() {
+			r.accessor = accessor.localAccessor
+			metadata, err = fs.Xattrs("a.file")
+			So(err, ShouldBeNil)
+			So(metadata, ShouldBeEmpty)
+		})
+	})
+
+	Convey("ReadAt() reads byte ranges of files without FUSE", t, func() {
+		readAtSource := filepath.Join(tmpdir, "readAtSource")
+		os.MkdirAll(filepath.Join(readAtSource, "sub"), os.FileMode(0777))
+		defer os.RemoveAll(readAtSource)
+		err := ioutil.WriteFile(filepath.Join(readAtSource, "a.file"), []byte("0123456789"), 0644)
+		So(err, ShouldBeNil)
+
+		for _, cacheData := range []bool{false, true} {
+			fs, r := newTestFS(&Config{Mount: filepath.Join(tmpdir, "readAtMount"), CacheBase: cacheBase}, &RemoteConfig{Accessor: &localAccessor{target: readAtSource}, CacheData: cacheData})
+
+			p := make([]byte, 4)
+			n, err := fs.ReadAt("a.file", p, 3)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 4)
+			So(string(p), ShouldEqual, "3456")
+
+			n, err = fs.ReadAt("/a.file", p, 8)
+			So(err, ShouldEqual, io.EOF)
+			So(n, ShouldEqual, 2)
+			So(string(p[:n]), ShouldEqual, "89")
+
+			n, err = fs.ReadAt("a.file", p, 10)
+			So(err, ShouldEqual, io.EOF)
+			So(n, ShouldEqual, 0)
+
+			_, err = fs.ReadAt("a.file", p, -1)
+			So(err, ShouldNotBeNil)
+			_, err = fs.ReadAt("sub", p, 0)
+			So(err, ShouldNotBeNil)
+			_, err = fs.ReadAt("missing.file", p, 0)
+			So(err, ShouldNotBeNil)
+
+			if cacheData {
+				So(r.Uncached(r.getLocalPath(r.getRemotePath("a.file")), NewInterval(3, 4)), ShouldBeEmpty)
+			}
+		}
+	})
+
+	Convey("AllowRecursiveRmdir lets non-empty directories be removed", t, func() {
+		rmdirSource := filepath.Join(tmpdir, "rmdirSource")
+		os.MkdirAll(filepath.Join(rmdirSource, "d", "sub"), os.FileMode(0777))
+		defer os.RemoveAll(rmdirSource)
+		err := ioutil.WriteFile(filepath.Join(rmdirSource, "d", "a.file"), []byte("a"), 0644)
+		So(err, ShouldBeNil)
+		err = ioutil.WriteFile(filepath.Join(rmdirSource, "d", "sub", "b.file"), []byte("b"), 0644)
+		So(err, ShouldBeNil)
+		err = ioutil.WriteFile(filepath.Join(rmdirSource, "keep.file"), []byte("k"), 0644)
+		So(err, ShouldBeNil)
+
+		fs, r := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "rmdirMount"), CacheBase: cacheBase}, &RemoteConfig{Accessor: &localAccessor{target: rmdirSource}, CacheData: true, Write: true})
+		fs.mapMutex.Lock()
+		So(fs.openDir(r, "d"), ShouldEqual, fuse.OK)
+		So(fs.openDir(r, "d/sub"), ShouldEqual, fuse.OK)
+		fs.mapMutex.Unlock()
+
+		p := make([]byte, 1)
+		_, err = fs.ReadAt("d/sub/b.file", p, 0)
+		So(err, ShouldBeNil)
+		cachedPath := r.getLocalPath(r.getRemotePath("d/sub/b.file"))
+		So(r.Uncached(cachedPath, NewInterval(0, 1)), ShouldBeEmpty)
+		file, status := fs.Create("d/new.file", uint32(os.O_WRONLY|os.O_CREATE), 0644, nil)
+		So(status, ShouldEqual, fuse.OK)
+		_, status = file.Write([]byte("new"), 0)
+		So(status, ShouldEqual, fuse.OK)
+		file.Release()
+
+		So(fs.Rmdir("d", nil), ShouldEqual, fuse.ENOSYS)
+		_, err = os.Stat(filepath.Join(rmdirSource, "d", "a.file"))
+		So(err, ShouldBeNil)
+
+		fs.recursiveRmdir = true
+		So(fs.Rmdir("d", nil), ShouldEqual, fuse.OK)
+		_, err = os.Stat(filepath.Join(rmdirSource, "d"))
+		So(os.IsNotExist(err), ShouldBeTrue)
+		_, err = os.Stat(filepath.Join(rmdirSource, "keep.file"))
+		So(err, ShouldBeNil)
+		_, err = os.Stat(r.getLocalPath(r.getRemotePath("d")))
+		So(os.IsNotExist(err), ShouldBeTrue)
+		So(r.Uncached(cachedPath, NewInterval(0, 1)), ShouldNotBeEmpty)
+
+		_, status = fs.GetAttr("d/sub/b.file", nil)
+		So(status, ShouldEqual, fuse.ENOENT)
+		_, status = fs.GetAttr("d", nil)
+		So(status, ShouldEqual, fuse.ENOENT)
+		fs.mapMutex.RLock()
+		So(fs.createdFiles, ShouldBeEmpty)
+		_, known := fs.dirs["d/sub"]
+		fs.mapMutex.RUnlock()
+		So(known, ShouldBeFalse)
+
+		So(fs.uploadCreated(), ShouldBeNil)
+		_, err = os.Stat(filepath.Join(rmdirSource, "d"))
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+
+	Convey("CacheShard spreads cached files over sub-directories", t, func() {
+		shardSource := filepath.Join(tmpdir, "shardSource")
+		os.MkdirAll(filepath.Join(shardSource, "d"), os.FileMode(0777))
+		defer os.RemoveAll(shardSource)
+		err := ioutil.WriteFile(filepath.Join(shardSource, "d", "a.file"), []byte("a"), 0644)
+		So(err, ShouldBeNil)
+		err = ioutil.WriteFile(filepath.Join(shardSource, "d", "b.file"), []byte("b"), 0644)
+		So(err, ShouldBeNil)
+
+		fs, r := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "shardMount"), CacheBase: cacheBase, AllowRecursiveRmdir: true}, &RemoteConfig{Accessor: &localAccessor{target: shardSource}, CacheData: true, CacheShard: true, Write: true, OfflineReads: true})
+		fs.mapMutex.Lock()
+		So(fs.openDir(r, "d"), ShouldEqual, fuse.OK)
+		fs.mapMutex.Unlock()
+
+		pathA := r.getLocalPath(r.getRemotePath("d/a.file"))
+		pathB := r.getLocalPath(r.getRemotePath("d/b.file"))
+		relA, err := filepath.Rel(r.cacheDir, pathA)
+		So(err, ShouldBeNil)
+		So(relA, ShouldEndWith, filepath.Join(shardSource, "d", "a.file"))
+		So(relA, ShouldNotStartWith, shardSource[1:])
+		parts := strings.Split(relA, string(filepath.Separator))
+		So(len(parts[0]), ShouldEqual, 2)
+		So(len(parts[1]), ShouldEqual, 2)
+		So(pathA, ShouldEqual, r.getLocalPath(r.getRemotePath("d/a.file")))
+		So(filepath.Dir(pathA), ShouldNotEqual, filepath.Dir(pathB))
+
+		p := make([]byte, 1)
+		_, err = fs.ReadAt("d/a.file", p, 0)
+		So(err, ShouldBeNil)
+		So(string(p), ShouldEqual, "a")
+		content, err := ioutil.ReadFile(pathA)
+		So(err, ShouldBeNil)
+		So(string(content), ShouldEqual, "a")
+		So(r.Uncached(pathA, NewInterval(0, 1)), ShouldBeEmpty)
+
+		_, err = r.cachedObjects(r.getRemotePath("d") + "/")
+		So(err, ShouldNotBeNil)
+
+		file, status := fs.Create("d/new.file", uint32(os.O_WRONLY|os.O_CREATE), 0644, nil)
+		So(status, ShouldEqual, fuse.OK)
+		_, status = file.Write([]byte("new"), 0)
+		So(status, ShouldEqual, fuse.OK)
+		file.Release()
+		So(fs.Rename("d/new.file", "d/renamed.file", nil), ShouldEqual, fuse.OK)
+		pathRenamed := r.getLocalPath(r.getRemotePath("d/renamed.file"))
+		content, err = ioutil.ReadFile(pathRenamed)
+		So(err, ShouldBeNil)
+		So(string(content), ShouldEqual, "new")
+		So(fs.uploadCreated(), ShouldBeNil)
+		content, err = ioutil.ReadFile(filepath.Join(shardSource, "d", "renamed.file"))
+		So(err, ShouldBeNil)
+		So(string(content), ShouldEqual, "new")
+
+		So(fs.Rmdir("d", nil), ShouldEqual, fuse.OK)
+		_, err = os.Stat(filepath.Join(shardSource, "d"))
+		So(os.IsNotExist(err), ShouldBeTrue)
+		_, err = os.Stat(pathA)
+		So(os.IsNotExist(err), ShouldBeTrue)
+		_, err = os.Stat(pathRenamed)
+		So(os.IsNotExist(err), ShouldBeTrue)
+		So(r.Uncached(pathA, NewInterval(0, 1)), ShouldNotBeEmpty)
+	})
+
+	Convey("CreateWithMetadata() stores metadata with the uploaded file", t, func() {
+		metaSource := filepath.Join(tmpdir, "metaSource")
+		os.MkdirAll(metaSource, os.FileMode(0777))
+		defer os.RemoveAll(metaSource)
+
+		local := &localAccessor{target: metaSource}
+		config := &Config{Mount: filepath.Join(tmpdir, "metaMount"), CacheBase: cacheBase}
+		fs, _ := mountTestFS(config, &RemoteConfig{Accessor: local, CacheData: true, Write: true})
+
+		err := fs.CreateWithMetadata("a.file", map[string]string{"sample": "s1"})
+		So(err, ShouldNotBeNil)
+		So(errors.Is(err, syscall.ENOSYS), ShouldBeTrue)
+
+		ma := &metadataAccessor{localAccessor: local, metadata: make(map[string]map[string]string)}
+		fs, r := mountTestFS(config, &RemoteConfig{Accessor: ma, CacheData: true, Write: true})
+
+		meta := map[string]string{"sample": "s1"}
+		So(fs.CreateWithMetadata("/a.file", meta), ShouldBeNil)
+		meta["sample"] = "altered"
+		So(fs.CreateWithMetadata("b.file", map[string]string{"sample": "s2"}), ShouldBeNil)
+
+		attr, status := fs.GetAttr("a.file", nil)
+		So(status, ShouldEqual, fuse.OK)
+		So(attr.Size, ShouldEqual, 0)
+		file, status := fs.Open("a.file", uint32(os.O_WRONLY), nil)
+		So(status, ShouldEqual, fuse.OK)
+		_, status = file.Write([]byte("data"), 0)
+		So(status, ShouldEqual, fuse.OK)
+		file.Release()
+		So(fs.Rename("a.file", "renamed.file", nil), ShouldEqual, fuse.OK)
+		fs.Unlink("b.file", nil)
+		So(len(r.metadata), ShouldEqual, 1)
+
+		So(fs.uploadCreated(), ShouldBeNil)
+		content, err := ioutil.ReadFile(filepath.Join(metaSource, "renamed.file"))
+		So(err, ShouldBeNil)
+		So(string(content), ShouldEqual, "data")
+		So(ma.metadata, ShouldResemble, map[string]map[string]string{
+			filepath.Join(metaSource, "renamed.file"): {"sample": "s1"},
+		})
+		So(r.metadata, ShouldBeEmpty)
+	})
+
+	Convey("UploadFileMode is recorded with uploads and shown for created files", t, func() {
+		modeSource := filepath.Join(tmpdir, "modeSource")
+		os.MkdirAll(modeSource, os.FileMode(0777))
+		defer os.RemoveAll(modeSource)
+		err := ioutil.WriteFile(filepath.Join(modeSource, "old.file"), []byte("old"), 0644)
+		So(err, ShouldBeNil)
+
+		local := &localAccessor{target: modeSource}
+		ma := &metadataAccessor{localAccessor: local, metadata: make(map[string]map[string]string)}
+		_, err = newRemote(&RemoteConfig{Accessor: local, CacheData: true, Write: true, UploadFileMode: 0644}, cacheBase, 1, log15.New())
+		So(err, ShouldNotBeNil)
+		_, err = newRemote(&RemoteConfig{Accessor: ma, CacheData: true, UploadFileMode: 0644}, cacheBase, 1, log15.New())
+		So(err, ShouldNotBeNil)
+		_, err = newRemote(&RemoteConfig{Accessor: ma, CacheData: true, Write: true, UploadFileMode: os.ModeDir | 0644}, cacheBase, 1, log15.New())
+		So(err, ShouldNotBeNil)
+
+		fs, r := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "modeMount"), CacheBase: cacheBase}, &RemoteConfig{Accessor: ma, CacheData: true, Write: true, UploadFileMode: 0644})
+
+		attr, status := fs.GetAttr("old.file", nil)
+		So(status, ShouldEqual, fuse.OK)
+		So(attr.Mode, ShouldEqual, fuse.S_IFREG|0600)
+
+		file, status := fs.Create("new.file", uint32(os.O_WRONLY|os.O_CREATE), 0644, nil)
+		So(status, ShouldEqual, fuse.OK)
+		_, status = file.Write([]byte("new"), 0)
+		So(status, ShouldEqual, fuse.OK)
+		file.Release()
+		attr, status = fs.GetAttr("new.file", nil)
+		So(status, ShouldEqual, fuse.OK)
+		So(attr.Mode, ShouldEqual, fuse.S_IFREG|0644)
+
+		So(fs.CreateWithMetadata("meta.file", map[string]string{"sample": "s1"}), ShouldBeNil)
+		So(fs.uploadCreated(), ShouldBeNil)
+		So(ma.metadata, ShouldResemble, map[string]map[string]string{
+			filepath.Join(modeSource, "new.file"):  {"mode": "0644"},
+			filepath.Join(modeSource, "meta.file"): {"mode": "0644", "sample": "s1"},
+		})
+
+		fs.mapMutex.Lock()
+		fs.files = make(map[string]*fuse.Attr)
+		fs.fileToRemote = make(map[string]*remote)
+		fs.createdFiles = make(map[string]bool)
+		So(fs.openDir(r, ""), ShouldEqual, fuse.OK)
+		fs.mapMutex.Unlock()
+		attr, status = fs.GetAttr("new.file", nil)
+		So(status, ShouldEqual, fuse.OK)
+		So(attr.Mode, ShouldEqual, fuse.S_IFREG|0644)
+		attr, status = fs.GetAttr("old.file", nil)
+		So(status, ShouldEqual, fuse.OK)
+		So(attr.Mode, ShouldEqual, fuse.S_IFREG|0600)
+	})
+
+	Convey("Appends with UploadFileMode upload the whole file with its mode", t, func() {
+		mem := NewMemoryAccessor("appendMode")
+		mem.Put(mem.RemotePath("old.file"), []byte("old"))
+		ama := &appendMetadataAccessor{MemoryAccessor: mem, metadata: make(map[string]map[string]string)}
+
+		fs, _ := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "appendModeMount"), CacheBase: cacheBase}, &RemoteConfig{Accessor: ama, CacheData: true, Write: true, UploadFileMode: 0640})
+
+		file, status := fs.Open("old.file", uint32(os.O_WRONLY|os.O_APPEND), nil)
+		So(status, ShouldEqual, fuse.OK)
+		_, status = file.Write([]byte("+tail"), 3)
+		So(status, ShouldEqual, fuse.OK)
+		file.Release()
+		So(fs.uploadCreated(), ShouldBeNil)
+
+		content, exists := mem.Get(mem.RemotePath("old.file"))
+		So(exists, ShouldBeTrue)
+		So(string(content), ShouldEqual, "old+tail")
+		So(ama.appends, ShouldEqual, 0)
+		So(ama.metadata, ShouldResemble, map[string]map[string]string{
+			mem.RemotePath("old.file"): {"mode": "0640"},
+		})
+	})
+
+	Convey("Files opened O_RDWR can be read before being partially rewritten", t, func() {
+		rmwSource := filepath.Join(tmpdir, "rmwSource")
+		os.MkdirAll(rmwSource, os.FileMode(0777))
+		defer os.RemoveAll(rmwSource)
+
+		for _, cacheDir := range []string{"", filepath.Join(tmpdir, "rmwCache")} {
+			err := ioutil.WriteFile(filepath.Join(rmwSource, "a.file"), []byte("0123456789"), 0644)
+			So(err, ShouldBeNil)
+			err = ioutil.WriteFile(filepath.Join(rmwSource, "b.file"), []byte("abcdefghij"), 0644)
+			So(err, ShouldBeNil)
+			err = ioutil.WriteFile(filepath.Join(rmwSource, "c.file"), []byte("klmnopqrst"), 0644)
+			So(err, ShouldBeNil)
+			err = ioutil.WriteFile(filepath.Join(rmwSource, "d.file"), []byte("uvwxyz1234"), 0644)
+			So(err, ShouldBeNil)
+			err = ioutil.WriteFile(filepath.Join(rmwSource, "e.file"), []byte("ABCDEFGHIJ"), 0644)
+			So(err, ShouldBeNil)
+
+			fs, r := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "rmwMount"), CacheBase: cacheBase}, &RemoteConfig{Accessor: &localAccessor{target: rmwSource}, CacheData: true, CacheDir: cacheDir, Write: true})
+
+			// read-modify-write a file we never read before
+			_, status := fs.GetAttr("a.file", nil)
+			So(status, ShouldEqual, fuse.OK)
+			file, status := fs.Open("a.file", uint32(os.O_RDWR), nil)
+			So(status, ShouldEqual, fuse.OK)
+			buf := make([]byte, 10)
+			rr, status := file.Read(buf, 0)
+			So(status, ShouldEqual, fuse.OK)
+			b, _ := rr.Bytes(buf)
+			So(string(b), ShouldEqual, "0123456789")
+			rr.Done()
+			_, status = file.Write([]byte("XY"), 2)
+			So(status, ShouldEqual, fuse.OK)
+			rr, status = file.Read(buf, 0)
+			So(status, ShouldEqual, fuse.OK)
+			b, _ = rr.Bytes(buf)
+			So(string(b), ShouldEqual, "01XY456789")
+			rr.Done()
+			file.Release()
+
+			// and one we partially read before
+			p := make([]byte, 3)
+			_, err = fs.ReadAt("b.file", p, 0)
+			So(err, ShouldBeNil)
+			file, status = fs.Open("b.file", uint32(os.O_RDWR), nil)
+			So(status, ShouldEqual, fuse.OK)
+			_, status = file.Write([]byte("Z"), 9)
+			So(status, ShouldEqual, fuse.OK)
+			rr, status = file.Read(buf, 0)
+			So(status, ShouldEqual, fuse.OK)
+			b, _ = rr.Bytes(buf)
+			So(string(b), ShouldEqual, "abcdefghiZ")
+			rr.Done()
+			file.Release()
+
+			// and one whose cache file is out of date
+			_, status = fs.GetAttr("c.file", nil)
+			So(status, ShouldEqual, fuse.OK)
+			err = ioutil.WriteFile(r.getLocalPath(r.getRemotePath("c.file")), []byte("stale"), 0644)
+			So(err, ShouldBeNil)
+			file, status = fs.Open("c.file", uint32(os.O_RDWR), nil)
+			So(status, ShouldEqual, fuse.OK)
+			_, status = file.Write([]byte("K"), 0)
+			So(status, ShouldEqual, fuse.OK)
+			rr, status = file.Read(buf, 0)
+			So(status, ShouldEqual, fuse.OK)
+			b, _ = rr.Bytes(buf)
+			So(string(b), ShouldEqual, "Klmnopqrst")
+			rr.Done()
+			file.Release()
+
+			// and one we write to without reading the rest of
+			_, status = fs.GetAttr("d.file", nil)
+			So(status, ShouldEqual, fuse.OK)
+			file, status = fs.Open("d.file", uint32(os.O_RDWR), nil)
+			So(status, ShouldEqual, fuse.OK)
+			_, status = file.Write([]byte("Q"), 4)
+			So(status, ShouldEqual, fuse.OK)
+			file.Release()
+
+			// holes left after truncating are zeros, not the old content
+			_, status = fs.GetAttr("e.file", nil)
+			So(status, ShouldEqual, fuse.OK)
+			file, status = fs.Open("e.file", uint32(os.O_WRONLY|os.O_TRUNC), nil)
+			So(status, ShouldEqual, fuse.OK)
+			_, status = file.Write([]byte("Z"), 5)
+			So(status, ShouldEqual, fuse.OK)
+			file.Release()
+
+			So(fs.uploadCreated(), ShouldBeNil)
+			content, err := ioutil.ReadFile(filepath.Join(rmwSource, "a.file"))
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, "01XY456789")
+			content, err = ioutil.ReadFile(filepath.Join(rmwSource, "b.file"))
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, "abcdefghiZ")
+			content, err = ioutil.ReadFile(filepath.Join(rmwSource, "c.file"))
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, "Klmnopqrst")
+			content, err = ioutil.ReadFile(filepath.Join(rmwSource, "d.file"))
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, "uvwxQz1234")
+			content, err = ioutil.ReadFile(filepath.Join(rmwSource, "e.file"))
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, "\x00\x00\x00\x00\x00Z")
+		}
+	})
+
+	Convey("LockDir holds the lock files for cache files", t, func() {
+		cacheDir := filepath.Join(tmpdir, "lockDirCache")
+		os.MkdirAll(cacheDir, os.FileMode(0777))
+		defer os.RemoveAll(cacheDir)
+		lockDir := filepath.Join(tmpdir, "lockDir", "sub")
+		defer os.RemoveAll(filepath.Join(tmpdir, "lockDir"))
+
+		fs, err := New(&Config{Mount: filepath.Join(tmpdir, "lockDirMount"), CacheBase: cacheBase, LockDir: lockDir})
+		So(err, ShouldBeNil)
+		info, err := os.Stat(lockDir)
+		So(err, ShouldBeNil)
+		So(info.IsDir(), ShouldBeTrue)
+
+		for _, name := range []string{"a.file", "b.file"} {
+			fmutex, err := fs.getFileMutex(filepath.Join(cacheDir, name))
+			So(err, ShouldBeNil)
+			So(fmutex.Lock(), ShouldBeNil)
+			So(fmutex.Close(), ShouldBeNil)
+		}
+		entries, err := ioutil.ReadDir(cacheDir)
+		So(err, ShouldBeNil)
+		So(entries, ShouldBeEmpty)
+		entries, err = ioutil.ReadDir(lockDir)
+		So(err, ShouldBeNil)
+		So(len(entries), ShouldEqual, 2)
+
+		fs, err = New(&Config{Mount: filepath.Join(tmpdir, "lockDirMount"), CacheBase: cacheBase})
+		So(err, ShouldBeNil)
+		fmutex, err := fs.getFileMutex(filepath.Join(cacheDir, "a.file"))
+		So(err, ShouldBeNil)
+		So(fmutex.Close(), ShouldBeNil)
+		_, err = os.Stat(filepath.Join(cacheDir, ".muxfys_lock.a.file"))
+		So(err, ShouldBeNil)
+	})
+
+	Convey("Listing a directory and then getting the attributes of its entries only lists it once", t, func() {
+		lsSource := filepath.Join(tmpdir, "lsSource")
+		os.MkdirAll(filepath.Join(lsSource, "sub"), os.FileMode(0777))
+		defer os.RemoveAll(lsSource)
+		for _, name := range []string{"a.file", "b.file", "sub/c.file"} {
+			err := ioutil.WriteFile(filepath.Join(lsSource, name), []byte("data"), 0644)
+			So(err, ShouldBeNil)
+		}
+
+		fi := NewFaultInjector(&localAccessor{target: lsSource})
+		fs, _ := newTestFS(&Config{Mount: filepath.Join(tmpdir, "lsMount"), CacheBase: cacheBase, NegativeCacheTTL: time.Minute, OpenRetry: time.Second}, &RemoteConfig{Accessor: fi})
+
+		for i, dir := range []string{"", "sub"} {
+			entries, status := fs.OpenDir(dir, nil)
+			So(status, ShouldEqual, fuse.OK)
+			So(len(entries), ShouldBeGreaterThan, 0)
+			for _, entry := range entries {
+				_, status = fs.GetAttr(filepath.Join(dir, entry.Name), nil)
+				So(status, ShouldEqual, fuse.OK)
+			}
+			So(fi.Calls("ListEntries"), ShouldEqual, i+1)
+		}
+	})
+
+	Convey("Cached files of writeable remotes can have space allocated", t, func() {
+		allocSource := filepath.Join(tmpdir, "allocSource")
+		os.MkdirAll(allocSource, os.FileMode(0777))
+		defer os.RemoveAll(allocSource)
+		err := ioutil.WriteFile(filepath.Join(allocSource, "existing.file"), []byte("abc"), 0644)
+		So(err, ShouldBeNil)
+
+		fs, _ := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "allocMount"), CacheBase: cacheBase}, &RemoteConfig{Accessor: &localAccessor{target: allocSource}, CacheData: true, Write: true})
+
+		file, status := fs.Create("alloc.file", uint32(os.O_RDWR), 0644, nil)
+		So(status, ShouldEqual, fuse.OK)
+		_, status = file.Write([]byte("abc"), 0)
+		So(status, ShouldEqual, fuse.OK)
+		So(file.Allocate(0, 10, 0), ShouldEqual, fuse.OK)
+		attr, status := fs.GetAttr("alloc.file", nil)
+		So(status, ShouldEqual, fuse.OK)
+		So(attr.Size, ShouldEqual, 10)
+		So(file.Allocate(0, 20, fallocKeepSize), ShouldEqual, fuse.OK)
+		So(attr.Size, ShouldEqual, 10)
+		So(file.Allocate(2, 4, 0), ShouldEqual, fuse.OK)
+		So(attr.Size, ShouldEqual, 10)
+		So(file.Allocate(0, 2, 0x02), ShouldEqual, fuse.ENOSYS)
+		buf := make([]byte, 20)
+		rr, status := file.Read(buf, 0)
+		So(status, ShouldEqual, fuse.OK)
+		b, _ := rr.Bytes(buf)
+		So(b, ShouldResemble, append([]byte("abc"), make([]byte, 7)...))
+		file.Release()
+
+		_, status = fs.GetAttr("existing.file", nil)
+		So(status, ShouldEqual, fuse.OK)
+		file, status = fs.Open("existing.file", uint32(os.O_RDWR), nil)
+		So(status, ShouldEqual, fuse.OK)
+		So(file.Allocate(0, 5, 0), ShouldEqual, fuse.OK)
+		file.Release()
+
+		So(fs.uploadCreated(), ShouldBeNil)
+		content, err := ioutil.ReadFile(filepath.Join(allocSource, "alloc.file"))
+		So(err, ShouldBeNil)
+		So(content, ShouldResemble, append([]byte("abc"), make([]byte, 7)...))
+		content, err = ioutil.ReadFile(filepath.Join(allocSource, "existing.file"))
+		So(err, ShouldBeNil)
+		So(content, ShouldResemble, []byte{'a', 'b', 'c', 0, 0})
+
+		Convey("But not those of read-only or uncached remotes", func() {
+			for _, write := range []bool{false, true} {
+				fs, _ := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "allocMount"), CacheBase: cacheBase}, &RemoteConfig{Accessor: &localAccessor{target: allocSource}, CacheData: !write, Write: write})
+				_, status := fs.GetAttr("existing.file", nil)
+				So(status, ShouldEqual, fuse.OK)
+
+				if write {
+					file, status := fs.Create("uncached.file", uint32(os.O_WRONLY), 0644, nil)
+					So(status, ShouldEqual, fuse.OK)
+					So(file.Allocate(0, 10, 0), ShouldEqual, fuse.ENOSYS)
+					file.Release()
+					os.Remove(filepath.Join(allocSource, "uncached.file"))
+				} else {
+					// like writes, fails on read-only handles
+					file, status := fs.Open("existing.file", uint32(os.O_RDONLY), nil)
+					So(status, ShouldEqual, fuse.OK)
+					So(file.Allocate(0, 10, 0), ShouldEqual, fuse.EPERM)
+					file.Release()
+				}
+			}
+		})
+	})
+
+	Convey("SeedCacheFrom serves pre-staged files without downloading them", t, func() {
+		seedSource := filepath.Join(tmpdir, "seedSource")
+		seedDir := filepath.Join(tmpdir, "seedDir")
+		for _, dir := range []string{seedSource, seedDir} {
+			os.MkdirAll(filepath.Join(dir, "sub"), os.FileMode(0777))
+			defer os.RemoveAll(dir)
+			err := ioutil.WriteFile(filepath.Join(dir, "a.file"), []byte("0123456789"), 0644)
+			So(err, ShouldBeNil)
+			err = ioutil.WriteFile(filepath.Join(dir, "sub", "b.file"), []byte("abc"), 0644)
+			So(err, ShouldBeNil)
+		}
+		seedCache := filepath.Join(tmpdir, "seedCache")
+		defer os.RemoveAll(seedCache)
+
+		readAll := func(fs *MuxFys, name string) string {
+			_, status := fs.GetAttr(name, nil)
+			So(status, ShouldEqual, fuse.OK)
+			file, status := fs.Open(name, uint32(os.O_RDONLY), nil)
+			So(status, ShouldEqual, fuse.OK)
+			defer file.Release()
+			buf := make([]byte, 20)
+			rr, status := file.Read(buf, 0)
+			So(status, ShouldEqual, fuse.OK)
+			b, _ := rr.Bytes(buf)
+			return string(b)
+		}
+
+		_, err := newRemote(&RemoteConfig{Accessor: &localAccessor{target: seedSource}, SeedCacheFrom: seedDir}, cacheBase, 1, nil)
+		So(err, ShouldNotBeNil)
+
+		config := &Config{Mount: filepath.Join(tmpdir, "seedMount"), CacheBase: cacheBase}
+		probe, err := New(config)
+		So(err, ShouldBeNil)
+		_, err = probe.createRemote(&RemoteConfig{Accessor: &localAccessor{target: seedSource}, CacheData: true, SeedCacheFrom: filepath.Join(seedDir, "a.file")})
+		So(err, ShouldNotBeNil)
+
+		fi := NewFaultInjector(&localAccessor{target: seedSource})
+		fs, _ := mountTestFS(config, &RemoteConfig{Accessor: fi, CacheData: true, CacheDir: seedCache, SeedCacheFrom: seedDir, OfflineReads: true})
+		So(readAll(fs, "a.file"), ShouldEqual, "0123456789")
+		So(readAll(fs, "sub/b.file"), ShouldEqual, "abc")
+		So(fi.Calls("OpenFile"), ShouldEqual, 0)
+		So(fi.Calls("DownloadFile"), ShouldEqual, 0)
+
+		Convey("And with OfflineReads, without the remote being reachable", func() {
+			fi.AddRule(FaultRule{Err: fmt.Errorf("unreachable")})
+			fs, _ := mountTestFS(config, &RemoteConfig{Accessor: fi, CacheData: true, CacheDir: seedCache, SeedCacheFrom: seedDir, OfflineReads: true})
+			So(readAll(fs, "sub/b.file"), ShouldEqual, "abc")
+			So(readAll(fs, "a.file"), ShouldEqual, "0123456789")
+			So(fi.Calls("OpenFile"), ShouldEqual, 0)
+		})
+	})
+
+	Convey("ContentTypeFunc decides the content type of uploads", t, func() {
+		ctSource := filepath.Join(tmpdir, "ctSource")
+		os.MkdirAll(ctSource, os.FileMode(0777))
+		defer os.RemoveAll(ctSource)
+
+		var asked []string
+		ca := &contentTypeAccessor{localAccessor: &localAccessor{target: ctSource}, contentTypes: make(map[string]string)}
+		fs, _ := mountTestFS(&Config{
+			Mount:     filepath.Join(tmpdir, "ctMount"),
+			CacheBase: cacheBase,
+			ContentTypeFunc: func(path string) string {
+				asked = append(asked, path)
+				if strings.HasSuffix(path, ".g.vcf.gz") {
+					return "application/x-gvcf"
+				}
+				return ""
+			},
+		}, &RemoteConfig{Accessor: ca, CacheData: true, Write: true})
+
+		for _, name := range []string{"sample.g.vcf.gz", "notes.txt"} {
+			file, status := fs.Create(name, uint32(os.O_WRONLY), 0644, nil)
+			So(status, ShouldEqual, fuse.OK)
+			_, status = file.Write([]byte("some text\n"), 0)
+			So(status, ShouldEqual, fuse.OK)
+			file.Release()
+		}
+		So(fs.uploadCreated(), ShouldBeNil)
+
+		sort.Strings(asked)
+		So(asked, ShouldResemble, []string{filepath.Join(ctSource, "notes.txt"), filepath.Join(ctSource, "sample.g.vcf.gz")})
+		So(ca.contentTypes[filepath.Join(ctSource, "sample.g.vcf.gz")], ShouldEqual, "application/x-gvcf")
+		So(ca.contentTypes[filepath.Join(ctSource, "notes.txt")], ShouldEqual, "text/plain; charset=utf-8")
+
+		// uploads that can be cancelled also record the content type
+		file, status := fs.Create("cancellable.g.vcf.gz", uint32(os.O_WRONLY), 0644, nil)
+		So(status, ShouldEqual, fuse.OK)
+		_, status = file.Write([]byte("some text\n"), 0)
+		So(status, ShouldEqual, fuse.OK)
+		file.Release()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		pending, err := fs.uploadCreatedContext(ctx, false)
+		So(err, ShouldBeNil)
+		So(pending, ShouldBeEmpty)
+		So(ca.contentTypes[filepath.Join(ctSource, "cancellable.g.vcf.gz")], ShouldEqual, "application/x-gvcf")
+	})
+
+	Convey("Commit() uploads created files but leaves the cache in place", t, func() {
+		commitSource := filepath.Join(tmpdir, "commitSource")
+		os.MkdirAll(commitSource, os.FileMode(0777))
+		defer os.RemoveAll(commitSource)
+
+		fs, r := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "commitMount"), CacheBase: cacheBase}, &RemoteConfig{Accessor: &localAccessor{target: commitSource}, CacheData: true, Write: true})
+
+		open, status := fs.Create("open.file", uint32(os.O_WRONLY), 0644, nil)
+		So(status, ShouldEqual, fuse.OK)
+		_, status = open.Write([]byte("open"), 0)
+		So(status, ShouldEqual, fuse.OK)
+		closed, status := fs.Create("closed.file", uint32(os.O_WRONLY), 0644, nil)
+		So(status, ShouldEqual, fuse.OK)
+		_, status = closed.Write([]byte("phase 1"), 0)
+		So(status, ShouldEqual, fuse.OK)
+		closed.Release()
+
+		So(fs.Commit(), ShouldBeNil)
+		content, err := ioutil.ReadFile(filepath.Join(commitSource, "closed.file"))
+		So(err, ShouldBeNil)
+		So(string(content), ShouldEqual, "phase 1")
+		_, err = os.Stat(filepath.Join(commitSource, "open.file"))
+		So(os.IsNotExist(err), ShouldBeTrue)
+		_, err = os.Stat(r.getLocalPath(r.getRemotePath("closed.file")))
+		So(err, ShouldBeNil)
+
+		open.Release()
+		closed, status = fs.Open("closed.file", uint32(os.O_WRONLY|os.O_TRUNC), nil)
+		So(status, ShouldEqual, fuse.OK)
+		_, status = closed.Write([]byte("phase 2"), 0)
+		So(status, ShouldEqual, fuse.OK)
+		closed.Release()
+
+		So(fs.Commit(), ShouldBeNil)
+		content, err = ioutil.ReadFile(filepath.Join(commitSource, "open.file"))
+		So(err, ShouldBeNil)
+		So(string(content), ShouldEqual, "open")
+		content, err = ioutil.ReadFile(filepath.Join(commitSource, "closed.file"))
+		So(err, ShouldBeNil)
+		So(string(content), ShouldEqual, "phase 2")
+		So(fs.createdFiles, ShouldBeEmpty)
+
+		So(fs.Unmount(), ShouldBeNil)
+		So(fs.Commit(), ShouldBeNil)
+	})
+
+	Convey("UnmountContext() stops uploading when its context is done", t, func() {
+		ctxSource := filepath.Join(tmpdir, "ctxSource")
+		os.MkdirAll(ctxSource, os.FileMode(0777))
+		defer os.RemoveAll(ctxSource)
+
+		for _, timeout := range []time.Duration{50 * time.Millisecond, 10 * time.Second} {
+			fs, r := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "ctxMount"), CacheBase: cacheBase}, &RemoteConfig{Accessor: &slowUploadAccessor{&localAccessor{target: ctxSource}, 100 * time.Millisecond}, CacheData: true, Write: true})
+
+			file, status := fs.Create("a.file", uint32(os.O_WRONLY|os.O_CREATE), 0644, nil)
+			So(status, ShouldEqual, fuse.OK)
+			_, status = file.Write([]byte("0123456789"), 0)
+			So(status, ShouldEqual, fuse.OK)
+			file.Release()
+			localPath := r.getLocalPath(r.getRemotePath("a.file"))
+
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			start := time.Now()
+			err := fs.UnmountContext(ctx)
+			cancel()
+			if timeout > time.Second {
+				So(err, ShouldBeNil)
+				content, errr := ioutil.ReadFile(filepath.Join(ctxSource, "a.file"))
+				So(errr, ShouldBeNil)
+				So(string(content), ShouldEqual, "0123456789")
+				_, errs := os.Stat(r.cacheDir)
+				So(os.IsNotExist(errs), ShouldBeTrue)
+				continue
+			}
+
+			So(time.Since(start), ShouldBeLessThan, 500*time.Millisecond)
+			So(err, ShouldNotBeNil)
+			So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+			var pendingErr *UploadsPendingError
+			So(errors.As(err, &pendingErr), ShouldBeTrue)
+			So(pendingErr.Pending, ShouldResemble, map[string]string{"a.file": localPath})
+			So(err.Error(), ShouldContainSubstring, "a.file")
+			content, errr := ioutil.ReadFile(localPath)
+			So(errr, ShouldBeNil)
+			So(string(content), ShouldEqual, "0123456789")
+			content, _ = ioutil.ReadFile(filepath.Join(ctxSource, "a.file"))
+			So(string(content), ShouldNotEqual, "0123456789")
+		}
+	})
+
+	Convey("EagerCacheBelow caches small files when their directory is listed", t, func() {
+		eagerSource := filepath.Join(tmpdir, "eagerSource")
+		os.MkdirAll(eagerSource, os.FileMode(0777))
+		defer os.RemoveAll(eagerSource)
+		err := ioutil.WriteFile(filepath.Join(eagerSource, "small.file"), []byte("small"), 0644)
+		So(err, ShouldBeNil)
+		err = ioutil.WriteFile(filepath.Join(eagerSource, "big.file"), []byte("this is a big file"), 0644)
+		So(err, ShouldBeNil)
+
+		local := &localAccessor{target: eagerSource}
+		_, err = newRemote(&RemoteConfig{Accessor: local, CacheData: true, EagerCacheBelow: -1}, cacheBase, 1, log15.New())
+		So(err, ShouldNotBeNil)
+		_, err = newRemote(&RemoteConfig{Accessor: local, EagerCacheBelow: 10}, cacheBase, 1, log15.New())
+		So(err, ShouldNotBeNil)
+
+		fs, r := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "eagerMount"), CacheBase: cacheBase}, &RemoteConfig{Accessor: local, CacheData: true, EagerCacheBelow: 10})
+		fs.eagerWG.Wait()
+
+		smallPath := r.getLocalPath(r.getRemotePath("small.file"))
+		So(r.Uncached(smallPath, NewInterval(0, 5)), ShouldBeEmpty)
+		content, err := ioutil.ReadFile(smallPath)
+		So(err, ShouldBeNil)
+		So(string(content), ShouldEqual, "small")
+		So(r.Uncached(r.getLocalPath(r.getRemotePath("big.file")), NewInterval(0, 18)), ShouldNotBeEmpty)
+		So(fs.OpenHandles(), ShouldBeEmpty)
+	})
+
+	Convey("Objects whose names are also directory prefixes are hidden by the directory", t, func() {
+		entryModes := func(entries []fuse.DirEntry) map[string]uint32 {
+			modes := make(map[string]uint32)
+			for _, entry := range entries {
+				_, dup := modes[entry.Name]
+				So(dup, ShouldBeFalse)
+				modes[entry.Name] = entry.Mode
+			}
+			return modes
+		}
+		checkDirWins := func(fs *MuxFys) {
+			entries, status := fs.OpenDir("", nil)
+			So(status, ShouldEqual, fuse.OK)
+			So(entryModes(entries), ShouldResemble, map[string]uint32{"x": uint32(fuse.S_IFDIR), "z": uint32(fuse.S_IFREG)})
+
+			attr, status := fs.GetAttr("x", nil)
+			So(status, ShouldEqual, fuse.OK)
+			So(attr.Mode&fuse.S_IFDIR, ShouldNotEqual, 0)
+			_, status = fs.Open("x", uint32(os.O_RDONLY), nil)
+			So(status, ShouldNotEqual, fuse.OK)
+
+			entries, status = fs.OpenDir("x", nil)
+			So(status, ShouldEqual, fuse.OK)
+			So(entryModes(entries), ShouldResemble, map[string]uint32{"y": uint32(fuse.S_IFREG)})
+			attr, status = fs.GetAttr("x/y", nil)
+			So(status, ShouldEqual, fuse.OK)
+			So(attr.Size, ShouldEqual, 3)
+		}
+
+		Convey("Within one remote", func() {
+			ma := NewMemoryAccessor("conflict")
+			ma.Put("x", []byte("file"))
+			ma.Put("x/y", []byte("why"))
+			ma.Put("z", []byte("zed"))
+			fs, _ := newTestFS(&Config{Mount: filepath.Join(tmpdir, "conflictMount"), CacheBase: cacheBase}, &RemoteConfig{Accessor: ma})
+			checkDirWins(fs)
+
+			fs.RefreshDir("")
+			checkDirWins(fs)
+		})
+
+		Convey("Across multiplexed remotes, in either order", func() {
+			fileAccessor := NewMemoryAccessor("conflictFile")
+			fileAccessor.Put("x", []byte("file"))
+			fileAccessor.Put("z", []byte("zed"))
+			dirAccessor := NewMemoryAccessor("conflictDir")
+			dirAccessor.Put("x/y", []byte("why"))
+
+			for _, accessors := range [][]RemoteAccessor{{fileAccessor, dirAccessor}, {dirAccessor, fileAccessor}} {
+				fs, _ := newTestFS(&Config{Mount: filepath.Join(tmpdir, "conflictMount"), CacheBase: cacheBase}, &RemoteConfig{Accessor: accessors[0]}, &RemoteConfig{Accessor: accessors[1]})
+				checkDirWins(fs)
+			}
+		})
+	})
+
+	Convey("OpenDir() lists multiplexed remotes concurrently, with the first remote winning", t, func() {
+		sourceA := filepath.Join(tmpdir, "concurrentA")
+		sourceB := filepath.Join(tmpdir, "concurrentB")
+		for _, source := range []string{sourceA, sourceB} {
+			os.MkdirAll(source, os.FileMode(0777))
+			defer os.RemoveAll(source)
+		}
+		err := ioutil.WriteFile(filepath.Join(sourceA, "same.file"), []byte("a"), 0644)
+		So(err, ShouldBeNil)
+		err = ioutil.WriteFile(filepath.Join(sourceA, "a.file"), []byte("a"), 0644)
+		So(err, ShouldBeNil)
+		err = ioutil.WriteFile(filepath.Join(sourceB, "same.file"), []byte("bb"), 0644)
+		So(err, ShouldBeNil)
+		err = ioutil.WriteFile(filepath.Join(sourceB, "b.file"), []byte("b"), 0644)
+		So(err, ShouldBeNil)
+
+		delay := 500 * time.Millisecond
+		fs, _ := newTestFS(&Config{Mount: filepath.Join(tmpdir, "concurrentMount"), CacheBase: cacheBase},
+			&RemoteConfig{Accessor: &slowListAccessor{&localAccessor{target: sourceA}, delay}},
+			&RemoteConfig{Accessor: &slowListAccessor{&localAccessor{target: sourceB}, delay * 4 / 5}})
+		rA, rB := fs.remotes[0], fs.remotes[1]
+
+		start := time.Now()
+		entries, status := fs.OpenDir("", nil)
+		So(status, ShouldEqual, fuse.OK)
+		So(time.Since(start), ShouldBeLessThan, delay+delay/2)
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name)
+		}
+		sort.Strings(names)
+		So(names, ShouldResemble, []string{"a.file", "b.file", "same.file"})
+
+		attr, status := fs.GetAttr("same.file", nil)
+		So(status, ShouldEqual, fuse.OK)
+		So(attr.Size, ShouldEqual, 1)
+		So(fs.fileToRemote["same.file"], ShouldEqual, rA)
+		So(fs.fileToRemote["b.file"], ShouldEqual, rB)
+
+		So(fs.RefreshDir(""), ShouldBeNil)
+		So(fs.fileToRemote["same.file"], ShouldEqual, rA)
+	})
+
+	Convey("In-flight transfers can be listed and cancelled", t, func() {
+		transferSource := filepath.Join(tmpdir, "transferSource")
+		os.MkdirAll(transferSource, os.FileMode(0777))
+		defer os.RemoveAll(transferSource)
+		err := ioutil.WriteFile(filepath.Join(transferSource, "a.file"), []byte("0123456789"), 0644)
+		So(err, ShouldBeNil)
+
+		for _, cacheData := range []bool{false, true} {
+			accessor := &localAccessor{target: transferSource}
+			fs, r := newTestFS(&Config{Mount: filepath.Join(tmpdir, "transferMount"), CacheBase: cacheBase}, &RemoteConfig{Accessor: accessor, CacheData: cacheData})
+			r.readBlockSize = 0 // (so reads aren't rounded up to whole blocks)
+			So(fs.InFlight(), ShouldBeEmpty)
+
+			_, status := fs.GetAttr("a.file", nil)
+			So(status, ShouldEqual, fuse.OK)
+			file, status := fs.Open("a.file", uint32(os.O_RDONLY), nil)
+			So(status, ShouldEqual, fuse.OK)
+			buf := make([]byte, 4)
+			_, status = file.Read(buf, 0)
+			So(status, ShouldEqual, fuse.OK)
+
+			inflight := fs.InFlight()
+			So(len(inflight), ShouldEqual, 1)
+			remotePath := accessor.RemotePath("a.file")
+			So(inflight[0].ID, ShouldEqual, remotePath+"@0")
+			So(inflight[0].Path, ShouldEqual, remotePath)
+			So(inflight[0].Offset, ShouldEqual, 0)
+			So(inflight[0].Bytes, ShouldBeGreaterThanOrEqualTo, 4)
+			So(inflight[0].Upload, ShouldBeFalse)
+
+			So(fs.Cancel("foo@0"), ShouldNotBeNil)
+			So(fs.Cancel(inflight[0].ID), ShouldBeNil)
+			So(fs.InFlight(), ShouldBeEmpty)
+			_, status = file.Read(buf, 4)
+			So(status, ShouldEqual, fuse.EINTR)
+			file.Release()
+			So(fs.InFlight(), ShouldBeEmpty)
+
+			if cacheData {
+				localPath := r.getLocalPath(remotePath)
+				So(r.Uncached(localPath, NewInterval(0, 10)), ShouldResemble, Intervals{NewInterval(4, 6)})
+			}
+
+			file, status = fs.Open("a.file", uint32(os.O_RDONLY), nil)
+			So(status, ShouldEqual, fuse.OK)
+			rr, status := file.Read(buf, 4)
+			So(status, ShouldEqual, fuse.OK)
+			b, _ := rr.Bytes(buf)
+			So(string(b), ShouldEqual, "4567")
+			file.Release()
+			So(fs.InFlight(), ShouldBeEmpty)
+		}
+
+		Convey("Cancelling aborts blocked reads and uploads of cached files", func() {
+			mem := NewMemoryAccessor("blocking")
+			mem.Put(mem.RemotePath("a.file"), []byte("0123456789"))
+			accessor := &stallingAccessor{MemoryAccessor: mem}
+			fs, _ := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "blockingMount"), CacheBase: cacheBase}, &RemoteConfig{Accessor: accessor, CacheData: true, Write: true})
+
+			waitForTransfer := func(upload bool) Transfer {
+				for {
+					for _, t := range fs.InFlight() {
+						if t.Upload == upload {
+							return t
+						}
+					}
+					<-time.After(time.Millisecond)
+				}
+			}
+
+			file, status := fs.Open("a.file", uint32(os.O_RDONLY), nil)
+			So(status, ShouldEqual, fuse.OK)
+			read := make(chan fuse.Status)
+			go func() {
+				_, status := file.Read(make([]byte, 4), 0)
+				read <- status
+			}()
+			download := waitForTransfer(false)
+			So(fs.Cancel(download.ID), ShouldBeNil)
+			So(<-read, ShouldEqual, fuse.EINTR)
+			file.Release()
+
+			file, status = fs.Create("b.file", uint32(os.O_WRONLY|os.O_CREATE), 0644, nil)
+			So(status, ShouldEqual, fuse.OK)
+			_, status = file.Write([]byte("data"), 0)
+			So(status, ShouldEqual, fuse.OK)
+			file.Release()
+			uploaded := make(chan error)
+			go func() {
+				uploaded <- fs.uploadCreated()
+			}()
+			upload := waitForTransfer(true)
+			So(upload.Path, ShouldEqual, mem.RemotePath("b.file"))
+			So(fs.Cancel(upload.ID), ShouldBeNil)
+			So(<-uploaded, ShouldNotBeNil)
+			So(fs.InFlight(), ShouldBeEmpty)
+			_, exists := mem.Get(mem.RemotePath("b.file"))
+			So(exists, ShouldBeFalse)
+		})
+	})
+
+	Convey("IsCached() reports how much of a file is cached", t, func() {
+		isCachedSource := filepath.Join(tmpdir, "isCachedSource")
+		os.MkdirAll(filepath.Join(isCachedSource, "sub"), os.FileMode(0777))
+		defer os.RemoveAll(isCachedSource)
+		err := ioutil.WriteFile(filepath.Join(isCachedSource, "a.file"), []byte("0123456789"), 0644)
+		So(err, ShouldBeNil)
+		err = ioutil.WriteFile(filepath.Join(isCachedSource, "empty.file"), []byte{}, 0644)
+		So(err, ShouldBeNil)
+
+		fs, r := newTestFS(&Config{Mount: filepath.Join(tmpdir, "isCachedMount"), CacheBase: cacheBase}, &RemoteConfig{Accessor: &localAccessor{target: isCachedSource}, CacheData: true})
+		r.readBlockSize = 0 // (so reads aren't rounded up to whole blocks)
+
+		cached, n, err := fs.IsCached("a.file")
+		So(err, ShouldBeNil)
+		So(cached, ShouldBeFalse)
+		So(n, ShouldEqual, 0)
+
+		p := make([]byte, 4)
+		_, err = fs.ReadAt("a.file", p, 3)
+		So(err, ShouldBeNil)
+		cached, n, err = fs.IsCached("/a.file")
+		So(err, ShouldBeNil)
+		So(cached, ShouldBeFalse)
+		So(n, ShouldEqual, 4)
+
+		p = make([]byte, 10)
+		_, err = fs.ReadAt("a.file", p, 0)
+		So(err, ShouldBeNil)
+		cached, n, err = fs.IsCached("a.file")
+		So(err, ShouldBeNil)
+		So(cached, ShouldBeTrue)
+		So(n, ShouldEqual, 10)
+
+		cached, n, err = fs.IsCached("empty.file")
+		So(err, ShouldBeNil)
+		So(cached, ShouldBeTrue)
+		So(n, ShouldEqual, 0)
+
+		_, _, err = fs.IsCached("missing.file")
+		So(err, ShouldNotBeNil)
+		_, _, err = fs.IsCached("sub")
+		So(err, ShouldNotBeNil)
+
+		fs2, _ := newTestFS(&Config{Mount: filepath.Join(tmpdir, "isCachedMount2"), CacheBase: cacheBase}, &RemoteConfig{Accessor: &localAccessor{target: isCachedSource}})
+		_, _, err = fs2.IsCached("a.file")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("ExposeMetadataXattrs gives files read-only extended attributes", t, func() {
+		xattrSource := filepath.Join(tmpdir, "xattrSource")
+		os.MkdirAll(filepath.Join(xattrSource, "sub"), os.FileMode(0777))
+		defer os.RemoveAll(xattrSource)
+		err := ioutil.WriteFile(filepath.Join(xattrSource, "a.file"), []byte("abc"), 0644)
+		So(err, ShouldBeNil)
+
+		accessor := &statAccessor{localAccessor: &localAccessor{target: xattrSource}, metadata: map[string]string{"sample": "s1", "size": "ignored"}}
+		fs, _ := newTestFS(&Config{Mount: filepath.Join(tmpdir, "xattrMount"), CacheBase: cacheBase, ExposeMetadataXattrs: true}, &RemoteConfig{Accessor: accessor})
+		_, status := fs.GetAttr("a.file", nil)
+		So(status, ShouldEqual, fuse.OK)
+
+		attributes, status := fs.ListXAttr("a.file", nil)
+		So(status, ShouldEqual, fuse.OK)
+		So(attributes, ShouldResemble, []string{"user.s3.sample", "user.s3.size"})
+		data, status := fs.GetXAttr("a.file", "user.s3.sample", nil)
+		So(status, ShouldEqual, fuse.OK)
+		So(string(data), ShouldEqual, "s1")
+		data, status = fs.GetXAttr("a.file", "user.s3.size", nil)
+		So(status, ShouldEqual, fuse.OK)
+		So(string(data), ShouldEqual, "3")
+		_, status = fs.GetXAttr("a.file", "user.s3.missing", nil)
+		So(status, ShouldEqual, fuse.ENOATTR)
+		_, status = fs.GetXAttr("a.file", "security.selinux", nil)
+		So(status, ShouldEqual, fuse.ENOATTR)
+
+		So(fs.SetXAttr("a.file", "user.s3.sample", []byte("s2"), 0, nil), ShouldEqual, fuse.ENOTSUP)
+		So(fs.RemoveXAttr("a.file", "user.s3.sample", nil), ShouldEqual, fuse.ENOTSUP)
+
+		attributes, status = fs.ListXAttr("sub", nil)
+		So(status, ShouldEqual, fuse.OK)
+		So(attributes, ShouldBeEmpty)
+		_, status = fs.ListXAttr("missing.file", nil)
+		So(status, ShouldEqual, fuse.ENOENT)
+
+		// metadata is only retrieved again if the file changes
+		accessor.metadata = map[string]string{"sample": "s2"}
+		data, _ = fs.GetXAttr("a.file", "user.s3.sample", nil)
+		So(string(data), ShouldEqual, "s1")
+		fs.mapMutex.Lock()
+		fs.files["a.file"].Mtime++
+		fs.mapMutex.Unlock()
+		data, _ = fs.GetXAttr("a.file", "user.s3.sample", nil)
+		So(string(data), ShouldEqual, "s2")
+	})
+
+	Convey("CannedACL is validated and passed to the Accessor", t, func() {
+		accessor := &aclAccessor{localAccessor: &localAccessor{target: tmpdir}}
+		_, err := newRemote(&RemoteConfig{Accessor: accessor, CannedACL: "public"}, cacheBase, 1, pkgLogger)
+		So(err, ShouldNotBeNil)
+		_, err = newRemote(&RemoteConfig{Accessor: accessor.localAccessor, CannedACL: "public-read"}, cacheBase, 1, pkgLogger)
+		So(err, ShouldNotBeNil)
+		So(accessor.acl, ShouldBeEmpty)
+
+		_, err = newRemote(&RemoteConfig{Accessor: accessor, CannedACL: "public-read"}, cacheBase, 1, pkgLogger)
+		So(err, ShouldBeNil)
+		So(accessor.acl, ShouldEqual, "public-read")
+	})
+
+	Convey("Uploaded files can be tagged", t, func() {
+		tagSource := filepath.Join(tmpdir, "tagSource")
+		os.MkdirAll(tagSource, os.FileMode(0777))
+		defer os.RemoveAll(tagSource)
+		err := ioutil.WriteFile(filepath.Join(tagSource, "old.file"), []byte("old"), 0644)
+		So(err, ShouldBeNil)
+
+		accessor := &tagAccessor{localAccessor: &localAccessor{target: tagSource}, tags: make(map[string]map[string]string)}
+		objectTags := map[string]string{"lifecycle": "scratch"}
+		_, err = newRemote(&RemoteConfig{Accessor: accessor, ObjectTags: objectTags}, cacheBase, 1, pkgLogger)
+		So(err, ShouldNotBeNil)
+		_, err = newRemote(&RemoteConfig{Accessor: accessor.localAccessor, ObjectTags: objectTags, Write: true}, cacheBase, 1, pkgLogger)
+		So(err, ShouldNotBeNil)
+
+		fs, r := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "tagMount"), CacheBase: cacheBase}, &RemoteConfig{Accessor: accessor, CacheData: true, Write: true, ObjectTags: objectTags})
+
+		file, status := fs.Create("new.file", uint32(os.O_RDWR|os.O_CREATE), 0644, nil)
+		So(status, ShouldEqual, fuse.OK)
+		_, status = file.Write([]byte("new"), 0)
+		So(status, ShouldEqual, fuse.OK)
+		file.Release()
+		So(fs.SetObjectTags("new.file", map[string]string{"a": "b"}), ShouldNotBeNil)
+
+		So(fs.uploadCreated(), ShouldBeNil)
+		newPath := filepath.Join(tagSource, "new.file")
+		So(accessor.tags[newPath], ShouldResemble, objectTags)
+
+		oldPath := filepath.Join(tagSource, "old.file")
+		So(accessor.tags[oldPath], ShouldBeNil)
+		So(fs.SetObjectTags("/old.file", map[string]string{"a": "b"}), ShouldBeNil)
+		So(accessor.tags[oldPath], ShouldResemble, map[string]string{"a": "b"})
+		So(fs.SetObjectTags("new.file", map[string]string{"a": "c"}), ShouldBeNil)
+		So(accessor.tags[newPath], ShouldResemble, map[string]string{"a": "c"})
+
+		So(fs.SetObjectTags("missing.file", map[string]string{"a": "b"}), ShouldNotBeNil)
+		r.tagger = nil
+		So(fs.SetObjectTags("old.file", map[string]string{"a": "b"}), ShouldNotBeNil)
+	})
+
+	Convey("VersionAsOf is validated and passed to the Accessor", t, func() {
+		accessor := &pinAccessor{localAccessor: &localAccessor{target: tmpdir}}
+		asOf := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+		_, err := newRemote(&RemoteConfig{Accessor: accessor, VersionAsOf: asOf, Write: true}, cacheBase, 1, pkgLogger)
+		So(err, ShouldNotBeNil)
+		_, err = newRemote(&RemoteConfig{Accessor: accessor.localAccessor, VersionAsOf: asOf}, cacheBase, 1, pkgLogger)
+		So(err, ShouldNotBeNil)
+		So(accessor.asOf.IsZero(), ShouldBeTrue)
+
+		_, err = newRemote(&RemoteConfig{Accessor: accessor, VersionAsOf: asOf}, cacheBase, 1, pkgLogger)
+		So(err, ShouldBeNil)
+		So(accessor.asOf, ShouldEqual, asOf)
+	})
+
+	Convey("Destroy() makes a MuxFys unusable", t, func() {
+		var events int
+		fs, err := New(&Config{Mount: filepath.Join(tmpdir, "destroyMount"), CacheBase: cacheBase, EventHandler: func(Event) { events++ }})
+		So(err, ShouldBeNil)
+		fs.Error("an error")
+		So(fs.Logs(), ShouldNotBeEmpty)
+
+		So(fs.Destroy(), ShouldBeNil)
+		So(fs.Logs(), ShouldBeEmpty)
+		fs.Error("another error")
+		So(fs.Logs(), ShouldBeEmpty)
+		So(fs.events.closed, ShouldBeTrue)
+		fs.emit(Event{Type: EventOpen})
+
+		So(fs.Mount(&RemoteConfig{Accessor: &localAccessor{target: tmpdir}}), ShouldEqual, ErrDestroyed)
+		So(fs.Unmount(), ShouldEqual, ErrDestroyed)
+		So(fs.Destroy(), ShouldEqual, ErrDestroyed)
+	})
+
+	Convey("Destroy() leaves a MuxFys usable if its Unmount() fails", t, func() {
+		fi := NewFaultInjector(NewMemoryAccessor("destroy"))
+		fs, _ := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "destroyFailMount"), CacheBase: cacheBase}, &RemoteConfig{Accessor: fi, CacheData: true, Write: true})
+		file, status := fs.Create("new.file", uint32(os.O_WRONLY), 0644, nil)
+		So(status, ShouldEqual, fuse.OK)
+		file.Release()
+
+		fi.AddRule(FaultRule{Method: "UploadFile", Err: errors.New("connection refused")})
+		err := fs.Destroy()
+		So(err, ShouldNotBeNil)
+		So(err, ShouldNotEqual, ErrDestroyed)
+		So(fs.Logs(), ShouldNotBeEmpty)
+
+		So(fs.Destroy(), ShouldBeNil)
+		So(fs.Destroy(), ShouldEqual, ErrDestroyed)
+	})
+
+	Convey("OpenHandles() reports the bytes read and written via each open file", t, func() {
+		handleSource := filepath.Join(tmpdir, "handleSource")
+		os.MkdirAll(handleSource, os.FileMode(0777))
+		defer os.RemoveAll(handleSource)
+		err := ioutil.WriteFile(filepath.Join(handleSource, "a.file"), []byte("0123456789"), 0644)
+		So(err, ShouldBeNil)
+
+		for _, cacheData := range []bool{false, true} {
+			fs, _ := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "handleMount"), CacheBase: cacheBase}, &RemoteConfig{Accessor: &localAccessor{target: handleSource}, CacheData: cacheData, Write: true})
+			So(fs.OpenHandles(), ShouldBeEmpty)
+
+			file, status := fs.Open("a.file", uint32(os.O_RDONLY), nil)
+			So(status, ShouldEqual, fuse.OK)
+			_, status = file.Read(make([]byte, 4), 0)
+			So(status, ShouldEqual, fuse.OK)
+			_, status = file.Read(make([]byte, 100), 4)
+			So(status, ShouldEqual, fuse.OK)
+
+			created, status := fs.Create("b.file", uint32(os.O_WRONLY|os.O_CREATE), uint32(0644), nil)
+			So(status, ShouldEqual, fuse.OK)
+			n, status := created.Write([]byte("abc"), 0)
+			So(status, ShouldEqual, fuse.OK)
+			So(n, ShouldEqual, 3)
+
+			handles := fs.OpenHandles()
+			So(len(handles), ShouldEqual, 2)
+			So(handles[0].Path, ShouldEqual, "a.file")
+			So(handles[0].BytesRead, ShouldEqual, 10)
+			So(handles[0].BytesWritten, ShouldEqual, 0)
+			So(handles[1].Path, ShouldEqual, "b.file")
+			So(handles[1].BytesRead, ShouldEqual, 0)
+			So(handles[1].BytesWritten, ShouldEqual, 3)
+
+			file.Release()
+			So(len(fs.OpenHandles()), ShouldEqual, 1)
+			created.Flush()
+			created.Release()
+			So(fs.OpenHandles(), ShouldBeEmpty)
+
+			if cacheData {
+				err = fs.uploadCreated()
+				So(err, ShouldBeNil)
+			}
+			os.Remove(filepath.Join(handleSource, "b.file"))
+		}
+	})
+
+	Convey("You can mount a single file", t, func() {
+		singleSource := filepath.Join(tmpdir, "singleSource")
+		os.MkdirAll(singleSource, os.FileMode(0777))
+		defer os.RemoveAll(singleSource)
+		err := ioutil.WriteFile(filepath.Join(singleSource, "ref.fa"), []byte(">chr1\nACGT\n"), 0644)
+		So(err, ShouldBeNil)
+		err = ioutil.WriteFile(filepath.Join(singleSource, "other.file"), []byte("other"), 0644)
+		So(err, ShouldBeNil)
+
+		for _, cacheData := range []bool{false, true} {
+			accessor := &statAccessor{localAccessor: &localAccessor{target: filepath.Join(singleSource, "ref.fa")}}
+			fs, r := newTestFS(&Config{Mount: filepath.Join(tmpdir, "singleMount"), CacheBase: cacheBase}, &RemoteConfig{Accessor: accessor, CacheData: cacheData})
+			So(r.singleFile, ShouldEqual, "ref.fa")
+
+			entries, status := fs.OpenDir("", nil)
+			So(status, ShouldEqual, fuse.OK)
+			So(len(entries), ShouldEqual, 1)
+			So(entries[0].Name, ShouldEqual, "ref.fa")
+
+			attr, status := fs.GetAttr("ref.fa", nil)
+			So(status, ShouldEqual, fuse.OK)
+			So(attr.Size, ShouldEqual, 11)
+			_, status = fs.GetAttr("other.file", nil)
+			So(status, ShouldEqual, fuse.ENOENT)
+
+			file, status := fs.Open("ref.fa", uint32(os.O_RDONLY), nil)
+			So(status, ShouldEqual, fuse.OK)
+			rr, status := file.Read(make([]byte, 4), 6)
+			So(status, ShouldEqual, fuse.OK)
+			b, _ := rr.Bytes(make([]byte, 4))
+			So(string(b), ShouldEqual, "ACGT")
+			file.Release()
+
+			if cacheData {
+				localPath := r.getLocalPath(r.getRemotePath("ref.fa"))
+				So(localPath, ShouldEqual, filepath.Join(r.cacheDir, singleSource, "ref.fa"))
+				So(r.Uncached(localPath, NewInterval(6, 4)), ShouldBeEmpty)
+			}
+		}
+
+		Convey("But directories are still mounted as directories", func() {
+			fs, r := newTestFS(&Config{Mount: filepath.Join(tmpdir, "singleMount"), CacheBase: cacheBase}, &RemoteConfig{Accessor: &statAccessor{localAccessor: &localAccessor{target: singleSource}}})
+			So(r.singleFile, ShouldBeEmpty)
+
+			entries, status := fs.OpenDir("", nil)
+			So(status, ShouldEqual, fuse.OK)
+			So(len(entries), ShouldEqual, 2)
+		})
+	})
+
+	Convey("Remotes can be mounted at a MountSubpath", t, func() {
+		subSource := filepath.Join(tmpdir, "subSource")
+		defer os.RemoveAll(subSource)
+		write := func(path, content string) {
+			path = filepath.Join(subSource, path)
+			So(os.MkdirAll(filepath.Dir(path), os.FileMode(0777)), ShouldBeNil)
+			So(ioutil.WriteFile(path, []byte(content), 0644), ShouldBeNil)
+		}
+		write("a/genome.fa", "ACGT")
+		write("a/shared.txt", "from a")
+		write("b/sample.bam", "bam")
+		write("c/root.file", "root")
+		write("c/ref/shared.txt", "from c")
+		write("c/ref/extra.txt", "extra")
+
+		fs, _ := newTestFS(&Config{Mount: filepath.Join(tmpdir, "subMount"), CacheBase: cacheBase},
+			&RemoteConfig{Accessor: &localAccessor{target: filepath.Join(subSource, "a")}, MountSubpath: "/ref/"},
+			&RemoteConfig{Accessor: &localAccessor{target: filepath.Join(subSource, "b")}, MountSubpath: "data/in", CacheData: true, Write: true},
+			&RemoteConfig{Accessor: &localAccessor{target: filepath.Join(subSource, "c")}})
+		remotes := fs.remotes
+		So(remotes[0].mountSubpath, ShouldEqual, "ref")
+		So(remotes[1].mountSubpath, ShouldEqual, "data/in")
+		So(remotes[2].mountSubpath, ShouldEqual, "")
+		So(fs.writeRemote, ShouldEqual, remotes[1])
+
+		names := func(dir string) []string {
+			entries, status := fs.OpenDir(dir, nil)
+			So(status, ShouldEqual, fuse.OK)
+			return dirEntryNames(entries)
+		}
+		So(names(""), ShouldResemble, []string{"data", "ref", "root.file"})
+		So(names("data"), ShouldResemble, []string{"in"})
+		So(names("data/in"), ShouldResemble, []string{"sample.bam"})
+		So(names("ref"), ShouldResemble, []string{"extra.txt", "genome.fa", "shared.txt"})
+
+		read := func(name string) string {
+			attr, status := fs.GetAttr(name, nil)
+			So(status, ShouldEqual, fuse.OK)
+			file, status := fs.Open(name, uint32(os.O_RDONLY), nil)
+			So(status, ShouldEqual, fuse.OK)
+			defer file.Release()
+			rr, status := file.Read(make([]byte, attr.Size), 0)
+			So(status, ShouldEqual, fuse.OK)
+			b, _ := rr.Bytes(make([]byte, attr.Size))
+			return string(b)
+		}
+		So(read("ref/genome.fa"), ShouldEqual, "ACGT")
+		So(read("ref/shared.txt"), ShouldEqual, "from a")
+		So(read("ref/extra.txt"), ShouldEqual, "extra")
+		So(read("data/in/sample.bam"), ShouldEqual, "bam")
+
+		_, status := fs.GetAttr("data/genome.fa", nil)
+		So(status, ShouldEqual, fuse.ENOENT)
+
+		Convey("The writeable remote can only write within its MountSubpath", func() {
+			_, status := fs.Create("root.new", uint32(os.O_WRONLY|os.O_CREATE), uint32(0644), nil)
+			So(status, ShouldEqual, fuse.EPERM)
+			So(fs.Mkdir("data/other", 0700, nil), ShouldEqual, fuse.EPERM)
+			So(fs.Rmdir("data/in", nil), ShouldNotEqual, fuse.OK)
+
+			file, status := fs.Create("data/in/new.file", uint32(os.O_WRONLY|os.O_CREATE), uint32(0644), nil)
+			So(status, ShouldEqual, fuse.OK)
+			_, status = file.Write([]byte("new"), 0)
+			So(status, ShouldEqual, fuse.OK)
+			file.Release()
+			So(fs.uploadCreated(), ShouldBeNil)
+			content, err := ioutil.ReadFile(filepath.Join(subSource, "b", "new.file"))
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, "new")
+		})
+	})
+
+	Convey("TreatForbiddenAsMissing makes forbidden files not exist", t, func() {
+		forbidSource := filepath.Join(tmpdir, "forbidSource")
+		os.MkdirAll(forbidSource, os.FileMode(0777))
+		defer os.RemoveAll(forbidSource)
+		err := ioutil.WriteFile(filepath.Join(forbidSource, "a.file"), []byte("a"), 0644)
+		So(err, ShouldBeNil)
+		err = ioutil.WriteFile(filepath.Join(forbidSource, "denied.file"), []byte("d"), 0644)
+		So(err, ShouldBeNil)
+
+		local := &localAccessor{target: forbidSource}
+		accessor := &forbidAccessor{localAccessor: local, forbidden: map[string]bool{local.RemotePath("denied.file"): true}}
+		_, err = newRemote(&RemoteConfig{Accessor: local, TreatForbiddenAsMissing: true}, cacheBase, 1, pkgLogger)
+		So(err, ShouldNotBeNil)
+
+		read := func(treat, cacheData bool, name string) fuse.Status {
+			fs, _ := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "forbidMount"), CacheBase: cacheBase}, &RemoteConfig{Accessor: accessor, CacheData: cacheData, TreatForbiddenAsMissing: treat})
+
+			file, status := fs.Open(name, uint32(os.O_RDONLY), nil)
+			if status != fuse.OK {
+				return status
+			}
+			defer file.Release()
+			_, status = file.Read(make([]byte, 1), 0)
+			return status
+		}
+
+		for _, cacheData := range []bool{false, true} {
+			So(read(false, cacheData, "a.file"), ShouldEqual, fuse.OK)
+			So(read(false, cacheData, "denied.file"), ShouldEqual, fuse.EIO)
+			So(read(true, cacheData, "a.file"), ShouldEqual, fuse.OK)
+			So(read(true, cacheData, "denied.file"), ShouldEqual, fuse.ENOENT)
+		}
+	})
+
+	Convey("StatFs() reflects the usage and quota of UsageAccessors", t, func() {
+		local := &localAccessor{target: tmpdir}
+		config := &Config{Mount: filepath.Join(tmpdir, "usageMount"), CacheBase: cacheBase}
+		fs, _ := newTestFS(config, &RemoteConfig{Accessor: local})
+
+		out := fs.StatFs("")
+		So(out.Bfree, ShouldEqual, totalBlocks)
+		So(out.Frsize, ShouldEqual, 0)
+
+		accessor := &usageAccessor{localAccessor: local, used: 10 * int64(blockSize), quota: 30*int64(blockSize) + 1}
+		fs, r := newTestFS(config, &RemoteConfig{Accessor: accessor})
+
+		out = fs.StatFs("")
+		So(out.Frsize, ShouldEqual, blockSize)
+		So(out.Blocks, ShouldEqual, 30)
+		So(out.Bfree, ShouldEqual, 20)
+		So(out.Bavail, ShouldEqual, 20)
+		So(accessor.calls, ShouldEqual, 1)
+
+		Convey("Usage is remembered for a while", func() {
+			accessor.used = 25 * int64(blockSize)
+			out = fs.StatFs("")
+			So(out.Bfree, ShouldEqual, 20)
+			So(accessor.calls, ShouldEqual, 1)
+
+			r.usageChecked = time.Now().Add(-usageTTL)
+			out = fs.StatFs("")
+			So(out.Bfree, ShouldEqual, 5)
+			So(accessor.calls, ShouldEqual, 2)
+		})
+
+		Convey("Failures fall back on the last known usage", func() {
+			accessor.used = 25 * int64(blockSize)
+			accessor.err = errors.New("unavailable")
+			r.usageChecked = time.Now().Add(-usageTTL)
+			out = fs.StatFs("")
+			So(out.Bfree, ShouldEqual, 20)
+			So(accessor.calls, ShouldEqual, 2)
+		})
+
+		Convey("Without a quota there's lots of free space", func() {
+			accessor2 := &usageAccessor{localAccessor: local, used: 1}
+			fs, _ := newTestFS(config, &RemoteConfig{Accessor: accessor}, &RemoteConfig{Accessor: accessor2})
+			out = fs.StatFs("")
+			So(out.Bfree, ShouldEqual, totalBlocks)
+			So(out.Blocks, ShouldEqual, totalBlocks+11)
+		})
+	})
+
+	Convey("Select() runs queries remotely when the Accessor is a SelectAccessor", t, func() {
+		selectSource := filepath.Join(tmpdir, "selectSource")
+		os.MkdirAll(filepath.Join(selectSource, "sub"), os.FileMode(0777))
+		defer os.RemoveAll(selectSource)
+		err := ioutil.WriteFile(filepath.Join(selectSource, "a.csv"), []byte("1,2,3\n"), 0644)
+		So(err, ShouldBeNil)
+
+		local := &localAccessor{target: selectSource}
+		config := &Config{Mount: filepath.Join(tmpdir, "selectMount"), CacheBase: cacheBase}
+		fs, _ := mountTestFS(config, &RemoteConfig{Accessor: local, CacheData: true})
+
+		_, err = fs.Select("a.csv", "SELECT * FROM S3Object")
+		So(err, ShouldNotBeNil)
+		So(errors.Is(err, syscall.ENOSYS), ShouldBeTrue)
+
+		fs, r := mountTestFS(config, &RemoteConfig{Accessor: &selectAccessor{local}, CacheData: true})
+
+		rc, err := fs.Select("/a.csv", "SELECT s._1 FROM S3Object s")
+		So(err, ShouldBeNil)
+		b, err := ioutil.ReadAll(rc)
+		So(err, ShouldBeNil)
+		So(rc.Close(), ShouldBeNil)
+		So(string(b), ShouldEqual, "SELECT s._1 FROM S3Object s on a.csv")
+		So(r.Uncached(r.getLocalPath(r.getRemotePath("a.csv")), NewInterval(0, 6)), ShouldResemble, Intervals{{0, 5}})
+
+		_, err = fs.Select("missing.csv", "SELECT * FROM S3Object")
+		So(err, ShouldNotBeNil)
+		_, err = fs.Select("sub", "SELECT * FROM S3Object")
+		So(err, ShouldNotBeNil)
+
+		fs.mapMutex.Lock()
+		fs.createdFiles["a.csv"] = true
+		fs.mapMutex.Unlock()
+		_, err = fs.Select("a.csv", "SELECT * FROM S3Object")
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "not been uploaded")
+	})
+
+	Convey("MountPrefix shifts the whole tree down in to a directory", t, func() {
+		prefixSource := filepath.Join(tmpdir, "prefixSource")
+		os.MkdirAll(filepath.Join(prefixSource, "a"), os.FileMode(0777))
+		os.MkdirAll(filepath.Join(prefixSource, "b"), os.FileMode(0777))
+		defer os.RemoveAll(prefixSource)
+		err := ioutil.WriteFile(filepath.Join(prefixSource, "a", "a.file"), []byte("a"), 0644)
+		So(err, ShouldBeNil)
+		err = ioutil.WriteFile(filepath.Join(prefixSource, "b", "b.file"), []byte("b"), 0644)
+		So(err, ShouldBeNil)
+
+		fs, ra := newTestFS(&Config{Mount: filepath.Join(tmpdir, "prefixMount"), CacheBase: cacheBase, MountPrefix: "/work/"},
+			&RemoteConfig{Accessor: &localAccessor{target: filepath.Join(prefixSource, "a")}, CacheData: true, Write: true},
+			&RemoteConfig{Accessor: &localAccessor{target: filepath.Join(prefixSource, "b")}, MountSubpath: "ref"})
+		rb := fs.remotes[1]
+		So(ra.mountSubpath, ShouldEqual, "work")
+		So(rb.mountSubpath, ShouldEqual, "work/ref")
+
+		names := func(dir string) []string {
+			entries, status := fs.OpenDir(dir, nil)
+			So(status, ShouldEqual, fuse.OK)
+			return dirEntryNames(entries)
+		}
+		So(names(""), ShouldResemble, []string{"work"})
+		So(names("work"), ShouldResemble, []string{"a.file", "ref"})
+		So(names("work/ref"), ShouldResemble, []string{"b.file"})
+
+		_, status := fs.GetAttr("a.file", nil)
+		So(status, ShouldEqual, fuse.ENOENT)
+		p := make([]byte, 1)
+		_, err = fs.ReadAt("work/ref/b.file", p, 0)
+		So(err, ShouldBeNil)
+		So(string(p), ShouldEqual, "b")
+
+		_, status = fs.Create("new.file", uint32(os.O_WRONLY|os.O_CREATE), uint32(0644), nil)
+		So(status, ShouldEqual, fuse.EPERM)
+		file, status := fs.Create("work/new.file", uint32(os.O_WRONLY|os.O_CREATE), uint32(0644), nil)
+		So(status, ShouldEqual, fuse.OK)
+		_, status = file.Write([]byte("new"), 0)
+		So(status, ShouldEqual, fuse.OK)
+		file.Release()
+		So(fs.uploadCreated(), ShouldBeNil)
+		content, err := ioutil.ReadFile(filepath.Join(prefixSource, "a", "new.file"))
+		So(err, ShouldBeNil)
+		So(string(content), ShouldEqual, "new")
+	})
+
+	Convey("fuse.conf is checked to see if allow_other is permitted", t, func() {
+		confPath := filepath.Join(tmpdir, "fuse.conf")
+		defer os.Remove(confPath)
+		So(fuseConfAllowsOther(confPath), ShouldBeFalse)
+
+		err := ioutil.WriteFile(confPath, []byte("# mount_max = 1000\n#user_allow_other\n"), 0644)
+		So(err, ShouldBeNil)
+		So(fuseConfAllowsOther(confPath), ShouldBeFalse)
+
+		err = ioutil.WriteFile(confPath, []byte("# mount_max = 1000\n  user_allow_other\n"), 0644)
+		So(err, ShouldBeNil)
+		So(fuseConfAllowsOther(confPath), ShouldBeTrue)
+	})
+
+	Convey("The kernel's attribute and entry timeouts can be configured", t, func() {
+		fs, err := New(&Config{Mount: filepath.Join(tmpdir, "timeoutMount"), CacheBase: cacheBase})
+		So(err, ShouldBeNil)
+		So(fs.attrTimeout, ShouldEqual, time.Second)
+		So(fs.entryTimeout, ShouldEqual, time.Second)
+		So(fs.negativeTimeout, ShouldEqual, time.Second)
+
+		fs, err = New(&Config{Mount: filepath.Join(tmpdir, "timeoutMount"), CacheBase: cacheBase, AttrTimeout: time.Hour, EntryTimeout: 2 * time.Hour, NegativeTimeout: -1})
+		So(err, ShouldBeNil)
+		So(fs.attrTimeout, ShouldEqual, time.Hour)
+		So(fs.entryTimeout, ShouldEqual, 2*time.Hour)
+		So(fs.negativeTimeout, ShouldEqual, 0)
+	})
+
+	Convey("Mount() only waits MountTimeout for the mount to become ready", t, func() {
+		fs, err := New(&Config{Mount: filepath.Join(tmpdir, "timeoutMount"), CacheBase: cacheBase})
+		So(err, ShouldBeNil)
+		So(fs.mountTimeout, ShouldEqual, defaultMountTimeout)
+		fs, err = New(&Config{Mount: filepath.Join(tmpdir, "timeoutMount"), CacheBase: cacheBase, MountTimeout: time.Minute})
+		So(err, ShouldBeNil)
+		So(fs.mountTimeout, ShouldEqual, time.Minute)
+
+		failed := errors.New("mount failed")
+		So(waitWithTimeout(func() error { return nil }, time.Second), ShouldBeNil)
+		So(waitWithTimeout(func() error { return failed }, time.Second), ShouldEqual, failed)
+		hang := make(chan bool)
+		defer close(hang)
+		hung := func() error {
+			<-hang
+			return nil
+		}
+		So(waitWithTimeout(hung, 10*time.Millisecond), ShouldEqual, ErrMountTimeout)
+		slow := func() error {
+			<-time.After(20 * time.Millisecond)
+			return failed
+		}
+		So(waitWithTimeout(slow, -1), ShouldEqual, failed)
+
+		Convey("A failed mount deletes the caches it created", func() {
+			failBase := filepath.Join(tmpdir, "failBase")
+			So(os.MkdirAll(failBase, os.FileMode(0700)), ShouldBeNil)
+			defer os.RemoveAll(failBase)
+			failMount := filepath.Join(tmpdir, "failMount")
+			fs, err := New(&Config{Mount: failMount, CacheBase: failBase})
+			So(err, ShouldBeNil)
+			So(os.Remove(failMount), ShouldBeNil)
+
+			err = fs.Mount(&RemoteConfig{Accessor: NewMemoryAccessor("fail"), CacheData: true})
+			So(err, ShouldNotBeNil)
+			So(fs.remotes, ShouldBeEmpty)
+			entries, err := ioutil.ReadDir(failBase)
+			So(err, ShouldBeNil)
+			So(entries, ShouldBeEmpty)
+		})
+	})
+
+	Convey("GetAttr() can remember paths that don't exist", t, func() {
+		_, err := New(&Config{Mount: filepath.Join(tmpdir, "negMount"), CacheBase: cacheBase, NegativeCacheTTL: -time.Second})
+		So(err, ShouldNotBeNil)
+
+		negSource := filepath.Join(tmpdir, "negSource")
+		os.MkdirAll(negSource, os.FileMode(0777))
+		defer os.RemoveAll(negSource)
+
+		config := &Config{Mount: filepath.Join(tmpdir, "negMount"), CacheBase: cacheBase, NegativeCacheTTL: time.Minute}
+		rc := &RemoteConfig{Accessor: &localAccessor{target: negSource}, Write: true}
+		fs, _ := newTestFS(config, rc)
+
+		_, status := fs.GetAttr("a.file", nil)
+		So(status, ShouldEqual, fuse.ENOENT)
+		So(fs.negativeCache, ShouldContainKey, "a.file")
+
+		// even if the file appears and we'd otherwise look for it again, we
+		// don't until the TTL expires
+		err = ioutil.WriteFile(filepath.Join(negSource, "a.file"), []byte("a"), 0644)
+		So(err, ShouldBeNil)
+		delete(fs.dirContents, "")
+		_, status = fs.GetAttr("a.file", nil)
+		So(status, ShouldEqual, fuse.ENOENT)
+		_, cached := fs.dirContents[""]
+		So(cached, ShouldBeFalse)
+
+		fs.negativeCache["a.file"] = time.Now().Add(-time.Hour)
+		attr, status := fs.GetAttr("a.file", nil)
+		So(status, ShouldEqual, fuse.OK)
+		So(attr.Size, ShouldEqual, 1)
+		So(fs.negativeCache, ShouldNotContainKey, "a.file")
+
+		Convey("Creating the path forgets it didn't exist", func() {
+			_, status = fs.GetAttr("sub", nil)
+			So(status, ShouldEqual, fuse.ENOENT)
+			So(fs.negativeCache, ShouldContainKey, "sub")
+			So(fs.Mkdir("sub", 0700, nil), ShouldEqual, fuse.OK)
+			So(fs.negativeCache, ShouldNotContainKey, "sub")
+			_, status = fs.GetAttr("sub", nil)
+			So(status, ShouldEqual, fuse.OK)
+		})
+
+		Convey("The number of paths remembered is bounded", func() {
+			for i := 0; i < maxNegativeCacheEntries; i++ {
+				fs.negativeCache[fmt.Sprintf("missing.%d", i)] = time.Now()
+			}
+			_, status = fs.GetAttr("another.file", nil)
+			So(status, ShouldEqual, fuse.ENOENT)
+			So(len(fs.negativeCache), ShouldBeLessThanOrEqualTo, maxNegativeCacheEntries/2)
+			So(fs.negativeCache, ShouldContainKey, "another.file")
+		})
+
+		Convey("Unmounting forgets the paths", func() {
+			_, status = fs.GetAttr("missing.file", nil)
+			So(status, ShouldEqual, fuse.ENOENT)
+			So(fs.negativeCache, ShouldNotBeEmpty)
+			So(fs.Unmount(), ShouldBeNil)
+			So(fs.negativeCache, ShouldBeEmpty)
+		})
+
+		Convey("Paths aren't remembered if their parent couldn't be listed", func() {
+			ma := NewMemoryAccessor("neg")
+			ma.Put("dir/c.file", []byte("c"))
+			fi := NewFaultInjector(ma)
+			fs, _ := mountTestFS(config, &RemoteConfig{Accessor: fi})
+
+			fi.AddRule(FaultRule{Method: "ListEntries", Err: errors.New("connection refused")})
+			_, status = fs.GetAttr("dir/c.file", nil)
+			So(status, ShouldEqual, fuse.ENOENT)
+			So(fs.negativeCache, ShouldNotContainKey, "dir/c.file")
+
+			fi.ClearRules()
+			delete(fs.dirContents, "dir")
+			attr, status := fs.GetAttr("dir/c.file", nil)
+			So(status, ShouldEqual, fuse.OK)
+			So(attr.Size, ShouldEqual, 1)
+		})
+
+		Convey("It is disabled by default", func() {
+			fs, _ := newTestFS(&Config{Mount: filepath.Join(tmpdir, "negMount"), CacheBase: cacheBase}, rc)
+			_, status = fs.GetAttr("b.file", nil)
+			So(status, ShouldEqual, fuse.ENOENT)
+			So(fs.negativeCache, ShouldBeEmpty)
+		})
+	})
+
+	Convey("Targets() describes the remotes in use", t, func() {
+		config := &Config{Mount: filepath.Join(tmpdir, "targetsMount"), CacheBase: cacheBase}
+		empty, err := New(config)
+		So(err, ShouldBeNil)
+		So(empty.Targets(), ShouldBeEmpty)
+
+		targetsCache := filepath.Join(tmpdir, "targetsCache")
+		defer os.RemoveAll(targetsCache)
+		fs, _ := newTestFS(config, &RemoteConfig{Accessor: &localAccessor{target: "/a"}}, &RemoteConfig{Accessor: &localAccessor{target: "/b"}, CacheDir: targetsCache, Write: true})
+
+		So(fs.Targets(), ShouldResemble, []TargetInfo{
+			{Target: "/a", RetryBackoffMin: 100 * time.Millisecond, RetryBackoffMax: 10 * time.Second, RetryBackoffFactor: 3, RetryJitter: true},
+			{Target: "/b", Write: true, CacheData: true, CacheDir: targetsCache, RetryBackoffMin: 100 * time.Millisecond, RetryBackoffMax: 10 * time.Second, RetryBackoffFactor: 3, RetryJitter: true},
+		})
+	})
+
+	Convey("The backoff between retries can be configured", t, func() {
+		fs, r := newTestFS(&Config{Mount: filepath.Join(tmpdir, "backoffMount"), CacheBase: cacheBase}, &RemoteConfig{
+			Accessor:           &localAccessor{target: "/a"},
+			RetryBackoffMin:    10 * time.Millisecond,
+			RetryBackoffMax:    50 * time.Millisecond,
+			RetryBackoffFactor: 2,
+			DisableRetryJitter: true,
+		})
+
+		So(fs.Targets(), ShouldResemble, []TargetInfo{
+			{Target: "/a", RetryBackoffMin: 10 * time.Millisecond, RetryBackoffMax: 50 * time.Millisecond, RetryBackoffFactor: 2},
+		})
+
+		So(r.clientBackoff.Duration(), ShouldEqual, 10*time.Millisecond)
+		So(r.clientBackoff.Duration(), ShouldEqual, 20*time.Millisecond)
+		So(r.clientBackoff.Duration(), ShouldEqual, 40*time.Millisecond)
+		So(r.clientBackoff.Duration(), ShouldEqual, 50*time.Millisecond)
+
+		for _, rc := range []*RemoteConfig{
+			{RetryBackoffMin: -1},
+			{RetryBackoffMax: -1},
+			{RetryBackoffMin: time.Second, RetryBackoffMax: time.Millisecond},
+			{RetryBackoffMin: time.Minute},
+			{RetryBackoffFactor: 0.5},
+		} {
+			rc.Accessor = &localAccessor{target: "/a"}
+			_, err := newRemote(rc, cacheBase, 1, fs.Logger)
+			So(err, ShouldNotBeNil)
+		}
+	})
+
+	Convey("Persistent caches are only re-downloaded if the remote file changed", t, func() {
+		etagSource := filepath.Join(tmpdir, "etagSource")
+		os.MkdirAll(etagSource, os.FileMode(0777))
+		defer os.RemoveAll(etagSource)
+		etagCache := filepath.Join(tmpdir, "etagCache")
+		defer os.RemoveAll(etagCache)
+		sourceFile := filepath.Join(etagSource, "a.file")
+		ioutil.WriteFile(sourceFile, []byte("abc"), 0644)
+
+		fs, err := New(&Config{Mount: filepath.Join(tmpdir, "etagMount"), CacheBase: cacheBase})
+		So(err, ShouldBeNil)
+		accessor := &etagAccessor{localAccessor: &localAccessor{target: etagSource}}
+		remoteConfig := &RemoteConfig{Accessor: accessor, CacheDir: etagCache}
+		r, err := newRemote(remoteConfig, cacheBase, 1, fs.Logger)
+		So(err, ShouldBeNil)
+		localPath := r.getLocalPath(sourceFile)
+
+		readCached := func(r *remote) string {
+			file, status := fs.openCached(r, "a.file", uint32(os.O_RDONLY), nil, &fuse.Attr{Size: 3}, false)
+			So(status, ShouldEqual, fuse.OK)
+			defer file.Release()
+			content, err := ioutil.ReadFile(localPath)
+			So(err, ShouldBeNil)
+			return string(content)
+		}
+
+		So(readCached(r), ShouldEqual, "abc")
+		So(accessor.downloads, ShouldEqual, 1)
+		So(r.cachedETag(localPath), ShouldEqual, fmt.Sprintf("%x", md5.Sum([]byte("abc"))))
+
+		// a new mount (remote) re-validates the cached file
+		r, err = newRemote(remoteConfig, cacheBase, 1, fs.Logger)
+		So(err, ShouldBeNil)
+		So(readCached(r), ShouldEqual, "abc")
+		So(accessor.downloads, ShouldEqual, 1)
+		So(r.Uncached(localPath, NewInterval(0, 3)), ShouldBeEmpty)
+
+		ioutil.WriteFile(sourceFile, []byte("xyz"), 0644)
+		r, err = newRemote(remoteConfig, cacheBase, 1, fs.Logger)
+		So(err, ShouldBeNil)
+		So(readCached(r), ShouldEqual, "xyz")
+		So(accessor.downloads, ShouldEqual, 2)
+		So(r.cachedETag(localPath), ShouldEqual, fmt.Sprintf("%x", md5.Sum([]byte("xyz"))))
+
+		Convey("Uploading forgets the ETag", func() {
+			So(r.uploadFile(localPath, sourceFile), ShouldEqual, fuse.OK)
+			So(r.cachedETag(localPath), ShouldBeEmpty)
+		})
+	})
+
+	Convey("With OfflineReads, cached files can be read while the remote is unreachable", t, func() {
+		offlineSource := filepath.Join(tmpdir, "offlineSource")
+		os.MkdirAll(filepath.Join(offlineSource, "sub"), os.FileMode(0777))
+		defer os.RemoveAll(offlineSource)
+		offlineCache := filepath.Join(tmpdir, "offlineCache")
+		defer os.RemoveAll(offlineCache)
+		err := ioutil.WriteFile(filepath.Join(offlineSource, "a.file"), []byte("abc"), 0644)
+		So(err, ShouldBeNil)
+		err = ioutil.WriteFile(filepath.Join(offlineSource, "b.file"), []byte("0123456789"), 0644)
+		So(err, ShouldBeNil)
+
+		accessor := &offlineAccessor{etagAccessor: &etagAccessor{localAccessor: &localAccessor{target: offlineSource}}}
+		_, err = newRemote(&RemoteConfig{Accessor: accessor, OfflineReads: true}, cacheBase, 1, pkgLogger)
+		So(err, ShouldNotBeNil)
+		_, err = newRemote(&RemoteConfig{Accessor: accessor, CacheCompress: true, OfflineReads: true}, cacheBase, 1, pkgLogger)
+		So(err, ShouldNotBeNil)
+
+		mount := func(rc *RemoteConfig) (*MuxFys, *remote) {
+			fs, r := newTestFS(&Config{Mount: filepath.Join(tmpdir, "offlineMount"), CacheBase: cacheBase}, rc)
+			r.readBlockSize = 0 // (so reads aren't rounded up to whole blocks)
+			return fs, r
+		}
+
+		read := func(fs *MuxFys, name string, offset, length int) (string, fuse.Status) {
+			if _, status := fs.GetAttr(name, nil); status != fuse.OK {
+				return "", status
+			}
+			file, status := fs.Open(name, uint32(os.O_RDONLY), nil)
+			if status != fuse.OK {
+				return "", status
+			}
+			defer file.Release()
+			rr, status := file.Read(make([]byte, length), int64(offset))
+			if status != fuse.OK {
+				return "", status
+			}
+			b, status := rr.Bytes(make([]byte, length))
+			return string(b), status
+		}
+
+		Convey("Files cached in a CacheDir by a previous mount can be listed and read", func() {
+			rc := &RemoteConfig{Accessor: accessor, CacheDir: offlineCache, OfflineReads: true}
+			fs, _ := mount(rc)
+			content, status := read(fs, "a.file", 0, 3)
+			So(status, ShouldEqual, fuse.OK)
+			So(content, ShouldEqual, "abc")
+
+			accessor.down = true
+			defer func() { accessor.down = false }()
+			fs, _ = mount(rc)
+			entries, status := fs.OpenDir("", nil)
+			So(status, ShouldEqual, fuse.OK)
+			So(dirEntryNames(entries), ShouldResemble, []string{"a.file"})
+			content, status = read(fs, "a.file", 0, 3)
+			So(status, ShouldEqual, fuse.OK)
+			So(content, ShouldEqual, "abc")
+			_, status = read(fs, "b.file", 0, 3)
+			So(status, ShouldEqual, fuse.ENOENT)
+
+			rc.OfflineReads = false
+			fs, _ = mount(rc)
+			_, status = read(fs, "a.file", 0, 3)
+			So(status, ShouldNotEqual, fuse.OK)
+		})
+
+		Convey("Only reads of uncached parts of files fail", func() {
+			fs, r := mount(&RemoteConfig{Accessor: accessor, CacheData: true, OfflineReads: true})
+			content, status := read(fs, "b.file", 2, 3)
+			So(status, ShouldEqual, fuse.OK)
+			So(content, ShouldEqual, "234")
+
+			accessor.down = true
+			defer func() { accessor.down = false }()
+			fs.mapMutex.Lock()
+			So(fs.openDir(r, ""), ShouldEqual, fuse.OK)
+			fs.mapMutex.Unlock()
+			attr, status := fs.GetAttr("b.file", nil)
+			So(status, ShouldEqual, fuse.OK)
+			So(attr.Size, ShouldEqual, 10)
+			_, status = fs.GetAttr("sub", nil)
+			So(status, ShouldEqual, fuse.OK)
+
+			content, status = read(fs, "b.file", 2, 3)
+			So(status, ShouldEqual, fuse.OK)
+			So(content, ShouldEqual, "234")
+			_, status = read(fs, "b.file", 5, 3)
+			So(status, ShouldEqual, fuse.EIO)
+		})
+	})
+
+	Convey("Directory markers can be hidden", t, func() {
+		config := &Config{Mount: filepath.Join(tmpdir, "markerMount"), CacheBase: cacheBase}
+		accessor := &markerAccessor{
+			localAccessor: &localAccessor{target: "/bucket"},
+			entries: []RemoteAttr{
+				{Name: "/bucket/"},
+				{Name: "/bucket/real.file", Size: 5},
+				{Name: "/bucket/empty.file"},
+				{Name: "/bucket/sub"},
+				{Name: "/bucket/sub/"},
+			},
+		}
+
+		Convey("Without HideDirMarkers, an ambiguous marker is still hidden by its directory", func() {
+			fs, r := newTestFS(config, &RemoteConfig{Accessor: accessor})
+			fs.mapMutex.Lock()
+			defer fs.mapMutex.Unlock()
+			So(fs.openDir(r, ""), ShouldEqual, fuse.OK)
+			So(dirEntryNames(fs.dirContents[""]), ShouldResemble, []string{"empty.file", "real.file", "sub"})
+			_, isFile := fs.files["sub"]
+			So(isFile, ShouldBeFalse)
+			_, isDir := fs.dirs["sub"]
+			So(isDir, ShouldBeTrue)
+		})
+
+		Convey("With HideDirMarkers, markers are only directories", func() {
+			fs, r := newTestFS(config, &RemoteConfig{Accessor: accessor, HideDirMarkers: true})
+			fs.mapMutex.Lock()
+			defer fs.mapMutex.Unlock()
+			So(fs.openDir(r, ""), ShouldEqual, fuse.OK)
+			So(dirEntryNames(fs.dirContents[""]), ShouldResemble, []string{"empty.file", "real.file", "sub"})
+			_, isFile := fs.files["sub"]
+			So(isFile, ShouldBeFalse)
+			_, isDir := fs.dirs["sub"]
+			So(isDir, ShouldBeTrue)
+			So(fs.files["real.file"].Size, ShouldEqual, 5)
+			So(fs.files["empty.file"].Size, ShouldEqual, 0)
+			for _, entry := range fs.dirContents[""] {
+				if entry.Name == "sub" {
+					So(entry.Mode, ShouldEqual, uint32(fuse.S_IFDIR))
+				}
+			}
+		})
+	})
+
+	Convey("Remotes with MaxBytesPerSecond limit their bandwidth", t, func() {
+		limitSource := filepath.Join(tmpdir, "limitSource")
+		os.MkdirAll(limitSource, os.FileMode(0777))
+		defer os.RemoveAll(limitSource)
+		limitCache := filepath.Join(tmpdir, "limitCache")
+		defer os.RemoveAll(limitCache)
+		data := make([]byte, 6000)
+		ioutil.WriteFile(filepath.Join(limitSource, "a.file"), data, 0644)
+		ioutil.WriteFile(filepath.Join(limitSource, "b.file"), data, 0644)
+		ioutil.WriteFile(filepath.Join(limitSource, "c.file"), append(data, data...), 0644)
+
+		logger := log15.New()
+		logger.SetHandler(log15.DiscardHandler())
+		accessor := &localAccessor{target: limitSource}
+
+		_, err := newRemote(&RemoteConfig{Accessor: accessor, MaxBytesPerSecond: -1}, limitCache, 1, logger)
+		So(err, ShouldNotBeNil)
+
+		// the limiter starts with a full bucket of 4000 bytes, so reading 12000
+		// bytes should take 2 seconds
+		mkRemote := func() *remote {
+			r, errn := newRemote(&RemoteConfig{Accessor: accessor, MaxBytesPerSecond: 4000}, limitCache, 1, logger)
+			So(errn, ShouldBeNil)
+			return r
+		}
+
+		Convey("When downloading a file", func() {
+			r := mkRemote()
+			local := filepath.Join(limitCache, "c.file")
+			start := time.Now()
+			So(r.downloadFile(filepath.Join(limitSource, "c.file"), local, 12000), ShouldEqual, fuse.OK)
+			So(time.Since(start), ShouldBeGreaterThan, 1500*time.Millisecond)
+			info, err := os.Stat(local)
+			So(err, ShouldBeNil)
+			So(info.Size(), ShouldEqual, 12000)
+		})
+
+		Convey("When uploading a file, which keeps its content type", func() {
+			ca := &contentTypeAccessor{localAccessor: accessor, contentTypes: make(map[string]string)}
+			r, errn := newRemote(&RemoteConfig{Accessor: ca, MaxBytesPerSecond: 4000}, limitCache, 1, logger)
+			So(errn, ShouldBeNil)
+			So(os.MkdirAll(limitCache, os.FileMode(0777)), ShouldBeNil)
+			local := filepath.Join(limitCache, "up.txt")
+			So(ioutil.WriteFile(local, []byte("some text\n"), 0644), ShouldBeNil)
+			dest := filepath.Join(limitSource, "up.txt")
+			So(r.uploadFile(local, dest), ShouldEqual, fuse.OK)
+			So(ca.contentTypes[dest], ShouldEqual, "text/plain; charset=utf-8")
+			b, err := ioutil.ReadFile(dest)
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, "some text\n")
+		})
+
+		Convey("When reading files concurrently", func() {
+			r := mkRemote()
+			var wg sync.WaitGroup
+			var read int64
+			start := time.Now()
+			for _, name := range []string{"a.file", "b.file"} {
+				wg.Add(1)
+				go func(name string) {
+					defer wg.Done()
+					rc, status := r.getObject(filepath.Join(limitSource, name), 0)
+					if status != fuse.OK {
+						return
+					}
+					defer rc.Close()
+					n, _ := io.Copy(ioutil.Discard, rc)
+					atomic.AddInt64(&read, n)
+				}(name)
+			}
+			wg.Wait()
+			So(time.Since(start), ShouldBeGreaterThan, 1500*time.Millisecond)
+			So(atomic.LoadInt64(&read), ShouldEqual, 12000)
+		})
+	})
+
+	Convey("Remotes with a ProgressFunc report the progress of transfers", t, func() {
+		progSource := filepath.Join(tmpdir, "progSource")
+		os.MkdirAll(progSource, os.FileMode(0777))
+		defer os.RemoveAll(progSource)
+		progCache := filepath.Join(tmpdir, "progCache")
+		defer os.RemoveAll(progCache)
+		size := int64(2*progressInterval + 1000)
+		sourceFile := filepath.Join(progSource, "big.file")
+		ioutil.WriteFile(sourceFile, make([]byte, size), 0644)
+
+		var mu sync.Mutex
+		var calls [][2]int64
+		var paths []string
+		progress := func(path string, transferred, total int64) {
+			mu.Lock()
+			defer mu.Unlock()
+			paths = append(paths, path)
+			calls = append(calls, [2]int64{transferred, total})
+		}
+
+		logger := log15.New()
+		logger.SetHandler(log15.DiscardHandler())
+		r, err := newRemote(&RemoteConfig{Accessor: &localAccessor{target: progSource}, ProgressFunc: progress}, progCache, 1, logger)
+		So(err, ShouldBeNil)
+
+		check := func(path string) {
+			So(calls, ShouldResemble, [][2]int64{{progressInterval, size}, {2 * progressInterval, size}, {size, size}})
+			for _, p := range paths {
+				So(p, ShouldEqual, path)
+			}
+		}
+
+		Convey("When downloading a file", func() {
+			local := filepath.Join(progCache, "big.file")
+			So(r.downloadFile(sourceFile, local, size), ShouldEqual, fuse.OK)
+			check(sourceFile)
+			info, err := os.Stat(local)
+			So(err, ShouldBeNil)
+			So(info.Size(), ShouldEqual, size)
+
+			Convey("And uploading it", func() {
+				calls, paths = nil, nil
+				dest := filepath.Join(progSource, "uploaded.file")
+				So(r.uploadFile(local, dest), ShouldEqual, fuse.OK)
+				check(dest)
+			})
+		})
+
+		Convey("Failed transfers don't report completion", func() {
+			So(r.downloadFile(filepath.Join(progSource, "missing.file"), filepath.Join(progCache, "missing.file"), 10), ShouldNotEqual, fuse.OK)
+			So(calls, ShouldBeEmpty)
+		})
+	})
+
+	Convey("Remotes with VerifyChecksum verify downloads", t, func() {
+		sumSource := filepath.Join(tmpdir, "sumSource")
+		os.MkdirAll(sumSource, os.FileMode(0777))
+		defer os.RemoveAll(sumSource)
+		sumCache := filepath.Join(tmpdir, "sumCache")
+		os.MkdirAll(sumCache, os.FileMode(0777))
+		defer os.RemoveAll(sumCache)
+		sourceFile := filepath.Join(sumSource, "a.file")
+		ioutil.WriteFile(sourceFile, []byte("some data"), 0644)
+		localFile := filepath.Join(sumCache, "a.file")
+
+		logger := log15.New()
+		logger.SetHandler(log15.DiscardHandler())
+		accessor := &checksumAccessor{localAccessor: &localAccessor{target: sumSource}, shas: make(map[string]string)}
+
+		_, err := newRemote(&RemoteConfig{Accessor: accessor, VerifyChecksum: true}, sumCache, 1, logger)
+		So(err, ShouldNotBeNil)
+		_, err = newRemote(&RemoteConfig{Accessor: accessor, VerifyChecksum: true, CacheData: true, EncryptionKey: make([]byte, 32)}, sumCache, 1, logger)
+		So(err, ShouldNotBeNil)
+
+		r, err := newRemote(&RemoteConfig{Accessor: accessor, VerifyChecksum: true, CacheData: true, Write: true}, sumCache, 1, logger)
+		So(err, ShouldBeNil)
+		_, _, _, partial := r.partialUploader(localFile)
+		So(partial, ShouldBeFalse)
+
+		Convey("Using the MD5", func() {
+			So(r.downloadFile(sourceFile, localFile, 9), ShouldEqual, fuse.OK)
+			content, err := ioutil.ReadFile(localFile)
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, "some data")
+
+			accessor.corrupt = true
+			So(r.downloadFile(sourceFile, localFile, 9), ShouldEqual, fuse.EIO)
+			_, err = os.Stat(localFile)
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("Using the SHA256 stored on upload", func() {
+			accessor.multipart = true
+			dest := filepath.Join(sumSource, "b.file")
+			ioutil.WriteFile(localFile, []byte("other data"), 0644)
+			So(r.uploadFile(localFile, dest), ShouldEqual, fuse.OK)
+			So(accessor.shas[dest], ShouldEqual, "873517954b8a3d8fd220525b1d20305e6e5cf87a7927b2debcc9391c6af606e9")
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			cancellable := filepath.Join(sumSource, "c.file")
+			So(r.uploadFileContext(ctx, localFile, cancellable), ShouldEqual, fuse.OK)
+			So(accessor.shas[cancellable], ShouldEqual, accessor.shas[dest])
+
+			So(r.downloadFile(dest, localFile, 10), ShouldEqual, fuse.OK)
+			accessor.corrupt = true
+			So(r.downloadFile(dest, localFile, 10), ShouldEqual, fuse.EIO)
+
+			Convey("Files without a known checksum aren't verified", func() {
+				So(r.downloadFile(sourceFile, localFile, 9), ShouldEqual, fuse.OK)
+			})
+		})
+	})
+
+	Convey("You can make a New MuxFys with a default Mount", t, func() {
+		defaultMnt := filepath.Join(tmpdir, "mnt")
+		fs, err := New(&Config{})
 		So(err, ShouldBeNil)
 		So(fs.mountPoint, ShouldEqual, defaultMnt)
 		_, err = os.Stat(defaultMnt)
@@ -934,6 +5008,51 @@ func TestMuxFys(t *testing.T) {
 	})
 }
 
+// dirEntryNames returns the sorted names of the given entries.
+func dirEntryNames(entries []fuse.DirEntry) []string {
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newTestFS makes a MuxFys with the given Config, set up as if it had been
+// Mount()ed with the given RemoteConfigs, but without fuse. The first writeable
+// remote is its writeRemote. It returns the MuxFys and its first remote (the
+// others are in its remotes). The caches of the remotes are deleted when the
+// calling Convey() finishes.
+func newTestFS(config *Config, rcs ...*RemoteConfig) (*MuxFys, *remote) {
+	fs, err := New(config)
+	So(err, ShouldBeNil)
+	for _, rc := range rcs {
+		r, err := fs.createRemote(rc)
+		So(err, ShouldBeNil)
+		Reset(func() {
+			r.deleteCache()
+		})
+		fs.remotes = append(fs.remotes, r)
+		if r.write && fs.writeRemote == nil {
+			fs.writeRemote = r
+		}
+	}
+	fs.OnMount(nil)
+	if len(fs.remotes) == 0 {
+		return fs, nil
+	}
+	return fs, fs.remotes[0]
+}
+
+// mountTestFS is like newTestFS(), but also lists the root directory, as the
+// first thing most users of a mount would do.
+func mountTestFS(config *Config, rcs ...*RemoteConfig) (*MuxFys, *remote) {
+	fs, r := newTestFS(config, rcs...)
+	_, status := fs.OpenDir("", nil)
+	So(status, ShouldEqual, fuse.OK)
+	return fs, r
+}
+
 // checkEmpty checks if the given directory is empty.
 func checkEmpty(dir string) bool {
 	f, err := os.Open(dir)
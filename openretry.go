@@ -0,0 +1,94 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+// This file implements the waiting for files to appear that is done when
+// Config.OpenRetry is set.
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/jpillora/backoff"
+)
+
+const (
+	openRetryBackoffMin    = 50 * time.Millisecond
+	openRetryBackoffMax    = 1 * time.Second
+	openRetryBackoffFactor = 2
+)
+
+// awaitFile lists the parent directory of name again, with backoff, until name
+// appears in it or our openRetry time has passed, returning true if it
+// appeared. Returns false immediately if we don't have an openRetry or don't
+// know about the parent directory. Must be called while you have the mapMutex
+// Locked; it is Unlocked while waiting between listings.
+func (fs *MuxFys) awaitFile(name string) bool {
+	if fs.openRetry <= 0 {
+		return false
+	}
+	parent := filepath.Dir(name)
+	if parent == "/" || parent == "." {
+		parent = ""
+	}
+	if _, exists := fs.dirs[parent]; !exists {
+		return false
+	}
+
+	b := &backoff.Backoff{
+		Min:    openRetryBackoffMin,
+		Max:    openRetryBackoffMax,
+		Factor: openRetryBackoffFactor,
+		Jitter: true,
+	}
+	deadline := time.Now().Add(fs.openRetry)
+	for attempts := 1; ; attempts++ {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			fs.Info("Gave up waiting for file to appear", "path", name, "attempts", attempts-1)
+			return false
+		}
+		wait := b.Duration()
+		if wait > remaining {
+			wait = remaining
+		}
+
+		fs.mapMutex.Unlock()
+		<-time.After(wait)
+		fs.mapMutex.Lock()
+
+		remotes, exists := fs.dirs[parent]
+		if !exists {
+			return false
+		}
+		for _, status := range fs.openDirs(remotes, parent) {
+			if status != fuse.OK && status != fuse.ENOENT {
+				fs.Warn("awaitFile openDir failed", "path", parent, "status", status)
+			}
+		}
+
+		_, isDir := fs.dirs[name]
+		_, isFile := fs.files[name]
+		if isDir || isFile {
+			fs.Info("File appeared after retrying", "path", name, "attempts", attempts)
+			return true
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenRetry(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "muxfys_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	cacheBase := filepath.Join(tmpdir, "base")
+
+	Convey("OpenRetry can't be negative", t, func() {
+		_, err := New(&Config{Mount: filepath.Join(tmpdir, "mnt"), CacheBase: cacheBase, OpenRetry: -time.Second})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("With OpenRetry, lookups wait for files to appear", t, func() {
+		retry := 500 * time.Millisecond
+		ma := NewMemoryAccessor("retry")
+		ma.Put("existing.file", []byte("a"))
+		ma.Put("dir/existing.file", []byte("b"))
+		fs, _ := newTestFS(&Config{Mount: filepath.Join(tmpdir, "mnt"), CacheBase: cacheBase, OpenRetry: retry, NegativeCacheTTL: time.Minute}, &RemoteConfig{Accessor: ma})
+		_, status := fs.OpenDir("", nil)
+		So(status, ShouldEqual, fuse.OK)
+
+		Convey("GetAttr() finds a file that appears in time", func() {
+			go func() {
+				<-time.After(retry / 5)
+				ma.Put("late.file", []byte("late"))
+			}()
+			start := time.Now()
+			attr, status := fs.GetAttr("late.file", nil)
+			So(status, ShouldEqual, fuse.OK)
+			So(attr.Size, ShouldEqual, 4)
+			So(time.Since(start), ShouldBeLessThan, retry)
+		})
+
+		Convey("Open() finds a file that appears in time in a subdirectory", func() {
+			_, status := fs.GetAttr("dir", nil)
+			So(status, ShouldEqual, fuse.OK)
+			go func() {
+				<-time.After(retry / 5)
+				ma.Put("dir/late.file", []byte("late"))
+			}()
+			file, status := fs.Open("dir/late.file", uint32(os.O_RDONLY), nil)
+			So(status, ShouldEqual, fuse.OK)
+			buf := make([]byte, 4)
+			rr, status := file.Read(buf, 0)
+			So(status, ShouldEqual, fuse.OK)
+			b, _ := rr.Bytes(buf)
+			So(string(b), ShouldEqual, "late")
+			file.Release()
+		})
+
+		Convey("GetAttr() gives up on a file that doesn't appear, then remembers it's missing", func() {
+			start := time.Now()
+			_, status := fs.GetAttr("never.file", nil)
+			So(status, ShouldEqual, fuse.ENOENT)
+			So(time.Since(start), ShouldBeGreaterThanOrEqualTo, retry)
+
+			start = time.Now()
+			_, status = fs.GetAttr("never.file", nil)
+			So(status, ShouldEqual, fuse.ENOENT)
+			So(time.Since(start), ShouldBeLessThan, retry)
+		})
+
+		Convey("Files in unknown directories are not waited for", func() {
+			start := time.Now()
+			_, status := fs.GetAttr("nodir/late.file", nil)
+			So(status, ShouldEqual, fuse.ENOENT)
+			So(time.Since(start), ShouldBeLessThan, retry)
+		})
+	})
+}
@@ -0,0 +1,154 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+// This file contains the implementation of MuxFys.Pause() and Resume().
+
+import (
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// pausedStatus is the status of remote operations attempted while paused, when
+// Config.PauseFailsFast is true or they aren't transferCalls.
+const pausedStatus = fuse.Status(syscall.EAGAIN)
+
+// transferCalls are the clientMethods of retry() that transfer file data, which
+// are made without holding MuxFys' mapMutex, so can wait while we're paused.
+// The other calls (listings, stats, copies, deletes and the like) are made by
+// FUSE operations that hold the mapMutex, which every other operation needs,
+// so they fail fast while paused instead.
+var transferCalls = map[string]bool{
+	"DownloadFile":          true,
+	"DownloadFileIfChanged": true,
+	"OpenFile":              true,
+	"Seek":                  true,
+	"FillUncached":          true,
+	"UploadFile":            true,
+	"UploadData":            true,
+	"Select":                true,
+}
+
+// waitsWhilePaused returns true if the given clientMethod of retry() should
+// wait while we're paused, instead of failing fast.
+func waitsWhilePaused(clientMethod string) bool {
+	if i := strings.Index(clientMethod, "("); i != -1 {
+		clientMethod = clientMethod[:i]
+	}
+	return transferCalls[clientMethod]
+}
+
+// pauseGate lets the operations of a remote be paused.
+type pauseGate struct {
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	paused   bool
+	failFast bool
+	active   int
+}
+
+// newPauseGate creates a new, unpaused, pauseGate.
+func newPauseGate() *pauseGate {
+	g := &pauseGate{}
+	g.cond = sync.NewCond(&g.mutex)
+	return g
+}
+
+// enter records that an operation is in progress, first waiting until we're
+// not paused. If we're paused with failFast, or the given wait is false, it
+// instead immediately returns false. After a true return you must call leave()
+// when the operation is done.
+func (g *pauseGate) enter(wait bool) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	for g.paused {
+		if g.failFast || !wait {
+			return false
+		}
+		g.cond.Wait()
+	}
+	g.active++
+	return true
+}
+
+// leave records that an operation started with enter() is done.
+func (g *pauseGate) leave() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.active--
+	if g.active == 0 {
+		g.cond.Broadcast()
+	}
+}
+
+// pause stops new operations from starting, making them wait for resume(), or
+// fail if failFast, and waits for operations in progress to finish.
+func (g *pauseGate) pause(failFast bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.paused = true
+	g.failFast = failFast
+	for g.active > 0 {
+		g.cond.Wait()
+	}
+}
+
+// resume lets operations start again.
+func (g *pauseGate) resume() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.paused = false
+	g.cond.Broadcast()
+}
+
+// Pause stops all remote activity, eg. so that a snapshot of the cache
+// directories can be taken. New downloads, uploads and reads of remote data
+// wait until Resume() is called (or fail with EAGAIN if Config.PauseFailsFast
+// was true), while Pause() waits for those and any other remote calls already
+// in progress to finish before returning. Note that without CacheData, a file
+// being written directly to a remote is being uploaded until it is closed.
+//
+// Other remote calls, such as the listing of directories not seen before, and
+// the remote parts of deleting, renaming and linking files, always fail with
+// EAGAIN while paused: they are made while holding a lock that every file
+// system operation needs, so waiting would stop them all. Operations that only
+// need local data, such as reading cached data or getting the attributes of
+// files in directories already listed, carry on as normal.
+func (fs *MuxFys) Pause() {
+	fs.mutex.Lock()
+	remotes := fs.remotes
+	fs.mutex.Unlock()
+	for _, r := range remotes {
+		r.gate.pause(fs.pauseFailsFast)
+	}
+}
+
+// Resume lets remote activity stopped by Pause() continue. Unmount() also
+// resumes.
+func (fs *MuxFys) Resume() {
+	fs.mutex.Lock()
+	remotes := fs.remotes
+	fs.mutex.Unlock()
+	for _, r := range remotes {
+		r.gate.resume()
+	}
+}
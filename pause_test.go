@@ -0,0 +1,130 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPause(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "muxfys_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	source := filepath.Join(tmpdir, "source")
+	os.MkdirAll(source, os.FileMode(0777))
+	ioutil.WriteFile(filepath.Join(source, "a.file"), []byte("abc"), 0644)
+	os.MkdirAll(filepath.Join(source, "sub"), os.FileMode(0777))
+	ioutil.WriteFile(filepath.Join(source, "sub", "b.file"), []byte("b"), 0644)
+
+	Convey("Pausing waits for remote operations in progress to finish", t, func() {
+		accessor := &blockingAccessor{localAccessor: &localAccessor{target: source}, block: make(chan bool)}
+		fs, r := newTestFS(&Config{Mount: filepath.Join(tmpdir, "pauseMount"), CacheBase: tmpdir}, &RemoteConfig{Accessor: accessor})
+
+		listed := make(chan fuse.Status)
+		go func() {
+			_, status := r.findObjects(source + "/")
+			listed <- status
+		}()
+		<-time.After(50 * time.Millisecond)
+
+		paused := make(chan bool)
+		go func() {
+			fs.Pause()
+			close(paused)
+		}()
+		select {
+		case <-paused:
+			So("paused before the listing finished", ShouldBeEmpty)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(accessor.block)
+		So(<-listed, ShouldEqual, fuse.OK)
+		<-paused
+
+		Convey("New transfers then wait until Resume()", func() {
+			downloaded := make(chan fuse.Status)
+			go func() {
+				downloaded <- r.downloadFile(filepath.Join(source, "a.file"), filepath.Join(tmpdir, "downloaded.file"), 3)
+			}()
+			select {
+			case <-downloaded:
+				So("downloaded while paused", ShouldBeEmpty)
+			case <-time.After(50 * time.Millisecond):
+			}
+
+			fs.Resume()
+			So(<-downloaded, ShouldEqual, fuse.OK)
+		})
+
+		Convey("Unmount() resumes", func() {
+			So(fs.Unmount(), ShouldBeNil)
+			_, status := r.findObjects(source + "/")
+			So(status, ShouldEqual, fuse.OK)
+		})
+	})
+
+	Convey("While paused, listings fail fast, so don't block other operations", t, func() {
+		fs, _ := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "pauseMount"), CacheBase: tmpdir}, &RemoteConfig{Accessor: &localAccessor{target: source}})
+
+		fs.Pause()
+		_, status := fs.OpenDir("sub", nil)
+		So(status, ShouldEqual, fuse.Status(syscall.EAGAIN))
+		_, status = fs.GetAttr("sub/b.file", nil)
+		So(status, ShouldEqual, fuse.Status(syscall.EAGAIN))
+		_, status = fs.GetAttr("a.file", nil)
+		So(status, ShouldEqual, fuse.OK)
+
+		fs.Resume()
+		entries, status := fs.OpenDir("sub", nil)
+		So(status, ShouldEqual, fuse.OK)
+		So(len(entries), ShouldEqual, 1)
+	})
+
+	Convey("With PauseFailsFast, operations fail while paused", t, func() {
+		fs, r := mountTestFS(&Config{Mount: filepath.Join(tmpdir, "pauseMount"), CacheBase: tmpdir, PauseFailsFast: true}, &RemoteConfig{Accessor: &localAccessor{target: source}})
+
+		file, status := fs.Open("a.file", uint32(os.O_RDONLY), nil)
+		So(status, ShouldEqual, fuse.OK)
+		defer file.Release()
+
+		fs.Pause()
+		_, status = r.findObjects(source + "/")
+		So(status, ShouldEqual, fuse.Status(syscall.EAGAIN))
+		_, status = file.Read(make([]byte, 3), 0)
+		So(status, ShouldEqual, fuse.Status(syscall.EAGAIN))
+
+		fs.Resume()
+		rr, status := file.Read(make([]byte, 3), 0)
+		So(status, ShouldEqual, fuse.OK)
+		b, _ := rr.Bytes(nil)
+		So(string(b), ShouldEqual, "abc")
+	})
+}
@@ -22,31 +22,118 @@ package muxfys
 // etc.
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/hanwen/go-fuse/v2/fuse"
 	"github.com/inconshreveable/log15"
 	"github.com/jpillora/backoff"
 	"github.com/mitchellh/go-homedir"
+	"golang.org/x/time/rate"
 )
 
-const downRemoteWaitTime = 10 * time.Minute
+const (
+	downRemoteWaitTime = 10 * time.Minute
+
+	// slowCallMsg is what we log when a remote call takes longer than
+	// Config.SlowThreshold.
+	slowCallMsg = "Remote call was slow"
+
+	// the defaults for the RemoteConfig.RetryBackoff* options.
+	defaultRetryBackoffMin    = 100 * time.Millisecond
+	defaultRetryBackoffMax    = 10 * time.Second
+	defaultRetryBackoffFactor = 3
+
+	// defaultListConcurrency is the default for RemoteConfig.ListConcurrency.
+	defaultListConcurrency = 8
+
+	// maxRateBurst is the most bytes we'll let through a rate limiter at once.
+	maxRateBurst = 1048576
+
+	// progressInterval is how many bytes get transferred between calls to a
+	// RemoteConfig.ProgressFunc.
+	progressInterval = 1048576
+
+	// etagFilePrefix is prefixed to the basename of cached files to get the
+	// name of the file we store their ETag in.
+	etagFilePrefix = ".muxfys_etag."
+
+	// uploadFilePrefix is prefixed to the basename of cached files to get the
+	// name of the file we record the progress of their resumable upload in.
+	uploadFilePrefix = ".muxfys_upload."
+
+	// usageTTL is how long we remember the result of UsageAccessor.Usage().
+	usageTTL = 30 * time.Second
+)
+
+// readCalls are the clientMethods of retry() that only read files, for which
+// RemoteConfig.TreatForbiddenAsMissing applies.
+var readCalls = map[string]bool{
+	"StatFile":              true,
+	"DownloadFile":          true,
+	"DownloadFileIfChanged": true,
+	"OpenFile":              true,
+}
+
+// ErrNotModified is returned by ConditionalDownloader.DownloadFileIfChanged()
+// when the remote file has not changed.
+var ErrNotModified = errors.New("remote file not modified")
+
+// ErrAlreadyExists is returned by ExclusiveUploader.UploadFileIfAbsent() when
+// the remote file already exists.
+var ErrAlreadyExists = errors.New("remote file already exists")
+
+// ErrPartialUploadUnsupported is returned by PartialUploader.UploadModified()
+// when it can't upload just the modified parts of a file, in which case the
+// whole file is uploaded instead.
+var ErrPartialUploadUnsupported = errors.New("partial upload not supported")
+
+// ErrAppendUnsupported is returned by Appender.AppendFile() when it can't
+// append to a particular file, in which case the parts of the file that weren't
+// downloaded are downloaded and the whole file is uploaded instead.
+var ErrAppendUnsupported = errors.New("append not supported")
+
+// ErrChecksumMismatch is the error (wrapped with details) that downloads fail
+// with when RemoteConfig.VerifyChecksum is true and the downloaded file does
+// not have the checksum of the remote file.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// cannedACLs are the valid values of RemoteConfig.CannedACL.
+var cannedACLs = map[string]bool{
+	"private":                   true,
+	"public-read":               true,
+	"public-read-write":         true,
+	"authenticated-read":        true,
+	"aws-exec-read":             true,
+	"bucket-owner-read":         true,
+	"bucket-owner-full-control": true,
+}
 
 // RemoteConfig struct is how you configure what you want to mount, and how you
 // want to cache.
 type RemoteConfig struct {
 	// Accessor is the RemoteAccessor for your desired remote file system type.
-	// Currently there is only one implemented choice: an S3Accessor. When you
-	// make a new one of these (by calling NewS3Accessor()), you will provide
-	// all the connection details for accessing your remote file system.
+	// Currently implemented choices are an S3Accessor, a B2Accessor, a
+	// SwiftAccessor and a read-only HTTPAccessor. When you make a new one of
+	// these (by calling NewS3Accessor(), NewB2Accessor(), NewSwiftAccessor()
+	// or NewHTTPAccessor()), you will provide all the connection details for
+	// accessing your remote file system.
 	Accessor RemoteAccessor
 
 	// CacheDir is the directory used to cache data if CacheData is true.
@@ -64,6 +151,296 @@ type RemoteConfig struct {
 	// Write enables write operations in the mount. Only set true if you know
 	// you really need to write.
 	Write bool
+
+	// MaxBytesPerSecond limits the combined download and upload bandwidth used
+	// by all files of this remote. The default of 0 means unlimited.
+	MaxBytesPerSecond int64
+
+	// HideDirMarkers treats zero-byte objects whose keys end in "/" purely as
+	// directory markers, so such objects are never shown as files. (Regardless
+	// of this setting, an object with the same name as a directory, such as
+	// "sub" alongside "sub/", is always hidden by the directory.)
+	HideDirMarkers bool
+
+	// CacheCompress stores cached data gzip compressed on local disk, in
+	// independently compressed blocks so that random reads remain efficient.
+	// Defining this makes CacheData be treated as true. With Write, files are
+	// stored uncompressed while open for writing, and compressed when their
+	// last handle is closed, if all their data is cached (otherwise they are
+	// compressed once uploaded). You shouldn't use the same CacheDir with and
+	// without CacheCompress.
+	CacheCompress bool
+
+	// CacheShard spreads cached files over 2 levels of sub-directories of the
+	// cache directory, named after the first 4 hex digits of a hash of each
+	// file's remote path, eg. CacheDir/3f/a2/host/bucket/key. This keeps the
+	// number of files in each cache directory manageable when caching many
+	// files with the same prefix. Directories can't be listed from a sharded
+	// cache, so with OfflineReads only directories that were already listed
+	// are available when offline. You shouldn't use the same CacheDir with and
+	// without CacheShard.
+	CacheShard bool
+
+	// EagerCacheBelow, if greater than 0, makes listing a directory also
+	// download in to the cache, in the background, each file in it smaller
+	// than this many bytes, so that opening them afterwards is instant. This is
+	// worthwhile for directories of small files that will almost certainly all
+	// be read, like indexes. Larger files are only downloaded when read.
+	// Requires CacheData.
+	EagerCacheBelow int64
+
+	// UploadFileMode, if set, is the permissions (eg. 0644) recorded with each
+	// file uploaded, as "mode" user metadata (for S3, an X-Amz-Meta-Mode header
+	// holding the octal value). Files created in the mount are shown with these
+	// permissions, as are listed files that had a mode recorded, if the
+	// Accessor includes metadata in its listings. Otherwise files are shown
+	// with permissions 0600. Requires Write and an Accessor that is a
+	// MetadataUploader, and can't be used with StreamWrites or EncryptionKey.
+	// Every upload is then of the whole file, so appends and partial uploads
+	// aren't done.
+	UploadFileMode os.FileMode
+
+	// EncryptionKey, if set, must be a 32 byte key that will be used to
+	// AES-256-GCM encrypt file contents before they are uploaded, and decrypt
+	// them after they are downloaded. Contents are encrypted in chunks of
+	// 64KiB, each of which is authenticated, so that files can be streamed and
+	// read from any offset. A random nonce per file is stored at the start of
+	// the file's remote data, so files are stored remotely with an extra 20
+	// bytes, plus 16 bytes per chunk. Filenames are not encrypted, content
+	// types are not recorded, and mixing encrypted and unencrypted files in the
+	// same remote is not supported: all files are assumed to be encrypted with
+	// the same key.
+	EncryptionKey []byte
+
+	// TransparentGunzip presents remote files with a ".gz" extension
+	// decompressed (at the same path), for when files are stored
+	// gzip-compressed but need to be read uncompressed. Their decompressed
+	// size is taken from their "original-size" metadata (eg. the
+	// "x-amz-meta-original-size" header of S3 objects) if they have it,
+	// otherwise they are decompressed once when first listed to find out.
+	// Since gzip files can't be decompressed from the middle, reads that
+	// don't carry on from where the previous read left off decompress from
+	// the start of the file again, so random access is slow; use CacheData
+	// so that the decompressed data gets cached. It can't be used with Write,
+	// EncryptionKey or VerifyChecksum.
+	TransparentGunzip bool
+
+	// CacheInMemory caches data read from the remote in memory instead of on
+	// local disk, for when you can't use CacheData. Data is cached in blocks of
+	// 256KiB, and the least recently read blocks are discarded to keep within
+	// MemCacheMaxBytes. It can't be combined with Write or any of the other
+	// Cache* options.
+	CacheInMemory bool
+
+	// MemCacheMaxBytes is the most data that will be held in memory when
+	// CacheInMemory is true. The default of 0 means 256MiB.
+	MemCacheMaxBytes int64
+
+	// DisablePartialUploads turns off the default behaviour when Write is
+	// true and the Accessor implements PartialUploader (as S3Accessor does):
+	// that when a file is altered in place without changing its size, only the
+	// parts of it containing bytes that were written to get uploaded, with the
+	// rest being copied remotely from the existing remote file. This is only
+	// done if the ETag of the remote file was known when the file was cached
+	// (which needs the Accessor to be a ConditionalDownloader or FileStater),
+	// and the remote file still has that ETag.
+	DisablePartialUploads bool
+
+	// OfflineReads lets you keep reading files you've already cached when the
+	// remote becomes unreachable. Directories that can't be listed remotely
+	// are listed from the cache instead, and cached files are trusted without
+	// checking if they've changed remotely, so that reads of cached data
+	// succeed; only reads of data that isn't cached fail. This is most useful
+	// with a CacheDir that persists between mounts. It requires CacheData and
+	// can't be used with CacheCompress.
+	OfflineReads bool
+
+	// SeedCacheFrom, if set, is a directory of files laid out like the remote
+	// (relative to the Accessor's root path), eg. pre-staged on to local disk,
+	// that are put in the cache (hard linked if possible, otherwise copied)
+	// when you Mount(), and treated as already cached. Reads of them are then
+	// served from the cache without downloading, as long as their sizes match
+	// the remote files (or, with OfflineReads, without the remote being
+	// reachable at all, letting you work entirely offline on the seeded
+	// files). It requires CacheData, and can't be used with CacheCompress.
+	SeedCacheFrom string
+
+	// SharedCache is for a CacheDir shared with other mounts that might be
+	// caching a remote file while it grows. Normally a cached file that isn't
+	// the same size as the remote file is deleted and downloaded again, but
+	// with this set, the existing cached data is trusted: only the missing end
+	// of a smaller cached file is downloaded, and a larger cached file is used
+	// as-is. It requires a CacheDir and can't be used with Write,
+	// CacheCompress or VerifyChecksum.
+	SharedCache bool
+
+	// MountSubpath is the directory, relative to the mount point (or to
+	// Config.MountPrefix, if set), that the contents of this remote will
+	// appear in, eg. "ref" or "inputs/sample1".
+	// The directories in the path are created as needed. The default of ""
+	// puts the contents at the mount point itself. When the MountSubpaths of
+	// different remotes are the same, or one remote has a directory at another
+	// remote's MountSubpath, their contents are multiplexed in the normal way.
+	MountSubpath string
+
+	// ProgressFunc, if set, is called periodically while a whole file is
+	// being downloaded to or uploaded from the cache, with the complete remote
+	// path of the file, the number of bytes transferred so far and the total
+	// size of the file. It is called a final time with transferred equal to
+	// total when the transfer succeeds. It may be called concurrently for
+	// different files, so must be safe for concurrent use. Like
+	// MaxBytesPerSecond, it means that the data of such transfers is streamed
+	// through muxfys, so the Accessor's ConditionalDownloader,
+	// PartialUploader and ResumableUploader implementations will not be used.
+	ProgressFunc func(path string, transferred, total int64)
+
+	// VerifyChecksum makes whole files be downloaded to the cache when they
+	// are opened, with the download then being checked against the remote
+	// file's MD5 or, if that isn't known (eg. S3 multipart uploads, which
+	// have ETags containing a dash), the SHA256 that muxfys stored alongside
+	// it when uploading it. If the checksums differ the download is retried,
+	// and if it still differs the bad cache file is deleted and the open
+	// fails. It requires CacheData, can't be used with CacheCompress or
+	// EncryptionKey, and has no effect unless the Accessor implements
+	// ChecksumAccessor (as S3Accessor does). Files uploaded from the cache
+	// then always get a SHA256 stored, so the Accessor's PartialUploader
+	// implementation is not used. Files whose checksum
+	// isn't known can't be verified. Note that S3 objects encrypted with
+	// SSE-KMS or SSE-C have ETags that aren't MD5s, so this can't be used with
+	// them.
+	VerifyChecksum bool
+
+	// MaxWriteBytes, if greater than 0, is the largest a file written via the
+	// mount is allowed to become. Writes (and truncations) that would make a
+	// file larger fail with EFBIG, and any larger file in the cache is not
+	// uploaded at Unmount() time. The default of 0 means unlimited.
+	MaxWriteBytes int64
+
+	// ListDelimiter is the string that separates the "directories" of object
+	// keys, in addition to "/", when deciding how to present them in the
+	// mount. The default of "" means only "/" is used. Eg. with a ListDelimiter
+	// of "_", the key "a_b_c.txt" is presented as the file c.txt in the
+	// directory a/b. It can't be combined with Write.
+	ListDelimiter string
+
+	// FlattenDepth, if greater than 0, is the maximum number of path
+	// components that presented paths may have. Any deeper components are
+	// collapsed in to a single file name, joined with ".". Eg. with a
+	// FlattenDepth of 2, the key "a/b/c.txt" is presented as "a/b.c.txt", and
+	// with a FlattenDepth of 1 every file is presented in the root directory.
+	// Each directory listing lists everything beneath the root of the remote,
+	// so this is best used with smaller buckets or prefixes. It can't be
+	// combined with Write. The default of 0 presents keys as they are.
+	FlattenDepth int
+
+	// KeyNormalizer, if supplied, is applied to object keys to decide the
+	// paths they are presented at in the mount, and to the paths you look up,
+	// so that keys that differ only in eg. case or unicode normalisation can
+	// still be found. Reads of a presented path use the real key it came from.
+	// NormalizeNFC and NormalizeCase are provided for this purpose. If
+	// different keys normalise to the same path, only one of them will be
+	// accessible (a warning is logged about the others), so be sure this is
+	// acceptable for your remote. Like FlattenDepth, each directory listing
+	// lists everything beneath the root of the remote, and it can't be
+	// combined with Write.
+	KeyNormalizer func(key string) string
+
+	// LongNameStrategy decides what happens to files and directories with
+	// names longer than NAME_MAX (255 bytes), which can't be presented via
+	// fuse as they are. The default of LongNameError leaves them out of
+	// directory listings, while LongNameHash and LongNameTruncate present them
+	// at shortened names; see the docs of those values for details.
+	LongNameStrategy LongNameStrategy
+
+	// IncludeGlobs, if supplied, limits the files that appear in directory
+	// listings to those matching at least one of these glob patterns (in the
+	// syntax of path.Match()). Patterns without a "/" are matched against
+	// file names, while those with a "/" are matched against the whole path
+	// relative to the root of the remote. Directories always appear, so
+	// matching files deeper down can still be reached. Files that are hidden
+	// this way can't be opened.
+	IncludeGlobs []string
+
+	// ExcludeGlobs is like IncludeGlobs, but hides the files that match any
+	// of these patterns. It applies after IncludeGlobs.
+	ExcludeGlobs []string
+
+	// CannedACL, if supplied, is the canned ACL given to every object
+	// uploaded or copied via the mount, instead of the default (typically
+	// "private"). It must be one of "private", "public-read",
+	// "public-read-write", "authenticated-read", "aws-exec-read",
+	// "bucket-owner-read" or "bucket-owner-full-control", and the Accessor
+	// must be a CannedACLSetter (as S3Accessor is).
+	CannedACL string
+
+	// RetryBackoffMin, RetryBackoffMax and RetryBackoffFactor control the
+	// exponential backoff between retries of failed remote calls: the first
+	// retry waits RetryBackoffMin, and each subsequent wait is
+	// RetryBackoffFactor times longer, up to RetryBackoffMax. The defaults of
+	// 0 mean 100ms, 10s and 3 respectively.
+	RetryBackoffMin    time.Duration
+	RetryBackoffMax    time.Duration
+	RetryBackoffFactor float64
+
+	// DisableRetryJitter turns off the default behaviour of randomising the
+	// waits between retries (to avoid many clients retrying in lock-step),
+	// making retry timing deterministic.
+	DisableRetryJitter bool
+
+	// ObjectTags, if supplied, are given as tags to every file uploaded via
+	// the mount (replacing any tags an existing file had). It requires Write,
+	// and the Accessor must be a TaggingAccessor (as S3Accessor is).
+	ObjectTags map[string]string
+
+	// StreamWrites, when Write is true, makes files that are created (or
+	// opened for writing and truncated) get uploaded as they are written,
+	// instead of being staged in the cache and uploaded when closed or at
+	// Unmount(). This avoids using local disk space for large outputs, but
+	// such files can only be written serially from the start: opening them
+	// with O_RDWR or O_APPEND, or writing anywhere other than their end,
+	// fails with ENOSYS. Without CacheData, files are always streamed like
+	// this.
+	StreamWrites bool
+
+	// VersionAsOf, if not the zero time, pins the mount to a snapshot of the
+	// remote as it was at this time: files created later don't appear, and
+	// files modified later are read as they were at this time. It is for
+	// reproducibly reading from buckets with versioning enabled, so can't be
+	// combined with Write, and the Accessor must be a VersionPinner (as
+	// S3Accessor is).
+	VersionAsOf time.Time
+
+	// TreatForbiddenAsMissing makes files that the remote says you're
+	// forbidden to read appear not to exist (ENOENT), instead of giving an
+	// I/O error. This is for misconfigured public buckets that deny access to
+	// missing files instead of saying they don't exist. It only applies to
+	// reading files, since it could otherwise mask real permission problems,
+	// and the Accessor must be a ForbiddenDetector (as S3Accessor is).
+	TreatForbiddenAsMissing bool
+
+	// NoOverwrite, when Write is true, protects existing remote files from
+	// being replaced: Create() fails with EEXIST if the file already exists
+	// (checked with a remote stat if the Accessor is a FileStater), and so
+	// does the upload of any created file that would replace one. If the
+	// Accessor is an ExclusiveUploader, uploads are done conditionally so that
+	// files created by someone else in the meantime are also protected;
+	// otherwise we check for the file just before uploading. It can't be used
+	// with StreamWrites.
+	NoOverwrite bool
+
+	// SkipWriteCheck, when Write is true, stops Mount() from checking that the
+	// remote really can be written to. Normally an empty file is uploaded in
+	// to a ".muxfys_write_test" directory and then deleted, and Mount() fails
+	// if the upload fails, instead of you only finding out when your files
+	// fail to upload. Skip the check for remotes where that test upload is
+	// undesirable, eg. because it would trigger a notification.
+	SkipWriteCheck bool
+
+	// ListConcurrency is the most directories that List(true) will list at
+	// once, so that listing a deep tree doesn't exhaust your connection pool
+	// or trip the remote's rate limits. The default of 0 means 8; use 1 to
+	// list one directory at a time.
+	ListConcurrency int
 }
 
 // RemoteAttr struct describes the attributes of a remote file or directory.
@@ -73,6 +450,15 @@ type RemoteAttr struct {
 	Size  int64     // Size of the file in bytes
 	MTime time.Time // Time the file was last modified
 	MD5   string    // MD5 checksum of the file (if known)
+
+	// Metadata is the user metadata of the file (if known), such as the
+	// values of S3 "x-amz-meta-*" headers, keyed on lower-cased names without
+	// that prefix.
+	Metadata map[string]string
+
+	// VersionID is the ID of this version of the file (if known), for remote
+	// file systems or object stores that keep multiple versions of files.
+	VersionID string
 }
 
 // RemoteAccessor is the interface used by remote to actually communicate with
@@ -144,26 +530,442 @@ type RemoteAccessor interface {
 	LocalPath(baseDir, remotePath string) (localPath string)
 }
 
+// ConditionalDownloader is an optional interface that RemoteAccessors can also
+// implement, to avoid downloading files that are already in a CacheDir that
+// persists between mounts.
+type ConditionalDownloader interface {
+	// DownloadFileIfChanged is like DownloadFile(), but if etag is not empty
+	// and the remote source file still has that ETag, it should leave dest
+	// untouched and return ErrNotModified. Otherwise it should return the
+	// ETag of the file it downloaded.
+	DownloadFileIfChanged(source, dest, etag string) (newETag string, err error)
+}
+
+// PartialUploader is an optional interface that RemoteAccessors can also
+// implement, to avoid uploading the whole of a large file when only small
+// parts of it were modified.
+type PartialUploader interface {
+	// UploadModified is like UploadFile(), but the local source file is the
+	// same as the version of the remote dest file with the given ETag, except
+	// in the given modified ranges. It should avoid sending the unmodified
+	// data, or return ErrPartialUploadUnsupported if it can't (eg. because dest
+	// doesn't exist, no longer has that ETag, or is a different size to
+	// source).
+	UploadModified(source, dest, contentType, etag string, modified Intervals) error
+}
+
+// UploadState describes the progress of an upload being done by a
+// ResumableUploader, so that it can be resumed if interrupted.
+type UploadState struct {
+	// UploadID identifies the upload to the remote file system or object
+	// store.
+	UploadID string
+
+	// Parts are the parts of the file that have been uploaded so far.
+	Parts []UploadedPart
+}
+
+// UploadedPart describes a part of a file uploaded by a ResumableUploader.
+type UploadedPart struct {
+	Number int
+	ETag   string
+}
+
+// ResumableUploader is an optional interface that RemoteAccessors can also
+// implement, so that interrupted uploads of large files can be resumed instead
+// of being started again from the beginning.
+type ResumableUploader interface {
+	// UploadFileResumable is like UploadFile(), but large files should be
+	// uploaded in parts, calling save with the current UploadState after
+	// starting the upload and after uploading each part. If state is not nil,
+	// it was saved during an earlier interrupted attempt to upload the same
+	// unaltered source to dest, and only the parts not yet uploaded should be
+	// sent. opts should be recorded as for ReaderUploader.UploadReader(), and
+	// it should give up, returning an error, if ctx is done.
+	UploadFileResumable(ctx context.Context, source, dest string, opts UploadOptions, state *UploadState, save func(*UploadState)) error
+
+	// AbortUpload abandons the upload to dest described by state, cleaning up
+	// anything that was uploaded.
+	AbortUpload(dest string, state *UploadState) error
+}
+
+// FileStater is an optional interface that RemoteAccessors can also implement,
+// to allow the mounting of a single file. If the path a RemoteAccessor was
+// configured with (ie. RemotePath("")) turns out to be a file instead of a
+// directory, the mount will contain just that file. It is also how
+// MuxFys.Xattrs() gets the Metadata of files.
+type FileStater interface {
+	// StatFile returns the details of the file at exactly the given remote
+	// path. It should return an error for which ErrorIsNotExists() returns
+	// true if there is no such file.
+	StatFile(path string) (RemoteAttr, error)
+}
+
+// ChecksumAccessor is an optional interface that RemoteAccessors can also
+// implement, to support RemoteConfig.VerifyChecksum. Its UploadReader() (and
+// UploadFileResumable(), if it is a ResumableUploader) should store any
+// UploadOptions.SHA256 alongside dest, so that Checksums() can return it.
+type ChecksumAccessor interface {
+	ReaderUploader
+
+	// Checksums returns the hex encoded MD5 and SHA256 of the remote file at
+	// path, as far as they are known; either may be empty. An MD5 containing
+	// a dash is assumed to be a multipart ETag and is ignored.
+	Checksums(path string) (md5, sha256 string, err error)
+}
+
+// CannedACLSetter is an optional interface that RemoteAccessors can also
+// implement, to support RemoteConfig.CannedACL.
+type CannedACLSetter interface {
+	// SetCannedACL makes all subsequent uploads and copies give the
+	// destination object the given canned ACL.
+	SetCannedACL(acl string)
+}
+
+// TaggingAccessor is an optional interface that RemoteAccessors can also
+// implement, to support RemoteConfig.ObjectTags and MuxFys.SetObjectTags().
+type TaggingAccessor interface {
+	// SetTags replaces the tags of the remote file at path with the given
+	// ones. Tags are distinct from the user metadata of RemoteAttr, and can
+	// be changed without re-uploading the file.
+	SetTags(path string, tags map[string]string) error
+}
+
+// VersionPinner is an optional interface that RemoteAccessors can also
+// implement, to support RemoteConfig.VersionAsOf.
+type VersionPinner interface {
+	// PinVersionsAsOf makes the accessor only see files as they were at time
+	// t: ListEntries() should only return the files that existed then, giving
+	// the details (including the VersionID) of the version that was current
+	// at t, and all reads of a file should be of that version.
+	PinVersionsAsOf(t time.Time)
+}
+
+// ForbiddenDetector is an optional interface that RemoteAccessors can also
+// implement, to support RemoteConfig.TreatForbiddenAsMissing.
+type ForbiddenDetector interface {
+	// ErrorIsForbidden should return true if the supplied error (retrieved
+	// from any of the RemoteAccessor methods) indicates that access to the
+	// requested file was denied.
+	ErrorIsForbidden(err error) bool
+}
+
+// ExclusiveUploader is an optional interface that RemoteAccessors can also
+// implement, to make RemoteConfig.NoOverwrite safe against other clients
+// creating the same file at the same time.
+type ExclusiveUploader interface {
+	// UploadFileIfAbsent is like UploadFile(), but should atomically fail with
+	// ErrAlreadyExists if dest already exists, eg. by doing a conditional PUT
+	// with an "If-None-Match: *" header. opts should be recorded as for
+	// ReaderUploader.UploadReader().
+	UploadFileIfAbsent(source, dest string, opts UploadOptions) error
+}
+
+// MetadataUploader is an optional interface that RemoteAccessors can also
+// implement, to support MuxFys.CreateWithMetadata() and
+// RemoteConfig.UploadFileMode. Its UploadReader() (and UploadFileResumable(),
+// if it is a ResumableUploader) should store any UploadOptions.Metadata with
+// dest.
+type MetadataUploader interface {
+	ReaderUploader
+
+	// StoresMetadata should return true if metadata can currently be stored,
+	// eg. because the remote system supports it.
+	StoresMetadata() bool
+}
+
+// UsageAccessor is an optional interface that RemoteAccessors can also
+// implement, so that StatFs() (and thus eg. `df`) reports the real usage and
+// quota of the remote instead of a very large, empty file system.
+type UsageAccessor interface {
+	// Usage returns the number of bytes currently stored in the remote, and
+	// the maximum number that may be stored there, which should be 0 if there
+	// is no limit.
+	Usage() (used, quota int64, err error)
+}
+
+// Appender is an optional interface that RemoteAccessors can also implement,
+// so that files opened for appending don't have to be downloaded first, with
+// only the appended data being uploaded.
+type Appender interface {
+	// CanAppend returns true if AppendFile() can be used, eg. because the
+	// remote system supports it and it has been configured to do so.
+	CanAppend() bool
+
+	// AppendFile appends the bytes of the local source file after offset to
+	// the remote dest file, which should be offset bytes long. The first
+	// offset bytes of source may not have been downloaded, so must not be
+	// read. It should return ErrAppendUnsupported if it can't append to dest
+	// (eg. because dest isn't offset bytes long).
+	AppendFile(source, dest string, offset int64) error
+}
+
+// UploadOptions describe what a ReaderUploader should record about a file it
+// uploads.
+type UploadOptions struct {
+	// ContentType is the MIME type of the file.
+	ContentType string
+
+	// SHA256 is the hex encoded SHA256 of the file, which a ChecksumAccessor
+	// should store. It is empty for other accessors.
+	SHA256 string
+
+	// Metadata is key/value metadata that a MetadataUploader should store
+	// with the file. It is nil for other accessors.
+	Metadata map[string]string
+}
+
+// ReaderUploader is an optional interface that RemoteAccessors can also
+// implement, so that files we upload through a reader we control (to limit
+// bandwidth, report progress or abort the upload) keep their content type.
+// Without it, such uploads are done with UploadData().
+type ReaderUploader interface {
+	// UploadReader uploads the size bytes read from data to the remote dest
+	// path, recording the given opts if possible. It should give up, returning
+	// an error, if ctx is done.
+	UploadReader(ctx context.Context, data io.Reader, size int64, dest string, opts UploadOptions) error
+}
+
 // remote struct is used by MuxFys to interact with some remote file system or
 // object store. It embeds a CacheTracker and a RemoteAccessor to do its work.
 type remote struct {
-	accessor RemoteAccessor
-	cacheDir string
+	accessor  RemoteAccessor
+	clientKey string
+	cacheDir  string
 	log15.Logger
 	*CacheTracker
-	maxAttempts   int
-	clientBackoff *backoff.Backoff
-	cbMutex       sync.Mutex
-	cacheData     bool
-	cacheIsTmp    bool
-	write         bool
-	hasWorked     bool
+	modified         *CacheTracker
+	maxAttempts      int
+	clientBackoff    *backoff.Backoff
+	streamWrites     bool
+	tagger           TaggingAccessor
+	forbidden        ForbiddenDetector
+	forbidDetector   ForbiddenDetector
+	noOverwrite      bool
+	transfers        *transfers
+	objectTags       map[string]string
+	cbMutex          sync.Mutex
+	cacheData        bool
+	cacheIsTmp       bool
+	write            bool
+	hasWorked        bool
+	hideDirMarkers   bool
+	listConcurrency  int
+	cacheCompress    bool
+	cacheShard       bool
+	eagerCacheBelow  int64
+	uploadFileMode   os.FileMode
+	partialUploads   bool
+	offlineReads     bool
+	sharedCache      bool
+	mountSubpath     string
+	singleFile       string
+	singleFileAttr   RemoteAttr
+	memCache         *memCache
+	emit             func(Event)
+	slowThreshold    time.Duration
+	readBlockSize    int64
+	contentTypeFunc  func(path string) string
+	limiter          *rate.Limiter
+	progress         func(path string, transferred, total int64)
+	verifyChecksum   bool
+	maxWriteBytes    int64
+	listDelimiter    string
+	flattenDepth     int
+	keyNormalizer    func(key string) string
+	keys             map[string]string
+	keysMutex        sync.Mutex
+	longNameStrategy LongNameStrategy
+	longNames        map[string]string
+	includeGlobs     []string
+	excludeGlobs     []string
+	appends          map[string]int64
+	appendsMutex     sync.Mutex
+	metadata         map[string]map[string]string
+	metadataMutex    sync.Mutex
+	gate             *pauseGate
+	blockCaches      map[string]*blockCache
+	plainHolds       map[string]int
+	bcMutex          sync.Mutex
+	inflight         map[string][]*inflightRead
+	ifMutex          sync.Mutex
+	usageUsed        int64
+	usageQuota       int64
+	usageKnown       bool
+	usageChecked     time.Time
+	usageMutex       sync.Mutex
+}
+
+// inflightRead is a read of part of a remote file in to its cache file that
+// is currently in progress. done is closed when the read finishes.
+type inflightRead struct {
+	iv   Interval
+	done chan struct{}
 }
 
 // newRemote creates a remote for use inside MuxFys.
-func newRemote(accessor RemoteAccessor, cacheData bool, cacheDir string, cacheBase string, write bool, maxAttempts int, logger log15.Logger) (*remote, error) {
+func newRemote(c *RemoteConfig, cacheBase string, maxAttempts int, logger log15.Logger) (*remote, error) {
+	if c.MaxBytesPerSecond < 0 {
+		return nil, fmt.Errorf("MaxBytesPerSecond can't be negative")
+	}
+	if c.ListConcurrency < 0 {
+		return nil, fmt.Errorf("ListConcurrency can't be negative")
+	}
+	if c.MaxWriteBytes < 0 {
+		return nil, fmt.Errorf("MaxWriteBytes can't be negative")
+	}
+	if c.MemCacheMaxBytes < 0 {
+		return nil, fmt.Errorf("MemCacheMaxBytes can't be negative")
+	}
+	if c.SharedCache && (c.CacheDir == "" || c.Write || c.CacheCompress || c.VerifyChecksum) {
+		return nil, fmt.Errorf("SharedCache requires CacheDir, and can't be used with Write, CacheCompress or VerifyChecksum")
+	}
+	if c.FlattenDepth < 0 {
+		return nil, fmt.Errorf("FlattenDepth can't be negative")
+	}
+	clientBackoff := &backoff.Backoff{
+		Min:    defaultRetryBackoffMin,
+		Max:    defaultRetryBackoffMax,
+		Factor: defaultRetryBackoffFactor,
+		Jitter: !c.DisableRetryJitter,
+	}
+	if c.RetryBackoffMin < 0 || c.RetryBackoffMax < 0 {
+		return nil, fmt.Errorf("RetryBackoffMin and RetryBackoffMax can't be negative")
+	}
+	if c.RetryBackoffMin > 0 {
+		clientBackoff.Min = c.RetryBackoffMin
+	}
+	if c.RetryBackoffMax > 0 {
+		clientBackoff.Max = c.RetryBackoffMax
+	}
+	if clientBackoff.Max < clientBackoff.Min {
+		return nil, fmt.Errorf("RetryBackoffMax can't be less than RetryBackoffMin")
+	}
+	if c.RetryBackoffFactor != 0 {
+		if c.RetryBackoffFactor < 1 {
+			return nil, fmt.Errorf("RetryBackoffFactor can't be less than 1")
+		}
+		clientBackoff.Factor = c.RetryBackoffFactor
+	}
+	for _, glob := range append(append([]string{}, c.IncludeGlobs...), c.ExcludeGlobs...) {
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("bad glob pattern [%s]: %s", glob, err)
+		}
+	}
+	if c.CannedACL != "" {
+		if !cannedACLs[c.CannedACL] {
+			return nil, fmt.Errorf("CannedACL [%s] is not a known canned ACL", c.CannedACL)
+		}
+		setter, ok := c.Accessor.(CannedACLSetter)
+		if !ok {
+			return nil, fmt.Errorf("CannedACL can't be used with an Accessor that isn't a CannedACLSetter")
+		}
+		setter.SetCannedACL(c.CannedACL)
+	}
+	tagger, _ := c.Accessor.(TaggingAccessor)
+	if len(c.ObjectTags) > 0 {
+		if !c.Write {
+			return nil, fmt.Errorf("ObjectTags requires Write")
+		}
+		if tagger == nil {
+			return nil, fmt.Errorf("ObjectTags can't be used with an Accessor that isn't a TaggingAccessor")
+		}
+	}
+	if c.StreamWrites && !c.Write {
+		return nil, fmt.Errorf("StreamWrites requires Write")
+	}
+	if c.NoOverwrite && c.StreamWrites {
+		// (opening an existing file for writing starts replacing it straight
+		// away, and streamed uploads can't be made conditional)
+		return nil, fmt.Errorf("NoOverwrite can't be used with StreamWrites")
+	}
+	if c.UploadFileMode != 0 {
+		if !c.Write || c.StreamWrites || c.EncryptionKey != nil {
+			return nil, fmt.Errorf("UploadFileMode requires Write, and can't be used with StreamWrites or EncryptionKey")
+		}
+		if c.UploadFileMode&^os.ModePerm != 0 {
+			return nil, fmt.Errorf("UploadFileMode can only hold permission bits")
+		}
+		if !storesMetadata(c.Accessor) {
+			return nil, fmt.Errorf("UploadFileMode can't be used with an Accessor that isn't a MetadataUploader")
+		}
+	}
+	// (our accessor may get wrapped below, so we note if the real one can
+	// detect forbidden errors now)
+	forbidDetector, _ := c.Accessor.(ForbiddenDetector)
+	var forbidden ForbiddenDetector
+	if c.TreatForbiddenAsMissing {
+		var ok bool
+		forbidden, ok = c.Accessor.(ForbiddenDetector)
+		if !ok {
+			return nil, fmt.Errorf("TreatForbiddenAsMissing can't be used with an Accessor that isn't a ForbiddenDetector")
+		}
+	}
+	if !c.VersionAsOf.IsZero() {
+		if c.Write {
+			return nil, fmt.Errorf("VersionAsOf can't be used with Write")
+		}
+		pinner, ok := c.Accessor.(VersionPinner)
+		if !ok {
+			return nil, fmt.Errorf("VersionAsOf can't be used with an Accessor that isn't a VersionPinner")
+		}
+		pinner.PinVersionsAsOf(c.VersionAsOf)
+	}
+	listDelimiter := c.ListDelimiter
+	if listDelimiter == "" {
+		listDelimiter = "/"
+	}
+	if c.Write && (listDelimiter != "/" || c.FlattenDepth > 0 || c.KeyNormalizer != nil) {
+		return nil, fmt.Errorf("ListDelimiter, FlattenDepth and KeyNormalizer can't be used with Write")
+	}
+	if c.OfflineReads && (c.CacheCompress || (!c.CacheData && c.CacheDir == "")) {
+		return nil, fmt.Errorf("OfflineReads requires CacheData, and can't be used with CacheCompress")
+	}
+	if c.SeedCacheFrom != "" && (c.CacheCompress || (!c.CacheData && c.CacheDir == "")) {
+		return nil, fmt.Errorf("SeedCacheFrom requires CacheData, and can't be used with CacheCompress")
+	}
+	if c.EagerCacheBelow < 0 {
+		return nil, fmt.Errorf("EagerCacheBelow can't be negative")
+	}
+	if c.EagerCacheBelow > 0 && !c.CacheData && c.CacheDir == "" && !c.CacheCompress {
+		return nil, fmt.Errorf("EagerCacheBelow requires CacheData")
+	}
+	if c.TransparentGunzip && (c.Write || c.EncryptionKey != nil || c.VerifyChecksum) {
+		return nil, fmt.Errorf("TransparentGunzip can't be used with Write, EncryptionKey or VerifyChecksum")
+	}
+	if c.VerifyChecksum && (c.CacheCompress || c.EncryptionKey != nil || (!c.CacheData && c.CacheDir == "")) {
+		return nil, fmt.Errorf("VerifyChecksum requires CacheData, and can't be used with CacheCompress or EncryptionKey")
+	}
+	// MountSubpath is made relative, without any trailing slash
+	mountSubpath := strings.TrimPrefix(path.Clean("/"+c.MountSubpath), "/")
+	var mc *memCache
+	if c.CacheInMemory {
+		if c.Write {
+			return nil, fmt.Errorf("CacheInMemory can't be used with Write")
+		}
+		if c.CacheData || c.CacheDir != "" || c.CacheCompress {
+			return nil, fmt.Errorf("CacheInMemory can't be used with CacheData, CacheDir or CacheCompress")
+		}
+		maxBytes := c.MemCacheMaxBytes
+		if maxBytes == 0 {
+			maxBytes = defaultMemCacheMaxBytes
+		}
+		mc = newMemCache(maxBytes)
+	}
+
 	// handle cacheData option, creating cache dir if necessary
-	if !cacheData && cacheDir != "" {
+	accessor, cacheData, cacheDir := c.Accessor, c.CacheData, c.CacheDir
+	if c.EncryptionKey != nil {
+		var err error
+		accessor, err = newEncryptingAccessor(accessor, c.EncryptionKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.TransparentGunzip {
+		accessor = newGunzipAccessor(accessor)
+	}
+	if !cacheData && (cacheDir != "" || c.CacheCompress) {
 		cacheData = true
 	}
 
@@ -187,31 +989,189 @@ func newRemote(accessor RemoteAccessor, cacheData bool, cacheDir string, cacheBa
 	if cacheData && cacheDir == "" {
 		// decide on our own cache directory
 		var err error
-		cacheDir, err = ioutil.TempDir(cacheBase, ".muxfys_cache")
+		cacheDir, err = makeTmpCacheDir(cacheBase)
 		if err != nil {
 			return nil, err
 		}
 		cacheIsTmp = true
 	}
 
+	var limiter *rate.Limiter
+	if c.MaxBytesPerSecond > 0 {
+		burst := c.MaxBytesPerSecond
+		if burst > maxRateBurst {
+			burst = maxRateBurst
+		}
+		limiter = rate.NewLimiter(rate.Limit(c.MaxBytesPerSecond), int(burst))
+	}
+
+	listConcurrency := c.ListConcurrency
+	if listConcurrency == 0 {
+		listConcurrency = defaultListConcurrency
+	}
+
 	return &remote{
-		CacheTracker: NewCacheTracker(),
-		accessor:     accessor,
-		cacheData:    cacheData,
-		cacheDir:     cacheDir,
-		cacheIsTmp:   cacheIsTmp,
-		maxAttempts:  maxAttempts,
-		write:        write,
-		clientBackoff: &backoff.Backoff{
-			Min:    100 * time.Millisecond,
-			Max:    10 * time.Second,
-			Factor: 3,
-			Jitter: true,
-		},
-		Logger: logger.New("target", accessor.Target()),
+		CacheTracker:     NewCacheTracker(),
+		modified:         NewCacheTracker(),
+		accessor:         accessor,
+		cacheData:        cacheData,
+		cacheDir:         cacheDir,
+		cacheIsTmp:       cacheIsTmp,
+		maxAttempts:      maxAttempts,
+		write:            c.Write,
+		hideDirMarkers:   c.HideDirMarkers,
+		listConcurrency:  listConcurrency,
+		cacheCompress:    c.CacheCompress,
+		cacheShard:       c.CacheShard,
+		eagerCacheBelow:  c.EagerCacheBelow,
+		uploadFileMode:   c.UploadFileMode,
+		partialUploads:   c.Write && !c.DisablePartialUploads,
+		offlineReads:     c.OfflineReads,
+		sharedCache:      c.SharedCache,
+		mountSubpath:     mountSubpath,
+		memCache:         mc,
+		limiter:          limiter,
+		progress:         c.ProgressFunc,
+		verifyChecksum:   c.VerifyChecksum,
+		maxWriteBytes:    c.MaxWriteBytes,
+		listDelimiter:    listDelimiter,
+		flattenDepth:     c.FlattenDepth,
+		keyNormalizer:    c.KeyNormalizer,
+		longNameStrategy: c.LongNameStrategy,
+		includeGlobs:     c.IncludeGlobs,
+		excludeGlobs:     c.ExcludeGlobs,
+		blockCaches:      make(map[string]*blockCache),
+		plainHolds:       make(map[string]int),
+		inflight:         make(map[string][]*inflightRead),
+		appends:          make(map[string]int64),
+		metadata:         make(map[string]map[string]string),
+		gate:             newPauseGate(),
+		clientBackoff:    clientBackoff,
+		streamWrites:     c.StreamWrites,
+		tagger:           tagger,
+		forbidden:        forbidden,
+		forbidDetector:   forbidDetector,
+		noOverwrite:      c.NoOverwrite,
+		transfers:        newTransfers(),
+		objectTags:       c.ObjectTags,
+		Logger:           logger.New("target", accessor.Target()),
 	}, nil
 }
 
+// List lets you see what would be in a mount of this RemoteConfig, without
+// needing to mount it (so fuse need not be installed). This is useful for
+// checking your credentials work and the Accessor is configured correctly.
+//
+// It returns the files and directories at the root of the mount, or everything
+// beneath it if recursive is true. The Names of the returned RemoteAttrs are
+// relative to the mount point, and directories have a trailing forward slash.
+// No data is cached and nothing is written, regardless of the Cache* and Write
+// options. If the remote couldn't be listed, the error is an *Error, so you can
+// check it with eg. errors.Is(err, ErrRemoteUnavailable). When recursive,
+// up to ListConcurrency directories are listed at once.
+func (c *RemoteConfig) List(recursive bool) ([]RemoteAttr, error) {
+	r, err := c.uncachedRemote()
+	if err != nil {
+		return nil, err
+	}
+	return r.list(recursive)
+}
+
+// uncachedRemote creates a remote for this RemoteConfig that doesn't cache
+// anything and isn't writable, for use without a mount.
+func (c *RemoteConfig) uncachedRemote() (*remote, error) {
+	lc := *c
+	lc.CacheData = false
+	lc.CacheDir = ""
+	lc.CacheCompress = false
+	lc.CacheInMemory = false
+	lc.MemCacheMaxBytes = 0
+	lc.Write = false
+	lc.OfflineReads = false
+	lc.SeedCacheFrom = ""
+	return newRemote(&lc, "", 1, pkgLogger)
+}
+
+// list is the implementation of RemoteConfig.List().
+func (r *remote) list(recursive bool) ([]RemoteAttr, error) {
+	root := r.getRemotePath("")
+	if root != "" {
+		root += "/"
+	}
+
+	if r.virtual() {
+		return r.listPresented(root, recursive)
+	}
+
+	// we list a level of the tree at a time, so that the results are in the
+	// same order however many directories we list at once
+	var ras []RemoteAttr
+	dirs := []string{root}
+	for len(dirs) > 0 {
+		listings, err := r.listDirs(dirs)
+		if err != nil {
+			return nil, err
+		}
+
+		var subDirs []string
+		for i, dir := range dirs {
+			objects := listings[i]
+			var markers map[string]bool
+			if r.hideDirMarkers {
+				markers = dirMarkers(objects)
+			}
+
+			for _, object := range objects {
+				if len(object.Name) <= len(dir) || !strings.HasPrefix(object.Name, dir) || (object.Size == 0 && markers[object.Name+"/"]) {
+					continue
+				}
+				isDir := strings.HasSuffix(object.Name, "/")
+				if recursive && isDir {
+					subDirs = append(subDirs, object.Name)
+				}
+				object.Name = object.Name[len(root):]
+				if !isDir && r.filteredOut(object.Name) {
+					continue
+				}
+				ras = append(ras, object)
+			}
+		}
+		dirs = subDirs
+	}
+	return ras, nil
+}
+
+// listDirs calls our accessor's ListEntries() on each of the given remote
+// dirs, with no more than our listConcurrency calls in flight at once,
+// returning the listings in the same order as dirs. If any fail, the error of
+// the first dir that failed is returned.
+func (r *remote) listDirs(dirs []string) ([][]RemoteAttr, error) {
+	listings := make([][]RemoteAttr, len(dirs))
+	errs := make([]error, len(dirs))
+	sem := make(chan struct{}, r.listConcurrency)
+	var wg sync.WaitGroup
+	for i, dir := range dirs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, dir string) {
+			defer wg.Done()
+			defer func() {
+				<-sem
+			}()
+			listings[i], errs[i] = r.accessor.ListEntries(dir)
+			listings[i] = r.withoutWriteCheckDir(listings[i])
+		}(i, dir)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, r.accessorErr(err)
+		}
+	}
+	return listings, nil
+}
+
 // retryFunc is used as an argument to remote.retry() - the function is retried
 // until it no longer returns an error. The function should be idempotent.
 type retryFunc func() error
@@ -231,17 +1191,37 @@ func (r *remote) retry(clientMethod string, path string, rf retryFunc) fuse.Stat
 ATTEMPTS:
 	for {
 		attempts++
+		if !r.gate.enter(waitsWhilePaused(clientMethod)) {
+			r.Warn("Remote call attempted while paused", "call", clientMethod, "path", path)
+			return pausedStatus
+		}
 		err := rf()
+		r.gate.leave()
 		if err != nil {
 			lastError = err
 
-			// return immediately if key not found or quota exceeded
+			// return immediately if cancelled, key not found or quota exceeded
+			if errors.Is(err, errTransferCancelled) || errors.Is(err, context.Canceled) {
+				r.Warn("Transfer cancelled", "call", clientMethod, "path", path, "walltime", time.Since(start))
+				return fuse.EINTR
+			}
 			if r.accessor.ErrorIsNotExists(err) {
 				r.Warn("File doesn't exist", "call", clientMethod, "path", path, "walltime", time.Since(start))
+				r.logIfSlow(clientMethod, path, attempts, start)
 				return fuse.ENOENT
 			}
+			if readCalls[clientMethod] && r.forbiddenAsMissing(err) {
+				r.Warn("File forbidden, treating as missing", "call", clientMethod, "path", path, "walltime", time.Since(start))
+				r.logIfSlow(clientMethod, path, attempts, start)
+				return fuse.ENOENT
+			}
+			if errors.Is(err, ErrAlreadyExists) {
+				r.Warn("File already exists", "call", clientMethod, "path", path, "walltime", time.Since(start))
+				return fuse.Status(syscall.EEXIST)
+			}
 			if r.accessor.ErrorIsNoQuota(err) {
 				r.Warn("Quota Exceeded", "call", clientMethod, "path", path, "walltime", time.Since(start))
+				r.logIfSlow(clientMethod, path, attempts, start)
 				return fuse.ENODATA
 			}
 
@@ -276,6 +1256,7 @@ ATTEMPTS:
 		} else {
 			r.Info("Remote call succeeded", "call", clientMethod, "path", path, "walltime", time.Since(start))
 		}
+		r.logIfSlow(clientMethod, path, attempts, start)
 		r.cbMutex.Lock()
 		r.clientBackoff.Reset()
 		r.hasWorked = true
@@ -284,15 +1265,41 @@ ATTEMPTS:
 	}
 }
 
+// logIfSlow logs a warning if a call that started at the given time (and
+// needed the given number of attempts) took longer than our slowThreshold.
+// These warnings are kept by Logs() even when not Verbose.
+func (r *remote) logIfSlow(clientMethod string, path string, attempts int, start time.Time) {
+	if r.slowThreshold <= 0 {
+		return
+	}
+	if walltime := time.Since(start); walltime > r.slowThreshold {
+		r.Warn(slowCallMsg, "call", clientMethod, "path", path, "retries", attempts-1, "walltime", walltime)
+	}
+}
+
+// forbiddenAsMissing returns true if we were configured with
+// TreatForbiddenAsMissing and the given error indicates access was denied.
+func (r *remote) forbiddenAsMissing(err error) bool {
+	return r.forbidden != nil && r.forbidden.ErrorIsForbidden(err)
+}
+
 // statusFromErr is for when you get an error from trying to use something you
 // you get back from a remote, such an object from getObject. It returns the
 // appropriate status and logs any error.
 func (r *remote) statusFromErr(clientMethod string, err error) fuse.Status {
 	if err != nil {
+		if errors.Is(err, errTransferCancelled) {
+			r.Warn("Transfer cancelled", "call", clientMethod)
+			return fuse.EINTR
+		}
 		if r.accessor.ErrorIsNotExists(err) {
 			r.Warn("File doesn't exist", "call", clientMethod)
 			return fuse.ENOENT
 		}
+		if r.forbiddenAsMissing(err) {
+			r.Warn("File forbidden, treating as missing", "call", clientMethod)
+			return fuse.ENOENT
+		}
 		if r.accessor.ErrorIsNoQuota(err) {
 			r.Warn("Quota Exceeded", "call", clientMethod)
 			return fuse.ENODATA
@@ -303,25 +1310,189 @@ func (r *remote) statusFromErr(clientMethod string, err error) fuse.Status {
 	return fuse.OK
 }
 
+// parseTarget splits a target of the form scheme://bucket/subpath in to its
+// bucket (or container) and subpath, for use by RemoteAccessor implementations
+// that take such targets.
+func parseTarget(scheme, target string) (bucket, basePath string, err error) {
+	if target == "" {
+		return "", "", fmt.Errorf("no Target defined")
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme != scheme {
+		return "", "", fmt.Errorf("Target [%s] must start with %s://", target, scheme)
+	}
+
+	bucket = u.Host
+	if bucket == "" {
+		return "", "", fmt.Errorf("no bucket could be determined from [%s]", target)
+	}
+
+	if len(u.Path) > 1 {
+		basePath = path.Clean(u.Path[1:])
+	}
+	return bucket, basePath, nil
+}
+
 // getRemotePath gets the real complete remote path given the path relative to
-// the configured remote mount point.
-func (r *remote) getRemotePath(relPath string) string {
+// the configured remote mount point. For paths that aren't within our
+// MountSubpath (see relPath()), you get the remote root path.
+func (r *remote) getRemotePath(name string) string {
+	relPath, _ := r.relPath(name)
+	if r.singleFile != "" && relPath == r.singleFile {
+		return r.accessor.RemotePath("")
+	}
+	relPath = r.unshortened(relPath)
+	if key, presented := r.realKey(relPath); presented {
+		relPath = key
+	}
 	return r.accessor.RemotePath(relPath)
 }
 
+// relPath converts a path relative to the mount point in to a path relative to
+// our root, ie. without our MountSubpath. ok is false if name isn't our
+// MountSubpath or within it.
+func (r *remote) relPath(name string) (relPath string, ok bool) {
+	switch {
+	case r.mountSubpath == "":
+		return name, true
+	case name == r.mountSubpath:
+		return "", true
+	case strings.HasPrefix(name, r.mountSubpath+"/"):
+		return name[len(r.mountSubpath)+1:], true
+	}
+	return "", false
+}
+
+// findSingleFile checks, if our accessor is a FileStater, if our root path is
+// a file instead of a directory. If so, we'll present it as the only file in
+// the root directory, named after the file.
+func (r *remote) findSingleFile() {
+	stater, ok := r.accessor.(FileStater)
+	if !ok {
+		return
+	}
+	root := r.accessor.RemotePath("")
+	if root == "" || root == "." || root == "/" {
+		return
+	}
+
+	var ra RemoteAttr
+	rf := func() error {
+		var err error
+		ra, err = stater.StatFile(root)
+		return err
+	}
+	if r.retry("StatFile", root, rf) != fuse.OK {
+		return
+	}
+	r.singleFile = filepath.Base(root)
+	r.singleFileAttr = ra
+}
+
+// statFile returns the details of the given remote file, if our accessor is a
+// FileStater (in which case known will be true).
+func (r *remote) statFile(remotePath string) (ra RemoteAttr, known bool, status fuse.Status) {
+	stater, ok := r.accessor.(FileStater)
+	if !ok {
+		return ra, false, fuse.OK
+	}
+
+	rf := func() error {
+		var err error
+		ra, err = stater.StatFile(remotePath)
+		return err
+	}
+	status = r.retry("StatFile", remotePath, rf)
+	return ra, status == fuse.OK, status
+}
+
+// usage returns the used bytes and quota of our remote, if our accessor is a
+// UsageAccessor. Results (including failures) are remembered for usageTTL, so
+// that every StatFs() doesn't need a remote call. known is false if we've never
+// been able to find out the usage.
+func (r *remote) usage() (used, quota int64, known bool) {
+	ua, ok := r.accessor.(UsageAccessor)
+	if !ok {
+		return 0, 0, false
+	}
+
+	r.usageMutex.Lock()
+	defer r.usageMutex.Unlock()
+	if !r.usageChecked.IsZero() && time.Since(r.usageChecked) < usageTTL {
+		return r.usageUsed, r.usageQuota, r.usageKnown
+	}
+
+	rf := func() error {
+		var err error
+		used, quota, err = ua.Usage()
+		return err
+	}
+	status := r.retry("Usage", "", rf)
+	r.usageChecked = time.Now()
+	if status == fuse.OK {
+		r.usageUsed, r.usageQuota, r.usageKnown = used, quota, true
+	}
+	return r.usageUsed, r.usageQuota, r.usageKnown
+}
+
 // getLocalPath gets the path to the local cached file when configured with
 // CacheData. You must supply the complete remote path (ie. the return value of
 // getRemotePath). Returns empty string if not in CacheData mode.
 func (r *remote) getLocalPath(remotePath string) string {
-	if r.cacheData {
-		return r.accessor.LocalPath(r.cacheDir, remotePath)
+	if !r.cacheData {
+		return ""
+	}
+	baseDir := r.cacheDir
+	if r.cacheShard {
+		sum := sha256.Sum256([]byte(remotePath))
+		shard := hex.EncodeToString(sum[:2])
+		baseDir = filepath.Join(baseDir, shard[:2], shard[2:])
 	}
-	return ""
+	return r.shortLocalPath(r.accessor.LocalPath(baseDir, remotePath))
 }
 
 // uploadFile uploads the given local file to the given remote path, with
 // automatic retries on failure.
 func (r *remote) uploadFile(localPath, remotePath string) fuse.Status {
+	return r.uploadFileContext(context.Background(), localPath, remotePath)
+}
+
+// uploadFileContext is like uploadFile(), but if the given context can be
+// cancelled, the upload is aborted (failing with EINTR) when it is done. The
+// upload is registered as an in-flight transfer, so can also be cancelled by
+// the user.
+func (r *remote) uploadFileContext(ctx context.Context, localPath, remotePath string) fuse.Status {
+	if r.cacheCompress {
+		if _, err := r.holdPlain(localPath, true); err != nil {
+			r.Error("Could not decompress cache file", "method", "uploadFile", "path", localPath, "err", err)
+			return fuse.EIO
+		}
+		defer r.releasePlain(localPath)
+	}
+
+	// metadata can only be given with a whole file upload, so we won't just
+	// be appending to the remote file
+	metadata := r.uploadMetadata(localPath)
+	if metadata != nil {
+		r.forgetAppend(localPath)
+	}
+
+	// parts of an existing remote file that were never read are holes in our
+	// cache file, which we must fill before uploading it, unless we'll only be
+	// appending to the remote file
+	if _, appending := r.appendBase(localPath); !appending {
+		status := r.retry("FillUncached", remotePath, func() error {
+			return r.fillUncached(localPath, remotePath)
+		})
+		if status != fuse.OK {
+			return status
+		}
+	}
+
 	// get the file's content type
 	file, err := os.Open(localPath)
 	if err != nil {
@@ -335,23 +1506,305 @@ func (r *remote) uploadFile(localPath, remotePath string) fuse.Status {
 		logClose(r.Logger, file, "upload file", "path", localPath)
 		return fuse.EIO
 	}
-	contentType := http.DetectContentType(buffer[:n])
+	var contentType string
+	if r.contentTypeFunc != nil {
+		contentType = r.contentTypeFunc(remotePath)
+	}
+	if contentType == "" {
+		contentType = http.DetectContentType(buffer[:n])
+	}
+	var size int64
+	if info, errs := file.Stat(); errs == nil {
+		size = info.Size()
+	}
 	logClose(r.Logger, file, "upload file", "path", localPath)
 
 	// upload, with automatic retries
-	rf := func() error {
-		return r.accessor.UploadFile(localPath, remotePath, contentType)
+	t := r.transfers.start(ctx, nil, remotePath, 0, true)
+	defer r.transfers.finish(t)
+	abortable := ctx.Done() != nil
+	upload := func() error {
+		sum, errh := r.uploadSHA256(localPath)
+		if errh != nil {
+			return errh
+		}
+		return r.uploadWhole(t, abortable, localPath, remotePath, UploadOptions{ContentType: contentType, SHA256: sum, Metadata: metadata})
+	}
+	rf := upload
+	if base, appending := r.appendBase(localPath); appending {
+		rf = func() error {
+			return r.uploadAppend(localPath, remotePath, base, upload)
+		}
+	} else if pu, etag, modified, ok := r.partialUploader(localPath); ok && metadata == nil {
+		rf = func() error {
+			err := pu.UploadModified(localPath, remotePath, contentType, etag, modified)
+			if err == ErrPartialUploadUnsupported {
+				r.Info("Partial upload not possible, uploading whole file", "path", remotePath)
+				return upload()
+			}
+			return err
+		}
+	}
+	if r.noOverwrite {
+		if eu, ok := r.accessor.(ExclusiveUploader); ok {
+			// this is the only way to be sure we don't replace a file someone
+			// else just created, so takes precedence over the above
+			rf = func() error {
+				return eu.UploadFileIfAbsent(localPath, remotePath, UploadOptions{ContentType: contentType, Metadata: metadata})
+			}
+		} else if _, exists, status := r.statFile(remotePath); exists {
+			r.Warn("Not overwriting existing file", "path", remotePath)
+			return fuse.Status(syscall.EEXIST)
+		} else if status != fuse.OK && status != fuse.ENOENT {
+			return status
+		}
 	}
+	start := time.Now()
 	status := r.retry("UploadFile", remotePath, rf)
+	r.event(EventUpload, remotePath, size, start, status)
 	if status != fuse.OK {
-		errd := r.accessor.DeleteIncompleteUpload(remotePath)
-		if errd != nil && !os.IsNotExist(errd) {
-			r.Warn("Deletion of incomplete upload failed", "err", errd)
+		if status == fuse.Status(syscall.EEXIST) {
+			// the remote file is someone else's, not an incomplete upload
+			return status
+		}
+		if _, errs := os.Stat(uploadRecordPath(localPath)); errs == nil {
+			r.Warn("Leaving incomplete upload to be resumed", "path", remotePath)
+		} else {
+			errd := r.accessor.DeleteIncompleteUpload(remotePath)
+			if errd != nil && !os.IsNotExist(errd) {
+				r.Warn("Deletion of incomplete upload failed", "err", errd)
+			}
 		}
+	} else {
+		// the remote file now has a new ETag we don't know, and is the same as
+		// our local file
+		r.forgetETag(localPath)
+		r.modified.CacheDelete(localPath)
+		r.forgetAppend(localPath)
+		r.forgetMetadata(localPath)
+		r.progressDone(remotePath, size)
+		status = r.tagUploaded(remotePath)
 	}
 	return status
 }
 
+// uploadWhole uploads the whole of the given local file as the given transfer,
+// as resumably as our accessor allows, giving up once the transfer is done. If
+// our accessor isn't a ReaderUploader, it can only give up part way if
+// abortable.
+func (r *remote) uploadWhole(t *transfer, abortable bool, localPath, remotePath string, opts UploadOptions) error {
+	if ru, ok := r.accessor.(ResumableUploader); ok && !r.streaming() {
+		return r.uploadResumable(t.ctx, ru, localPath, remotePath, opts)
+	}
+	_, readable := r.accessor.(ReaderUploader)
+	if readable || r.streaming() || abortable || opts.SHA256 != "" || opts.Metadata != nil {
+		// we can only limit bandwidth, report progress or abort the upload
+		// (or record a SHA256 or metadata) by supplying a reader we control
+		return r.uploadReader(t, localPath, remotePath, opts)
+	}
+	return r.accessor.UploadFile(localPath, remotePath, opts.ContentType)
+}
+
+// uploadReader uploads the given local file as the given transfer, through a
+// reader that is rate limited, reports progress and stops once the transfer is
+// done. The opts are lost unless our accessor is a ReaderUploader.
+func (r *remote) uploadReader(t *transfer, localPath, remotePath string, opts UploadOptions) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer logClose(r.Logger, f, "upload file", "path", localPath)
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+
+	data := r.limitReader(r.progressReader(t.counter(f), remotePath, size))
+	if ru, ok := r.accessor.(ReaderUploader); ok {
+		return ru.UploadReader(t.ctx, data, size, remotePath, opts)
+	}
+	return r.accessor.UploadData(data, remotePath)
+}
+
+// tagUploaded gives the just uploaded remote file our ObjectTags, if any.
+func (r *remote) tagUploaded(remotePath string) fuse.Status {
+	if len(r.objectTags) == 0 {
+		return fuse.OK
+	}
+	return r.setTags(remotePath, r.objectTags)
+}
+
+// setTags sets the tags of the given remote file, with automatic retries on
+// failure. Returns ENOSYS if our accessor isn't a TaggingAccessor.
+func (r *remote) setTags(remotePath string, tags map[string]string) fuse.Status {
+	if r.tagger == nil {
+		return fuse.ENOSYS
+	}
+	rf := func() error {
+		return r.tagger.SetTags(remotePath, tags)
+	}
+	return r.retry("SetTags", remotePath, rf)
+}
+
+// uploadRecord is what we store in a file alongside a cached file while it is
+// being uploaded by a ResumableUploader.
+type uploadRecord struct {
+	Dest  string
+	Size  int64
+	MTime time.Time
+	State *UploadState
+}
+
+// uploadRecordPath returns the path of the file we record the progress of
+// uploading the given cache file in.
+func uploadRecordPath(localPath string) string {
+	return filepath.Join(filepath.Dir(localPath), uploadFilePrefix+filepath.Base(localPath))
+}
+
+// uploadResumable uploads the given local file using the given
+// ResumableUploader, resuming any previous interrupted upload of it to the
+// same remote path. If the local file was altered since that upload was
+// interrupted, the upload is aborted and we start again.
+func (r *remote) uploadResumable(ctx context.Context, ru ResumableUploader, localPath, remotePath string, opts UploadOptions) error {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+	recordPath := uploadRecordPath(localPath)
+
+	var state *UploadState
+	if content, errr := ioutil.ReadFile(recordPath); errr == nil {
+		record := &uploadRecord{}
+		if errj := json.Unmarshal(content, record); errj != nil || record.State == nil {
+			r.Warn("Ignoring bad upload record", "path", recordPath, "err", errj)
+		} else if record.Dest != remotePath || record.Size != info.Size() || !record.MTime.Equal(info.ModTime()) {
+			r.Info("Local file changed since upload was interrupted, aborting it", "path", localPath, "dest", record.Dest)
+			if erra := ru.AbortUpload(record.Dest, record.State); erra != nil {
+				r.Warn("Aborting interrupted upload failed", "dest", record.Dest, "err", erra)
+			}
+		} else {
+			r.Info("Resuming interrupted upload", "path", remotePath, "parts", len(record.State.Parts))
+			state = record.State
+		}
+
+		if state == nil {
+			r.forgetUploadRecord(recordPath)
+		}
+	}
+
+	save := func(state *UploadState) {
+		content, errj := json.Marshal(&uploadRecord{
+			Dest:  remotePath,
+			Size:  info.Size(),
+			MTime: info.ModTime(),
+			State: state,
+		})
+		if errj == nil {
+			errj = ioutil.WriteFile(recordPath, content, os.FileMode(fileMode))
+		}
+		if errj != nil {
+			r.Warn("Could not record upload progress", "path", recordPath, "err", errj)
+		}
+	}
+
+	err = ru.UploadFileResumable(ctx, localPath, remotePath, opts, state, save)
+	if err != nil {
+		return err
+	}
+	r.forgetUploadRecord(recordPath)
+	return nil
+}
+
+// forgetUploadRecord removes the given upload record file.
+func (r *remote) forgetUploadRecord(recordPath string) {
+	err := os.Remove(recordPath)
+	if err != nil && !os.IsNotExist(err) {
+		r.Warn("Could not remove upload record", "path", recordPath, "err", err)
+	}
+}
+
+// partialUploader returns our accessor as a PartialUploader, along with the
+// ETag of the remote file the given local file was cached from and the local
+// file's modified parts, if the file can be partially uploaded. That requires
+// we be configured for partial uploads, not be streaming (see streaming()) or
+// verifying checksums, know the ETag, and have every byte of the file cached,
+// so that its unmodified parts are known to be the same as that version of the
+// remote file's.
+func (r *remote) partialUploader(localPath string) (PartialUploader, string, Intervals, bool) {
+	pu, ok := r.accessor.(PartialUploader)
+	if !ok || !r.canUploadPartially() {
+		return nil, "", nil, false
+	}
+	etag := r.cachedETag(localPath)
+	if etag == "" {
+		return nil, "", nil, false
+	}
+	info, err := os.Stat(localPath)
+	if err != nil || info.Size() == 0 {
+		return nil, "", nil, false
+	}
+	if len(r.Uncached(localPath, NewInterval(0, info.Size()))) > 0 {
+		return nil, "", nil, false
+	}
+	return pu, etag, r.modified.intervals(localPath), true
+}
+
+// canUploadPartially returns true if our accessor is a PartialUploader and we
+// are configured to use it, not streaming (see streaming()) or verifying
+// checksums.
+func (r *remote) canUploadPartially() bool {
+	_, ok := r.accessor.(PartialUploader)
+	_, verifying := r.checksummer()
+	return ok && r.partialUploads && !r.streaming() && !verifying
+}
+
+// storeSparseETag records the current ETag of the given remote file for the
+// given cache file, which was just created empty for reads to cache in to, so
+// that it can later be partially uploaded. Nothing is recorded if it can't be
+// partially uploaded, or our accessor isn't a FileStater.
+func (r *remote) storeSparseETag(localPath, remotePath string) {
+	if !r.canUploadPartially() {
+		return
+	}
+	ra, known, status := r.statFile(remotePath)
+	if known && status == fuse.OK {
+		r.storeETag(localPath, ra.MD5)
+	}
+}
+
+// truncatedCache records that the given cache file was truncated to offset, so
+// that we know all of its content from there onwards: anything written there
+// is ours, and any holes are zeros, not parts of the remote file that still
+// need to be read.
+func (r *remote) truncatedCache(localPath string, offset int64) {
+	r.CacheTruncate(localPath, offset)
+	r.Cached(localPath, Interval{Start: offset, End: math.MaxInt64 - 1})
+}
+
+// markModified records that the bytes of the given local file between offsets
+// from and to (in either order, end exclusive) were changed, for the benefit of
+// partial uploads.
+func (r *remote) markModified(localPath string, from, to int64) {
+	if from > to {
+		from, to = to, from
+	}
+	if from == to {
+		return
+	}
+	r.modified.Cached(localPath, NewInterval(from, to-from))
+}
+
+// exceedsMaxWrite returns true, logging an error, if the given size of the
+// given file is larger than our MaxWriteBytes.
+func (r *remote) exceedsMaxWrite(remotePath string, size int64) bool {
+	if r.maxWriteBytes == 0 || size <= r.maxWriteBytes {
+		return false
+	}
+	r.Error("File too large for MaxWriteBytes", "path", remotePath, "size", size, "max", r.maxWriteBytes)
+	return true
+}
+
 // uploadData uploads the given data stream to the given remote path, with
 // automatic retries on failure (of the initial connection attempt). Since we
 // need to write the data that the remote system will read from, we must be
@@ -363,8 +1816,11 @@ func (r *remote) uploadFile(localPath, remotePath string) fuse.Status {
 // finished receives false.)
 func (r *remote) uploadData(data io.ReadCloser, remotePath string) (ready chan bool, finished chan bool) {
 	// upload, with automatic retries
+	tr := r.transfers.reader(data, remotePath, 0, true)
+	counter := &countingReader{ReadCloser: tr}
+	limited := r.limitReader(counter)
 	rf := func() error {
-		return r.accessor.UploadData(data, remotePath)
+		return r.accessor.UploadData(limited, remotePath)
 	}
 
 	ready = make(chan bool)
@@ -376,12 +1832,19 @@ func (r *remote) uploadData(data io.ReadCloser, remotePath string) (ready chan b
 			ready <- true
 			sentReady <- true
 		}()
+		start := time.Now()
 		status := r.retry("UploadData", remotePath, rf)
+		r.transfers.finish(tr.t)
+		r.event(EventUpload, remotePath, counter.n, start, status)
+		if status == fuse.OK {
+			// (a failure to tag is logged, but doesn't undo the upload)
+			r.tagUploaded(remotePath)
+		}
 		<-sentReady // in case rf completes in less than 50ms
 		if status == fuse.OK {
 			finished <- true
 		} else {
-			logClose(r.Logger, data, "upload data")
+			logClose(r.Logger, tr, "upload data")
 			finished <- false
 			errd := r.accessor.DeleteIncompleteUpload(remotePath)
 			if errd != nil {
@@ -392,14 +1855,186 @@ func (r *remote) uploadData(data io.ReadCloser, remotePath string) (ready chan b
 	return ready, finished
 }
 
-// downloadFile downloads the given remote file to the given local path, with
-// automatic retries on failure.
-func (r *remote) downloadFile(remotePath, localPath string) fuse.Status {
-	// upload, with automatic retries
+// downloadFile downloads the given remote file, expected to be of the given
+// size, to the given local path, with automatic retries on failure.
+func (r *remote) downloadFile(remotePath, localPath string, size int64) fuse.Status {
+	// download, with automatic retries
 	rf := func() error {
 		return r.accessor.DownloadFile(remotePath, localPath)
 	}
-	return r.retry("DownloadFile", remotePath, rf)
+	if r.streaming() {
+		// we can only limit bandwidth or report progress by reading the remote
+		// file ourselves
+		rf = func() error {
+			return r.streamDownload(remotePath, localPath, size)
+		}
+	}
+	download := rf
+	rf = func() error {
+		if err := download(); err != nil {
+			return err
+		}
+		return r.verifyDownload(remotePath, localPath)
+	}
+	start := time.Now()
+	status := r.retry("DownloadFile", remotePath, rf)
+	r.downloadEvent(remotePath, localPath, start, status)
+	if status == fuse.OK {
+		r.progressDone(remotePath, size)
+	}
+	return status
+}
+
+// downloadEvent emits an EventDownload for a download of remotePath to
+// localPath that began at start.
+func (r *remote) downloadEvent(remotePath, localPath string, start time.Time, status fuse.Status) {
+	if r.emit == nil {
+		return
+	}
+	var size int64
+	if info, err := os.Stat(localPath); err == nil && status == fuse.OK {
+		size = info.Size()
+	}
+	r.event(EventDownload, remotePath, size, start, status)
+}
+
+// canDownloadIfChanged returns true if downloadFileIfChanged() can avoid
+// downloading unchanged files.
+func (r *remote) canDownloadIfChanged() bool {
+	_, ok := r.accessor.(ConditionalDownloader)
+	return ok && !r.streaming()
+}
+
+// downloadFileIfChanged is like downloadFile(), but if our accessor is a
+// ConditionalDownloader (and we're not streaming), the remote file's ETag is
+// recorded, and the download is skipped if etag is not empty and matches the
+// remote file. notModified is true if the download was skipped.
+func (r *remote) downloadFileIfChanged(remotePath, localPath, etag string, size int64) (notModified bool, status fuse.Status) {
+	if !r.canDownloadIfChanged() {
+		return false, r.downloadFile(remotePath, localPath, size)
+	}
+	cd := r.accessor.(ConditionalDownloader)
+
+	var newETag string
+	rf := func() error {
+		var err error
+		newETag, err = cd.DownloadFileIfChanged(remotePath, localPath, etag)
+		if err == ErrNotModified {
+			notModified = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return r.verifyDownload(remotePath, localPath)
+	}
+	start := time.Now()
+	status = r.retry("DownloadFileIfChanged", remotePath, rf)
+	if status == fuse.OK && !notModified {
+		r.storeETag(localPath, newETag)
+	}
+	if !notModified {
+		r.downloadEvent(remotePath, localPath, start, status)
+	}
+	return notModified, status
+}
+
+// etagPath returns the path of the file we store the ETag of the given cache
+// file in.
+func etagPath(localPath string) string {
+	return filepath.Join(filepath.Dir(localPath), etagFilePrefix+filepath.Base(localPath))
+}
+
+// growCached is used with SharedCache when the given cache file is smaller
+// than the remote file, presumably because another mount cached it before the
+// remote file grew. The existing cached data is trusted, and only the missing
+// end of the remote file is downloaded.
+func (r *remote) growCached(localPath, remotePath string, localSize, remoteSize int64) fuse.Status {
+	if localSize > 0 {
+		r.Cached(localPath, NewInterval(0, localSize))
+	}
+	uncached := r.Uncached(localPath, NewInterval(0, remoteSize))
+	if len(uncached) == 0 {
+		return fuse.OK
+	}
+
+	f, err := os.OpenFile(localPath, os.O_WRONLY, os.FileMode(fileMode))
+	if err != nil {
+		r.Error("Could not open cached file", "path", localPath, "err", err)
+		return fuse.ToStatus(err)
+	}
+	defer logClose(r.Logger, f, "grow cached file", "path", localPath)
+	for _, iv := range uncached {
+		rf := func() error {
+			return r.fillInterval(f, remotePath, iv)
+		}
+		status := r.retry("OpenFile", remotePath, rf)
+		if status != fuse.OK {
+			return status
+		}
+		r.Cached(localPath, iv)
+	}
+	return fuse.OK
+}
+
+// cachedETag returns the ETag we recorded for the given cache file, or an empty
+// string if we don't know it.
+func (r *remote) cachedETag(localPath string) string {
+	etag, err := ioutil.ReadFile(etagPath(localPath))
+	if err != nil {
+		return ""
+	}
+	return string(etag)
+}
+
+// storeETag records the ETag of the given cache file, so that a future mount
+// can check if the remote file has changed.
+func (r *remote) storeETag(localPath, etag string) {
+	if etag == "" {
+		r.forgetETag(localPath)
+		return
+	}
+	err := ioutil.WriteFile(etagPath(localPath), []byte(etag), os.FileMode(fileMode))
+	if err != nil {
+		r.Warn("Could not store ETag", "path", localPath, "err", err)
+	}
+}
+
+// forgetETag removes any ETag we recorded for the given cache file.
+func (r *remote) forgetETag(localPath string) {
+	err := os.Remove(etagPath(localPath))
+	if err != nil && !os.IsNotExist(err) {
+		r.Warn("Could not remove ETag", "path", localPath, "err", err)
+	}
+}
+
+// streamDownload is the rate limited and progress reporting alternative to our
+// accessor's DownloadFile().
+func (r *remote) streamDownload(remotePath, localPath string, size int64) (err error) {
+	object, err := r.accessor.OpenFile(remotePath, 0)
+	if err != nil {
+		return err
+	}
+	reader := r.transfers.reader(object, remotePath, 0, false)
+	defer logClose(r.Logger, reader, "download reader", "path", remotePath)
+
+	err = os.MkdirAll(filepath.Dir(localPath), os.FileMode(dirMode))
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(localPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		errc := f.Close()
+		if err == nil {
+			err = errc
+		}
+	}()
+
+	_, err = io.Copy(f, r.limitReader(r.progressReader(reader, remotePath, size)))
+	return err
 }
 
 // findObjects returns details of all files and directories with the same prefix
@@ -407,6 +2042,15 @@ func (r *remote) downloadFile(remotePath, localPath string) fuse.Status {
 // it's like a directory listing. Returns the details and fuse.OK if there were
 // no problems getting those details.
 func (r *remote) findObjects(remotePath string) ([]RemoteAttr, fuse.Status) {
+	if r.virtual() {
+		return r.virtualObjects(remotePath)
+	}
+	return r.listEntries(remotePath)
+}
+
+// listEntries is like findObjects(), but always lists the real contents of the
+// given remote directory, ignoring our ListDelimiter and FlattenDepth.
+func (r *remote) listEntries(remotePath string) ([]RemoteAttr, fuse.Status) {
 	// find objects, with automatic retries
 	var ras []RemoteAttr
 	rf := func() error {
@@ -415,7 +2059,70 @@ func (r *remote) findObjects(remotePath string) ([]RemoteAttr, fuse.Status) {
 		return err
 	}
 	status := r.retry("ListEntries", remotePath, rf)
-	return ras, status
+	return r.withoutWriteCheckDir(ras), status
+}
+
+// cachedObjects is like findObjects(), but returns details of the files and
+// directories in the corresponding directory of our cache dir.
+func (r *remote) cachedObjects(remotePath string) ([]RemoteAttr, error) {
+	if r.cacheShard {
+		return nil, fmt.Errorf("directories can't be listed from a sharded cache")
+	}
+	entries, err := ioutil.ReadDir(r.getLocalPath(remotePath))
+	if err != nil {
+		return nil, err
+	}
+
+	var ras []RemoteAttr
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".muxfys_") {
+			// the lock, etag and other files we keep alongside cached files
+			continue
+		}
+		size := entry.Size()
+		if entry.IsDir() {
+			name += "/"
+			size = 0
+		}
+		ras = append(ras, RemoteAttr{
+			Name:  remotePath + name,
+			Size:  size,
+			MTime: entry.ModTime(),
+		})
+	}
+	return ras, nil
+}
+
+// filteredOut tells you if the file at the given path (relative to our root)
+// is hidden from listings by our IncludeGlobs or ExcludeGlobs.
+func (r *remote) filteredOut(relPath string) bool {
+	if len(r.includeGlobs) > 0 && !matchesGlob(r.includeGlobs, relPath) {
+		return true
+	}
+	return matchesGlob(r.excludeGlobs, relPath)
+}
+
+// matchesGlob tells you if the given path matches any of the given glob
+// patterns, matching just its base name for patterns without a "/".
+func matchesGlob(globs []string, relPath string) bool {
+	for _, glob := range globs {
+		name := relPath
+		if !strings.Contains(glob, "/") {
+			name = path.Base(relPath)
+		}
+		if matched, _ := path.Match(glob, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// offline tells you if the given status from a remote call means we should
+// fall back on our cached data, because we're configured with OfflineReads and
+// the call failed for some reason other than the file not existing.
+func (r *remote) offline(status fuse.Status) bool {
+	return r.offlineReads && status != fuse.OK && status != fuse.ENOENT
 }
 
 // getObject gets the object representing an opened remote file, ready to be
@@ -430,6 +2137,9 @@ func (r *remote) getObject(remotePath string, offset int64) (io.ReadCloser, fuse
 		return err
 	}
 	status := r.retry("OpenFile", remotePath, rf)
+	if status == fuse.OK {
+		reader = r.transfers.reader(r.limitReader(reader), remotePath, offset, false)
+	}
 	return reader, status
 }
 
@@ -438,6 +2148,15 @@ func (r *remote) getObject(remotePath string, offset int64) (io.ReadCloser, fuse
 // which is why remotePath must be supplied, and why you get back an object.
 // This might be the same object you supplied if there were no problems.
 func (r *remote) seek(rc io.ReadCloser, offset int64, remotePath string) (io.ReadCloser, fuse.Status) {
+	if tr, ok := rc.(*transferReader); ok {
+		// the transfer from the old offset is over
+		tr.ts.finish(tr.t)
+		rc = tr.ReadCloser
+	}
+	if limited, ok := rc.(*rateLimitedReader); ok {
+		// our accessor needs the object it originally returned
+		rc = limited.ReadCloser
+	}
 	var reader io.ReadCloser
 	rf := func() error {
 		var err error
@@ -445,6 +2164,9 @@ func (r *remote) seek(rc io.ReadCloser, offset int64, remotePath string) (io.Rea
 		return err
 	}
 	status := r.retry(fmt.Sprintf("Seek(%d)", offset), remotePath, rf)
+	if status == fuse.OK {
+		reader = r.transfers.reader(r.limitReader(reader), remotePath, offset, false)
+	}
 	return reader, status
 }
 
@@ -468,11 +2190,211 @@ func (r *remote) deleteFile(remotePath string) fuse.Status {
 	return r.retry("DeleteFile", remotePath, rf)
 }
 
+// deleteDir deletes every remote file in the given remote directory (which
+// should end in a slash), including those in its sub-directories, and any
+// objects marking the existence of those sub-directories.
+func (r *remote) deleteDir(remotePath string) fuse.Status {
+	objects, status := r.listEntries(remotePath)
+	if status != fuse.OK {
+		return status
+	}
+
+	for _, object := range objects {
+		if object.Name == remotePath {
+			continue
+		}
+		if strings.HasSuffix(object.Name, "/") {
+			status = r.deleteDir(object.Name)
+		} else {
+			status = r.deleteFile(object.Name)
+		}
+		if status != fuse.OK && status != fuse.ENOENT {
+			return status
+		}
+	}
+
+	status = r.deleteFile(remotePath)
+	if status == fuse.ENOENT {
+		status = fuse.OK
+	}
+	return status
+}
+
+// rateLimitedReader wraps a ReadCloser so that reads are limited by a shared
+// rate limiter.
+type rateLimitedReader struct {
+	io.ReadCloser
+	limiter *rate.Limiter
+}
+
+// Read reads no more than the limiter's burst size at once, then waits until
+// the limiter allows the bytes that were read.
+func (l *rateLimitedReader) Read(p []byte) (int, error) {
+	if burst := l.limiter.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := l.ReadCloser.Read(p)
+	if n > 0 {
+		if errw := l.limiter.WaitN(context.Background(), n); errw != nil && err == nil {
+			err = errw
+		}
+	}
+	return n, err
+}
+
+// streaming returns true if whole file transfers must stream their data through
+// us, so that we can limit bandwidth or report progress, instead of leaving the
+// transfer entirely to our accessor.
+func (r *remote) streaming() bool {
+	return r.limiter != nil || r.progress != nil
+}
+
+// progressReader wraps a ReadCloser so that the bytes read through it are
+// reported to a RemoteConfig.ProgressFunc every progressInterval bytes.
+type progressReader struct {
+	io.ReadCloser
+	progress func(path string, transferred, total int64)
+	path     string
+	total    int64
+	n        int64
+	reported int64
+}
+
+// Read implements io.Reader by reading from the underlying reader, calling our
+// progress function if enough has been read since we last called it.
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.ReadCloser.Read(b)
+	p.n += int64(n)
+	if p.n-p.reported >= progressInterval {
+		p.reported = p.n
+		p.progress(p.path, p.n, p.total)
+	}
+	return n, err
+}
+
+// progressReader wraps the given reader so that reading from it reports
+// progress on transferring remotePath of the given total size, if we have a
+// ProgressFunc. If the reader is not a ReadCloser, the returned ReadCloser's
+// Close() does nothing.
+func (r *remote) progressReader(reader io.Reader, remotePath string, total int64) io.ReadCloser {
+	rc, isCloser := reader.(io.ReadCloser)
+	if !isCloser {
+		rc = ioutil.NopCloser(reader)
+	}
+	if r.progress == nil {
+		return rc
+	}
+	return &progressReader{ReadCloser: rc, progress: r.progress, path: remotePath, total: total}
+}
+
+// progressDone calls our ProgressFunc, if we have one, to say that the whole
+// of remotePath, of the given size, has been transferred.
+func (r *remote) progressDone(remotePath string, size int64) {
+	if r.progress != nil {
+		r.progress(remotePath, size, size)
+	}
+}
+
+// limitReader wraps the given reader with our rate limiter, if we have one. If
+// the reader is not a ReadCloser, the returned ReadCloser's Close() does
+// nothing.
+func (r *remote) limitReader(reader io.Reader) io.ReadCloser {
+	rc, isCloser := reader.(io.ReadCloser)
+	if !isCloser {
+		rc = ioutil.NopCloser(reader)
+	}
+	if r.limiter == nil {
+		return rc
+	}
+	return &rateLimitedReader{ReadCloser: rc, limiter: r.limiter}
+}
+
+// readBlocks expands the given interval of a remote file of the given size to
+// cover the whole of the Config.ReadBlockSize blocks it touches (up to the end
+// of the file), which is what we fetch when caching data. Intervals are left
+// as they are if we have no readBlockSize.
+func (r *remote) readBlocks(iv Interval, size int64) Interval {
+	if r.readBlockSize > 0 {
+		iv.Start -= iv.Start % r.readBlockSize
+		iv.End = (iv.End/r.readBlockSize+1)*r.readBlockSize - 1
+	}
+	if iv.End > size-1 {
+		iv.End = size - 1
+	}
+	return iv
+}
+
+// claimRead is used before reading the given interval of a remote file in to
+// the given cache file. If no overlapping read of the same file is in progress,
+// it records that we're doing the read, returning claimed true; you must call
+// finishRead() when done. Otherwise it returns the overlapping inflightRead,
+// whose done channel you can wait on.
+func (r *remote) claimRead(localPath string, iv Interval) (ir *inflightRead, claimed bool) {
+	r.ifMutex.Lock()
+	defer r.ifMutex.Unlock()
+	for _, other := range r.inflight[localPath] {
+		if other.iv.Overlaps(iv) {
+			return other, false
+		}
+	}
+	ir = &inflightRead{iv: iv, done: make(chan struct{})}
+	r.inflight[localPath] = append(r.inflight[localPath], ir)
+	return ir, true
+}
+
+// finishRead is used after a read claimed with claimRead() has finished, to
+// wake up anything waiting on it.
+func (r *remote) finishRead(localPath string, ir *inflightRead) {
+	r.ifMutex.Lock()
+	defer r.ifMutex.Unlock()
+	irs := r.inflight[localPath]
+	for i, other := range irs {
+		if other == ir {
+			irs = append(irs[:i], irs[i+1:]...)
+			break
+		}
+	}
+	if len(irs) == 0 {
+		delete(r.inflight, localPath)
+	} else {
+		r.inflight[localPath] = irs
+	}
+	close(ir.done)
+}
+
 // deleteCache physically deletes the whole cache directory and erases our
 // knowledge of what parts of what files we have cached. You'd probably call
 // this when unmounting, only if cacheIsTmp was true.
 func (r *remote) deleteCache() (err error) {
 	err = os.RemoveAll(r.cacheDir)
 	r.CacheWipe()
+	r.modified.CacheWipe()
+	r.bcMutex.Lock()
+	r.blockCaches = make(map[string]*blockCache)
+	r.plainHolds = make(map[string]int)
+	r.bcMutex.Unlock()
 	return
 }
+
+// getBlockCache returns the blockCache for the given compressed cache file,
+// shared by all the open handles of that file. If the blockCache isn't already
+// open, any blocks it already holds from a previous mount are recorded as
+// cached.
+func (r *remote) getBlockCache(localPath string, size int64) (*blockCache, error) {
+	r.bcMutex.Lock()
+	defer r.bcMutex.Unlock()
+	if bc, exists := r.blockCaches[localPath]; exists && bc.size == size {
+		return bc, nil
+	}
+
+	r.CacheDelete(localPath)
+	bc, err := openBlockCache(localPath, size)
+	if err != nil {
+		return nil, err
+	}
+	for _, iv := range bc.cachedIntervals() {
+		r.Cached(localPath, iv)
+	}
+	r.blockCaches[localPath] = bc
+	return bc, nil
+}
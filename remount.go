@@ -0,0 +1,93 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+// This file contains the implementation of Config.AutoRemount.
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// defaultRemountInterval is how often an AutoRemount watchdog checks the
+// mount point.
+const defaultRemountInterval = 5 * time.Second
+
+// watchMount periodically checks our mount point, remounting if our fuse
+// connection has been lost, until stop is closed.
+func (fs *MuxFys) watchMount(stop chan bool) {
+	ticker := time.NewTicker(fs.remountInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			_, err := os.Stat(fs.mountPoint)
+			if mountLost(err) {
+				fs.remount(stop)
+			}
+		}
+	}
+}
+
+// mountLost tells you if the given error from accessing our mount point means
+// that the kernel has dropped our fuse connection.
+func mountLost(err error) bool {
+	return errors.Is(err, syscall.ENOTCONN)
+}
+
+// remount tears down our dead fuse server and serves our mount point again,
+// keeping our remotes and everything we know about our files. Does nothing if
+// stop has been closed (because we're unmounting).
+func (fs *MuxFys) remount(stop chan bool) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	select {
+	case <-stop:
+		return
+	default:
+	}
+	if !fs.mounted {
+		return
+	}
+
+	fs.Warn("Mount lost, remounting")
+	err := fs.server.Unmount()
+	if err != nil {
+		// the kernel may still think files are open; detach the dead mount
+		// lazily
+		out, errl := exec.Command("fusermount", "-u", "-z", fs.mountPoint).CombinedOutput()
+		if errl != nil {
+			fs.Error("Remount failed to unmount", "err", err, "lazyErr", errl, "output", string(out))
+			return
+		}
+	}
+
+	err = fs.serve()
+	if err != nil {
+		fs.Error("Remount failed", "err", err)
+		return
+	}
+	fs.remounts++
+	fs.Warn("Remounted", "remounts", fs.remounts)
+}
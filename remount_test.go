@@ -0,0 +1,73 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAutoRemount(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "muxfys_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	Convey("Only transport errors mean the mount was lost", t, func() {
+		So(mountLost(nil), ShouldBeFalse)
+		So(mountLost(&os.PathError{Op: "stat", Path: "/mnt", Err: syscall.ENOENT}), ShouldBeFalse)
+		So(mountLost(&os.PathError{Op: "stat", Path: "/mnt", Err: syscall.ENOTCONN}), ShouldBeTrue)
+	})
+
+	Convey("The watchdog doesn't remount healthy mounts, and stops when told", t, func() {
+		fs, err := New(&Config{Mount: filepath.Join(tmpdir, "remountMount"), CacheBase: tmpdir, AutoRemount: true})
+		So(err, ShouldBeNil)
+		So(fs.autoRemount, ShouldBeTrue)
+		So(fs.Stats().Remounts, ShouldEqual, 0)
+
+		fs.mounted = true
+		fs.remountInterval = time.Millisecond
+		stop := make(chan bool)
+		stopped := make(chan bool)
+		go func() {
+			fs.watchMount(stop)
+			close(stopped)
+		}()
+		<-time.After(20 * time.Millisecond)
+		close(stop)
+		select {
+		case <-stopped:
+		case <-time.After(time.Second):
+			t.Fatal("watchdog didn't stop")
+		}
+		So(fs.Stats().Remounts, ShouldEqual, 0)
+
+		Convey("remount() does nothing once stopped", func() {
+			fs.remount(stop)
+			So(fs.Stats().Remounts, ShouldEqual, 0)
+		})
+	})
+}
@@ -21,11 +21,16 @@ package muxfys
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"math"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"os/exec"
@@ -40,9 +45,1182 @@ import (
 	"time"
 
 	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/minio/minio-go/v7"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
+func TestS3Config(t *testing.T) {
+	Convey("S3ConfigFromEnvironment reads requester_pays from the config files", t, func() {
+		tmpdir, err := ioutil.TempDir("", "muxfys_testing")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tmpdir)
+
+		confPath := filepath.Join(tmpdir, "config")
+		err = ioutil.WriteFile(confPath, []byte("[muxfys_payer]\nrequester_pays = true\n[muxfys_nonpayer]\nregion = us-east-1\n"), 0600)
+		So(err, ShouldBeNil)
+		origConf := os.Getenv("AWS_CONFIG_FILE")
+		os.Setenv("AWS_CONFIG_FILE", confPath)
+		defer os.Setenv("AWS_CONFIG_FILE", origConf)
+
+		config, err := S3ConfigFromEnvironment("muxfys_payer", "mybucket")
+		So(err, ShouldBeNil)
+		So(config.RequesterPays, ShouldBeTrue)
+
+		config, err = S3ConfigFromEnvironment("muxfys_nonpayer", "mybucket")
+		So(err, ShouldBeNil)
+		So(config.RequesterPays, ShouldBeFalse)
+	})
+
+	Convey("S3ConfigFromEnvironmentOnly only uses environment variables", t, func() {
+		vars := []string{"AWS_ENDPOINT_URL", "AWS_S3_ENDPOINT", "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_DEFAULT_REGION", "AWS_REGION"}
+		orig := make(map[string]string)
+		for _, v := range vars {
+			if val, set := os.LookupEnv(v); set {
+				orig[v] = val
+			}
+			os.Unsetenv(v)
+		}
+		defer func() {
+			for _, v := range vars {
+				if val, set := orig[v]; set {
+					os.Setenv(v, val)
+				} else {
+					os.Unsetenv(v)
+				}
+			}
+		}()
+
+		_, err := S3ConfigFromEnvironmentOnly("mybucket/subdir")
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "AWS_ENDPOINT_URL")
+
+		os.Setenv("AWS_S3_ENDPOINT", "s3.domain.com")
+		_, err = S3ConfigFromEnvironmentOnly("")
+		So(err, ShouldNotBeNil)
+
+		config, err := S3ConfigFromEnvironmentOnly("mybucket/subdir")
+		So(err, ShouldBeNil)
+		So(config.Target, ShouldEqual, "https://s3.domain.com/mybucket/subdir")
+		So(config.AccessKey, ShouldBeEmpty)
+		So(config.Region, ShouldBeEmpty)
+
+		os.Setenv("AWS_ENDPOINT_URL", "http://localhost:9000")
+		os.Setenv("AWS_ACCESS_KEY_ID", "key")
+		os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+		os.Setenv("AWS_REGION", "eu-west-2")
+		config, err = S3ConfigFromEnvironmentOnly("mybucket/subdir")
+		So(err, ShouldBeNil)
+		So(config.Target, ShouldEqual, "http://localhost:9000/mybucket/subdir")
+		So(config.AccessKey, ShouldEqual, "key")
+		So(config.SecretKey, ShouldEqual, "secret")
+		So(config.Region, ShouldEqual, "eu-west-2")
+
+		os.Setenv("AWS_DEFAULT_REGION", "us-east-1")
+		config, err = S3ConfigFromEnvironmentOnly("mybucket")
+		So(err, ShouldBeNil)
+		So(config.Region, ShouldEqual, "us-east-1")
+
+		os.Setenv("AWS_ENDPOINT_URL", "ftp://localhost")
+		_, err = S3ConfigFromEnvironmentOnly("mybucket")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("RequesterPays S3Accessors send the request payer header", t, func() {
+		a := &S3Accessor{}
+		So(a.getObjectOptions().Header().Get(requestPayerHeader), ShouldBeEmpty)
+		a.requesterPays = true
+		So(a.getObjectOptions().Header().Get(requestPayerHeader), ShouldEqual, requestPayerValue)
+	})
+
+	Convey("S3Accessor can tell if an error is due to access being denied", t, func() {
+		a := &S3Accessor{}
+		So(a.ErrorIsForbidden(minio.ErrorResponse{Code: "AccessDenied", StatusCode: http.StatusForbidden}), ShouldBeTrue)
+		So(a.ErrorIsForbidden(minio.ErrorResponse{StatusCode: http.StatusForbidden}), ShouldBeTrue)
+		So(a.ErrorIsForbidden(minio.ErrorResponse{Code: "NoSuchKey", StatusCode: http.StatusNotFound}), ShouldBeFalse)
+		So(a.ErrorIsForbidden(fmt.Errorf("other")), ShouldBeFalse)
+	})
+
+	Convey("S3Config.Addressing controls how buckets are addressed", t, func() {
+		So(S3AddressingAuto.bucketLookup(), ShouldEqual, minio.BucketLookupAuto)
+		So(S3AddressingPath.bucketLookup(), ShouldEqual, minio.BucketLookupPath)
+		So(S3AddressingVirtualHost.bucketLookup(), ShouldEqual, minio.BucketLookupDNS)
+
+		var paths []string
+		var pMutex sync.Mutex
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pMutex.Lock()
+			paths = append(paths, r.Host+r.URL.Path)
+			pMutex.Unlock()
+			w.Header().Set("Content-Type", "application/xml")
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>mybucket</Name><KeyCount>0</KeyCount><MaxKeys>1000</MaxKeys><IsTruncated>false</IsTruncated></ListBucketResult>`)
+		}))
+		defer server.Close()
+		host := strings.TrimPrefix(server.URL, "http://")
+
+		_, err := NewS3Accessor(&S3Config{Target: server.URL + "/mybucket/sub", Region: "us-east-1", Addressing: S3AddressingPath})
+		So(err, ShouldBeNil)
+		pMutex.Lock()
+		defer pMutex.Unlock()
+		So(paths, ShouldNotBeEmpty)
+		for _, p := range paths {
+			So(p, ShouldStartWith, host+"/mybucket")
+		}
+	})
+
+	Convey("S3Config can configure TLS and proxies", t, func() {
+		tmpdir, err := ioutil.TempDir("", "muxfys_testing")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tmpdir)
+
+		listing := func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/xml")
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>mybucket</Name><KeyCount>0</KeyCount><MaxKeys>1000</MaxKeys><IsTruncated>false</IsTruncated></ListBucketResult>`)
+		}
+
+		Convey("CACertFile lets you trust a self-signed endpoint", func() {
+			server := httptest.NewTLSServer(http.HandlerFunc(listing))
+			defer server.Close()
+			caFile := filepath.Join(tmpdir, "ca.pem")
+			err := ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+			So(err, ShouldBeNil)
+
+			config := &S3Config{Target: server.URL + "/mybucket", Region: "us-east-1", CACertFile: caFile}
+			a, err := NewS3Accessor(config)
+			So(err, ShouldBeNil)
+			_, err = a.ListEntries("")
+			So(err, ShouldBeNil)
+
+			config.CACertFile = ""
+			config.InsecureSkipVerify = true
+			_, err = NewS3Accessor(config)
+			So(err, ShouldBeNil)
+
+			config.InsecureSkipVerify = false
+			config.CACertFile = filepath.Join(tmpdir, "missing.pem")
+			_, err = NewS3Accessor(config)
+			So(err, ShouldNotBeNil)
+
+			err = ioutil.WriteFile(caFile, []byte("not a cert"), 0600)
+			So(err, ShouldBeNil)
+			config.CACertFile = caFile
+			_, err = NewS3Accessor(config)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("MinTLSVersion rejects endpoints that only offer older TLS", func() {
+			server := httptest.NewUnstartedServer(http.HandlerFunc(listing))
+			server.TLS = &tls.Config{MinVersion: tls.VersionTLS10, MaxVersion: tls.VersionTLS10}
+			server.StartTLS()
+			defer server.Close()
+			caFile := filepath.Join(tmpdir, "ca.pem")
+			err := ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+			So(err, ShouldBeNil)
+
+			// don't waste time retrying failed handshakes
+			maxRetry := minio.MaxRetry
+			minio.MaxRetry = 1
+			defer func() {
+				minio.MaxRetry = maxRetry
+			}()
+
+			config := &S3Config{Target: server.URL + "/mybucket", Region: "us-east-1", CACertFile: caFile, MinTLSVersion: tls.VersionTLS12}
+			_, err = NewS3Accessor(config)
+			So(err, ShouldNotBeNil)
+
+			// the default is also TLS 1.2
+			config.MinTLSVersion = 0
+			_, err = NewS3Accessor(config)
+			So(err, ShouldNotBeNil)
+
+			config.MinTLSVersion = tls.VersionTLS10
+			_, err = NewS3Accessor(config)
+			So(err, ShouldBeNil)
+
+			config.MinTLSVersion = 1
+			_, err = NewS3Accessor(config)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("MinTLSVersion and CipherSuites adjust the default transport", func() {
+			ciphers := []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}
+			got, err := (&S3Config{MinTLSVersion: tls.VersionTLS13, CipherSuites: ciphers}).transport(true)
+			So(err, ShouldBeNil)
+			tr, ok := got.(*http.Transport)
+			So(ok, ShouldBeTrue)
+			So(tr.TLSClientConfig.MinVersion, ShouldEqual, tls.VersionTLS13)
+			So(tr.TLSClientConfig.CipherSuites, ShouldResemble, ciphers)
+		})
+
+		Convey("ProxyURL sends requests through a proxy", func() {
+			var proxyAuth, proxiedHost string
+			var pMutex sync.Mutex
+			proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				pMutex.Lock()
+				proxyAuth = r.Header.Get("Proxy-Authorization")
+				proxiedHost = r.Host
+				pMutex.Unlock()
+				listing(w, r)
+			}))
+			defer proxy.Close()
+
+			_, err := NewS3Accessor(&S3Config{Target: "http://s3.muxfys.invalid/mybucket", Region: "us-east-1", ProxyURL: strings.Replace(proxy.URL, "http://", "http://user:pass@", 1)})
+			So(err, ShouldBeNil)
+			pMutex.Lock()
+			defer pMutex.Unlock()
+			So(proxiedHost, ShouldEqual, "s3.muxfys.invalid")
+			So(proxyAuth, ShouldEqual, "Basic dXNlcjpwYXNz")
+
+			_, err = NewS3Accessor(&S3Config{Target: "http://s3.muxfys.invalid/mybucket", ProxyURL: "ftp://proxy"})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("A supplied Transport is used as-is", func() {
+			tr := &http.Transport{}
+			config := &S3Config{Transport: tr, ProxyURL: "ftp://ignored"}
+			got, err := config.transport(true)
+			So(err, ShouldBeNil)
+			So(got, ShouldEqual, tr)
+
+			got, err = (&S3Config{}).transport(true)
+			So(err, ShouldBeNil)
+			So(got, ShouldBeNil)
+		})
+	})
+
+	Convey("planPartialUpload finds the parts containing modified bytes", t, func() {
+		partSize, dirty := planPartialUpload(3*minUploadPartSize+10, Intervals{{10, 20}, {2*minUploadPartSize - 1, 2 * minUploadPartSize}})
+		So(partSize, ShouldEqual, minUploadPartSize)
+		So(dirty, ShouldResemble, []bool{true, true, true, false})
+
+		_, dirty = planPartialUpload(minUploadPartSize+10, Intervals{{minUploadPartSize + 5, minUploadPartSize + 100}})
+		So(dirty, ShouldResemble, []bool{false, true})
+
+		_, dirty = planPartialUpload(10, nil)
+		So(dirty, ShouldResemble, []bool{false})
+
+		partSize, dirty = planPartialUpload(maxUploadParts*minUploadPartSize*2+1, nil)
+		So(partSize, ShouldEqual, 2*minUploadPartSize+1)
+		So(len(dirty), ShouldEqual, maxUploadParts)
+	})
+
+	Convey("S3Accessor.StatFile finds out about exactly one object", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet {
+				w.Header().Set("Content-Type", "application/xml")
+				fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>mybucket</Name><KeyCount>0</KeyCount><MaxKeys>1000</MaxKeys><IsTruncated>false</IsTruncated></ListBucketResult>`)
+				return
+			}
+			if r.URL.Path != "/mybucket/ref/genome.fa" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Length", "1234")
+			w.Header().Set("Last-Modified", time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC).Format(http.TimeFormat))
+			w.Header().Set("ETag", `"abc"`)
+			w.Header().Set("X-Amz-Meta-Sample", "s1")
+			w.Header().Set("X-Amz-Meta-Muxfys-Sha256", "0123abcd")
+		}))
+		defer server.Close()
+
+		a, err := NewS3Accessor(&S3Config{Target: server.URL + "/mybucket/ref/genome.fa", Region: "us-east-1", Addressing: S3AddressingPath})
+		So(err, ShouldBeNil)
+		var fst FileStater = a
+		ra, err := fst.StatFile(a.RemotePath(""))
+		So(err, ShouldBeNil)
+		So(ra.Name, ShouldEqual, "ref/genome.fa")
+		So(ra.Size, ShouldEqual, 1234)
+		So(ra.MTime.Unix(), ShouldEqual, time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC).Unix())
+		So(ra.MD5, ShouldEqual, "abc")
+		So(ra.Metadata, ShouldResemble, map[string]string{"sample": "s1"})
+
+		_, err = fst.StatFile("ref")
+		So(err, ShouldNotBeNil)
+		So(a.ErrorIsNotExists(err), ShouldBeTrue)
+	})
+
+	Convey("S3Accessors for the same host share a client, but stay scoped to their prefix", t, func() {
+		var rMutex sync.Mutex
+		var prefixes []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			q := r.URL.Query()
+			if q.Get("list-type") != "" {
+				rMutex.Lock()
+				prefixes = append(prefixes, r.URL.Path+" "+q.Get("prefix"))
+				rMutex.Unlock()
+				w.Header().Set("Content-Type", "application/xml")
+				fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>mybucket</Name><KeyCount>0</KeyCount><MaxKeys>1000</MaxKeys><IsTruncated>false</IsTruncated></ListBucketResult>`)
+				return
+			}
+			rMutex.Lock()
+			prefixes = append(prefixes, r.URL.Path)
+			rMutex.Unlock()
+			w.Header().Set("Content-Length", "1")
+			w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+			fmt.Fprint(w, "a")
+		}))
+		defer server.Close()
+
+		a1, err := NewS3Accessor(&S3Config{Target: server.URL + "/mybucket/one", Region: "us-east-1", Addressing: S3AddressingPath})
+		So(err, ShouldBeNil)
+		a2, err := NewS3Accessor(&S3Config{Target: server.URL + "/mybucket/two", Region: "us-east-1", Addressing: S3AddressingPath})
+		So(err, ShouldBeNil)
+		a3, err := NewS3Accessor(&S3Config{Target: server.URL + "/otherbucket", Region: "us-east-1", Addressing: S3AddressingPath})
+		So(err, ShouldBeNil)
+		So(a2.client, ShouldEqual, a1.client)
+		So(a3.client, ShouldEqual, a1.client)
+
+		a4, err := NewS3Accessor(&S3Config{Target: server.URL + "/mybucket/one", Region: "us-east-1", Addressing: S3AddressingPath, AccessKey: "key", SecretKey: "secret"})
+		So(err, ShouldBeNil)
+		So(a4.client, ShouldNotEqual, a1.client)
+		a5, err := NewS3Accessor(&S3Config{Target: server.URL + "/mybucket/one", Region: "us-east-1", Addressing: S3AddressingPath, Transport: http.DefaultTransport})
+		So(err, ShouldBeNil)
+		So(a5.client, ShouldNotEqual, a1.client)
+
+		rMutex.Lock()
+		prefixes = nil
+		rMutex.Unlock()
+		_, err = a1.ListEntries(a1.RemotePath("") + "/")
+		So(err, ShouldBeNil)
+		_, err = a2.ListEntries(a2.RemotePath("") + "/")
+		So(err, ShouldBeNil)
+		rc, err := a2.OpenFile(a2.RemotePath("a.file"), 0)
+		So(err, ShouldBeNil)
+		So(rc.Close(), ShouldBeNil)
+		_, err = a3.ListEntries(a3.RemotePath(""))
+		So(err, ShouldBeNil)
+
+		rMutex.Lock()
+		defer rMutex.Unlock()
+		So(prefixes, ShouldResemble, []string{"/mybucket/ one/", "/mybucket/ two/", "/mybucket/two/a.file", "/otherbucket/ "})
+	})
+
+	Convey("Shared clients are forgotten once no S3Accessor or mounted MuxFys uses them", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/xml")
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>mybucket</Name><KeyCount>0</KeyCount><MaxKeys>1000</MaxKeys><IsTruncated>false</IsTruncated></ListBucketResult>`)
+		}))
+		defer server.Close()
+
+		config := &S3Config{Target: server.URL + "/mybucket/sub", Region: "us-east-1", Addressing: S3AddressingPath}
+		users := func(key string) int {
+			s3ClientsMutex.Lock()
+			defer s3ClientsMutex.Unlock()
+			if shared, exists := s3Clients[key]; exists {
+				return shared.users
+			}
+			return 0
+		}
+
+		a1, err := NewS3Accessor(config)
+		So(err, ShouldBeNil)
+		a2, err := NewS3Accessor(config)
+		So(err, ShouldBeNil)
+		So(a1.clientKey, ShouldNotBeEmpty)
+		So(users(a1.clientKey), ShouldEqual, 2)
+
+		tmpdir, err := ioutil.TempDir("", "muxfys_testing")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tmpdir)
+		fs, _ := newTestFS(&Config{Mount: filepath.Join(tmpdir, "mount"), CacheBase: tmpdir}, &RemoteConfig{Accessor: a1})
+		So(users(a1.clientKey), ShouldEqual, 3)
+
+		So(a1.Close(), ShouldBeNil)
+		So(a1.Close(), ShouldBeNil)
+		So(a2.Close(), ShouldBeNil)
+		So(users(a1.clientKey), ShouldEqual, 1)
+
+		a3, err := NewS3Accessor(config)
+		So(err, ShouldBeNil)
+		So(a3.client, ShouldEqual, a1.client)
+		So(a3.Close(), ShouldBeNil)
+		So(users(a1.clientKey), ShouldEqual, 1)
+
+		fs.forgetRemotes()
+		So(users(a1.clientKey), ShouldEqual, 0)
+		a4, err := NewS3Accessor(config)
+		So(err, ShouldBeNil)
+		defer a4.Close()
+		So(a4.client, ShouldNotEqual, a1.client)
+	})
+
+	Convey("S3Accessors sharing a client can copy files between buckets remotely", t, func() {
+		var cMutex sync.Mutex
+		var copies []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/xml")
+			if r.Method == http.MethodPut {
+				cMutex.Lock()
+				copies = append(copies, r.Header.Get("X-Amz-Copy-Source")+" > "+r.URL.Path)
+				cMutex.Unlock()
+				fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><CopyObjectResult><ETag>"abc"</ETag><LastModified>2026-01-02T03:04:05.000Z</LastModified></CopyObjectResult>`)
+				return
+			}
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>mybucket</Name><KeyCount>0</KeyCount><MaxKeys>1000</MaxKeys><IsTruncated>false</IsTruncated></ListBucketResult>`)
+		}))
+		defer server.Close()
+
+		src, err := NewS3Accessor(&S3Config{Target: server.URL + "/srcbucket/in", Region: "us-east-1", Addressing: S3AddressingPath})
+		So(err, ShouldBeNil)
+		dst, err := NewS3Accessor(&S3Config{Target: server.URL + "/dstbucket/out", Region: "us-east-1", Addressing: S3AddressingPath})
+		So(err, ShouldBeNil)
+		other, err := NewS3Accessor(&S3Config{Target: server.URL + "/dstbucket/out", Region: "us-east-1", Addressing: S3AddressingPath, AccessKey: "key", SecretKey: "secret"})
+		So(err, ShouldBeNil)
+
+		So(dst.CanCopyFrom(src), ShouldBeTrue)
+		So(dst.CanCopyFrom(other), ShouldBeFalse)
+		So(dst.CanCopyFrom(&localAccessor{}), ShouldBeFalse)
+		So(dst.CopyFileFrom(other, "in/a.file", "out/a.file"), ShouldNotBeNil)
+
+		So(dst.CopyFileFrom(src, src.RemotePath("sub/a.file"), dst.RemotePath("sub/a.file")), ShouldBeNil)
+		So(dst.CopyFile(dst.RemotePath("sub/a.file"), dst.RemotePath("b.file")), ShouldBeNil)
+
+		cMutex.Lock()
+		defer cMutex.Unlock()
+		So(copies, ShouldResemble, []string{"srcbucket/in/sub/a.file > /dstbucket/out/sub/a.file", "dstbucket/out/sub/a.file > /dstbucket/out/b.file"})
+	})
+
+	Convey("S3Accessor can Select() from objects, in the configured formats", t, func() {
+		var sMutex sync.Mutex
+		var requests []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/xml")
+			if r.Method == http.MethodPost {
+				body, _ := ioutil.ReadAll(r.Body)
+				sMutex.Lock()
+				requests = append(requests, r.URL.Path+"?"+r.URL.RawQuery+" "+string(body))
+				sMutex.Unlock()
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>InvalidRequest</Code><Message>test</Message></Error>`)
+				return
+			}
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>mybucket</Name><KeyCount>0</KeyCount><MaxKeys>1000</MaxKeys><IsTruncated>false</IsTruncated></ListBucketResult>`)
+		}))
+		defer server.Close()
+
+		config := &S3Config{Target: server.URL + "/mybucket/data", Region: "us-east-1", Addressing: S3AddressingPath}
+		for _, sc := range []S3SelectConfig{{InputFormat: "XML"}, {OutputFormat: "Parquet"}, {CSVHeader: "MAYBE"}, {InputCompression: "ZIP"}, {InputFormat: "Parquet", InputCompression: "GZIP"}} {
+			config.Select = sc
+			_, err := NewS3Accessor(config)
+			So(err, ShouldNotBeNil)
+		}
+
+		config.Select = S3SelectConfig{CSVHeader: "use", InputCompression: "gzip", CSVFieldDelimiter: "\t"}
+		a, err := NewS3Accessor(config)
+		So(err, ShouldBeNil)
+		_, err = a.Select(a.RemotePath("a.csv.gz"), "SELECT s.name FROM S3Object s")
+		So(err, ShouldNotBeNil)
+
+		config.Select = S3SelectConfig{InputFormat: "JSONLines", OutputFormat: "JSON"}
+		a, err = NewS3Accessor(config)
+		So(err, ShouldBeNil)
+		_, err = a.Select(a.RemotePath("a.json"), "SELECT * FROM S3Object s")
+		So(err, ShouldNotBeNil)
+
+		sMutex.Lock()
+		So(len(requests), ShouldEqual, 2)
+		So(requests[0], ShouldStartWith, "/mybucket/data/a.csv.gz?select=&select-type=2 ")
+		So(requests[0], ShouldContainSubstring, "<Expression>SELECT s.name FROM S3Object s</Expression>")
+		So(requests[0], ShouldContainSubstring, "<CompressionType>GZIP</CompressionType>")
+		So(requests[0], ShouldContainSubstring, "<FileHeaderInfo>USE</FileHeaderInfo>")
+		So(requests[0], ShouldContainSubstring, "<FieldDelimiter>&#x9;</FieldDelimiter></CSV></InputSerialization>")
+		So(requests[1], ShouldContainSubstring, "<InputSerialization><CompressionType>NONE</CompressionType><JSON><Type>LINES</Type></JSON></InputSerialization>")
+		So(requests[1], ShouldContainSubstring, "<OutputSerialization><JSON>")
+		sMutex.Unlock()
+
+		a.versionsAsOf = time.Now()
+		_, err = a.Select(a.RemotePath("a.json"), "SELECT * FROM S3Object s")
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "VersionAsOf")
+	})
+
+	Convey("S3Accessor can be pinned to the versions of objects as of a time", t, func() {
+		var rMutex sync.Mutex
+		var versionIDs []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			q := r.URL.Query()
+			if _, versions := q["versions"]; versions {
+				w.Header().Set("Content-Type", "application/xml")
+				fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><ListVersionsResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>mybucket</Name><MaxKeys>1000</MaxKeys><IsTruncated>false</IsTruncated>`+
+					`<Version><Key>a.file</Key><VersionId>a2</VersionId><IsLatest>true</IsLatest><LastModified>2026-03-01T00:00:00.000Z</LastModified><ETag>"ea2"</ETag><Size>3</Size></Version>`+
+					`<Version><Key>a.file</Key><VersionId>a1</VersionId><IsLatest>false</IsLatest><LastModified>2026-01-01T00:00:00.000Z</LastModified><ETag>"ea1"</ETag><Size>5</Size></Version>`+
+					`<Version><Key>b.file</Key><VersionId>b1</VersionId><IsLatest>true</IsLatest><LastModified>2026-03-01T00:00:00.000Z</LastModified><ETag>"eb1"</ETag><Size>1</Size></Version>`+
+					`<DeleteMarker><Key>c.file</Key><VersionId>c2</VersionId><IsLatest>true</IsLatest><LastModified>2026-01-15T00:00:00.000Z</LastModified></DeleteMarker>`+
+					`<Version><Key>c.file</Key><VersionId>c1</VersionId><IsLatest>false</IsLatest><LastModified>2026-01-01T00:00:00.000Z</LastModified><ETag>"ec1"</ETag><Size>1</Size></Version>`+
+					`<CommonPrefixes><Prefix>sub/</Prefix></CommonPrefixes></ListVersionsResult>`)
+				return
+			}
+			if q.Get("list-type") != "" {
+				w.Header().Set("Content-Type", "application/xml")
+				fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>mybucket</Name><KeyCount>0</KeyCount><MaxKeys>1000</MaxKeys><IsTruncated>false</IsTruncated></ListBucketResult>`)
+				return
+			}
+			rMutex.Lock()
+			versionIDs = append(versionIDs, q.Get("versionId"))
+			rMutex.Unlock()
+			w.Header().Set("Content-Length", "5")
+			w.Header().Set("Last-Modified", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC).Format(http.TimeFormat))
+			w.Header().Set("ETag", `"ea1"`)
+			w.Header().Set("x-amz-version-id", q.Get("versionId"))
+			if r.Method == http.MethodGet {
+				fmt.Fprint(w, "first")
+			}
+		}))
+		defer server.Close()
+
+		a, err := NewS3Accessor(&S3Config{Target: server.URL + "/mybucket", Region: "us-east-1", Addressing: S3AddressingPath})
+		So(err, ShouldBeNil)
+		var pinner VersionPinner = a
+		pinner.PinVersionsAsOf(time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC))
+
+		Convey("Listing only finds the versions that were current then", func() {
+			ras, err := a.ListEntries("")
+			So(err, ShouldBeNil)
+			So(len(ras), ShouldEqual, 2)
+			So(ras[0].Name, ShouldEqual, "sub/")
+			So(ras[1].Name, ShouldEqual, "a.file")
+			So(ras[1].Size, ShouldEqual, 5)
+			So(ras[1].VersionID, ShouldEqual, "a1")
+
+			rc, err := a.OpenFile("a.file", 0)
+			So(err, ShouldBeNil)
+			content, err := ioutil.ReadAll(rc)
+			So(err, ShouldBeNil)
+			So(rc.Close(), ShouldBeNil)
+			So(string(content), ShouldEqual, "first")
+
+			rMutex.Lock()
+			defer rMutex.Unlock()
+			So(versionIDs, ShouldResemble, []string{"a1"})
+		})
+
+		Convey("Files that weren't listed are looked up", func() {
+			ra, err := a.StatFile("a.file")
+			So(err, ShouldBeNil)
+			So(ra.VersionID, ShouldEqual, "a1")
+
+			_, err = a.StatFile("b.file")
+			So(err, ShouldNotBeNil)
+			So(a.ErrorIsNotExists(err), ShouldBeTrue)
+			_, err = a.OpenFile("c.file", 0)
+			So(err, ShouldNotBeNil)
+			So(a.ErrorIsNotExists(err), ShouldBeTrue)
+
+			rMutex.Lock()
+			defer rMutex.Unlock()
+			So(versionIDs, ShouldResemble, []string{"a1"})
+		})
+	})
+
+	Convey("S3Accessor can set the tags of objects", t, func() {
+		var tagged, body string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, tagging := r.URL.Query()["tagging"]; tagging && r.Method == http.MethodPut {
+				tagged = r.URL.Path
+				b, _ := ioutil.ReadAll(r.Body)
+				body = string(b)
+				return
+			}
+			w.Header().Set("Content-Type", "application/xml")
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>mybucket</Name><KeyCount>0</KeyCount><MaxKeys>1000</MaxKeys><IsTruncated>false</IsTruncated></ListBucketResult>`)
+		}))
+		defer server.Close()
+
+		a, err := NewS3Accessor(&S3Config{Target: server.URL + "/mybucket", Region: "us-east-1", Addressing: S3AddressingPath})
+		So(err, ShouldBeNil)
+		var tagger TaggingAccessor = a
+		So(tagger.SetTags("a.file", map[string]string{"lifecycle": "scratch"}), ShouldBeNil)
+		So(tagged, ShouldEqual, "/mybucket/a.file")
+		So(body, ShouldContainSubstring, "<Key>lifecycle</Key><Value>scratch</Value>")
+
+		So(tagger.SetTags("a.file", map[string]string{"": "bad"}), ShouldNotBeNil)
+	})
+
+	Convey("S3Accessor stores and retrieves SHA256 checksums", t, func() {
+		tmpdir, err := ioutil.TempDir("", "muxfys_testing")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tmpdir)
+		source := filepath.Join(tmpdir, "source")
+		So(ioutil.WriteFile(source, []byte("data"), 0644), ShouldBeNil)
+
+		var stored string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet:
+				w.Header().Set("Content-Type", "application/xml")
+				fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>mybucket</Name><KeyCount>0</KeyCount><MaxKeys>1000</MaxKeys><IsTruncated>false</IsTruncated></ListBucketResult>`)
+			case http.MethodPut:
+				stored = r.Header.Get("X-Amz-Meta-Muxfys-Sha256")
+				w.Header().Set("ETag", `"abc-2"`)
+			case http.MethodHead:
+				w.Header().Set("Content-Length", "4")
+				w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+				w.Header().Set("ETag", `"abc-2"`)
+				if stored != "" {
+					w.Header().Set("X-Amz-Meta-Muxfys-Sha256", stored)
+				}
+			}
+		}))
+		defer server.Close()
+
+		a, err := NewS3Accessor(&S3Config{Target: server.URL + "/mybucket", Region: "us-east-1", Addressing: S3AddressingPath})
+		So(err, ShouldBeNil)
+		var ca ChecksumAccessor = a
+		So(ca.UploadReader(context.Background(), strings.NewReader("data"), 4, "up.file", UploadOptions{ContentType: "text/plain", SHA256: "0123abcd"}), ShouldBeNil)
+		So(stored, ShouldEqual, "0123abcd")
+
+		md5sum, sha, err := ca.Checksums("up.file")
+		So(err, ShouldBeNil)
+		So(md5sum, ShouldEqual, "abc-2")
+		So(sha, ShouldEqual, "0123abcd")
+	})
+
+	Convey("S3Accessor stores metadata given at upload", t, func() {
+		var sample, contentType string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet:
+				w.Header().Set("Content-Type", "application/xml")
+				fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>mybucket</Name><KeyCount>0</KeyCount><MaxKeys>1000</MaxKeys><IsTruncated>false</IsTruncated></ListBucketResult>`)
+			case http.MethodPut:
+				sample = r.Header.Get("X-Amz-Meta-Sample")
+				contentType = r.Header.Get("Content-Type")
+				w.Header().Set("ETag", `"abc"`)
+			}
+		}))
+		defer server.Close()
+
+		a, err := NewS3Accessor(&S3Config{Target: server.URL + "/mybucket", Region: "us-east-1", Addressing: S3AddressingPath})
+		So(err, ShouldBeNil)
+		var mu MetadataUploader = a
+		So(mu.StoresMetadata(), ShouldBeTrue)
+		So(mu.UploadReader(context.Background(), strings.NewReader("data"), 4, "up.file", UploadOptions{ContentType: "text/plain", Metadata: map[string]string{"Sample": "s1"}}), ShouldBeNil)
+		So(sample, ShouldEqual, "s1")
+		So(contentType, ShouldEqual, "text/plain")
+	})
+
+	Convey("S3Accessor records the content type of data uploaded from a reader", t, func() {
+		var contentType, body string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet:
+				w.Header().Set("Content-Type", "application/xml")
+				fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>mybucket</Name><KeyCount>0</KeyCount><MaxKeys>1000</MaxKeys><IsTruncated>false</IsTruncated></ListBucketResult>`)
+			case http.MethodPut:
+				contentType = r.Header.Get("Content-Type")
+				b, _ := ioutil.ReadAll(r.Body)
+				body = string(b)
+				w.Header().Set("ETag", `"abc"`)
+			}
+		}))
+		defer server.Close()
+
+		a, err := NewS3Accessor(&S3Config{Target: server.URL + "/mybucket", Region: "us-east-1", Addressing: S3AddressingPath})
+		So(err, ShouldBeNil)
+		var ru ReaderUploader = a
+		So(ru.UploadReader(context.Background(), strings.NewReader("data"), 4, "up.file", UploadOptions{ContentType: "text/plain"}), ShouldBeNil)
+		So(contentType, ShouldEqual, "text/plain")
+		So(body, ShouldEqual, "data")
+	})
+
+	Convey("S3Accessor gives uploaded and copied objects its canned ACL", t, func() {
+		tmpdir, err := ioutil.TempDir("", "muxfys_testing")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tmpdir)
+		source := filepath.Join(tmpdir, "source")
+		So(ioutil.WriteFile(source, []byte("data"), 0644), ShouldBeNil)
+
+		var acls, copied []string
+		var aMutex sync.Mutex
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet:
+				w.Header().Set("Content-Type", "application/xml")
+				fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>mybucket</Name><KeyCount>0</KeyCount><MaxKeys>1000</MaxKeys><IsTruncated>false</IsTruncated></ListBucketResult>`)
+			case http.MethodPut:
+				aMutex.Lock()
+				acls = append(acls, r.Header.Get("X-Amz-Acl"))
+				copied = append(copied, r.Header.Get("X-Amz-Copy-Source"))
+				aMutex.Unlock()
+				w.Header().Set("ETag", `"abc"`)
+				if r.Header.Get("X-Amz-Copy-Source") != "" {
+					w.Header().Set("Content-Type", "application/xml")
+					fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><CopyObjectResult><LastModified>2026-01-02T03:04:05.000Z</LastModified><ETag>"abc"</ETag></CopyObjectResult>`)
+				}
+			}
+		}))
+		defer server.Close()
+
+		a, err := NewS3Accessor(&S3Config{Target: server.URL + "/mybucket", Region: "us-east-1", Addressing: S3AddressingPath})
+		So(err, ShouldBeNil)
+		So(a.UploadFile(source, "default.file", "text/plain"), ShouldBeNil)
+
+		var setter CannedACLSetter = a
+		setter.SetCannedACL("public-read")
+		So(a.UploadFile(source, "up.file", "text/plain"), ShouldBeNil)
+		So(a.UploadReader(context.Background(), strings.NewReader("data"), 4, "sha.file", UploadOptions{ContentType: "text/plain", SHA256: "0123abcd"}), ShouldBeNil)
+		So(a.CopyFile("up.file", "copy.file"), ShouldBeNil)
+
+		aMutex.Lock()
+		defer aMutex.Unlock()
+		So(acls, ShouldResemble, []string{"", "public-read", "public-read", "public-read"})
+		So(copied[3], ShouldEqual, "mybucket/up.file")
+	})
+
+	Convey("S3Config can identify requests with a UserAgent and ExtraHeaders", t, func() {
+		tmpdir, err := ioutil.TempDir("", "muxfys_testing")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tmpdir)
+		source := filepath.Join(tmpdir, "source")
+		So(ioutil.WriteFile(source, []byte("data"), 0644), ShouldBeNil)
+
+		var agents, routes, caches []string
+		var aMutex sync.Mutex
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			aMutex.Lock()
+			agents = append(agents, r.Header.Get("User-Agent"))
+			routes = append(routes, r.Header.Get("X-Route"))
+			caches = append(caches, r.Header.Get("Cache-Control"))
+			aMutex.Unlock()
+			switch r.Method {
+			case http.MethodGet:
+				w.Header().Set("Content-Type", "application/xml")
+				fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>mybucket</Name><KeyCount>0</KeyCount><MaxKeys>1000</MaxKeys><IsTruncated>false</IsTruncated></ListBucketResult>`)
+			case http.MethodHead:
+				w.Header().Set("ETag", `"abc"`)
+				w.Header().Set("Last-Modified", "Fri, 02 Jan 2026 03:04:05 GMT")
+				w.Header().Set("Content-Length", "4")
+			case http.MethodPut:
+				w.Header().Set("ETag", `"abc"`)
+			}
+		}))
+		defer server.Close()
+
+		_, err = NewS3Accessor(&S3Config{Target: server.URL + "/mybucket", Region: "us-east-1", Addressing: S3AddressingPath, UserAgent: "muxfys"})
+		So(err, ShouldNotBeNil)
+
+		headers := http.Header{}
+		headers.Set("X-Route", "fast")
+		headers.Set("Cache-Control", "no-cache")
+		a, err := NewS3Accessor(&S3Config{Target: server.URL + "/mybucket", Region: "us-east-1", Addressing: S3AddressingPath, UserAgent: "muxfys-test/1.0", ExtraHeaders: headers})
+		So(err, ShouldBeNil)
+		_, err = a.StatFile("a.file")
+		So(err, ShouldBeNil)
+		So(a.UploadFile(source, "up.file", "text/plain"), ShouldBeNil)
+
+		aMutex.Lock()
+		defer aMutex.Unlock()
+		So(len(agents), ShouldEqual, 3)
+		for _, agent := range agents {
+			So(agent, ShouldEndWith, " muxfys-test/1.0")
+		}
+		So(routes, ShouldResemble, []string{"", "fast", ""})
+		So(caches, ShouldResemble, []string{"", "no-cache", "no-cache"})
+	})
+
+	Convey("S3Config.CredentialsProvider supplies credentials that can change", t, func() {
+		var accepted string
+		var calls int
+		var cMutex sync.Mutex
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cMutex.Lock()
+			ok := strings.Contains(r.Header.Get("Authorization"), "Credential="+accepted+"/") && r.Header.Get("X-Amz-Security-Token") == "token-"+accepted
+			cMutex.Unlock()
+			if !ok {
+				w.Header().Set("Content-Type", "application/xml")
+				w.WriteHeader(http.StatusForbidden)
+				fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>AccessDenied</Code><Message>denied</Message></Error>`)
+				return
+			}
+			w.Header().Set("Content-Type", "application/xml")
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>mybucket</Name><KeyCount>0</KeyCount><MaxKeys>1000</MaxKeys><IsTruncated>false</IsTruncated></ListBucketResult>`)
+		}))
+		defer server.Close()
+
+		rotate := func(key string) {
+			cMutex.Lock()
+			defer cMutex.Unlock()
+			accepted = key
+		}
+		provider := func() (string, string, string, error) {
+			cMutex.Lock()
+			defer cMutex.Unlock()
+			calls++
+			return accepted, "secret", "token-" + accepted, nil
+		}
+		callCount := func() int {
+			cMutex.Lock()
+			defer cMutex.Unlock()
+			return calls
+		}
+
+		rotate("key1")
+		a, err := NewS3Accessor(&S3Config{Target: server.URL + "/mybucket", Region: "us-east-1", Addressing: S3AddressingPath,
+			CredentialsProvider: provider, CredentialsRefreshInterval: 500 * time.Millisecond})
+		So(err, ShouldBeNil)
+		So(callCount(), ShouldEqual, 1)
+		_, err = a.ListEntries("/")
+		So(err, ShouldBeNil)
+		So(callCount(), ShouldEqual, 1)
+
+		rotate("key2")
+		_, err = a.ListEntries("/")
+		So(err, ShouldNotBeNil)
+		<-time.After(600 * time.Millisecond)
+		_, err = a.ListEntries("/")
+		So(err, ShouldBeNil)
+		So(callCount(), ShouldEqual, 2)
+
+		Convey("Rejected requests make credentials be retrieved again, but not too often", func() {
+			p := newS3CredentialsProvider(provider, time.Hour)
+			So(p.IsExpired(), ShouldBeTrue)
+			_, err := p.Retrieve()
+			So(err, ShouldBeNil)
+			So(p.IsExpired(), ShouldBeFalse)
+
+			transport, err := p.watch(nil, false)
+			So(err, ShouldBeNil)
+			client := &http.Client{Transport: transport}
+			resp, err := client.Get(server.URL + "/mybucket")
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+			So(p.IsExpired(), ShouldBeFalse)
+
+			p.refreshed = time.Now().Add(-minCredentialsRefreshInterval)
+			resp, err = client.Get(server.URL + "/mybucket")
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(p.IsExpired(), ShouldBeTrue)
+
+			value, err := p.Retrieve()
+			So(err, ShouldBeNil)
+			So(value.AccessKeyID, ShouldEqual, "key2")
+			So(value.SessionToken, ShouldEqual, "token-key2")
+			So(p.IsExpired(), ShouldBeFalse)
+		})
+	})
+
+	Convey("S3Accessor.UploadModified only uploads the modified parts", t, func() {
+		tmpdir, err := ioutil.TempDir("", "muxfys_testing")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tmpdir)
+		source := filepath.Join(tmpdir, "source")
+		size := int64(2*minUploadPartSize + 10)
+		f, err := os.Create(source)
+		So(err, ShouldBeNil)
+		So(f.Truncate(size), ShouldBeNil)
+		So(f.Close(), ShouldBeNil)
+
+		remoteSize := size
+		copyCode := ""
+		var requests []string
+		var rMutex sync.Mutex
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			q := r.URL.Query()
+			request := r.Method
+			switch {
+			case r.Method == http.MethodHead:
+				w.Header().Set("Content-Length", strconv.FormatInt(remoteSize, 10))
+				w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+				w.Header().Set("ETag", `"remote"`)
+			case r.Method == http.MethodPost && q.Get("uploadId") == "":
+				request += " initiate"
+				fmt.Fprint(w, `<InitiateMultipartUploadResult><Bucket>mybucket</Bucket><Key>file</Key><UploadId>up1</UploadId></InitiateMultipartUploadResult>`)
+			case r.Method == http.MethodPut && r.Header.Get("x-amz-copy-source") != "":
+				request += " copy " + q.Get("partNumber") + " " + r.Header.Get("x-amz-copy-source-range") + " " + r.Header.Get(copySourceIfMatchHeader)
+				if copyCode != "" {
+					w.WriteHeader(http.StatusNotImplemented)
+					fmt.Fprintf(w, `<Error><Code>%s</Code><Message>no</Message></Error>`, copyCode)
+					break
+				}
+				fmt.Fprint(w, `<CopyPartResult><ETag>"c"</ETag></CopyPartResult>`)
+			case r.Method == http.MethodPut:
+				n, _ := io.Copy(ioutil.Discard, r.Body)
+				request += fmt.Sprintf(" part %s %d", q.Get("partNumber"), n)
+				w.Header().Set("ETag", `"p"`)
+			case r.Method == http.MethodPost:
+				request += " complete"
+				fmt.Fprint(w, `<CompleteMultipartUploadResult><Bucket>mybucket</Bucket><Key>file</Key><ETag>"done"</ETag></CompleteMultipartUploadResult>`)
+			case r.Method == http.MethodDelete:
+				request += " abort"
+				w.WriteHeader(http.StatusNoContent)
+			default:
+				w.Header().Set("Content-Type", "application/xml")
+				fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>mybucket</Name><KeyCount>0</KeyCount><MaxKeys>1000</MaxKeys><IsTruncated>false</IsTruncated></ListBucketResult>`)
+				return
+			}
+			rMutex.Lock()
+			requests = append(requests, request)
+			rMutex.Unlock()
+		}))
+		defer server.Close()
+
+		a, err := NewS3Accessor(&S3Config{Target: server.URL + "/mybucket", Region: "us-east-1", Addressing: S3AddressingPath})
+		So(err, ShouldBeNil)
+		var pu PartialUploader = a
+		lastPart := fmt.Sprintf("bytes=%d-%d", 2*minUploadPartSize, size-1)
+
+		err = pu.UploadModified(source, "file", "text/plain", `"remote"`, Intervals{{minUploadPartSize + 5, minUploadPartSize + 6}})
+		So(err, ShouldBeNil)
+		So(requests, ShouldResemble, []string{
+			"HEAD",
+			"POST initiate",
+			fmt.Sprintf("PUT copy 1 bytes=0-%d \"remote\"", minUploadPartSize-1),
+			fmt.Sprintf("PUT part 2 %d", minUploadPartSize),
+			"PUT copy 3 " + lastPart + ` "remote"`,
+			"POST complete",
+		})
+
+		Convey("Unless nothing was modified, when nothing is uploaded", func() {
+			requests = nil
+			So(pu.UploadModified(source, "file", "text/plain", `"remote"`, nil), ShouldBeNil)
+			So(requests, ShouldResemble, []string{"HEAD"})
+		})
+
+		Convey("It isn't possible if everything was modified", func() {
+			err = pu.UploadModified(source, "file", "text/plain", `"remote"`, Intervals{{0, size - 1}})
+			So(err, ShouldEqual, ErrPartialUploadUnsupported)
+		})
+
+		Convey("It isn't possible if the remote file is a different size", func() {
+			remoteSize = size + 1
+			err = pu.UploadModified(source, "file", "text/plain", `"remote"`, Intervals{{0, 1}})
+			So(err, ShouldEqual, ErrPartialUploadUnsupported)
+		})
+
+		Convey("It isn't possible if the remote file has a different ETag", func() {
+			requests = nil
+			err = pu.UploadModified(source, "file", "text/plain", "other", Intervals{{0, 1}})
+			So(err, ShouldEqual, ErrPartialUploadUnsupported)
+			So(requests, ShouldResemble, []string{"HEAD"})
+		})
+
+		Convey("It isn't possible if the service can't copy parts", func() {
+			requests = nil
+			copyCode = "NotImplemented"
+			err = pu.UploadModified(source, "file", "text/plain", `"remote"`, Intervals{{0, 1}})
+			So(err, ShouldEqual, ErrPartialUploadUnsupported)
+			So(requests[len(requests)-1], ShouldEqual, "DELETE abort")
+		})
+	})
+
+	Convey("planAppend copies the existing object then uploads the rest", t, func() {
+		So(planAppend(minCopyPartSize, minCopyPartSize+10), ShouldResemble, []appendPart{
+			{offset: 0, length: minCopyPartSize, copy: true},
+			{offset: minCopyPartSize, length: 10},
+		})
+
+		base := int64(2*maxCopyPartSize + 1)
+		parts := planAppend(base, base+minUploadPartSize+1)
+		So(parts, ShouldHaveLength, 5)
+		var copied int64
+		for _, part := range parts[:3] {
+			So(part.copy, ShouldBeTrue)
+			So(part.offset, ShouldEqual, copied)
+			So(part.length, ShouldBeLessThanOrEqualTo, maxCopyPartSize)
+			copied += part.length
+		}
+		So(copied, ShouldEqual, base)
+		So(parts[3], ShouldResemble, appendPart{offset: base, length: minUploadPartSize})
+		So(parts[4], ShouldResemble, appendPart{offset: base + minUploadPartSize, length: 1})
+	})
+
+	Convey("S3Accessor.AppendFile only uploads the appended bytes", t, func() {
+		tmpdir, err := ioutil.TempDir("", "muxfys_testing")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tmpdir)
+		source := filepath.Join(tmpdir, "source")
+		base := int64(minCopyPartSize)
+		f, err := os.Create(source)
+		So(err, ShouldBeNil)
+		So(f.Truncate(base+10), ShouldBeNil)
+		So(f.Close(), ShouldBeNil)
+
+		remoteSize := base
+		var requests []string
+		var rMutex sync.Mutex
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			q := r.URL.Query()
+			request := r.Method
+			switch {
+			case r.Method == http.MethodHead:
+				w.Header().Set("Content-Length", strconv.FormatInt(remoteSize, 10))
+				w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+				w.Header().Set("ETag", `"remote"`)
+				w.Header().Set("Content-Type", "text/plain")
+			case r.Method == http.MethodPost && q.Get("uploadId") == "":
+				request += " initiate " + r.Header.Get("Content-Type")
+				fmt.Fprint(w, `<InitiateMultipartUploadResult><Bucket>mybucket</Bucket><Key>file</Key><UploadId>up1</UploadId></InitiateMultipartUploadResult>`)
+			case r.Method == http.MethodPut && r.Header.Get("x-amz-copy-source") != "":
+				request += " copy " + q.Get("partNumber") + " " + r.Header.Get("x-amz-copy-source-range") + " " + r.Header.Get(copySourceIfMatchHeader)
+				fmt.Fprint(w, `<CopyPartResult><ETag>"c"</ETag></CopyPartResult>`)
+			case r.Method == http.MethodPut:
+				n, _ := io.Copy(ioutil.Discard, r.Body)
+				request += fmt.Sprintf(" part %s %d", q.Get("partNumber"), n)
+				w.Header().Set("ETag", `"p"`)
+			case r.Method == http.MethodPost:
+				request += " complete"
+				fmt.Fprint(w, `<CompleteMultipartUploadResult><Bucket>mybucket</Bucket><Key>file</Key><ETag>"done"</ETag></CompleteMultipartUploadResult>`)
+			default:
+				w.Header().Set("Content-Type", "application/xml")
+				fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>mybucket</Name><KeyCount>0</KeyCount><MaxKeys>1000</MaxKeys><IsTruncated>false</IsTruncated></ListBucketResult>`)
+				return
+			}
+			rMutex.Lock()
+			requests = append(requests, request)
+			rMutex.Unlock()
+		}))
+		defer server.Close()
+
+		a, err := NewS3Accessor(&S3Config{Target: server.URL + "/mybucket", Region: "us-east-1", Addressing: S3AddressingPath})
+		So(err, ShouldBeNil)
+		So(a.CanAppend(), ShouldBeFalse)
+		a, err = NewS3Accessor(&S3Config{Target: server.URL + "/mybucket", Region: "us-east-1", Addressing: S3AddressingPath, ComposeOnUpload: true})
+		So(err, ShouldBeNil)
+		var ap Appender = a
+		So(ap.CanAppend(), ShouldBeTrue)
+
+		So(ap.AppendFile(source, "file", base), ShouldBeNil)
+		So(requests, ShouldResemble, []string{
+			"HEAD",
+			"POST initiate text/plain",
+			fmt.Sprintf("PUT copy 1 bytes=0-%d \"remote\"", base-1),
+			"PUT part 2 10",
+			"POST complete",
+		})
+
+		Convey("It isn't possible if the remote file is a different size", func() {
+			remoteSize = base + 1
+			So(ap.AppendFile(source, "file", base), ShouldEqual, ErrAppendUnsupported)
+		})
+
+		Convey("It isn't possible if the remote file is too small to copy", func() {
+			remoteSize = base - 1
+			So(ap.AppendFile(source, "file", base-1), ShouldEqual, ErrAppendUnsupported)
+		})
+	})
+
+	Convey("S3Accessor.UploadFileResumable can resume interrupted uploads", t, func() {
+		tmpdir, err := ioutil.TempDir("", "muxfys_testing")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tmpdir)
+		source := filepath.Join(tmpdir, "source")
+		size := int64(2*minUploadPartSize + 10)
+		f, err := os.Create(source)
+		So(err, ShouldBeNil)
+		So(f.Truncate(size), ShouldBeNil)
+		So(f.Close(), ShouldBeNil)
+
+		failPart := "2"
+		uploads := 0
+		uploaded := make(map[string]int64)
+		var requests []string
+		var rMutex sync.Mutex
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			q := r.URL.Query()
+			request := r.Method
+			switch {
+			case r.Method == http.MethodPost && q.Get("uploadId") == "":
+				uploads++
+				uploaded = make(map[string]int64)
+				request += " initiate"
+				fmt.Fprintf(w, `<InitiateMultipartUploadResult><Bucket>mybucket</Bucket><Key>file</Key><UploadId>up%d</UploadId></InitiateMultipartUploadResult>`, uploads)
+			case r.Method == http.MethodGet && q.Get("uploadId") != "":
+				request += " list " + q.Get("uploadId")
+				if q.Get("uploadId") != fmt.Sprintf("up%d", uploads) {
+					w.WriteHeader(http.StatusNotFound)
+					fmt.Fprint(w, `<Error><Code>NoSuchUpload</Code><Message>no</Message></Error>`)
+					break
+				}
+				fmt.Fprint(w, `<ListPartsResult><Bucket>mybucket</Bucket><Key>file</Key><IsTruncated>false</IsTruncated>`)
+				for number, n := range uploaded {
+					fmt.Fprintf(w, `<Part><PartNumber>%s</PartNumber><ETag>"p%s"</ETag><Size>%d</Size></Part>`, number, number, n)
+				}
+				fmt.Fprint(w, `</ListPartsResult>`)
+			case r.Method == http.MethodPut:
+				n, _ := io.Copy(ioutil.Discard, r.Body)
+				number := q.Get("partNumber")
+				request += fmt.Sprintf(" part %s %d", number, n)
+				if number == failPart {
+					w.WriteHeader(http.StatusBadRequest)
+					fmt.Fprint(w, `<Error><Code>InvalidPart</Code><Message>no</Message></Error>`)
+					break
+				}
+				uploaded[number] = n
+				w.Header().Set("ETag", `"p`+number+`"`)
+			case r.Method == http.MethodPost:
+				request += " complete " + q.Get("uploadId")
+				fmt.Fprint(w, `<CompleteMultipartUploadResult><Bucket>mybucket</Bucket><Key>file</Key><ETag>"done"</ETag></CompleteMultipartUploadResult>`)
+			case r.Method == http.MethodDelete:
+				request += " abort " + q.Get("uploadId")
+				w.WriteHeader(http.StatusNoContent)
+			default:
+				w.Header().Set("Content-Type", "application/xml")
+				fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>mybucket</Name><KeyCount>0</KeyCount><MaxKeys>1000</MaxKeys><IsTruncated>false</IsTruncated></ListBucketResult>`)
+				return
+			}
+			rMutex.Lock()
+			requests = append(requests, request)
+			rMutex.Unlock()
+		}))
+		defer server.Close()
+
+		a, err := NewS3Accessor(&S3Config{Target: server.URL + "/mybucket", Region: "us-east-1", Addressing: S3AddressingPath})
+		So(err, ShouldBeNil)
+		var ru ResumableUploader = a
+		var saved UploadState
+		save := func(state *UploadState) {
+			saved = *state
+			saved.Parts = append([]UploadedPart(nil), state.Parts...)
+		}
+
+		err = ru.UploadFileResumable(context.Background(), source, "file", UploadOptions{}, nil, save)
+		So(err, ShouldNotBeNil)
+		So(saved, ShouldResemble, UploadState{UploadID: "up1", Parts: []UploadedPart{{Number: 1, ETag: "p1"}}})
+
+		Convey("Resuming only uploads the remaining parts", func() {
+			requests = nil
+			failPart = ""
+			err = ru.UploadFileResumable(context.Background(), source, "file", UploadOptions{}, &saved, save)
+			So(err, ShouldBeNil)
+			So(requests, ShouldResemble, []string{
+				"GET list up1",
+				"PUT part 2 " + strconv.Itoa(minUploadPartSize),
+				"PUT part 3 10",
+				"POST complete up1",
+			})
+			So(saved.Parts, ShouldHaveLength, 3)
+		})
+
+		Convey("Parts that weren't recorded are uploaded again", func() {
+			requests = nil
+			failPart = ""
+			saved.Parts = nil
+			err = ru.UploadFileResumable(context.Background(), source, "file", UploadOptions{}, &saved, save)
+			So(err, ShouldBeNil)
+			So(requests, ShouldHaveLength, 5)
+			So(requests[1], ShouldEqual, "PUT part 1 "+strconv.Itoa(minUploadPartSize))
+		})
+
+		Convey("Uploads that no longer exist are started again", func() {
+			requests = nil
+			failPart = ""
+			saved.UploadID = "gone"
+			err = ru.UploadFileResumable(context.Background(), source, "file", UploadOptions{}, &saved, save)
+			So(err, ShouldBeNil)
+			So(requests[0], ShouldEqual, "GET list gone")
+			So(requests[1], ShouldEqual, "POST initiate")
+			So(requests[len(requests)-1], ShouldEqual, "POST complete up2")
+		})
+
+		Convey("Uploads can be aborted", func() {
+			requests = nil
+			So(ru.AbortUpload("file", &saved), ShouldBeNil)
+			So(requests, ShouldResemble, []string{"DELETE abort up1"})
+		})
+	})
+}
+
 func TestS3Localntegration(t *testing.T) {
 	// We will create test files on local disk and then start up minio server
 	// to give us an S3 system to test against.
@@ -0,0 +1,93 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+// This file contains the implementation of MuxFys.Select().
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// SelectAccessor is an optional interface that RemoteAccessors can also
+// implement, to support MuxFys.Select().
+type SelectAccessor interface {
+	// Select should run the given query expression against the contents of
+	// the remote file at the given path on the remote server, returning a
+	// reader of just the results.
+	Select(path, expression string) (io.ReadCloser, error)
+}
+
+// Select runs the given SQL expression against the contents of the file at the
+// given path (relative to the mount point) on the remote server, eg.
+// "SELECT s._1 FROM S3Object s WHERE s._3 > 100", and returns a reader of just
+// the results, so that you can pull a few columns or rows out of a huge CSV,
+// JSON or Parquet file without downloading all of it. The results are streamed
+// from the remote; they do not go through or get added to the cache.
+//
+// The formats of the file and results depend on the remote; for S3 see
+// S3Config.Select. If the file's remote doesn't support this (its Accessor is
+// not a SelectAccessor, or its RemoteConfig has an EncryptionKey), the error
+// wraps syscall.ENOSYS. Files you have created or altered via the mount can't
+// be selected from until they have been uploaded.
+func (fs *MuxFys) Select(path, expression string) (io.ReadCloser, error) {
+	name := strings.TrimPrefix(filepath.Clean("/"+path), "/")
+	attr, status := fs.GetAttr(name, nil)
+	if status != fuse.OK {
+		return nil, fmt.Errorf("could not stat %s: %w", path, statusErr(status))
+	}
+	if !attr.IsRegular() {
+		return nil, fmt.Errorf("%s is not a file", path)
+	}
+
+	fs.mapMutex.RLock()
+	r := fs.fileToRemote[name]
+	created := fs.createdFiles[name]
+	fs.mapMutex.RUnlock()
+	if r == nil {
+		return nil, fmt.Errorf("could not stat %s: %w", path, statusErr(fuse.ENOENT))
+	}
+	if created {
+		return nil, fmt.Errorf("%s has not been uploaded yet", path)
+	}
+
+	sa, ok := r.accessor.(SelectAccessor)
+	if !ok {
+		return nil, &os.PathError{Op: "select", Path: path, Err: syscall.ENOSYS}
+	}
+
+	remotePath := r.getRemotePath(name)
+	var rc io.ReadCloser
+	rf := func() error {
+		var err error
+		rc, err = sa.Select(remotePath, expression)
+		return err
+	}
+	status = r.retry("Select", remotePath, rf)
+	if status != fuse.OK {
+		return nil, fmt.Errorf("could not select from %s: %w", path, statusErr(status))
+	}
+	return rc, nil
+}
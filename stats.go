@@ -0,0 +1,117 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+import (
+	"time"
+	"unsafe"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// the sizes of things stored in our attr caches, for CacheMemoryStats()
+const (
+	stringSize   = int64(unsafe.Sizeof(""))
+	pointerSize  = int64(unsafe.Sizeof(uintptr(0)))
+	sliceSize    = int64(unsafe.Sizeof([]byte{}))
+	attrSize     = int64(unsafe.Sizeof(fuse.Attr{}))
+	dirEntrySize = int64(unsafe.Sizeof(fuse.DirEntry{}))
+	timeSize     = int64(unsafe.Sizeof(time.Time{}))
+)
+
+// Stats describes what has happened to a MuxFys since New() was called.
+type Stats struct {
+	// Remounts is the number of times Config.AutoRemount remounted after our
+	// fuse connection was lost.
+	Remounts int
+}
+
+// Stats returns a description of what has happened to us since New().
+func (fs *MuxFys) Stats() Stats {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	return Stats{Remounts: fs.remounts}
+}
+
+// MemStats describes how much we're storing in memory about the files and
+// directories in our remotes.
+type MemStats struct {
+	// Files is the number of files we have cached the attributes of.
+	Files int
+
+	// Dirs is the number of directories we know exist.
+	Dirs int
+
+	// DirListings is the number of directories we have cached the contents
+	// of, and DirEntries the total number of entries in those.
+	DirListings int
+	DirEntries  int
+
+	// MissingPaths is the number of paths remembered as not existing, due to
+	// Config.NegativeCacheTTL.
+	MissingPaths int
+
+	// Bytes is an estimate of the memory used to store all of the above. It
+	// is the size of the stored keys and values, not including the overhead
+	// of the maps holding them, so the real usage will be somewhat higher.
+	Bytes int64
+}
+
+// CacheMemoryStats tells you how many file attributes and directory listings
+// we have cached, and roughly how much memory they use. With huge buckets this
+// can be significant, and since we only forget about files when we notice they
+// were deleted, the way to reclaim the memory is to Unmount() and Mount() again.
+func (fs *MuxFys) CacheMemoryStats() MemStats {
+	fs.mapMutex.RLock()
+	defer fs.mapMutex.RUnlock()
+
+	ms := MemStats{
+		Files:        len(fs.files),
+		Dirs:         len(fs.dirs),
+		DirListings:  len(fs.dirContents),
+		MissingPaths: len(fs.negativeCache),
+	}
+
+	for name := range fs.files {
+		ms.Bytes += stringSize + int64(len(name)) + pointerSize + attrSize
+	}
+	for name := range fs.fileToRemote {
+		ms.Bytes += stringSize + int64(len(name)) + pointerSize
+	}
+	for name := range fs.createdFiles {
+		ms.Bytes += stringSize + int64(len(name)) + 1
+	}
+	for name, remotes := range fs.dirs {
+		ms.Bytes += stringSize + int64(len(name)) + sliceSize + int64(len(remotes))*pointerSize
+	}
+	for name := range fs.createdDirs {
+		ms.Bytes += stringSize + int64(len(name)) + 1
+	}
+	for name, entries := range fs.dirContents {
+		ms.DirEntries += len(entries)
+		ms.Bytes += stringSize + int64(len(name)) + sliceSize + int64(cap(entries))*dirEntrySize
+		for _, entry := range entries {
+			ms.Bytes += int64(len(entry.Name))
+		}
+	}
+	for name := range fs.negativeCache {
+		ms.Bytes += stringSize + int64(len(name)) + timeSize
+	}
+	return ms
+}
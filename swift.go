@@ -0,0 +1,285 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+// This file contains an implementation of RemoteAccessor for OpenStack Swift.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/ncw/swift"
+)
+
+const swiftScheme = "swift"
+
+// SwiftConfig struct lets you provide details of the OpenStack Swift container
+// you wish to mount. You must either supply AuthURL, UserName and APIKey (and
+// possibly Domain and Tenant) for Keystone authentication, or StorageURL and
+// AuthToken if you have already authenticated.
+type SwiftConfig struct {
+	// Target is the container and possible pseudo-folder prefix you wish to
+	// mount, in the form swift://container/prefix. For performance reasons,
+	// you should specify the deepest prefix that holds all your files.
+	Target string
+
+	// AuthURL is the Keystone v2 or v3 authentication URL, eg.
+	// https://keystone.domain.com:5000/v3.
+	AuthURL string
+
+	// AuthVersion is optional if you need to force auth version 1, 2 or 3; by
+	// default it is determined from AuthURL.
+	AuthVersion int
+
+	// UserName and APIKey are your access credentials (APIKey is your password
+	// for Keystone auth).
+	UserName string
+	APIKey   string
+
+	// Domain is your user's domain name (v3 auth only).
+	Domain string
+
+	// Tenant is the name of your tenant/project (v2 and v3 auth only).
+	Tenant string
+
+	// Region is optional if you need to use a specific region.
+	Region string
+
+	// StorageURL and AuthToken let you use a previously obtained auth token
+	// instead of authenticating with UserName and APIKey.
+	StorageURL string
+	AuthToken  string
+}
+
+// SwiftAccessor implements the RemoteAccessor interface by embedding
+// github.com/ncw/swift.
+type SwiftAccessor struct {
+	conn      *swift.Connection
+	container string
+	target    string
+	host      string
+	basePath  string
+}
+
+// NewSwiftAccessor creates a SwiftAccessor for interacting with OpenStack
+// Swift.
+func NewSwiftAccessor(config *SwiftConfig) (*SwiftAccessor, error) {
+	container, basePath, err := parseTarget(swiftScheme, config.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &swift.Connection{
+		AuthUrl:     config.AuthURL,
+		AuthVersion: config.AuthVersion,
+		UserName:    config.UserName,
+		ApiKey:      config.APIKey,
+		Domain:      config.Domain,
+		Tenant:      config.Tenant,
+		Region:      config.Region,
+	}
+
+	switch {
+	case config.StorageURL != "" && config.AuthToken != "":
+		conn.StorageUrl = config.StorageURL
+		conn.AuthToken = config.AuthToken
+	case config.AuthURL != "" && config.UserName != "" && config.APIKey != "":
+		err = conn.Authenticate()
+		if err != nil {
+			return nil, fmt.Errorf("could not authenticate with Swift: %s", err)
+		}
+	default:
+		return nil, fmt.Errorf("SwiftConfig requires either StorageURL and AuthToken, or AuthURL, UserName and APIKey")
+	}
+
+	u, err := url.Parse(conn.StorageUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &SwiftAccessor{
+		conn:      conn,
+		container: container,
+		target:    config.Target,
+		host:      u.Host,
+		basePath:  basePath,
+	}
+
+	// test that the connection actually works (credentials are ok?)
+	_, _, err = conn.Container(container)
+	if err != nil {
+		err = fmt.Errorf("could not access Swift container %s: %s", container, err)
+	}
+
+	return a, err
+}
+
+// DownloadFile implements RemoteAccessor by deferring to swift.
+func (a *SwiftAccessor) DownloadFile(source, dest string) (err error) {
+	err = os.MkdirAll(filepath.Dir(dest), os.FileMode(dirMode))
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		errc := f.Close()
+		if err == nil {
+			err = errc
+		}
+	}()
+
+	_, err = a.conn.ObjectGet(a.container, source, f, true, nil)
+	return err
+}
+
+// UploadFile implements RemoteAccessor by deferring to swift.
+func (a *SwiftAccessor) UploadFile(source, dest, contentType string) error {
+	f, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = a.conn.ObjectPut(a.container, dest, f, false, "", contentType, nil)
+	return err
+}
+
+// UploadData implements RemoteAccessor by deferring to swift.
+func (a *SwiftAccessor) UploadData(data io.Reader, dest string) error {
+	_, err := a.conn.ObjectPut(a.container, dest, data, false, "", "", nil)
+	return err
+}
+
+// UploadReader implements ReaderUploader by deferring to swift.
+func (a *SwiftAccessor) UploadReader(ctx context.Context, data io.Reader, size int64, dest string, opts UploadOptions) error {
+	_, err := a.conn.ObjectPut(a.container, dest, &contextReader{ctx: ctx, Reader: io.LimitReader(data, size)}, false, "", opts.ContentType, nil)
+	return err
+}
+
+// ListEntries implements RemoteAccessor by deferring to swift, using a "/"
+// delimiter so that pseudo-folders are returned as directories.
+func (a *SwiftAccessor) ListEntries(dir string) ([]RemoteAttr, error) {
+	objects, err := a.conn.ObjectsAll(a.container, &swift.ObjectsOpts{
+		Prefix:    dir,
+		Delimiter: '/',
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ras := make([]RemoteAttr, 0, len(objects))
+	for _, object := range objects {
+		if object.PseudoDirectory {
+			ras = append(ras, RemoteAttr{Name: object.Name})
+			continue
+		}
+		ras = append(ras, RemoteAttr{
+			Name:  object.Name,
+			Size:  object.Bytes,
+			MTime: object.LastModified,
+			MD5:   object.Hash,
+		})
+	}
+
+	return ras, nil
+}
+
+// OpenFile implements RemoteAccessor by deferring to swift, using a Range
+// header to start reading from offset.
+func (a *SwiftAccessor) OpenFile(path string, offset int64) (io.ReadCloser, error) {
+	var headers swift.Headers
+	if offset > 0 {
+		headers = swift.Headers{"Range": fmt.Sprintf("bytes=%d-", offset)}
+	}
+	file, _, err := a.conn.ObjectOpen(a.container, path, false, headers)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// Seek implements RemoteAccessor by closing the given object and opening a new
+// one starting at offset.
+func (a *SwiftAccessor) Seek(path string, rc io.ReadCloser, offset int64) (io.ReadCloser, error) {
+	err := rc.Close()
+	if err != nil {
+		return nil, err
+	}
+	return a.OpenFile(path, offset)
+}
+
+// CopyFile implements RemoteAccessor by deferring to swift.
+func (a *SwiftAccessor) CopyFile(source, dest string) error {
+	_, err := a.conn.ObjectCopy(a.container, source, a.container, dest, nil)
+	return err
+}
+
+// DeleteFile implements RemoteAccessor by deferring to swift. Like S3,
+// deleting a file that doesn't exist is not an error.
+func (a *SwiftAccessor) DeleteFile(path string) error {
+	err := a.conn.ObjectDelete(a.container, path)
+	if err == swift.ObjectNotFound {
+		return nil
+	}
+	return err
+}
+
+// DeleteIncompleteUpload implements RemoteAccessor by deleting the object,
+// since Swift doesn't keep partial uploads of ordinary objects.
+func (a *SwiftAccessor) DeleteIncompleteUpload(path string) error {
+	return a.DeleteFile(path)
+}
+
+// ErrorIsNotExists implements RemoteAccessor by looking for swift's
+// ObjectNotFound and ContainerNotFound errors.
+func (a *SwiftAccessor) ErrorIsNotExists(err error) bool {
+	return err == swift.ObjectNotFound || err == swift.ContainerNotFound
+}
+
+// ErrorIsNoQuota implements RemoteAccessor by looking for swift's
+// TooLargeObject error, which is what Swift returns when a quota would be
+// exceeded.
+func (a *SwiftAccessor) ErrorIsNoQuota(err error) bool {
+	return err == swift.TooLargeObject
+}
+
+// Target implements RemoteAccessor by returning the initial target we were
+// configured with.
+func (a *SwiftAccessor) Target() string {
+	return a.target
+}
+
+// RemotePath implements RemoteAccessor by using the initially configured base
+// path.
+func (a *SwiftAccessor) RemotePath(relPath string) string {
+	return filepath.Join(a.basePath, relPath)
+}
+
+// LocalPath implements RemoteAccessor by including the storage host and
+// container in the return value.
+func (a *SwiftAccessor) LocalPath(baseDir, remotePath string) string {
+	return filepath.Join(baseDir, a.host, a.container, remotePath)
+}
@@ -0,0 +1,118 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ncw/swift"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSwift(t *testing.T) {
+	Convey("NewSwiftAccessor requires a valid target and credentials", t, func() {
+		_, err := NewSwiftAccessor(&SwiftConfig{Target: "s3://container/prefix", AuthToken: "t", StorageURL: "http://localhost"})
+		So(err, ShouldNotBeNil)
+
+		_, err = NewSwiftAccessor(&SwiftConfig{Target: "swift://container/prefix"})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "requires either")
+
+		_, err = NewSwiftAccessor(&SwiftConfig{Target: "swift://container/prefix", UserName: "user", APIKey: "key"})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "requires either")
+	})
+
+	Convey("SwiftAccessor maps swift errors", t, func() {
+		a := &SwiftAccessor{}
+		So(a.ErrorIsNotExists(swift.ObjectNotFound), ShouldBeTrue)
+		So(a.ErrorIsNotExists(swift.ContainerNotFound), ShouldBeTrue)
+		So(a.ErrorIsNotExists(swift.Forbidden), ShouldBeFalse)
+		So(a.ErrorIsNoQuota(swift.TooLargeObject), ShouldBeTrue)
+		So(a.ErrorIsNoQuota(swift.ObjectNotFound), ShouldBeFalse)
+	})
+
+	// For the remaining tests to work, MUXFYS_SWIFT_TARGET must be a
+	// swift://container/prefix that you have read and write permissions for,
+	// and the standard OS_AUTH_URL, OS_USERNAME, OS_PASSWORD, OS_PROJECT_NAME
+	// and OS_USER_DOMAIN_NAME environment variables must be set.
+	target := os.Getenv("MUXFYS_SWIFT_TARGET")
+	authURL := os.Getenv("OS_AUTH_URL")
+	if target == "" || authURL == "" {
+		SkipConvey("Without MUXFYS_SWIFT_TARGET and OS_AUTH_URL etc. environment variables, we'll skip remote Swift tests", t, func() {})
+		return
+	}
+
+	Convey("You can make a SwiftAccessor and use it to upload, list, read and delete", t, func() {
+		accessor, err := NewSwiftAccessor(&SwiftConfig{
+			Target:   target,
+			AuthURL:  authURL,
+			UserName: os.Getenv("OS_USERNAME"),
+			APIKey:   os.Getenv("OS_PASSWORD"),
+			Tenant:   os.Getenv("OS_PROJECT_NAME"),
+			Domain:   os.Getenv("OS_USER_DOMAIN_NAME"),
+			Region:   os.Getenv("OS_REGION_NAME"),
+		})
+		So(err, ShouldBeNil)
+
+		tmpdir, err := ioutil.TempDir("", "muxfys_testing")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tmpdir)
+
+		source := filepath.Join(tmpdir, "source")
+		err = ioutil.WriteFile(source, []byte("0123456789"), 0600)
+		So(err, ShouldBeNil)
+
+		remotePath := accessor.RemotePath("muxfys_swift_test/test.file")
+		err = accessor.UploadFile(source, remotePath, "text/plain")
+		So(err, ShouldBeNil)
+		defer accessor.DeleteFile(remotePath)
+
+		ras, err := accessor.ListEntries(accessor.RemotePath("") + "/")
+		So(err, ShouldBeNil)
+		var found bool
+		for _, ra := range ras {
+			if ra.Name == accessor.RemotePath("muxfys_swift_test")+"/" {
+				found = true
+			}
+		}
+		So(found, ShouldBeTrue)
+
+		rc, err := accessor.OpenFile(remotePath, 5)
+		So(err, ShouldBeNil)
+		b, err := ioutil.ReadAll(rc)
+		So(err, ShouldBeNil)
+		So(string(b), ShouldEqual, "56789")
+
+		rc, err = accessor.Seek(remotePath, rc, 2)
+		So(err, ShouldBeNil)
+		b, err = ioutil.ReadAll(rc)
+		So(err, ShouldBeNil)
+		So(string(b), ShouldEqual, "23456789")
+		rc.Close()
+
+		err = accessor.DeleteFile(remotePath)
+		So(err, ShouldBeNil)
+		_, err = accessor.OpenFile(remotePath, 0)
+		So(accessor.ErrorIsNotExists(err), ShouldBeTrue)
+	})
+}
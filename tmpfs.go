@@ -0,0 +1,108 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+// This file contains the implementation of Config.CacheInTmpfs.
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/inconshreveable/log15"
+)
+
+const (
+	// tmpfsMagic is the file system type statfs reports for tmpfs on linux.
+	tmpfsMagic = 0x01021994
+
+	// defaultCacheMaxBytes is the Config.CacheMaxBytes used by CacheInTmpfs if
+	// not otherwise specified.
+	defaultCacheMaxBytes = 1073741824 // 1GB
+
+	// writeOK is the mode for syscall.Access() that checks for write access.
+	writeOK = 0x2
+)
+
+// tmpfsCandidates are the directories we consider using for CacheInTmpfs, in
+// order of preference. Only those that are on a tmpfs are used.
+var tmpfsCandidates = []string{"/dev/shm", "/run/shm", os.TempDir()}
+
+// procMeminfo is where we find out how much memory is available.
+var procMeminfo = "/proc/meminfo"
+
+// tmpfsCacheBase returns the first of our tmpfsCandidates that is a writable
+// tmpfs with at least wantBytes free, for use instead of cacheBase. Since tmpfs
+// data is held in memory, wantBytes of memory must also be available. If no
+// tmpfs qualifies, cacheBase is returned and a warning logged.
+func tmpfsCacheBase(cacheBase string, wantBytes int64, logger log15.Logger) string {
+	if wantBytes == 0 {
+		wantBytes = defaultCacheMaxBytes
+	}
+
+	if available, known := memAvailable(); known && available < uint64(wantBytes) {
+		logger.Warn("Not enough memory available to cache in tmpfs, using CacheBase instead", "available", available, "wanted", wantBytes, "base", cacheBase)
+		return cacheBase
+	}
+
+	for _, dir := range tmpfsCandidates {
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(dir, &st); err != nil || int64(st.Type) != tmpfsMagic {
+			continue
+		}
+		if st.Bavail*uint64(st.Bsize) < uint64(wantBytes) {
+			logger.Warn("tmpfs too small to cache in", "dir", dir, "free", st.Bavail*uint64(st.Bsize), "wanted", wantBytes)
+			continue
+		}
+		if syscall.Access(dir, writeOK) != nil {
+			continue
+		}
+		return filepath.Clean(dir)
+	}
+
+	logger.Warn("No suitable tmpfs to cache in, using CacheBase instead", "base", cacheBase)
+	return cacheBase
+}
+
+// memAvailable returns the MemAvailable reported in procMeminfo, in bytes. known
+// is false if that couldn't be found out.
+func memAvailable() (available uint64, known bool) {
+	f, err := os.Open(procMeminfo)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "MemAvailable:" {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		return kb * 1024, true
+	}
+	return 0, false
+}
@@ -0,0 +1,103 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/inconshreveable/log15"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTmpfs(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "muxfys_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	origCandidates, origMeminfo := tmpfsCandidates, procMeminfo
+	defer func() {
+		tmpfsCandidates, procMeminfo = origCandidates, origMeminfo
+	}()
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	cacheBase := filepath.Join(tmpdir, "base")
+
+	Convey("memAvailable() reads MemAvailable from meminfo", t, func() {
+		procMeminfo = filepath.Join(tmpdir, "meminfo")
+		err := ioutil.WriteFile(procMeminfo, []byte("MemTotal:       16000000 kB\nMemAvailable:    2048 kB\n"), 0644)
+		So(err, ShouldBeNil)
+		available, known := memAvailable()
+		So(known, ShouldBeTrue)
+		So(available, ShouldEqual, 2048*1024)
+
+		err = ioutil.WriteFile(procMeminfo, []byte("MemTotal:       16000000 kB\n"), 0644)
+		So(err, ShouldBeNil)
+		_, known = memAvailable()
+		So(known, ShouldBeFalse)
+
+		procMeminfo = filepath.Join(tmpdir, "missing")
+		_, known = memAvailable()
+		So(known, ShouldBeFalse)
+	})
+
+	Convey("tmpfsCacheBase() falls back to CacheBase when there's no tmpfs", t, func() {
+		procMeminfo = filepath.Join(tmpdir, "missing")
+		tmpfsCandidates = []string{tmpdir, filepath.Join(tmpdir, "missing")}
+		var st syscall.Statfs_t
+		So(syscall.Statfs(tmpdir, &st), ShouldBeNil)
+		if int64(st.Type) == tmpfsMagic {
+			SkipSo("the temp dir is a tmpfs")
+			return
+		}
+		So(tmpfsCacheBase(cacheBase, 1, logger), ShouldEqual, cacheBase)
+	})
+
+	Convey("tmpfsCacheBase() uses a tmpfs with enough space and memory", t, func() {
+		var st syscall.Statfs_t
+		if err := syscall.Statfs("/dev/shm", &st); err != nil || int64(st.Type) != tmpfsMagic || syscall.Access("/dev/shm", writeOK) != nil {
+			SkipSo("/dev/shm is not a writable tmpfs")
+			return
+		}
+		tmpfsCandidates = []string{tmpdir, "/dev/shm/"}
+		procMeminfo = filepath.Join(tmpdir, "meminfo")
+		err := ioutil.WriteFile(procMeminfo, []byte("MemAvailable:    2048 kB\n"), 0644)
+		So(err, ShouldBeNil)
+
+		So(tmpfsCacheBase(cacheBase, 1024, logger), ShouldEqual, "/dev/shm")
+		So(tmpfsCacheBase(cacheBase, 4096*1024, logger), ShouldEqual, cacheBase)
+
+		procMeminfo = filepath.Join(tmpdir, "missing")
+		So(tmpfsCacheBase(cacheBase, int64(st.Bavail*uint64(st.Bsize))+1, logger), ShouldEqual, cacheBase)
+
+		fs, err := New(&Config{Mount: filepath.Join(tmpdir, "mount"), CacheBase: cacheBase, CacheInTmpfs: true, CacheMaxBytes: 1024})
+		So(err, ShouldBeNil)
+		So(fs.cacheBase, ShouldEqual, "/dev/shm")
+	})
+
+	Convey("CacheMaxBytes can't be negative", t, func() {
+		_, err := New(&Config{Mount: filepath.Join(tmpdir, "mount"), CacheBase: cacheBase, CacheInTmpfs: true, CacheMaxBytes: -1})
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,228 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+// This file implements a registry of in-flight transfers, so that they can be
+// listed and cancelled.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errTransferCancelled is returned by the reads of transfers that were
+// cancelled with MuxFys.Cancel().
+var errTransferCancelled = errors.New("transfer cancelled")
+
+// Transfer describes a download or upload that is currently in progress, as
+// returned by MuxFys.InFlight().
+type Transfer struct {
+	// ID identifies the transfer for MuxFys.Cancel(). It is made from Path
+	// and Offset.
+	ID string
+
+	// Path is the remote path of the file being transferred.
+	Path string
+
+	// Offset is the byte offset within the file that the transfer started
+	// at.
+	Offset int64
+
+	// Bytes is the number of bytes transferred so far.
+	Bytes int64
+
+	// Upload is true for uploads, and false for downloads.
+	Upload bool
+
+	// Started is when the transfer began.
+	Started time.Time
+}
+
+// transferID returns the Transfer.ID of a transfer of the given remote path
+// starting at the given offset.
+func transferID(path string, offset int64) string {
+	return fmt.Sprintf("%s@%d", path, offset)
+}
+
+// transfer is an entry in a transfers registry.
+type transfer struct {
+	Transfer
+	bytes     int64 // accessed atomically
+	ctx       context.Context
+	cancel    context.CancelFunc
+	rc        io.Closer
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// close closes the reader being transferred from, if any. It is safe to call
+// more than once, and concurrently with a blocked Read(), which it aborts.
+func (t *transfer) close() error {
+	if t.rc == nil {
+		return nil
+	}
+	t.closeOnce.Do(func() {
+		t.closeErr = t.rc.Close()
+	})
+	return t.closeErr
+}
+
+// transfers is a registry of a remote's in-flight transfers.
+type transfers struct {
+	mutex    sync.Mutex
+	inflight map[*transfer]bool
+}
+
+// newTransfers creates an empty transfers registry.
+func newTransfers() *transfers {
+	return &transfers{inflight: make(map[*transfer]bool)}
+}
+
+// start registers a new transfer of the given remote path, starting at the
+// given offset, from the given reader (if any; it is closed if the transfer is
+// cancelled). The transfer's context is done when the given parent is.
+func (ts *transfers) start(parent context.Context, rc io.Closer, path string, offset int64, upload bool) *transfer {
+	ctx, cancel := context.WithCancel(parent)
+	t := &transfer{
+		Transfer: Transfer{
+			ID:      transferID(path, offset),
+			Path:    path,
+			Offset:  offset,
+			Upload:  upload,
+			Started: time.Now(),
+		},
+		ctx:    ctx,
+		cancel: cancel,
+		rc:     rc,
+	}
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	ts.inflight[t] = true
+	return t
+}
+
+// finish unregisters the given transfer. It is safe to call more than once.
+func (ts *transfers) finish(t *transfer) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	if ts.inflight[t] {
+		delete(ts.inflight, t)
+		t.cancel()
+	}
+}
+
+// list returns the details of all our in-flight transfers.
+func (ts *transfers) list() []Transfer {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	list := make([]Transfer, 0, len(ts.inflight))
+	for t := range ts.inflight {
+		details := t.Transfer
+		details.Bytes = atomic.LoadInt64(&t.bytes)
+		list = append(list, details)
+	}
+	return list
+}
+
+// cancel cancels all our in-flight transfers with the given ID, returning
+// true if there were any. Their readers are closed, so that any reads blocked
+// on the remote system are aborted.
+func (ts *transfers) cancel(id string) bool {
+	ts.mutex.Lock()
+	var cancelled []*transfer
+	for t := range ts.inflight {
+		if t.ID == id {
+			t.cancel()
+			delete(ts.inflight, t)
+			cancelled = append(cancelled, t)
+		}
+	}
+	ts.mutex.Unlock()
+
+	for _, t := range cancelled {
+		// (any error is returned when the reader's owner closes it, and they
+		// get errTransferCancelled regardless)
+		t.close()
+	}
+	return len(cancelled) > 0
+}
+
+// reader wraps the given reader of the given remote path, that started at the
+// given offset, so that its reads are registered as a transfer. Once the
+// transfer has been cancelled, reads return errTransferCancelled. Closing the
+// returned reader unregisters the transfer.
+func (ts *transfers) reader(rc io.ReadCloser, path string, offset int64, upload bool) *transferReader {
+	return &transferReader{ReadCloser: rc, t: ts.start(context.Background(), rc, path, offset, upload), ts: ts}
+}
+
+// contextReader is an io.Reader whose reads fail with errTransferCancelled
+// once its context is done.
+type contextReader struct {
+	io.Reader
+	ctx context.Context
+}
+
+// Read reads from the wrapped reader, unless our context is done.
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if cr.ctx.Err() != nil {
+		return 0, errTransferCancelled
+	}
+	return cr.Reader.Read(p)
+}
+
+// transferReader is the io.ReadCloser returned by transfers.reader().
+type transferReader struct {
+	io.ReadCloser
+	t  *transfer
+	ts *transfers
+}
+
+// Read reads from the wrapped reader, unless our transfer was cancelled.
+func (tr *transferReader) Read(p []byte) (int, error) {
+	if tr.t.ctx.Err() != nil {
+		return 0, errTransferCancelled
+	}
+	n, err := tr.ReadCloser.Read(p)
+	atomic.AddInt64(&tr.t.bytes, int64(n))
+	if err != nil && tr.t.ctx.Err() != nil {
+		// our reader was closed by the cancellation
+		return n, errTransferCancelled
+	}
+	return n, err
+}
+
+// Close unregisters our transfer and closes the wrapped reader, unless the
+// cancellation of our transfer already closed it.
+func (tr *transferReader) Close() error {
+	tr.ts.finish(tr.t)
+	return tr.t.close()
+}
+
+// counter returns an io.Reader that reads from the given one, adding to the
+// bytes transferred by this transfer, and failing with errTransferCancelled
+// once this transfer is done.
+func (t *transfer) counter(r io.Reader) io.Reader {
+	return &transferReader{ReadCloser: ioutil.NopCloser(r), t: t}
+}
@@ -0,0 +1,269 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+// This file contains the implementation of RemoteConfig.ListDelimiter,
+// RemoteConfig.FlattenDepth and RemoteConfig.KeyNormalizer.
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"golang.org/x/text/unicode/norm"
+)
+
+// NormalizeNFC is a RemoteConfig.KeyNormalizer that converts keys to unicode
+// Normalization Form C, so that eg. keys with decomposed accented characters
+// (as created on macOS) are presented the same way as those with precomposed
+// ones.
+func NormalizeNFC(key string) string {
+	return norm.NFC.String(key)
+}
+
+// NormalizeCase is a RemoteConfig.KeyNormalizer that presents keys in lower
+// case (after converting them to unicode Normalization Form C), making look
+// ups case-insensitive.
+func NormalizeCase(key string) string {
+	return strings.ToLower(norm.NFC.String(key))
+}
+
+// virtual tells you if we present object keys in a different hierarchy to the
+// one implied by the "/" in them, or with different names.
+func (r *remote) virtual() bool {
+	return r.listDelimiter != "/" || r.flattenDepth > 0 || r.keyNormalizer != nil
+}
+
+// presentKey converts a key relative to our root in to the path (relative to
+// our root) that we present it at. Returns "" if the key can't be presented.
+func (r *remote) presentKey(key string) string {
+	if r.keyNormalizer != nil {
+		key = r.keyNormalizer(key)
+	}
+	var parts []string
+	for _, dirPart := range strings.Split(key, "/") {
+		for _, part := range strings.Split(dirPart, r.listDelimiter) {
+			if part != "" && part != "." && part != ".." {
+				parts = append(parts, part)
+			}
+		}
+	}
+	if r.flattenDepth > 0 && len(parts) > r.flattenDepth {
+		last := strings.Join(parts[r.flattenDepth-1:], ".")
+		parts = append(parts[:r.flattenDepth-1], last)
+	}
+	return strings.Join(parts, "/")
+}
+
+// realKey returns the key (relative to our root) of the file we present at the
+// given path (relative to our root), as seen by the last presentTree(). ok is
+// false if we don't present a file at that path.
+func (r *remote) realKey(relPath string) (key string, ok bool) {
+	if !r.virtual() {
+		return "", false
+	}
+	r.keysMutex.Lock()
+	defer r.keysMutex.Unlock()
+	key, ok = r.keys[relPath]
+	return key, ok
+}
+
+// listTree returns details of all the files beneath the given remote directory
+// (which should have a trailing slash, or be ""), traversing all its
+// "sub-directories".
+func (r *remote) listTree(root string) ([]RemoteAttr, fuse.Status) {
+	var files []RemoteAttr
+	dirs := []string{root}
+	for len(dirs) > 0 {
+		dir := dirs[0]
+		dirs = dirs[1:]
+
+		objects, status := r.listEntries(dir)
+		if status != fuse.OK {
+			return nil, status
+		}
+
+		var markers map[string]bool
+		if r.hideDirMarkers {
+			markers = dirMarkers(objects)
+		}
+
+		for _, object := range objects {
+			if len(object.Name) <= len(dir) || !strings.HasPrefix(object.Name, dir) || (object.Size == 0 && markers[object.Name+"/"]) {
+				continue
+			}
+			if strings.HasSuffix(object.Name, "/") {
+				dirs = append(dirs, object.Name)
+				continue
+			}
+			files = append(files, object)
+		}
+	}
+	return files, fuse.OK
+}
+
+// presentTree lists all the files beneath our root, returning them with their
+// Names changed to the paths (relative to our root) that we present them at.
+// It remembers the real keys of those paths for getRemotePath().
+func (r *remote) presentTree(root string) ([]RemoteAttr, fuse.Status) {
+	files, status := r.listTree(root)
+	if status != fuse.OK {
+		return nil, status
+	}
+
+	keys := make(map[string]string)
+	dirs := make(map[string]bool)
+	var presented []RemoteAttr
+	for _, file := range files {
+		key := file.Name[len(root):]
+		name := r.presentKey(key)
+		if name == "" {
+			continue
+		}
+		if other, exists := keys[name]; exists {
+			r.Warn("Object hidden by another presented at the same path", "key", key, "other", other, "path", name)
+			continue
+		}
+		keys[name] = key
+		for i, c := range name {
+			if c == '/' {
+				dirs[name[:i]] = true
+			}
+		}
+		file.Name = name
+		presented = append(presented, file)
+	}
+
+	// a directory hides any file presented at the same path
+	kept := presented[:0]
+	for _, file := range presented {
+		if dirs[file.Name] {
+			r.Warn("Object hidden by a directory presented at the same path", "key", keys[file.Name], "path", file.Name)
+			delete(keys, file.Name)
+			continue
+		}
+		kept = append(kept, file)
+	}
+
+	r.keysMutex.Lock()
+	r.keys = keys
+	r.keysMutex.Unlock()
+	return kept, fuse.OK
+}
+
+// presentedEntries returns the entries of dir (a presented path relative to
+// our root, with a trailing slash, or "" for the root) amongst the given files
+// from presentTree(). Directories have a trailing slash. If recursive is true,
+// everything beneath dir is returned.
+func presentedEntries(files []RemoteAttr, dir string, recursive bool) []RemoteAttr {
+	seen := make(map[string]bool)
+	var ras []RemoteAttr
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name, dir) {
+			continue
+		}
+		nested := false
+		rest := file.Name[len(dir):]
+		for i, c := range rest {
+			if c != '/' {
+				continue
+			}
+			nested = true
+			sub := dir + rest[:i+1]
+			if !seen[sub] {
+				seen[sub] = true
+				ras = append(ras, RemoteAttr{Name: sub})
+			}
+			if !recursive {
+				break
+			}
+		}
+		if !nested || recursive {
+			ras = append(ras, file)
+		}
+	}
+	return ras
+}
+
+// virtualObjects is the implementation of findObjects() when we're virtual():
+// remotePath is treated as a presented directory, and the returned details
+// have presented Names.
+func (r *remote) virtualObjects(remotePath string) ([]RemoteAttr, fuse.Status) {
+	root := r.accessor.RemotePath("")
+	if root != "" {
+		root += "/"
+	}
+	files, status := r.presentTree(root)
+	if status != fuse.OK {
+		return nil, status
+	}
+	ras := presentedEntries(files, strings.TrimPrefix(remotePath, root), false)
+	for i := range ras {
+		ras[i].Name = root + ras[i].Name
+	}
+	return ras, fuse.OK
+}
+
+// listPresented is the implementation of RemoteConfig.List() when we're
+// virtual().
+func (r *remote) listPresented(root string, recursive bool) ([]RemoteAttr, error) {
+	files, status := r.presentTree(root)
+	if status != fuse.OK {
+		return nil, statusErr(status)
+	}
+	var ras []RemoteAttr
+	for _, ra := range presentedEntries(files, "", recursive) {
+		if strings.HasSuffix(ra.Name, "/") || !r.filteredOut(ra.Name) {
+			ras = append(ras, ra)
+		}
+	}
+	return ras, nil
+}
+
+// lookupName returns the name that the given path is presented at, so that
+// with a RemoteConfig.KeyNormalizer you can look up eg. "ABC.txt" when we
+// present it as "abc.txt". Returns name unchanged if it's already known, or if
+// no remote with a KeyNormalizer is mounted at it. Must be called while you
+// have the mapMutex Locked (read lock is sufficient).
+func (fs *MuxFys) lookupName(name string) string {
+	if _, isDir := fs.dirs[name]; isDir {
+		return name
+	}
+	if _, isFile := fs.files[name]; isFile {
+		return name
+	}
+	for _, r := range fs.remotes {
+		if r.keyNormalizer == nil {
+			continue
+		}
+		relPath, ok := r.relPath(name)
+		if !ok || relPath == "" {
+			continue
+		}
+		return filepath.Join(r.mountSubpath, r.keyNormalizer(relPath))
+	}
+	return name
+}
+
+// presentedName is like lookupName(), but takes the mapMutex itself.
+func (fs *MuxFys) presentedName(name string) string {
+	fs.mapMutex.RLock()
+	defer fs.mapMutex.RUnlock()
+	return fs.lookupName(name)
+}
@@ -0,0 +1,211 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/inconshreveable/log15"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestVirtual(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "muxfys_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	source := filepath.Join(tmpdir, "source")
+	os.MkdirAll(filepath.Join(source, "x", "y", "z"), os.FileMode(0777))
+	ioutil.WriteFile(filepath.Join(source, "a_b_c.txt"), []byte("abc"), 0644)
+	ioutil.WriteFile(filepath.Join(source, "a_d.txt"), []byte("ad"), 0644)
+	ioutil.WriteFile(filepath.Join(source, "x", "y", "z", "deep.txt"), []byte("deep"), 0644)
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	accessor := &localAccessor{target: source}
+
+	names := func(entries []fuse.DirEntry) []string {
+		var n []string
+		for _, entry := range entries {
+			n = append(n, entry.Name)
+		}
+		sort.Strings(n)
+		return n
+	}
+
+	Convey("ListDelimiter and FlattenDepth can't be used with Write", t, func() {
+		_, err := newRemote(&RemoteConfig{Accessor: accessor, ListDelimiter: "_", Write: true}, tmpdir, 1, logger)
+		So(err, ShouldNotBeNil)
+		_, err = newRemote(&RemoteConfig{Accessor: accessor, FlattenDepth: 1, Write: true}, tmpdir, 1, logger)
+		So(err, ShouldNotBeNil)
+		_, err = newRemote(&RemoteConfig{Accessor: accessor, FlattenDepth: -1}, tmpdir, 1, logger)
+		So(err, ShouldNotBeNil)
+
+		r, err := newRemote(&RemoteConfig{Accessor: accessor}, tmpdir, 1, logger)
+		So(err, ShouldBeNil)
+		So(r.virtual(), ShouldBeFalse)
+	})
+
+	Convey("Keys can be presented split on a ListDelimiter and flattened", t, func() {
+		r, err := newRemote(&RemoteConfig{Accessor: accessor, ListDelimiter: "_"}, tmpdir, 1, logger)
+		So(err, ShouldBeNil)
+		So(r.presentKey("a_b_c.txt"), ShouldEqual, "a/b/c.txt")
+		So(r.presentKey("x/y_z/deep.txt"), ShouldEqual, "x/y/z/deep.txt")
+		So(r.presentKey("a__b_"), ShouldEqual, "a/b")
+
+		r, err = newRemote(&RemoteConfig{Accessor: accessor, FlattenDepth: 2}, tmpdir, 1, logger)
+		So(err, ShouldBeNil)
+		So(r.presentKey("a_b_c.txt"), ShouldEqual, "a_b_c.txt")
+		So(r.presentKey("x/y/z/deep.txt"), ShouldEqual, "x/y.z.deep.txt")
+		So(r.presentKey("x/deep.txt"), ShouldEqual, "x/deep.txt")
+
+		r, err = newRemote(&RemoteConfig{Accessor: accessor, FlattenDepth: 1}, tmpdir, 1, logger)
+		So(err, ShouldBeNil)
+		So(r.presentKey("x/y/z/deep.txt"), ShouldEqual, "x.y.z.deep.txt")
+	})
+
+	Convey("A remote with a ListDelimiter and FlattenDepth presents keys in a different hierarchy", t, func() {
+		fs, r := newTestFS(&Config{Mount: filepath.Join(tmpdir, "virtualMount"), CacheBase: tmpdir}, &RemoteConfig{Accessor: accessor, ListDelimiter: "_", FlattenDepth: 2})
+
+		entries, status := fs.OpenDir("", nil)
+		So(status, ShouldEqual, fuse.OK)
+		So(names(entries), ShouldResemble, []string{"a", "x"})
+
+		entries, status = fs.OpenDir("a", nil)
+		So(status, ShouldEqual, fuse.OK)
+		So(names(entries), ShouldResemble, []string{"b.c.txt", "d.txt"})
+
+		entries, status = fs.OpenDir("x", nil)
+		So(status, ShouldEqual, fuse.OK)
+		So(names(entries), ShouldResemble, []string{"y.z.deep.txt"})
+
+		attr, status := fs.GetAttr("x/y.z.deep.txt", nil)
+		So(status, ShouldEqual, fuse.OK)
+		So(attr.Size, ShouldEqual, 4)
+		So(r.getRemotePath("x/y.z.deep.txt"), ShouldEqual, filepath.Join(source, "x", "y", "z", "deep.txt"))
+
+		file, status := fs.Open("a/b.c.txt", uint32(os.O_RDONLY), nil)
+		So(status, ShouldEqual, fuse.OK)
+		rr, status := file.Read(make([]byte, 3), 0)
+		So(status, ShouldEqual, fuse.OK)
+		b, _ := rr.Bytes(make([]byte, 3))
+		So(string(b), ShouldEqual, "abc")
+		file.Release()
+
+		_, status = fs.GetAttr("a_b_c.txt", nil)
+		So(status, ShouldEqual, fuse.ENOENT)
+	})
+
+	Convey("List() presents keys the same way as the mount", t, func() {
+		rc := &RemoteConfig{Accessor: accessor, ListDelimiter: "_", FlattenDepth: 2}
+		ras, err := rc.List(false)
+		So(err, ShouldBeNil)
+		var got []string
+		for _, ra := range ras {
+			got = append(got, ra.Name)
+		}
+		sort.Strings(got)
+		So(got, ShouldResemble, []string{"a/", "x/"})
+
+		ras, err = rc.List(true)
+		So(err, ShouldBeNil)
+		got = nil
+		for _, ra := range ras {
+			got = append(got, ra.Name)
+		}
+		sort.Strings(got)
+		So(got, ShouldResemble, []string{"a/", "a/b.c.txt", "a/d.txt", "x/", "x/y.z.deep.txt"})
+	})
+
+	Convey("A directory hides a file presented at the same path", t, func() {
+		clashSource := filepath.Join(tmpdir, "clash")
+		os.MkdirAll(clashSource, os.FileMode(0777))
+		ioutil.WriteFile(filepath.Join(clashSource, "a"), []byte("a"), 0644)
+		ioutil.WriteFile(filepath.Join(clashSource, "a_b"), []byte("ab"), 0644)
+		r, err := newRemote(&RemoteConfig{Accessor: &localAccessor{target: clashSource}, ListDelimiter: "_"}, tmpdir, 1, logger)
+		So(err, ShouldBeNil)
+		ras, status := r.findObjects(clashSource + "/")
+		So(status, ShouldEqual, fuse.OK)
+		So(len(ras), ShouldEqual, 1)
+		So(ras[0].Name, ShouldEqual, clashSource+"/a/")
+		_, presented := r.realKey("a")
+		So(presented, ShouldBeFalse)
+	})
+
+	Convey("NormalizeNFC and NormalizeCase normalise keys", t, func() {
+		nfd := "Cafe\u0301.txt"
+		So(NormalizeNFC(nfd), ShouldEqual, "Caf\u00e9.txt")
+		So(NormalizeNFC("ABC.txt"), ShouldEqual, "ABC.txt")
+		So(NormalizeCase(nfd), ShouldEqual, "caf\u00e9.txt")
+		So(NormalizeCase("ABC/Readme.TXT"), ShouldEqual, "abc/readme.txt")
+	})
+
+	Convey("A remote with a KeyNormalizer presents normalised keys", t, func() {
+		normSource := filepath.Join(tmpdir, "norm")
+		os.MkdirAll(filepath.Join(normSource, "ABC"), os.FileMode(0777))
+		ioutil.WriteFile(filepath.Join(normSource, "Cafe\u0301.txt"), []byte("cafe"), 0644)
+		ioutil.WriteFile(filepath.Join(normSource, "ABC", "Readme.TXT"), []byte("readme"), 0644)
+		normAccessor := &localAccessor{target: normSource}
+
+		_, err := newRemote(&RemoteConfig{Accessor: normAccessor, KeyNormalizer: NormalizeCase, Write: true}, tmpdir, 1, logger)
+		So(err, ShouldNotBeNil)
+
+		fs, r := newTestFS(&Config{Mount: filepath.Join(tmpdir, "normMount"), CacheBase: tmpdir}, &RemoteConfig{Accessor: normAccessor, KeyNormalizer: NormalizeCase})
+		So(r.virtual(), ShouldBeTrue)
+
+		entries, status := fs.OpenDir("", nil)
+		So(status, ShouldEqual, fuse.OK)
+		So(names(entries), ShouldResemble, []string{"abc", "caf\u00e9.txt"})
+
+		entries, status = fs.OpenDir("ABC", nil)
+		So(status, ShouldEqual, fuse.OK)
+		So(names(entries), ShouldResemble, []string{"readme.txt"})
+
+		attr, status := fs.GetAttr("ABC/README.txt", nil)
+		So(status, ShouldEqual, fuse.OK)
+		So(attr.Size, ShouldEqual, 6)
+
+		read := func(name string, size int) string {
+			file, status := fs.Open(name, uint32(os.O_RDONLY), nil)
+			So(status, ShouldEqual, fuse.OK)
+			defer file.Release()
+			rr, status := file.Read(make([]byte, size), 0)
+			So(status, ShouldEqual, fuse.OK)
+			b, _ := rr.Bytes(make([]byte, size))
+			return string(b)
+		}
+		So(read("abc/readme.txt", 6), ShouldEqual, "readme")
+		So(read("ABC/README.txt", 6), ShouldEqual, "readme")
+
+		_, status = fs.GetAttr("Cafe\u0301.txt", nil)
+		So(status, ShouldEqual, fuse.OK)
+		So(read("caf\u00e9.txt", 4), ShouldEqual, "cafe")
+		So(r.getRemotePath("caf\u00e9.txt"), ShouldEqual, filepath.Join(normSource, "Cafe\u0301.txt"))
+
+		_, status = fs.GetAttr("other.txt", nil)
+		So(status, ShouldEqual, fuse.ENOENT)
+	})
+}
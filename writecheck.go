@@ -0,0 +1,77 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+// This file contains the implementation of the check that writeable remotes
+// really can be written to, done when you Mount().
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// writeCheckDir is the directory, relative to the root of a remote, that
+// checkWriteable() writes its test file in to.
+const writeCheckDir = ".muxfys_write_test"
+
+// checkWriteable tests that we can write to our remote by uploading an empty
+// file in to our writeCheckDir, and then deleting it. Returns an error
+// explaining why if the upload fails. Failure to delete the file is only
+// logged.
+func (r *remote) checkWriteable() error {
+	path := r.accessor.RemotePath(fmt.Sprintf("%s/%d.%d", writeCheckDir, os.Getpid(), time.Now().UnixNano()))
+	var err error
+	status := r.retry("UploadData", path, func() error {
+		err = r.accessor.UploadData(bytes.NewReader(nil), path)
+		return err
+	})
+	if status != fuse.OK {
+		if err == nil {
+			err = statusError(status)
+		}
+		return fmt.Errorf("target %s is not writeable: %s", r.accessor.Target(), err)
+	}
+
+	status = r.retry("DeleteFile", path, func() error {
+		err = r.accessor.DeleteFile(path)
+		return err
+	})
+	if status != fuse.OK {
+		r.Warn("Deletion of write check file failed", "path", path, "err", err)
+	}
+	return nil
+}
+
+// withoutWriteCheckDir returns the given listing without our writeCheckDir,
+// which Accessors for file systems with real directories will leave behind
+// after checkWriteable() deletes the file in it.
+func (r *remote) withoutWriteCheckDir(ras []RemoteAttr) []RemoteAttr {
+	dir := strings.TrimSuffix(r.accessor.RemotePath(writeCheckDir), "/") + "/"
+	for i, ra := range ras {
+		if ra.Name == dir {
+			return append(ras[:i], ras[i+1:]...)
+		}
+	}
+	return ras
+}
@@ -0,0 +1,102 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWriteCheck(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "muxfys_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	cacheBase := filepath.Join(tmpdir, "base")
+	err = os.MkdirAll(cacheBase, os.FileMode(0700))
+	if err != nil {
+		t.Fatal(err)
+	}
+	denied := errors.New("access denied")
+
+	Convey("checkWriteable() uploads and deletes a test file", t, func() {
+		ma := NewMemoryAccessor("writeable")
+		fi := NewFaultInjector(ma)
+		r, err := newRemote(&RemoteConfig{Accessor: fi, Write: true}, cacheBase, 1, pkgLogger)
+		So(err, ShouldBeNil)
+
+		So(r.checkWriteable(), ShouldBeNil)
+		So(fi.Calls("UploadData"), ShouldEqual, 1)
+		So(fi.Calls("DeleteFile"), ShouldEqual, 1)
+		ras, err := (&RemoteConfig{Accessor: ma}).List(true)
+		So(err, ShouldBeNil)
+		So(ras, ShouldBeEmpty)
+
+		Convey("Failure to delete it is not an error", func() {
+			fi.AddRule(FaultRule{Method: "DeleteFile", Err: denied})
+			So(r.checkWriteable(), ShouldBeNil)
+		})
+
+		Convey("Failure to upload it is", func() {
+			fi.AddRule(FaultRule{Method: "UploadData", Err: denied})
+			err := r.checkWriteable()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "target memory://writeable is not writeable: access denied")
+		})
+	})
+
+	Convey("A leftover write check directory is not listed", t, func() {
+		ma := NewMemoryAccessor("leftover")
+		ma.Put(writeCheckDir+"/old", nil)
+		ma.Put("a.file", []byte("a"))
+		rc := &RemoteConfig{Accessor: ma}
+		ras, err := rc.List(false)
+		So(err, ShouldBeNil)
+		So(len(ras), ShouldEqual, 1)
+		So(ras[0].Name, ShouldEqual, "a.file")
+
+		r, err := newRemote(rc, cacheBase, 1, pkgLogger)
+		So(err, ShouldBeNil)
+		ras, status := r.findObjects("")
+		So(status, ShouldEqual, fuse.OK)
+		So(len(ras), ShouldEqual, 1)
+	})
+
+	Convey("Mount() fails if a writeable remote can't be written to", t, func() {
+		fs, err := New(&Config{Mount: filepath.Join(tmpdir, "mnt"), CacheBase: cacheBase})
+		So(err, ShouldBeNil)
+		fi := NewFaultInjector(NewMemoryAccessor("readonly"), FaultRule{Method: "UploadData", Err: denied})
+		err = fs.Mount(&RemoteConfig{Accessor: NewMemoryAccessor("other"), CacheData: true}, &RemoteConfig{Accessor: fi, CacheData: true, Write: true})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "target memory://readonly is not writeable: access denied")
+		So(fs.Mounted(), ShouldBeFalse)
+		So(fs.remotes, ShouldBeNil)
+		So(fs.writeRemote, ShouldBeNil)
+		matches, err := filepath.Glob(filepath.Join(cacheBase, tmpCachePrefix+"*"))
+		So(err, ShouldBeNil)
+		So(matches, ShouldBeEmpty)
+	})
+}
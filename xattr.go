@@ -0,0 +1,128 @@
+// Copyright © 2026 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of muxfys.
+//
+//  muxfys is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  muxfys is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with muxfys. If not, see <http://www.gnu.org/licenses/>.
+
+package muxfys
+
+// This file contains the implementation of Config.ExposeMetadataXattrs.
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// xattrPrefix is the prefix of the names of the extended attributes we expose.
+const xattrPrefix = "user.s3."
+
+// fileXattrs holds the extended attributes of a file, along with the size and
+// modification time the file had when we got them.
+type fileXattrs struct {
+	size   uint64
+	mtime  uint64
+	values map[string]string
+}
+
+// GetXAttr returns the value of one of our metadata extended attributes, if
+// Config.ExposeMetadataXattrs is true.
+func (fs *MuxFys) GetXAttr(name string, attribute string, context *fuse.Context) ([]byte, fuse.Status) {
+	if !fs.exposeXattrs {
+		return fs.FileSystem.GetXAttr(name, attribute, context)
+	}
+	if !strings.HasPrefix(attribute, xattrPrefix) {
+		return nil, fuse.ENOATTR
+	}
+	values, status := fs.fileXattrs(name)
+	if status != fuse.OK {
+		return nil, status
+	}
+	value, exists := values[attribute]
+	if !exists {
+		return nil, fuse.ENOATTR
+	}
+	return []byte(value), fuse.OK
+}
+
+// ListXAttr returns the names of our metadata extended attributes, if
+// Config.ExposeMetadataXattrs is true.
+func (fs *MuxFys) ListXAttr(name string, context *fuse.Context) ([]string, fuse.Status) {
+	if !fs.exposeXattrs {
+		return fs.FileSystem.ListXAttr(name, context)
+	}
+	values, status := fs.fileXattrs(name)
+	if status != fuse.OK {
+		return nil, status
+	}
+	attributes := make([]string, 0, len(values))
+	for attribute := range values {
+		attributes = append(attributes, attribute)
+	}
+	sort.Strings(attributes)
+	return attributes, fuse.OK
+}
+
+// fileXattrs returns our extended attributes of the given file, keyed on their
+// full names. Directories have none. We only ask the remote for the file's
+// metadata if we haven't already since the file last changed.
+func (fs *MuxFys) fileXattrs(name string) (map[string]string, fuse.Status) {
+	name = fs.presentedName(name)
+	attr, r, status := fs.fileDetails(name, false)
+	if status != fuse.OK {
+		fs.mapMutex.RLock()
+		_, isDir := fs.dirs[name]
+		fs.mapMutex.RUnlock()
+		if isDir {
+			return nil, fuse.OK
+		}
+		return nil, status
+	}
+
+	fs.xattrsMutex.Lock()
+	cached, exists := fs.xattrs[name]
+	fs.xattrsMutex.Unlock()
+	if exists && cached.size == attr.Size && cached.mtime == attr.Mtime {
+		return cached.values, fuse.OK
+	}
+
+	values := map[string]string{xattrPrefix + "size": strconv.FormatUint(attr.Size, 10)}
+	fs.mapMutex.RLock()
+	created := fs.createdFiles[name]
+	fs.mapMutex.RUnlock()
+	if !created {
+		ra, known, status := r.statFile(r.getRemotePath(name))
+		if status != fuse.OK {
+			return nil, status
+		}
+		if known {
+			for key, value := range ra.Metadata {
+				if key != "size" && key != "etag" {
+					values[xattrPrefix+key] = value
+				}
+			}
+			if etag := strings.Trim(ra.MD5, `"`); etag != "" {
+				values[xattrPrefix+"etag"] = etag
+			}
+		}
+	}
+
+	fs.xattrsMutex.Lock()
+	fs.xattrs[name] = &fileXattrs{size: attr.Size, mtime: attr.Mtime, values: values}
+	fs.xattrsMutex.Unlock()
+	return values, fuse.OK
+}